@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/database"
+	"magnet-webdav/services"
+	"os"
+)
+
+// commands 命令行子命令，返回进程退出码
+var commands = map[string]func(args []string) int{
+	"export": runExport,
+	"import": runImport,
+}
+
+// openLibrary 加载配置并连接数据库，不启动种子客户端。username 为空时使用默认用户
+func openLibrary(configPath, username string) (*services.TorrentService, *config.UserConfig, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	var user *config.UserConfig
+	if username != "" {
+		if user = cfg.Auth.FindUser(username); user == nil {
+			return nil, nil, fmt.Errorf("unknown user: %s", username)
+		}
+	}
+
+	db, err := database.InitDB(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return services.NewTorrentService(cfg, db), user, nil
+}
+
+// runExport 导出媒体库：magnet-webdav export [-c config.yaml] [-user name] [-format json|tar] [-o file]
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("c", "", "Path to configuration file")
+	username := fs.String("user", "", "Export the library of this user (default: auth.username)")
+	format := fs.String("format", "json", "Export format: json or tar")
+	output := fs.String("o", "-", "Output file, - for stdout")
+	fs.Parse(args)
+
+	if *format != "json" && *format != "tar" {
+		log.Printf("Invalid format: %s", *format)
+		return 2
+	}
+
+	torrentService, user, err := openLibrary(*configPath, *username)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer database.Close()
+
+	export, err := torrentService.ExportLibrary(user, true)
+	if err != nil {
+		log.Printf("Failed to export library: %v", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Printf("Failed to create %s: %v", *output, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "tar" {
+		err = services.WriteExportTar(w, export)
+	} else {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(export)
+	}
+	if err != nil {
+		log.Printf("Failed to write export: %v", err)
+		return 1
+	}
+
+	log.Printf("Exported %d magnets", len(export.Magnets))
+	return 0
+}
+
+// runImport 导入媒体库：magnet-webdav import [-c config.yaml] [-user name] <file>
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := fs.String("c", "", "Path to configuration file")
+	username := fs.String("user", "", "Import into the library of this user (default: auth.username)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: magnet-webdav import [-c config.yaml] [-user name] <file|->")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			log.Printf("Failed to open %s: %v", name, err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+
+	lib, err := services.ReadLibraryImport(r)
+	if err != nil {
+		log.Printf("Failed to read import: %v", err)
+		return 1
+	}
+
+	torrentService, user, err := openLibrary(*configPath, *username)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer database.Close()
+
+	result, err := torrentService.ImportLibrary(user, lib, func(progress services.ImportProgress) {
+		fmt.Fprintf(os.Stderr, "\rImporting %d/%d", progress.Processed, progress.Total)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Printf("Failed to import library: %v", err)
+		return 1
+	}
+
+	for _, message := range result.Errors {
+		log.Printf("Failed: %s", message)
+	}
+	log.Printf("Imported %d magnets, %d duplicates, %d failed", result.Added, result.Duplicates, result.Failed)
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,14 +17,45 @@ type Config struct {
 	Database DatabaseConfig `yaml:"database"`
 	Torrent  TorrentConfig  `yaml:"torrent"`
 	Auth     AuthConfig     `yaml:"auth"`
+	Share    ShareConfig    `yaml:"share"`
+	Webhook  WebhookConfig  `yaml:"webhook"`
+	Feed     FeedConfig     `yaml:"feed"`
+	Search   SearchConfig   `yaml:"search"`
+	Media    MediaConfig    `yaml:"media"`
+	// qBittorrent Web API 兼容接口，供 Sonarr/Radarr 等使用
+	QBittorrent QBittorrentConfig `yaml:"qbittorrent"`
+	// Transmission RPC 兼容接口，供 Flexget 和手机遥控客户端等使用
+	Transmission TransmissionConfig `yaml:"transmission"`
+	// aria2 JSON-RPC 兼容接口，供浏览器扩展和 AriaNg 等使用
+	Aria2 Aria2Config `yaml:"aria2"`
 }
 
 type ServerConfig struct {
-	Port         string        `yaml:"port"`
-	Env          string        `yaml:"env"`
-	ReadTimeout  time.Duration `yaml:"read_timeout"`
-	WriteTimeout time.Duration `yaml:"write_timeout"`
-	Domain       string        `yaml:"domain"`
+	Port           string        `yaml:"port"`
+	Env            string        `yaml:"env"`
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	Domain         string        `yaml:"domain"`
+	TrustedProxies []string      `yaml:"trusted_proxies"` // 只信任这些代理传来的 X-Forwarded-For
+	// 关闭服务时等待进行中的流式传输结束的最长时间
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// HTTPS 配置，证书文件变化或收到 SIGHUP 时自动重新加载
+	TLSCert           string        `yaml:"tls_cert"`
+	TLSKey            string        `yaml:"tls_key"`
+	TLSReloadInterval time.Duration `yaml:"tls_reload_interval"` // 检查证书文件变化的间隔
+	HTTPRedirectPort  string        `yaml:"http_redirect_port"`  // 非空时在该端口监听 HTTP 并跳转到 HTTPS
+	TLSClientCA       string        `yaml:"tls_client_ca"`       // 客户端证书 CA，非空时启用双向 TLS 认证
+	TLSClientAuth     string        `yaml:"tls_client_auth"`     // optional / require
+	// /metrics 的 Bearer token，供 Prometheus 抓取；为空时只有管理员可以访问
+	MetricsToken string `yaml:"metrics_token"`
+	// 非空时在该端口通过 HTTP 提供 /healthz 和 /readyz，启用 HTTPS 或双向 TLS 时供容器健康检查使用
+	HealthPort string `yaml:"health_port"`
+}
+
+// TLSEnabled 是否启用 HTTPS
+func (s *ServerConfig) TLSEnabled() bool {
+	return s.TLSCert != "" && s.TLSKey != ""
 }
 
 type DatabaseConfig struct {
@@ -44,12 +77,168 @@ type TorrentConfig struct {
 	MaxConnections int    `yaml:"max_connections"`
 	UserAgent      string `yaml:"user_agent"`
 	ListenPort     int    `yaml:"listen_port"`
+	MinFreeSpace   int64  `yaml:"min_free_space"` // 下载目录所在磁盘的最小剩余空间，小于 0 时不检查
 }
 
 type AuthConfig struct {
-	Enabled  bool   `yaml:"enabled"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Enabled  bool          `yaml:"enabled"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Users    []UserConfig  `yaml:"users"`
+	Lockout  LockoutConfig `yaml:"lockout"`
+	Scheme   string        `yaml:"scheme"`    // basic / digest / both
+	NonceTTL time.Duration `yaml:"nonce_ttl"` // Digest nonce 有效期
+}
+
+// 认证方式
+const (
+	AuthSchemeBasic  = "basic"
+	AuthSchemeDigest = "digest"
+	AuthSchemeBoth   = "both"
+)
+
+// BasicEnabled 是否接受 Basic 认证
+func (a *AuthConfig) BasicEnabled() bool {
+	return a.Scheme == AuthSchemeBasic || a.Scheme == AuthSchemeBoth
+}
+
+// DigestEnabled 是否接受 Digest 认证
+func (a *AuthConfig) DigestEnabled() bool {
+	return a.Scheme == AuthSchemeDigest || a.Scheme == AuthSchemeBoth
+}
+
+// LockoutConfig 认证失败锁定配置，锁定时长按次数指数增长
+type LockoutConfig struct {
+	MaxFailures   int           `yaml:"max_failures"`    // 单个用户名在窗口内允许的失败次数
+	MaxIPFailures int           `yaml:"max_ip_failures"` // 单个 IP 在窗口内允许的失败次数
+	FailureWindow time.Duration `yaml:"failure_window"`  // 失败计数的统计窗口
+	BaseDuration  time.Duration `yaml:"base_duration"`   // 第一次锁定的时长
+	MaxDuration   time.Duration `yaml:"max_duration"`    // 锁定时长上限
+}
+
+// UserConfig 额外的用户账号，用户名和密码同时用于 WebDAV 和 API 认证
+type UserConfig struct {
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Groups   []string `yaml:"groups"`
+	Admin    bool     `yaml:"admin"`
+}
+
+// InGroup 判断用户是否属于指定分组
+func (u *UserConfig) InGroup(group string) bool {
+	for _, g := range u.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// AllUsers 返回所有用户，auth.username 作为默认管理员排在第一位
+func (a *AuthConfig) AllUsers() []UserConfig {
+	users := []UserConfig{{
+		Username: a.Username,
+		Password: a.Password,
+		Admin:    true,
+	}}
+	for _, u := range a.Users {
+		if u.Username == a.Username {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+// FindUser 按用户名查找用户，不存在时返回 nil
+func (a *AuthConfig) FindUser(username string) *UserConfig {
+	for _, u := range a.AllUsers() {
+		if u.Username == username {
+			user := u
+			return &user
+		}
+	}
+	return nil
+}
+
+// ShareConfig 文件分享链接配置
+type ShareConfig struct {
+	Secret     string        `yaml:"secret"`      // HMAC 签名密钥，为空时自动生成并保存到数据库文件旁边或下载目录
+	DefaultTTL time.Duration `yaml:"default_ttl"` // 未指定有效期时使用的默认值
+	MaxTTL     time.Duration `yaml:"max_ttl"`     // 允许的最长有效期
+}
+
+// WebhookConfig 出站 Webhook 配置，投递失败时按指数退避重试
+type WebhookConfig struct {
+	Endpoints   []WebhookEndpoint `yaml:"endpoints"`
+	MaxAttempts int               `yaml:"max_attempts"` // 最多投递次数，超过后标记为失败
+	RetryBase   time.Duration     `yaml:"retry_base"`   // 第一次重试的等待时间，之后每次翻倍
+	RetryMax    time.Duration     `yaml:"retry_max"`    // 重试等待时间上限
+	Timeout     time.Duration     `yaml:"timeout"`      // 单次请求超时
+	Retention   time.Duration     `yaml:"retention"`    // 投递记录保留时间
+}
+
+type WebhookEndpoint struct {
+	Name   string   `yaml:"name"`
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"` // 非空时对请求体做 HMAC-SHA256 签名
+	Events []string `yaml:"events"` // 订阅的事件，为空时接收所有事件
+}
+
+// FeedConfig RSS/Atom 订阅配置
+type FeedConfig struct {
+	DefaultInterval time.Duration `yaml:"default_interval"` // 订阅未指定拉取间隔时使用的默认值
+	MinInterval     time.Duration `yaml:"min_interval"`     // 允许的最短拉取间隔
+	Timeout         time.Duration `yaml:"timeout"`          // 拉取订阅和 .torrent 文件的超时
+}
+
+// SearchConfig 种子搜索配置
+type SearchConfig struct {
+	Timeout   time.Duration          `yaml:"timeout"` // 单个搜索源的超时
+	Providers []SearchProviderConfig `yaml:"providers"`
+}
+
+// SearchProviderConfig Torznab/Newznab 搜索源，例如 Jackett 或 Prowlarr 中的索引器
+type SearchProviderConfig struct {
+	Name       string `yaml:"name"`
+	Type       string `yaml:"type"` // torznab 或 newznab，两者使用相同的 API，默认 torznab
+	URL        string `yaml:"url"`  // API 地址，请求发送到 <url>/api
+	APIKey     string `yaml:"api_key"`
+	Categories []int  `yaml:"categories"` // 搜索的分类 ID，为空表示不限制
+}
+
+// MediaConfig 视频文件元数据解析配置
+type MediaConfig struct {
+	AutoProbe    bool          `yaml:"auto_probe"`    // 种子元数据就绪后自动解析所有 MP4/MKV 文件
+	ProbeTimeout time.Duration `yaml:"probe_timeout"` // 单个文件的解析超时，需要的数据还没下载时会一直等待到超时
+	// 提取 MKV 内嵌字幕的超时，提取需要读取整个文件
+	ExtractTimeout time.Duration `yaml:"extract_timeout"`
+	// HLS 切片的目标时长，实际时长取决于关键帧间隔
+	HLSSegmentDuration time.Duration `yaml:"hls_segment_duration"`
+	// 读取 HLS 索引或单个切片的超时
+	HLSTimeout time.Duration `yaml:"hls_timeout"`
+}
+
+// QBittorrentConfig qBittorrent Web API (/api/v2) 兼容接口配置
+type QBittorrentConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	SavePath   string        `yaml:"save_path"`   // 使用方看到的 WebDAV 挂载路径，作为种子的保存路径返回
+	SessionTTL time.Duration `yaml:"session_ttl"` // 登录会话在没有请求时的有效期
+}
+
+// TransmissionConfig Transmission RPC (/transmission/rpc) 兼容接口配置
+type TransmissionConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	DownloadDir string `yaml:"download_dir"` // 使用方看到的 WebDAV 挂载路径，作为种子的下载目录返回
+}
+
+// Aria2Config aria2 JSON-RPC (/jsonrpc) 兼容接口配置。aria2 客户端不支持用户名和密码，
+// 所有请求都使用 User 的媒体库，通过 Secret 认证
+type Aria2Config struct {
+	Enabled bool   `yaml:"enabled"`
+	Secret  string `yaml:"secret"` // 对应 aria2 的 --rpc-secret，客户端以 token:<secret> 作为第一个参数
+	User    string `yaml:"user"`   // 使用的媒体库所属用户，为空表示 auth.username
+	Dir     string `yaml:"dir"`    // 使用方看到的 WebDAV 挂载路径，作为下载目录返回
 }
 
 // LoadConfig 从指定路径加载配置文件
@@ -108,8 +297,8 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, err
 	}
 
-	fmt.Printf("Configuration loaded from: %s\n", configPath)
-	fmt.Printf("Database driver: %s\n", cfg.Database.Driver)
+	log.Printf("Configuration loaded from: %s", configPath)
+	log.Printf("Database driver: %s", cfg.Database.Driver)
 	return cfg, nil
 }
 
@@ -130,6 +319,15 @@ func (c *Config) setDefaults() {
 	if c.Server.Domain == "" {
 		c.Server.Domain = "localhost"
 	}
+	if c.Server.ShutdownTimeout == 0 {
+		c.Server.ShutdownTimeout = 30 * time.Second
+	}
+	if c.Server.TLSReloadInterval == 0 {
+		c.Server.TLSReloadInterval = time.Minute
+	}
+	if c.Server.TLSClientAuth == "" {
+		c.Server.TLSClientAuth = "optional"
+	}
 
 	// 数据库默认配置
 	if c.Database.Driver == "" {
@@ -163,6 +361,17 @@ func (c *Config) setDefaults() {
 	if c.Torrent.UserAgent == "" {
 		c.Torrent.UserAgent = "Magnet-WebDAV/1.0"
 	}
+	if c.Torrent.MinFreeSpace == 0 {
+		c.Torrent.MinFreeSpace = 512 * 1024 * 1024
+	}
+
+	// 分享链接默认配置
+	if c.Share.DefaultTTL == 0 {
+		c.Share.DefaultTTL = 24 * time.Hour
+	}
+	if c.Share.MaxTTL == 0 {
+		c.Share.MaxTTL = 30 * 24 * time.Hour
+	}
 
 	// 认证默认配置
 	if c.Auth.Username == "" {
@@ -171,6 +380,27 @@ func (c *Config) setDefaults() {
 	if c.Auth.Password == "" {
 		c.Auth.Password = "password"
 	}
+	if c.Auth.Scheme == "" {
+		c.Auth.Scheme = AuthSchemeBasic
+	}
+	if c.Auth.NonceTTL == 0 {
+		c.Auth.NonceTTL = 5 * time.Minute
+	}
+	if c.Auth.Lockout.MaxFailures == 0 {
+		c.Auth.Lockout.MaxFailures = 5
+	}
+	if c.Auth.Lockout.MaxIPFailures == 0 {
+		c.Auth.Lockout.MaxIPFailures = 20
+	}
+	if c.Auth.Lockout.FailureWindow == 0 {
+		c.Auth.Lockout.FailureWindow = 15 * time.Minute
+	}
+	if c.Auth.Lockout.BaseDuration == 0 {
+		c.Auth.Lockout.BaseDuration = time.Minute
+	}
+	if c.Auth.Lockout.MaxDuration == 0 {
+		c.Auth.Lockout.MaxDuration = 24 * time.Hour
+	}
 }
 
 // 使用环境变量覆盖配置
@@ -184,6 +414,18 @@ func (c *Config) overrideWithEnv() {
 	if domain := os.Getenv("DOMAIN"); domain != "" {
 		c.Server.Domain = domain
 	}
+	if tlsCert := os.Getenv("TLS_CERT"); tlsCert != "" {
+		c.Server.TLSCert = tlsCert
+	}
+	if metricsToken := os.Getenv("METRICS_TOKEN"); metricsToken != "" {
+		c.Server.MetricsToken = metricsToken
+	}
+	if healthPort := os.Getenv("HEALTH_PORT"); healthPort != "" {
+		c.Server.HealthPort = healthPort
+	}
+	if tlsKey := os.Getenv("TLS_KEY"); tlsKey != "" {
+		c.Server.TLSKey = tlsKey
+	}
 
 	if driver := os.Getenv("DB_DRIVER"); driver != "" {
 		c.Database.Driver = driver
@@ -216,12 +458,87 @@ func (c *Config) overrideWithEnv() {
 			c.Auth.Enabled = enabled
 		}
 	}
+	if scheme := os.Getenv("AUTH_SCHEME"); scheme != "" {
+		c.Auth.Scheme = scheme
+	}
 	if username := os.Getenv("WEBDAV_USERNAME"); username != "" {
 		c.Auth.Username = username
 	}
 	if password := os.Getenv("WEBDAV_PASSWORD"); password != "" {
 		c.Auth.Password = password
 	}
+
+	// Webhook 默认配置
+	if c.Webhook.MaxAttempts == 0 {
+		c.Webhook.MaxAttempts = 8
+	}
+	if c.Webhook.RetryBase == 0 {
+		c.Webhook.RetryBase = 30 * time.Second
+	}
+	if c.Webhook.RetryMax == 0 {
+		c.Webhook.RetryMax = time.Hour
+	}
+	if c.Webhook.Timeout == 0 {
+		c.Webhook.Timeout = 10 * time.Second
+	}
+	if c.Webhook.Retention == 0 {
+		c.Webhook.Retention = 7 * 24 * time.Hour
+	}
+
+	// 订阅默认配置
+	if c.Feed.DefaultInterval == 0 {
+		c.Feed.DefaultInterval = 30 * time.Minute
+	}
+	if c.Feed.MinInterval == 0 {
+		c.Feed.MinInterval = 5 * time.Minute
+	}
+	if c.Feed.Timeout == 0 {
+		c.Feed.Timeout = 30 * time.Second
+	}
+
+	// 搜索默认配置
+	if c.Search.Timeout == 0 {
+		c.Search.Timeout = 15 * time.Second
+	}
+	for i := range c.Search.Providers {
+		if c.Search.Providers[i].Type == "" {
+			c.Search.Providers[i].Type = "torznab"
+		}
+	}
+
+	if c.Media.ProbeTimeout == 0 {
+		c.Media.ProbeTimeout = 2 * time.Minute
+	}
+	if c.Media.ExtractTimeout == 0 {
+		c.Media.ExtractTimeout = 30 * time.Minute
+	}
+	if c.Media.HLSSegmentDuration == 0 {
+		c.Media.HLSSegmentDuration = 6 * time.Second
+	}
+	if c.Media.HLSTimeout == 0 {
+		c.Media.HLSTimeout = 2 * time.Minute
+	}
+
+	// qBittorrent 兼容接口默认配置
+	if c.QBittorrent.SavePath == "" {
+		c.QBittorrent.SavePath = "/mnt/webdav"
+	}
+	if c.QBittorrent.SessionTTL == 0 {
+		c.QBittorrent.SessionTTL = time.Hour
+	}
+	if c.Transmission.DownloadDir == "" {
+		c.Transmission.DownloadDir = "/mnt/webdav"
+	}
+	if c.Aria2.Dir == "" {
+		c.Aria2.Dir = "/mnt/webdav"
+	}
+
+	if secret := os.Getenv("SHARE_SECRET"); secret != "" {
+		c.Share.Secret = secret
+	}
+	if secret := os.Getenv("ARIA2_SECRET"); secret != "" {
+		c.Aria2.Secret = secret
+	}
 }
 
 // 创建必要的目录
@@ -247,6 +564,20 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("server port is required")
 	}
 
+	// 验证 TLS 配置
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		return fmt.Errorf("server tls_cert and tls_key must be set together")
+	}
+	if !c.Server.TLSEnabled() && (c.Server.HTTPRedirectPort != "" || c.Server.TLSClientCA != "") {
+		return fmt.Errorf("http_redirect_port and tls_client_ca require tls_cert and tls_key")
+	}
+	if c.Server.TLSClientAuth != "optional" && c.Server.TLSClientAuth != "require" {
+		return fmt.Errorf("unsupported tls_client_auth: %s", c.Server.TLSClientAuth)
+	}
+	if c.Server.HealthPort != "" && (c.Server.HealthPort == c.Server.Port || c.Server.HealthPort == c.Server.HTTPRedirectPort) {
+		return fmt.Errorf("server health_port must differ from port and http_redirect_port")
+	}
+
 	// 验证数据库配置
 	supportedDrivers := map[string]bool{
 		"sqlite":    true,
@@ -259,6 +590,68 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("unsupported database driver: %s", c.Database.Driver)
 	}
 
+	// 验证认证方式
+	switch c.Auth.Scheme {
+	case AuthSchemeBasic, AuthSchemeDigest, AuthSchemeBoth:
+	default:
+		return fmt.Errorf("unsupported auth scheme: %s", c.Auth.Scheme)
+	}
+
+	// 验证用户配置
+	seenUsers := make(map[string]bool)
+	for _, u := range c.Auth.AllUsers() {
+		if u.Username == "" {
+			return fmt.Errorf("auth user without username")
+		}
+		if seenUsers[u.Username] {
+			return fmt.Errorf("duplicate auth user: %s", u.Username)
+		}
+		seenUsers[u.Username] = true
+	}
+
+	// 验证 aria2 配置，启用认证时不允许无密钥访问
+	if c.Aria2.Enabled {
+		if c.Auth.Enabled && c.Aria2.Secret == "" {
+			return fmt.Errorf("aria2 secret is required when auth is enabled")
+		}
+		if c.Aria2.User != "" && c.Auth.FindUser(c.Aria2.User) == nil {
+			return fmt.Errorf("aria2 user %s is not an auth user", c.Aria2.User)
+		}
+	}
+
+	// 验证 Webhook 配置
+	seenEndpoints := make(map[string]bool)
+	for _, endpoint := range c.Webhook.Endpoints {
+		if endpoint.Name == "" {
+			return fmt.Errorf("webhook endpoint without name")
+		}
+		if seenEndpoints[endpoint.Name] {
+			return fmt.Errorf("duplicate webhook endpoint: %s", endpoint.Name)
+		}
+		seenEndpoints[endpoint.Name] = true
+		if !strings.HasPrefix(endpoint.URL, "http://") && !strings.HasPrefix(endpoint.URL, "https://") {
+			return fmt.Errorf("webhook endpoint %s: url must start with http:// or https://", endpoint.Name)
+		}
+	}
+
+	// 验证搜索源配置
+	seenProviders := make(map[string]bool)
+	for _, provider := range c.Search.Providers {
+		if provider.Name == "" {
+			return fmt.Errorf("search provider without name")
+		}
+		if seenProviders[provider.Name] {
+			return fmt.Errorf("duplicate search provider: %s", provider.Name)
+		}
+		seenProviders[provider.Name] = true
+		if provider.Type != "torznab" && provider.Type != "newznab" {
+			return fmt.Errorf("search provider %s: unsupported type %s", provider.Name, provider.Type)
+		}
+		if !strings.HasPrefix(provider.URL, "http://") && !strings.HasPrefix(provider.URL, "https://") {
+			return fmt.Errorf("search provider %s: url must start with http:// or https://", provider.Name)
+		}
+	}
+
 	// 验证数据库特定配置
 	switch c.Database.Driver {
 	case "mysql", "postgres", "sqlserver":
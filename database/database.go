@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"log"
 	"magnet-webdav/config"
+	"magnet-webdav/metrics"
 	"magnet-webdav/models"
+	"os"
+	"sort"
+	"time"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
@@ -39,11 +43,17 @@ func InitDB(cfg *config.Config) (*gorm.DB, error) {
 		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Database.Driver)
 	}
 	// GORM 配置
+	// SQL 日志和其他日志一样写到标准错误，命令行导出到标准输出时不会混在一起
+	sqlLogger := logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{
+		SlowThreshold: 200 * time.Millisecond,
+		LogLevel:      logger.Warn,
+		Colorful:      true,
+	})
 	gormConfig := &gorm.Config{}
 	if cfg.Server.Env == "development" {
-		gormConfig.Logger = logger.Default.LogMode(logger.Info)
+		gormConfig.Logger = sqlLogger.LogMode(logger.Info)
 	} else {
-		gormConfig.Logger = logger.Default.LogMode(logger.Warn)
+		gormConfig.Logger = sqlLogger.LogMode(logger.Warn)
 	}
 
 	// 连接数据库
@@ -67,6 +77,12 @@ func InitDB(cfg *config.Config) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	if cfg.Database.Driver == "sqlite" {
+		if err := dropStaleFTSTriggers(db); err != nil {
+			return nil, fmt.Errorf("failed to drop full-text triggers: %w", err)
+		}
+	}
+
 	// 自动迁移
 	if err := autoMigrate(db); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
@@ -85,6 +101,18 @@ func autoMigrate(db *gorm.DB) error {
 	models := []interface{}{
 		&models.Magnet{},
 		&models.File{},
+		&models.LibraryEntry{},
+		&models.MagnetShare{},
+		&models.ShareLink{},
+		&models.AuthEvent{},
+		&models.WebhookDelivery{},
+		&models.Tag{},
+		&models.EntryTag{},
+		&models.Category{},
+		&models.Feed{},
+		&models.FeedItem{},
+		&models.MediaInfo{},
+		&models.PlaybackProgress{},
 	}
 
 	// 执行迁移
@@ -98,6 +126,30 @@ func autoMigrate(db *gorm.DB) error {
 	return nil
 }
 
+// dropStaleFTSTriggers 当前构建不支持 FTS5 时，删除支持 FTS5 的构建留下的全文索引同步触发器（见 services/fulltext.go）。
+// 触发器保存在数据库文件中，不删除的话重建表的迁移和之后的写入都会失败（no such module: fts5）
+func dropStaleFTSTriggers(db *gorm.DB) error {
+	var enabled int
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled).Error; err != nil || enabled == 1 {
+		return err
+	}
+
+	var triggers []string
+	err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'trigger' AND name LIKE '%!_fts!_%' ESCAPE '!'").Scan(&triggers).Error
+	if err != nil {
+		return err
+	}
+	for _, name := range triggers {
+		if err := db.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s"`, name)).Error; err != nil {
+			return err
+		}
+	}
+	if len(triggers) > 0 {
+		log.Printf("Dropped %d full-text triggers, SQLite is built without FTS5", len(triggers))
+	}
+	return nil
+}
+
 // Close 关闭数据库连接
 func Close() error {
 	if DB == nil {
@@ -160,3 +212,26 @@ func GetStats() map[string]interface{} {
 
 	return stats
 }
+
+// CollectMetrics 输出 GetStats 中的表统计和连接池统计
+func CollectMetrics(w *metrics.Writer) {
+	stats := GetStats()
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var value float64
+		switch v := stats[key].(type) {
+		case int:
+			value = float64(v)
+		case int64:
+			value = float64(v)
+		default:
+			continue
+		}
+		w.Gauge("magnet_webdav_db_"+key, "Database statistic "+key+".", value)
+	}
+}
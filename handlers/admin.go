@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	authGuard *services.AuthGuard
+}
+
+func NewAdminHandler(authGuard *services.AuthGuard) *AdminHandler {
+	return &AdminHandler{
+		authGuard: authGuard,
+	}
+}
+
+func (h *AdminHandler) ListBans(c *gin.Context) {
+	c.JSON(http.StatusOK, h.authGuard.ListBans())
+}
+
+func (h *AdminHandler) ClearBan(c *gin.Context) {
+	if !h.authGuard.ClearBan(c.Param("scope"), c.Param("key")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ban not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ban cleared"})
+}
+
+func (h *AdminHandler) ListAuthEvents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	events, err := h.authGuard.ListEvents(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"errors"
+	"magnet-webdav/middleware"
 	"magnet-webdav/models"
 	"magnet-webdav/services"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,6 +23,37 @@ func NewAPIHandler(torrentService *services.TorrentService) *APIHandler {
 
 type AddMagnetRequest struct {
 	MagnetURI string `json:"magnet_uri" binding:"required"`
+	Name      string `json:"name"`
+}
+
+// BatchRequest 批量操作请求，add 使用 magnet_uris，其他操作使用 ids
+type BatchRequest struct {
+	Action     string   `json:"action" binding:"required"`
+	MagnetURIs []string `json:"magnet_uris"`
+	IDs        []string `json:"ids"`
+	DeleteData bool     `json:"delete_data"` // remove: 同时删除已下载的数据
+}
+
+type ShareRequest struct {
+	User  string `json:"user"`
+	Group string `json:"group"`
+}
+
+// respondError 根据错误类型返回对应的状态码
+func respondError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Magnet not found"})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidQuery) || errors.Is(err, services.ErrInvalidInput) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrNotReady) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
 
 func (h *APIHandler) AddMagnet(c *gin.Context) {
@@ -29,7 +63,7 @@ func (h *APIHandler) AddMagnet(c *gin.Context) {
 		return
 	}
 
-	magnet, err := h.torrentService.AddMagnet(req.MagnetURI)
+	magnet, err := h.torrentService.AddMagnet(middleware.CurrentUser(c.Request), req.MagnetURI, req.Name)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -38,61 +72,191 @@ func (h *APIHandler) AddMagnet(c *gin.Context) {
 	c.JSON(http.StatusCreated, magnet)
 }
 
+// ListMagnets 分页查询磁力链接，支持过滤、排序和搜索
 func (h *APIHandler) ListMagnets(c *gin.Context) {
-	var magnets []models.Magnet
+	query, err := parseMagnetQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	db := h.torrentService.DB()
-	if err := db.Order("last_accessed DESC").Find(&magnets).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	page, err := h.torrentService.ListMagnets(middleware.CurrentUser(c.Request), query)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, magnets)
+	c.JSON(http.StatusOK, page)
 }
 
-func (h *APIHandler) ListFiles(c *gin.Context) {
-	magnetID := c.Param("id")
+// GetMagnet 返回磁力链接详情，包括标签、文件和种子的实时状态
+func (h *APIHandler) GetMagnet(c *gin.Context) {
+	detail, err := h.torrentService.GetMagnetDetail(middleware.CurrentUser(c.Request), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 
-	var files []models.File
-	db := h.torrentService.DB()
+	c.JSON(http.StatusOK, detail)
+}
 
-	if err := db.Where("magnet_id = ?", magnetID).Order("file_index").Find(&files).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+// UpdateMagnet 修改显示名称、标签和做种策略，只能修改自己的条目
+func (h *APIHandler) UpdateMagnet(c *gin.Context) {
+	var req services.MagnetUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, files)
+	detail, err := h.torrentService.UpdateMagnet(middleware.CurrentUser(c.Request), c.Param("id"), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, detail)
 }
 
-func (h *APIHandler) RemoveMagnet(c *gin.Context) {
+// BatchMagnets 批量操作，任一条目失败时整个请求回滚并返回 422
+func (h *APIHandler) BatchMagnets(c *gin.Context) {
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.torrentService.BatchMagnets(middleware.CurrentUser(c.Request), req.Action, req.MagnetURIs, req.IDs, req.DeleteData)
+	if errors.Is(err, services.ErrBatchFailed) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "batch rolled back", "results": results})
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func (h *APIHandler) ListFiles(c *gin.Context) {
 	magnetID := c.Param("id")
 
-	// 从数据库中删除相关记录
-	db := h.torrentService.DB()
+	if !h.torrentService.CanAccess(middleware.CurrentUser(c.Request), magnetID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Magnet not found"})
+		return
+	}
 
-	// 删除文件记录
-	db.Where("magnet_id = ?", magnetID).Delete(&models.File{})
+	query, err := parseFileQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// 删除磁力记录
-	if err := db.Where("id = ?", magnetID).Delete(&models.Magnet{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	page, err := h.torrentService.ListFiles(magnetID, query)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Magnet removed successfully"})
+	c.JSON(http.StatusOK, page)
+}
+
+// RemoveMagnet 从媒体库删除磁力链接，delete_data=true 时同时删除已下载的数据
+func (h *APIHandler) RemoveMagnet(c *gin.Context) {
+	deleteData := false
+	if value := c.Query("delete_data"); value != "" {
+		var err error
+		if deleteData, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delete_data: " + value})
+			return
+		}
+	}
+
+	result, err := h.torrentService.RemoveMagnet(middleware.CurrentUser(c.Request), c.Param("id"), deleteData)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Magnet removed successfully",
+		"orphaned":     result.Orphaned,
+		"data_deleted": result.DataDeleted,
+	})
 }
 
 func (h *APIHandler) GetStats(c *gin.Context) {
 	var stats models.Stats
 
+	user := middleware.CurrentUser(c.Request)
 	db := h.torrentService.DB()
 
 	// 获取统计信息
-	db.Model(&models.Magnet{}).Count(&stats.TotalMagnets)
-	db.Model(&models.File{}).Count(&stats.TotalFiles)
+	var magnetIDs []string
+	h.torrentService.VisibleMagnetIDs(user).Pluck("library_entries.magnet_id", &magnetIDs)
+	stats.TotalMagnets = int64(len(magnetIDs))
+	db.Model(&models.File{}).Where("magnet_id IN (?)", h.torrentService.VisibleMagnetIDs(user)).Count(&stats.TotalFiles)
 
 	// 获取活跃种子数量
-	stats.ActiveTorrents = h.torrentService.GetActiveTorrentCount()
+	for _, magnetID := range magnetIDs {
+		if h.torrentService.GetTorrent(magnetID) != nil {
+			stats.ActiveTorrents++
+		}
+	}
 
 	c.JSON(http.StatusOK, stats)
 }
+
+func (h *APIHandler) ListShares(c *gin.Context) {
+	shares, err := h.torrentService.ListShares(middleware.CurrentUser(c.Request), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+func (h *APIHandler) ShareMagnet(c *gin.Context) {
+	var req ShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	targetType, target := services.ShareTargetUser, req.User
+	if req.Group != "" {
+		targetType, target = services.ShareTargetGroup, req.Group
+	}
+	if target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user or group is required"})
+		return
+	}
+
+	share, err := h.torrentService.ShareMagnet(middleware.CurrentUser(c.Request), c.Param("id"), targetType, target)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, share)
+}
+
+func (h *APIHandler) UnshareMagnet(c *gin.Context) {
+	shareID, err := strconv.ParseUint(c.Param("share_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share id"})
+		return
+	}
+
+	if err := h.torrentService.UnshareMagnet(middleware.CurrentUser(c.Request), c.Param("id"), uint(shareID)); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share removed successfully"})
+}
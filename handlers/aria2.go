@@ -0,0 +1,757 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"magnet-webdav/services"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// aria2Version 兼容接口报告的 aria2 版本
+const aria2Version = "1.37.0"
+
+// aria2MaxRequestSize 单个请求的最大大小，aria2.addTorrent 中 base64 编码的 .torrent 会比原文件大三分之一
+const aria2MaxRequestSize = 24 << 20
+
+// JSON-RPC 错误码，业务错误和 aria2 一样使用 1
+const (
+	aria2ErrParse          = -32700
+	aria2ErrInvalidRequest = -32600
+	aria2ErrMethodNotFound = -32601
+	aria2ErrFailed         = 1
+)
+
+// aria2Methods system.listMethods 返回的方法
+var aria2Methods = []string{
+	"aria2.addUri", "aria2.addTorrent", "aria2.remove", "aria2.forceRemove",
+	"aria2.tellStatus", "aria2.getFiles", "aria2.tellActive", "aria2.tellWaiting", "aria2.tellStopped",
+	"aria2.getGlobalStat", "aria2.getGlobalOption", "aria2.getVersion",
+	"system.multicall", "system.listMethods", "system.listNotifications",
+}
+
+// aria2Notifications 通过 WebSocket 推送的通知
+var aria2Notifications = []string{
+	"aria2.onDownloadStart", "aria2.onDownloadStop", "aria2.onDownloadComplete", "aria2.onDownloadError",
+}
+
+// aria2Upgrader AriaNg 和浏览器扩展通常不和本服务同源，认证使用 token 而不是 Cookie，所以和 aria2 一样允许跨域连接
+var aria2Upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+type aria2Request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type aria2Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *aria2Error     `json:"error,omitempty"`
+}
+
+type aria2Notification struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type aria2Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *aria2Error) Error() string {
+	return e.Message
+}
+
+func aria2Failed(format string, args ...interface{}) *aria2Error {
+	return &aria2Error{Code: aria2ErrFailed, Message: fmt.Sprintf(format, args...)}
+}
+
+// Aria2Handler aria2 JSON-RPC (/jsonrpc) 的兼容实现，支持 HTTP POST 和 WebSocket。
+// GID 是媒体库条目 ID 的 16 位十六进制形式，所有请求都使用 aria2.user 的媒体库。
+// 错误的 token 和其他认证方式一样按客户端 IP 计入 guard
+type Aria2Handler struct {
+	torrentService *services.TorrentService
+	guard          *services.AuthGuard
+	cfg            *config.Aria2Config
+	user           *config.UserConfig
+	owner          string
+}
+
+func NewAria2Handler(torrentService *services.TorrentService, guard *services.AuthGuard, cfg *config.Config) *Aria2Handler {
+	owner := cfg.Aria2.User
+	if owner == "" {
+		owner = cfg.Auth.Username
+	}
+	// 未启用认证时 user 为 nil，和其他接口一样不做用户隔离
+	var user *config.UserConfig
+	if cfg.Auth.Enabled {
+		user = cfg.Auth.FindUser(owner)
+	}
+	return &Aria2Handler{
+		torrentService: torrentService,
+		guard:          guard,
+		cfg:            &cfg.Aria2,
+		user:           user,
+		owner:          owner,
+	}
+}
+
+// ServeRPC 处理 JSON-RPC 请求，WebSocket 握手请求使用 WebSocket 并推送下载通知
+func (h *Aria2Handler) ServeRPC(c *gin.Context) {
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.serveWebSocket(c)
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	if c.Request.Method == http.MethodOptions {
+		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type")
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if c.Request.Method != http.MethodPost {
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, aria2MaxRequestSize))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, aria2Response{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &aria2Error{Code: aria2ErrInvalidRequest, Message: "Request too large"}})
+		return
+	}
+	c.JSON(http.StatusOK, h.handleMessage(body, c.ClientIP()))
+}
+
+// handleMessage 处理单个请求或批量请求，clientIP 用于统计 token 错误次数
+func (h *Aria2Handler) handleMessage(data []byte, clientIP string) interface{} {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var batch []aria2Request
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return aria2ParseError(err)
+		}
+		responses := make([]aria2Response, 0, len(batch))
+		for i := range batch {
+			responses = append(responses, h.handleRequest(&batch[i], clientIP))
+		}
+		return responses
+	}
+
+	var req aria2Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return aria2ParseError(err)
+	}
+	return h.handleRequest(&req, clientIP)
+}
+
+func aria2ParseError(err error) aria2Response {
+	return aria2Response{JSONRPC: "2.0", ID: json.RawMessage("null"),
+		Error: &aria2Error{Code: aria2ErrParse, Message: "Parse error: " + err.Error()}}
+}
+
+func (h *Aria2Handler) handleRequest(req *aria2Request, clientIP string) aria2Response {
+	resp := aria2Response{JSONRPC: "2.0", ID: req.ID}
+	if len(resp.ID) == 0 {
+		resp.ID = json.RawMessage("null")
+	}
+	result, err := h.call(req.Method, req.Params, clientIP)
+	if err != nil {
+		resp.Error = toAria2Error(err)
+		return resp
+	}
+	resp.Result = result
+	return resp
+}
+
+func toAria2Error(err error) *aria2Error {
+	var rpcErr *aria2Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	return &aria2Error{Code: aria2ErrFailed, Message: err.Error()}
+}
+
+// call 校验 token 并调用方法。和 aria2 一样，system.listMethods 和 system.listNotifications 不需要 token
+func (h *Aria2Handler) call(method string, params []json.RawMessage, clientIP string) (interface{}, error) {
+	switch method {
+	case "system.listMethods":
+		return aria2Methods, nil
+	case "system.listNotifications":
+		return aria2Notifications, nil
+	case "system.multicall":
+		return h.multicall(params, clientIP)
+	}
+
+	params, err := h.checkToken(params, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case "aria2.addUri":
+		return h.addURI(params)
+	case "aria2.addTorrent":
+		return h.addTorrent(params)
+	case "aria2.remove", "aria2.forceRemove":
+		return h.remove(params)
+	case "aria2.tellStatus":
+		return h.tellStatus(params)
+	case "aria2.getFiles":
+		return h.getFiles(params)
+	case "aria2.tellActive":
+		return h.tellActive(params)
+	case "aria2.tellWaiting":
+		return []interface{}{}, nil
+	case "aria2.tellStopped":
+		return h.tellStopped(params)
+	case "aria2.getGlobalStat":
+		return h.getGlobalStat()
+	case "aria2.getGlobalOption":
+		return map[string]string{"dir": strings.TrimRight(h.cfg.Dir, `/\`)}, nil
+	case "aria2.getVersion":
+		return gin.H{"version": aria2Version, "enabledFeatures": []string{"BitTorrent"}}, nil
+	}
+	return nil, &aria2Error{Code: aria2ErrMethodNotFound, Message: "Method not found"}
+}
+
+// checkToken 校验并去掉第一个参数中的 token:<secret>，未配置密钥时不校验。
+// 错误的 token 计入客户端 IP 的失败次数，IP 被锁定时直接拒绝
+func (h *Aria2Handler) checkToken(params []json.RawMessage, clientIP string) ([]json.RawMessage, error) {
+	var token string
+	if len(params) > 0 && json.Unmarshal(params[0], &token) == nil && strings.HasPrefix(token, "token:") {
+		params = params[1:]
+	} else {
+		token = ""
+	}
+	if h.cfg.Secret == "" {
+		return params, nil
+	}
+
+	if remaining := h.guard.Check(clientIP, ""); remaining > 0 {
+		return nil, aria2Failed("Too many failed authentication attempts, retry after %d seconds", int(remaining.Seconds())+1)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte("token:"+h.cfg.Secret)) != 1 {
+		h.guard.RecordFailure(clientIP, "")
+		return nil, aria2Failed("Unauthorized")
+	}
+	h.guard.RecordSuccess(clientIP, "")
+	return params, nil
+}
+
+// multicall 依次调用多个方法，成功的结果包装为单元素数组，失败时为错误对象
+func (h *Aria2Handler) multicall(params []json.RawMessage, clientIP string) (interface{}, error) {
+	var calls []struct {
+		MethodName string            `json:"methodName"`
+		Params     []json.RawMessage `json:"params"`
+	}
+	if err := aria2Param(params, 0, &calls); err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, 0, len(calls))
+	for _, call := range calls {
+		if call.MethodName == "system.multicall" {
+			results = append(results, aria2Failed("Recursive system.multicall forbidden."))
+			continue
+		}
+		result, err := h.call(call.MethodName, call.Params, clientIP)
+		if err != nil {
+			results = append(results, toAria2Error(err))
+			continue
+		}
+		results = append(results, []interface{}{result})
+	}
+	return results, nil
+}
+
+// aria2Param 解析第 i 个参数，参数不存在时保留 v 的原值
+func aria2Param(params []json.RawMessage, i int, v interface{}) error {
+	if i >= len(params) {
+		return nil
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return aria2Failed("Invalid parameter %d: %v", i+1, err)
+	}
+	return nil
+}
+
+// formatGID 把媒体库条目 ID 转换为 aria2 的 GID
+func formatGID(entryID uint) string {
+	return fmt.Sprintf("%016x", entryID)
+}
+
+func (h *Aria2Handler) findByGID(params []json.RawMessage) (*models.LibraryMagnet, error) {
+	var gid string
+	if err := aria2Param(params, 0, &gid); err != nil {
+		return nil, err
+	}
+	entryID, err := strconv.ParseUint(gid, 16, 64)
+	if len(gid) != 16 || err != nil {
+		return nil, aria2Failed("Bad GID %s", gid)
+	}
+
+	var magnets []models.LibraryMagnet
+	if err := h.torrentService.OwnMagnets(h.user).Where("library_entries.id = ?", entryID).Scan(&magnets).Error; err != nil {
+		return nil, err
+	}
+	if len(magnets) == 0 {
+		return nil, aria2Failed("GID %s is not found", gid)
+	}
+	return &magnets[0], nil
+}
+
+// addURI 添加 uris 中的磁力链接，返回 GID
+func (h *Aria2Handler) addURI(params []json.RawMessage) (interface{}, error) {
+	var uris []string
+	if err := aria2Param(params, 0, &uris); err != nil {
+		return nil, err
+	}
+	for _, uri := range uris {
+		if !strings.HasPrefix(uri, "magnet:") {
+			continue
+		}
+		magnetURI, _, err := services.NormalizeMagnetURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		magnet, err := h.torrentService.AddMagnet(h.user, magnetURI, "")
+		if err != nil {
+			return nil, err
+		}
+		return formatGID(magnet.EntryID), nil
+	}
+	return nil, aria2Failed("No magnet URI specified, only magnet links are supported")
+}
+
+// addTorrent 添加 base64 编码的 .torrent 文件，返回 GID
+func (h *Aria2Handler) addTorrent(params []json.RawMessage) (interface{}, error) {
+	var encoded string
+	if err := aria2Param(params, 0, &encoded); err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, aria2Failed("Invalid base64 torrent data")
+	}
+	file, err := services.ParseTorrentFile(data)
+	if err != nil {
+		return nil, err
+	}
+	magnet, err := h.torrentService.AddTorrentFile(h.user, file, "")
+	if err != nil {
+		return nil, err
+	}
+	return formatGID(magnet.EntryID), nil
+}
+
+// remove 从媒体库删除条目，不删除已下载的数据
+func (h *Aria2Handler) remove(params []json.RawMessage) (interface{}, error) {
+	magnet, err := h.findByGID(params)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.torrentService.RemoveMagnet(h.user, magnet.ID, false); err != nil {
+		return nil, err
+	}
+	return formatGID(magnet.EntryID), nil
+}
+
+func (h *Aria2Handler) tellStatus(params []json.RawMessage) (interface{}, error) {
+	magnet, err := h.findByGID(params)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := aria2Param(params, 1, &keys); err != nil {
+		return nil, err
+	}
+	statuses, err := h.statuses([]models.LibraryMagnet{*magnet}, keys)
+	if err != nil {
+		return nil, err
+	}
+	return statuses[0], nil
+}
+
+func (h *Aria2Handler) getFiles(params []json.RawMessage) (interface{}, error) {
+	magnet, err := h.findByGID(params)
+	if err != nil {
+		return nil, err
+	}
+	files, err := h.torrentService.MagnetFiles([]string{magnet.ID})
+	if err != nil {
+		return nil, err
+	}
+	return h.files(magnet, files[magnet.ID]), nil
+}
+
+// tellActive 返回正在获取元数据和正在做种的下载
+func (h *Aria2Handler) tellActive(params []json.RawMessage) (interface{}, error) {
+	var keys []string
+	if err := aria2Param(params, 0, &keys); err != nil {
+		return nil, err
+	}
+	magnets, err := h.ownMagnets()
+	if err != nil {
+		return nil, err
+	}
+	active := make([]models.LibraryMagnet, 0, len(magnets))
+	for _, magnet := range magnets {
+		if h.status(&magnet) == "active" {
+			active = append(active, magnet)
+		}
+	}
+	return h.statuses(active, keys)
+}
+
+// tellStopped 返回已完成和出错的下载，offset 为负数时从末尾倒序返回
+func (h *Aria2Handler) tellStopped(params []json.RawMessage) (interface{}, error) {
+	var offset, num int
+	var keys []string
+	if err := aria2Param(params, 0, &offset); err != nil {
+		return nil, err
+	}
+	if err := aria2Param(params, 1, &num); err != nil {
+		return nil, err
+	}
+	if err := aria2Param(params, 2, &keys); err != nil {
+		return nil, err
+	}
+
+	magnets, err := h.ownMagnets()
+	if err != nil {
+		return nil, err
+	}
+	stopped := make([]models.LibraryMagnet, 0, len(magnets))
+	for _, magnet := range magnets {
+		if h.status(&magnet) != "active" {
+			stopped = append(stopped, magnet)
+		}
+	}
+
+	// offset 和 num 来自客户端，按已停止的下载数量限制后再分配
+	if num < 0 || offset >= len(stopped) || offset < -len(stopped) {
+		num = 0
+	}
+	if num > len(stopped) {
+		num = len(stopped)
+	}
+	page := make([]models.LibraryMagnet, 0, num)
+	if offset >= 0 {
+		for i := offset; i < len(stopped) && len(page) < num; i++ {
+			page = append(page, stopped[i])
+		}
+	} else {
+		for i := len(stopped) + offset; i >= 0 && len(page) < num; i-- {
+			page = append(page, stopped[i])
+		}
+	}
+	return h.statuses(page, keys)
+}
+
+func (h *Aria2Handler) getGlobalStat() (interface{}, error) {
+	magnets, err := h.ownMagnets()
+	if err != nil {
+		return nil, err
+	}
+	active := 0
+	for _, magnet := range magnets {
+		if h.status(&magnet) == "active" {
+			active++
+		}
+	}
+	stopped := strconv.Itoa(len(magnets) - active)
+	return map[string]string{
+		"downloadSpeed":   "0",
+		"uploadSpeed":     "0",
+		"numActive":       strconv.Itoa(active),
+		"numWaiting":      "0",
+		"numStopped":      stopped,
+		"numStoppedTotal": stopped,
+	}, nil
+}
+
+func (h *Aria2Handler) ownMagnets() ([]models.LibraryMagnet, error) {
+	magnets := []models.LibraryMagnet{}
+	err := h.torrentService.OwnMagnets(h.user).Order("library_entries.id").Scan(&magnets).Error
+	return magnets, err
+}
+
+// status 把种子状态映射为 aria2 的状态：获取元数据中和做种中为 active，不在客户端中为 complete
+func (h *Aria2Handler) status(magnet *models.LibraryMagnet) string {
+	switch magnet.Status {
+	case "pending":
+		return "active"
+	case "error":
+		return "error"
+	}
+	if h.torrentService.GetTorrent(magnet.ID) != nil {
+		return "active"
+	}
+	return "complete"
+}
+
+// statuses 生成 tellStatus 格式的结果，keys 为空时返回全部字段。和 aria2 一样，数值都是字符串
+func (h *Aria2Handler) statuses(magnets []models.LibraryMagnet, keys []string) ([]map[string]interface{}, error) {
+	wantFiles := len(keys) == 0
+	for _, key := range keys {
+		if key == "files" {
+			wantFiles = true
+		}
+	}
+	var files map[string][]models.File
+	if wantFiles {
+		magnetIDs := make([]string, 0, len(magnets))
+		for _, magnet := range magnets {
+			magnetIDs = append(magnetIDs, magnet.ID)
+		}
+		var err error
+		if files, err = h.torrentService.MagnetFiles(magnetIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]map[string]interface{}, 0, len(magnets))
+	for i := range magnets {
+		all := h.statusFields(&magnets[i], files[magnets[i].ID], wantFiles)
+		if len(keys) == 0 {
+			result = append(result, all)
+			continue
+		}
+		selected := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			if value, ok := all[key]; ok {
+				selected[key] = value
+			}
+		}
+		result = append(result, selected)
+	}
+	return result, nil
+}
+
+func (h *Aria2Handler) statusFields(magnet *models.LibraryMagnet, files []models.File, wantFiles bool) map[string]interface{} {
+	state := h.torrentService.TorrentState(magnet.ID)
+	status := h.status(magnet)
+
+	completed := magnet.BytesCompleted
+	var uploaded, pieceLength int64
+	numPieces, connections, seeders := 0, 0, 0
+	if state != nil {
+		completed = state.BytesCompleted
+		uploaded = state.BytesUploaded
+		pieceLength = state.PieceLength
+		numPieces = state.PiecesTotal
+		connections = state.ActivePeers
+		seeders = state.Seeders
+	}
+	// 文件通过 WebDAV 按需读取，获取到元数据即视为下载完成
+	if magnet.Status == "ready" {
+		completed = magnet.TotalSize
+	}
+	errorCode, errorMessage := "0", ""
+	if status == "error" {
+		errorCode, errorMessage = "1", magnet.Name
+	}
+
+	fields := map[string]interface{}{
+		"gid":             formatGID(magnet.EntryID),
+		"status":          status,
+		"totalLength":     strconv.FormatInt(magnet.TotalSize, 10),
+		"completedLength": strconv.FormatInt(completed, 10),
+		"uploadLength":    strconv.FormatInt(uploaded, 10),
+		"downloadSpeed":   "0",
+		"uploadSpeed":     "0",
+		"infoHash":        magnet.ID,
+		"numSeeders":      strconv.Itoa(seeders),
+		"seeder":          strconv.FormatBool(magnet.Status == "ready"),
+		"pieceLength":     strconv.FormatInt(pieceLength, 10),
+		"numPieces":       strconv.Itoa(numPieces),
+		"connections":     strconv.Itoa(connections),
+		"errorCode":       errorCode,
+		"errorMessage":    errorMessage,
+		"dir":             h.dir(magnet.ID),
+		"bittorrent":      gin.H{},
+	}
+	// 获取元数据期间和 aria2 一样不返回 info
+	if magnet.Status != "pending" {
+		fields["bittorrent"] = gin.H{"info": gin.H{"name": magnetDisplayName(magnet)}}
+	}
+	if wantFiles {
+		fields["files"] = h.files(magnet, files)
+	}
+	return fields
+}
+
+// files 生成 getFiles 格式的文件列表，获取元数据期间和 aria2 一样返回 [METADATA] 占位文件
+func (h *Aria2Handler) files(magnet *models.LibraryMagnet, files []models.File) []gin.H {
+	if len(files) == 0 && magnet.Status == "pending" {
+		return []gin.H{{
+			"index": "1", "path": "[METADATA]" + magnetDisplayName(magnet),
+			"length": "0", "completedLength": "0", "selected": "true", "uris": []string{},
+		}}
+	}
+
+	result := make([]gin.H, 0, len(files))
+	for i, file := range files {
+		completed := int64(0)
+		if magnet.Status == "ready" {
+			completed = file.FileSize
+		}
+		result = append(result, gin.H{
+			"index":           strconv.Itoa(i + 1),
+			"path":            h.dir(magnet.ID) + "/" + file.FilePath,
+			"length":          strconv.FormatInt(file.FileSize, 10),
+			"completedLength": strconv.FormatInt(completed, 10),
+			"selected":        "true",
+			"uris":            []string{},
+		})
+	}
+	return result
+}
+
+// dir 种子在 WebDAV 挂载中的目录
+func (h *Aria2Handler) dir(magnetID string) string {
+	return strings.TrimRight(h.cfg.Dir, `/\`) + "/" + magnetID
+}
+
+// serveWebSocket 在 WebSocket 上处理请求，并把媒体库事件转换为 aria2.onDownload* 通知
+func (h *Aria2Handler) serveWebSocket(c *gin.Context) {
+	conn, err := aria2Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade 已经写入了错误响应
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := h.torrentService.Events().Subscribe()
+	defer sub.Close()
+
+	// 响应和通知来自不同的 goroutine，写入需要加锁
+	var writeMutex sync.Mutex
+	write := func(v interface{}) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+		return conn.WriteJSON(v)
+	}
+
+	closed := make(chan struct{})
+	conn.SetReadLimit(aria2MaxRequestSize)
+	conn.SetReadDeadline(time.Now().Add(2 * eventsHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * eventsHeartbeat))
+	})
+	clientIP := c.ClientIP()
+	go func() {
+		defer close(closed)
+		// 这个 goroutine 不在 gin 的 Recovery 保护范围内，panic 时只关闭当前连接
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic in aria2 WebSocket handler: %v\n%s", r, debug.Stack())
+			}
+		}()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(2 * eventsHeartbeat))
+			if err := write(h.handleMessage(data, clientIP)); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			method, gid := h.notification(event)
+			if method == "" {
+				continue
+			}
+			if err := write(aria2Notification{JSONRPC: "2.0", Method: method, Params: []interface{}{gin.H{"gid": gid}}}); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			writeMutex.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsWriteTimeout))
+			writeMutex.Unlock()
+			if err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// notification 把媒体库事件转换为 aria2 通知，与 aria2.user 的媒体库无关的事件返回空字符串
+func (h *Aria2Handler) notification(event services.Event) (string, string) {
+	switch event.Type {
+	case services.EventMagnetRemoved:
+		entryID, ok := event.Data["entry_id"].(uint)
+		if event.Owner != h.owner || !ok {
+			return "", ""
+		}
+		return "aria2.onDownloadStop", formatGID(entryID)
+
+	case services.EventMagnetAdded:
+		if event.Owner != h.owner {
+			return "", ""
+		}
+		return h.entryNotification("aria2.onDownloadStart", event.MagnetID)
+
+	case services.EventStatusChanged:
+		switch event.Data["status"] {
+		case "pending":
+			return h.entryNotification("aria2.onDownloadStart", event.MagnetID)
+		case "ready":
+			return h.entryNotification("aria2.onDownloadComplete", event.MagnetID)
+		case "error":
+			return h.entryNotification("aria2.onDownloadError", event.MagnetID)
+		}
+	}
+	return "", ""
+}
+
+func (h *Aria2Handler) entryNotification(method, magnetID string) (string, string) {
+	entry, err := h.torrentService.GetOwnEntry(h.user, magnetID)
+	if err != nil {
+		if !errors.Is(err, services.ErrNotFound) {
+			log.Printf("Failed to load library entry of %s: %v", magnetID, err)
+		}
+		return "", ""
+	}
+	return method, formatGID(entry.ID)
+}
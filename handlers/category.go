@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CategoryRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentID uint   `json:"parent_id"`
+}
+
+type TagRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// respondLabelError 和 respondError 相同，但找不到记录时使用对应的提示
+func respondLabelError(c *gin.Context, err error, notFound string) {
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+	respondError(c, err)
+}
+
+func parseIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// ListTags 列出当前用户媒体库中使用的标签
+func (h *APIHandler) ListTags(c *gin.Context) {
+	tags, err := h.torrentService.ListTags(middleware.CurrentUser(c.Request))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
+
+// RenameTag 重命名标签（管理员）
+func (h *APIHandler) RenameTag(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	var req TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag, err := h.torrentService.RenameTag(id, req.Name)
+	if err != nil {
+		respondLabelError(c, err, "Tag not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, tag)
+}
+
+// DeleteTag 删除标签（管理员）
+func (h *APIHandler) DeleteTag(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.torrentService.DeleteTag(id); err != nil {
+		respondLabelError(c, err, "Tag not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+}
+
+// ListCategories 返回分类树，包含当前用户在每个分类中的磁力链接数
+func (h *APIHandler) ListCategories(c *gin.Context) {
+	tree, err := h.torrentService.CategoryTree(middleware.CurrentUser(c.Request))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tree)
+}
+
+// CreateCategory 创建分类（管理员）
+func (h *APIHandler) CreateCategory(c *gin.Context) {
+	var req CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	category, err := h.torrentService.CreateCategory(req.Name, req.ParentID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, category)
+}
+
+// UpdateCategory 重命名或移动分类（管理员）
+func (h *APIHandler) UpdateCategory(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	var req services.CategoryUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	category, err := h.torrentService.UpdateCategory(id, req)
+	if err != nil {
+		respondLabelError(c, err, "Category not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+// DeleteCategory 删除分类（管理员），其中的磁力链接移动到上级分类
+func (h *APIHandler) DeleteCategory(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.torrentService.DeleteCategory(id); err != nil {
+		respondLabelError(c, err, "Category not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// eventsHeartbeat SSE 注释行和 WebSocket ping 的间隔，防止代理断开空闲连接
+	eventsHeartbeat = 15 * time.Second
+	// eventsWriteTimeout 单次写入的超时时间
+	eventsWriteTimeout = 10 * time.Second
+	// visibilityTTL 每个连接缓存磁力链接可见性的时间，共享变化后最多延迟这么久生效
+	visibilityTTL = 5 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+type EventsHandler struct {
+	torrentService *services.TorrentService
+	done           chan struct{}
+	closeOnce      sync.Once
+}
+
+func NewEventsHandler(torrentService *services.TorrentService) *EventsHandler {
+	return &EventsHandler{
+		torrentService: torrentService,
+		done:           make(chan struct{}),
+	}
+}
+
+// Close 结束所有 SSE 和 WebSocket 连接。http.Server.Shutdown 不会取消进行中请求的上下文，
+// 需要通过 RegisterOnShutdown 调用，否则打开的管理页面会让关闭一直等到超时
+func (h *EventsHandler) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// Stream 推送媒体库和种子事件，WebSocket 握手请求使用 WebSocket，否则使用 SSE
+func (h *EventsHandler) Stream(c *gin.Context) {
+	filter := newEventFilter(h.torrentService, middleware.CurrentUser(c.Request))
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.serveWebSocket(c, filter)
+		return
+	}
+	h.serveSSE(c, filter)
+}
+
+func (h *EventsHandler) serveSSE(c *gin.Context, filter *eventFilter) {
+	// 先订阅再读取历史，避免两者之间的事件丢失
+	sub := h.torrentService.Events().Subscribe()
+	defer sub.Close()
+
+	// 长连接不受服务器 WriteTimeout 限制，每次写入单独设置超时
+	rc := http.NewResponseController(c.Writer)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	write := func(payload string) bool {
+		rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+		if _, err := c.Writer.WriteString(payload); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	// 浏览器重连时通过 Last-Event-ID 补发错过的事件
+	var lastID uint64
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		if parsed, err := strconv.ParseUint(id, 10, 64); err == nil {
+			lastID = parsed
+			for _, event := range h.torrentService.Events().Since(lastID) {
+				lastID = event.ID
+				if filter.allow(event) && !write(formatSSE(event)) {
+					return
+				}
+			}
+		}
+	}
+
+	if !write(": connected\n\n") {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if event.ID <= lastID || !filter.allow(event) {
+				continue
+			}
+			lastID = event.ID
+			if !write(formatSSE(event)) {
+				return
+			}
+		case <-heartbeat.C:
+			if !write(": ping\n\n") {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func formatSSE(event services.Event) string {
+	data, _ := json.Marshal(event)
+	return fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
+
+func (h *EventsHandler) serveWebSocket(c *gin.Context, filter *eventFilter) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade 已经写入了错误响应
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := h.torrentService.Events().Subscribe()
+	defer sub.Close()
+
+	// 客户端不会发送业务消息，读循环只用于处理 pong 和关闭帧
+	closed := make(chan struct{})
+	conn.SetReadLimit(1024)
+	conn.SetReadDeadline(time.Now().Add(2 * eventsHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * eventsHeartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !filter.allow(event) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-h.done:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(eventsWriteTimeout))
+			return
+		}
+	}
+}
+
+// eventFilter 只放行当前用户能看到的磁力链接的事件
+type eventFilter struct {
+	torrentService *services.TorrentService
+	user           *config.UserConfig
+	visible        map[string]bool
+	checkedAt      map[string]time.Time
+}
+
+func newEventFilter(torrentService *services.TorrentService, user *config.UserConfig) *eventFilter {
+	return &eventFilter{
+		torrentService: torrentService,
+		user:           user,
+		visible:        make(map[string]bool),
+		checkedAt:      make(map[string]time.Time),
+	}
+}
+
+func (f *eventFilter) allow(event services.Event) bool {
+	// 未启用认证时所有事件都可见
+	if f.user == nil {
+		return true
+	}
+	// 针对某个用户媒体库条目的事件只发给该用户
+	if event.Owner != "" {
+		return event.Owner == f.user.Username
+	}
+
+	if at, ok := f.checkedAt[event.MagnetID]; ok && time.Since(at) < visibilityTTL {
+		return f.visible[event.MagnetID]
+	}
+	visible := f.torrentService.CanAccess(f.user, event.MagnetID)
+	f.visible[event.MagnetID] = visible
+	f.checkedAt[event.MagnetID] = time.Now()
+	return visible
+}
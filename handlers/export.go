@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize 导入文件的最大大小
+const maxImportSize = 256 << 20
+
+type ExportHandler struct {
+	torrentService *services.TorrentService
+	importService  *services.ImportService
+}
+
+func NewExportHandler(torrentService *services.TorrentService, importService *services.ImportService) *ExportHandler {
+	return &ExportHandler{
+		torrentService: torrentService,
+		importService:  importService,
+	}
+}
+
+// Export 导出当前用户的媒体库，format=json（默认）或 tar
+func (h *ExportHandler) Export(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "tar" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format: " + format})
+		return
+	}
+	withMetainfo := true
+	if value := c.Query("metainfo"); value != "" {
+		var err error
+		if withMetainfo, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metainfo: " + value})
+			return
+		}
+	}
+
+	export, err := h.torrentService.ExportLibrary(middleware.CurrentUser(c.Request), withMetainfo || format == "tar")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	filename := fmt.Sprintf("magnet-webdav-%s.%s", time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if format == "json" {
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Status(http.StatusOK)
+	if err := services.WriteExportTar(c.Writer, export); err != nil {
+		c.Error(err)
+	}
+}
+
+// Import 导入 JSON 或 tar 格式的媒体库，在后台执行并返回任务，通过 GET /api/import/:id 查询进度
+func (h *ExportHandler) Import(c *gin.Context) {
+	lib, err := services.ReadLibraryImport(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "import file too large"})
+			return
+		}
+		respondError(c, err)
+		return
+	}
+
+	job := h.importService.Start(middleware.CurrentUser(c.Request), lib)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetImport 查询导入任务的进度
+func (h *ExportHandler) GetImport(c *gin.Context) {
+	job, err := h.importService.Get(middleware.CurrentUser(c.Request), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+			return
+		}
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
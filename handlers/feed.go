@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FeedHandler struct {
+	feedService *services.FeedService
+}
+
+func NewFeedHandler(feedService *services.FeedService) *FeedHandler {
+	return &FeedHandler{
+		feedService: feedService,
+	}
+}
+
+// ListFeeds 列出当前用户的订阅
+func (h *FeedHandler) ListFeeds(c *gin.Context) {
+	feeds, err := h.feedService.ListFeeds(middleware.CurrentUser(c.Request))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, feeds)
+}
+
+// CreateFeed 创建订阅
+func (h *FeedHandler) CreateFeed(c *gin.Context) {
+	var req services.FeedUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	feed, err := h.feedService.CreateFeed(middleware.CurrentUser(c.Request), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, feed)
+}
+
+func (h *FeedHandler) GetFeed(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	feed, err := h.feedService.GetFeed(middleware.CurrentUser(c.Request), id)
+	if err != nil {
+		respondLabelError(c, err, "Feed not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, feed)
+}
+
+// UpdateFeed 修改订阅，只更新请求中出现的字段
+func (h *FeedHandler) UpdateFeed(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	var req services.FeedUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	feed, err := h.feedService.UpdateFeed(middleware.CurrentUser(c.Request), id, req)
+	if err != nil {
+		respondLabelError(c, err, "Feed not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, feed)
+}
+
+func (h *FeedHandler) DeleteFeed(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.feedService.DeleteFeed(middleware.CurrentUser(c.Request), id); err != nil {
+		respondLabelError(c, err, "Feed not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Feed removed successfully"})
+}
+
+// RefreshFeed 立即拉取订阅并返回本次处理的结果
+func (h *FeedHandler) RefreshFeed(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.feedService.Refresh(middleware.CurrentUser(c.Request), id)
+	if err != nil {
+		respondLabelError(c, err, "Feed not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ListFeedItems 查看订阅处理过的条目，可按 status 过滤
+func (h *FeedHandler) ListFeedItems(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	items, err := h.feedService.ListItems(middleware.CurrentUser(c.Request), id, c.Query("status"), limit)
+	if err != nil {
+		respondLabelError(c, err, "Feed not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"magnet-webdav/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	healthService *services.HealthService
+	info          gin.H
+}
+
+// NewHealthHandler info 为 /health 额外输出的版本等静态信息
+func NewHealthHandler(healthService *services.HealthService, info gin.H) *HealthHandler {
+	return &HealthHandler{
+		healthService: healthService,
+		info:          info,
+	}
+}
+
+// Liveness 进程能处理请求即视为存活，不检查外部依赖，避免依赖故障导致容器被反复重启
+func (h *HealthHandler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": services.HealthStatusOK})
+}
+
+// Readiness 执行所有就绪检查，任意一项失败时返回 503
+func (h *HealthHandler) Readiness(c *gin.Context) {
+	report := h.healthService.Ready(c.Request.Context())
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// Health 兼容旧版 /health，在静态信息之外附带就绪检查结果
+func (h *HealthHandler) Health(c *gin.Context) {
+	report := h.healthService.Ready(c.Request.Context())
+
+	body := gin.H{"status": "healthy", "checks": report.Checks}
+	for key, value := range h.info {
+		body[key] = value
+	}
+	status := http.StatusOK
+	if !report.OK() {
+		body["status"] = "unhealthy"
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MediaHandler struct {
+	mediaService *services.MediaService
+}
+
+func NewMediaHandler(mediaService *services.MediaService) *MediaHandler {
+	return &MediaHandler{
+		mediaService: mediaService,
+	}
+}
+
+// ListMediaInfo 列出种子中已经解析过的视频文件
+func (h *MediaHandler) ListMediaInfo(c *gin.Context) {
+	infos, err := h.mediaService.ListMediaInfo(middleware.CurrentUser(c.Request), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, infos)
+}
+
+// GetMediaInfo 返回文件的时长、编码和轨道，还没有解析过时立即解析
+func (h *MediaHandler) GetMediaInfo(c *gin.Context) {
+	h.mediaInfo(c, false)
+}
+
+// ProbeMedia 重新解析文件
+func (h *MediaHandler) ProbeMedia(c *gin.Context) {
+	h.mediaInfo(c, true)
+}
+
+func (h *MediaHandler) mediaInfo(c *gin.Context, refresh bool) {
+	fileIndex, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file index"})
+		return
+	}
+
+	info, err := h.mediaService.GetMediaInfo(middleware.CurrentUser(c.Request), c.Param("id"), fileIndex, refresh)
+	if err != nil {
+		respondLabelError(c, err, "File not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PlaybackHandler struct {
+	playbackService *services.PlaybackService
+}
+
+func NewPlaybackHandler(playbackService *services.PlaybackService) *PlaybackHandler {
+	return &PlaybackHandler{
+		playbackService: playbackService,
+	}
+}
+
+type SaveProgressRequest struct {
+	Position *float64 `json:"position" binding:"required"` // 秒
+	Duration float64  `json:"duration"`
+}
+
+// GetPlayer 返回播放页需要的文件信息、字幕和播放位置
+func (h *PlaybackHandler) GetPlayer(c *gin.Context) {
+	fileIndex, ok := parseFileIndex(c)
+	if !ok {
+		return
+	}
+
+	info, err := h.playbackService.Player(middleware.CurrentUser(c.Request), c.Param("id"), fileIndex)
+	if err != nil {
+		respondLabelError(c, err, "File not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// GetFileState 返回文件的分片完成情况，播放页用于显示缓冲和下载进度
+func (h *PlaybackHandler) GetFileState(c *gin.Context) {
+	fileIndex, ok := parseFileIndex(c)
+	if !ok {
+		return
+	}
+
+	state, err := h.playbackService.FileState(middleware.CurrentUser(c.Request), c.Param("id"), fileIndex)
+	if err != nil {
+		respondLabelError(c, err, "File not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+// GetProgress 返回当前用户在文件上的播放位置
+func (h *PlaybackHandler) GetProgress(c *gin.Context) {
+	fileIndex, ok := parseFileIndex(c)
+	if !ok {
+		return
+	}
+
+	progress, err := h.playbackService.GetProgress(middleware.CurrentUser(c.Request), c.Param("id"), fileIndex)
+	if err != nil {
+		respondLabelError(c, err, "File not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// SaveProgress 保存当前用户在文件上的播放位置
+func (h *PlaybackHandler) SaveProgress(c *gin.Context) {
+	fileIndex, ok := parseFileIndex(c)
+	if !ok {
+		return
+	}
+	var req SaveProgressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	progress, err := h.playbackService.SaveProgress(middleware.CurrentUser(c.Request), c.Param("id"), fileIndex, *req.Position, req.Duration)
+	if err != nil {
+		respondLabelError(c, err, "File not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// DeleteProgress 删除当前用户在文件上的播放位置
+func (h *PlaybackHandler) DeleteProgress(c *gin.Context) {
+	fileIndex, ok := parseFileIndex(c)
+	if !ok {
+		return
+	}
+
+	if err := h.playbackService.DeleteProgress(middleware.CurrentUser(c.Request), c.Param("id"), fileIndex); err != nil {
+		respondLabelError(c, err, "File not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Progress deleted successfully"})
+}
+
+// ListProgress 列出当前用户最近的播放记录
+func (h *PlaybackHandler) ListProgress(c *gin.Context) {
+	progress, err := h.playbackService.ListProgress(middleware.CurrentUser(c.Request))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+func parseFileIndex(c *gin.Context) (int, bool) {
+	fileIndex, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file index"})
+		return 0, false
+	}
+	return fileIndex, true
+}
@@ -0,0 +1,691 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"magnet-webdav/config"
+	"magnet-webdav/middleware"
+	"magnet-webdav/models"
+	"magnet-webdav/services"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 兼容接口报告的 qBittorrent 版本，Sonarr/Radarr 根据 Web API 版本选择使用的功能
+const (
+	qbAppVersion    = "v4.6.7"
+	qbWebAPIVersion = "2.9.3"
+)
+
+const (
+	// qbMaxTorrentSize 上传的 .torrent 文件的最大大小
+	qbMaxTorrentSize = 16 << 20
+	// qbETAInfinity qBittorrent 表示无法估计剩余时间的值
+	qbETAInfinity = 8640000
+)
+
+// qbTorrent torrents/info 返回的种子信息
+type qbTorrent struct {
+	Hash                     string  `json:"hash"`
+	Name                     string  `json:"name"`
+	MagnetURI                string  `json:"magnet_uri"`
+	Size                     int64   `json:"size"`
+	TotalSize                int64   `json:"total_size"`
+	Progress                 float64 `json:"progress"`
+	DLSpeed                  int64   `json:"dlspeed"`
+	UPSpeed                  int64   `json:"upspeed"`
+	State                    string  `json:"state"`
+	Category                 string  `json:"category"`
+	Tags                     string  `json:"tags"`
+	SavePath                 string  `json:"save_path"`
+	ContentPath              string  `json:"content_path"`
+	AddedOn                  int64   `json:"added_on"`
+	CompletionOn             int64   `json:"completion_on"`
+	ETA                      int64   `json:"eta"`
+	Ratio                    float64 `json:"ratio"`
+	RatioLimit               float64 `json:"ratio_limit"`
+	SeedingTime              int64   `json:"seeding_time"`
+	SeedingTimeLimit         int64   `json:"seeding_time_limit"`
+	InactiveSeedingTimeLimit int64   `json:"inactive_seeding_time_limit"`
+	NumSeeds                 int     `json:"num_seeds"`
+	NumLeechs                int     `json:"num_leechs"`
+	AmountLeft               int64   `json:"amount_left"`
+	Completed                int64   `json:"completed"`
+	Downloaded               int64   `json:"downloaded"`
+	Uploaded                 int64   `json:"uploaded"`
+	LastActivity             int64   `json:"last_activity"`
+	Priority                 int     `json:"priority"`
+	AutoTMM                  bool    `json:"auto_tmm"`
+}
+
+// qbFile torrents/files 返回的文件信息
+type qbFile struct {
+	Index        int     `json:"index"`
+	Name         string  `json:"name"`
+	Size         int64   `json:"size"`
+	Progress     float64 `json:"progress"`
+	Priority     int     `json:"priority"`
+	IsSeed       bool    `json:"is_seed"`
+	PieceRange   []int   `json:"piece_range"`
+	Availability float64 `json:"availability"`
+}
+
+// qbProperties torrents/properties 返回的种子属性
+type qbProperties struct {
+	Hash            string  `json:"hash"`
+	Name            string  `json:"name"`
+	SavePath        string  `json:"save_path"`
+	CreationDate    int64   `json:"creation_date"`
+	PieceSize       int64   `json:"piece_size"`
+	Comment         string  `json:"comment"`
+	TotalWasted     int64   `json:"total_wasted"`
+	TotalUploaded   int64   `json:"total_uploaded"`
+	TotalDownloaded int64   `json:"total_downloaded"`
+	UpLimit         int64   `json:"up_limit"`
+	DLLimit         int64   `json:"dl_limit"`
+	TimeElapsed     int64   `json:"time_elapsed"`
+	SeedingTime     int64   `json:"seeding_time"`
+	NbConnections   int     `json:"nb_connections"`
+	ShareRatio      float64 `json:"share_ratio"`
+	AdditionDate    int64   `json:"addition_date"`
+	CompletionDate  int64   `json:"completion_date"`
+	CreatedBy       string  `json:"created_by"`
+	DLSpeed         int64   `json:"dl_speed"`
+	UpSpeed         int64   `json:"up_speed"`
+	ETA             int64   `json:"eta"`
+	Peers           int     `json:"peers"`
+	Seeds           int     `json:"seeds"`
+	PiecesHave      int     `json:"pieces_have"`
+	PiecesNum       int     `json:"pieces_num"`
+	TotalSize       int64   `json:"total_size"`
+}
+
+type qbCategory struct {
+	Name     string `json:"name"`
+	SavePath string `json:"savePath"`
+}
+
+// qbFilters torrents/info 的 filter 参数对应的状态
+var qbFilters = map[string]map[string]bool{
+	"downloading":         {"metaDL": true, "downloading": true, "stalledDL": true},
+	"seeding":             {"uploading": true, "stalledUP": true},
+	"completed":           {"uploading": true, "stalledUP": true, "pausedUP": true},
+	"paused":              {"pausedUP": true},
+	"stopped":             {"pausedUP": true},
+	"active":              {"uploading": true, "downloading": true},
+	"inactive":            {"metaDL": true, "stalledDL": true, "stalledUP": true, "pausedUP": true, "error": true},
+	"resumed":             {"metaDL": true, "downloading": true, "stalledDL": true, "uploading": true, "stalledUP": true},
+	"running":             {"metaDL": true, "downloading": true, "stalledDL": true, "uploading": true, "stalledUP": true},
+	"stalled":             {"stalledUP": true, "stalledDL": true},
+	"stalled_uploading":   {"stalledUP": true},
+	"stalled_downloading": {"stalledDL": true},
+	"errored":             {"error": true},
+}
+
+// QBittorrentHandler qBittorrent Web API (/api/v2) 的兼容实现，供 Sonarr/Radarr 添加种子并从 WebDAV 挂载导入。
+// 文件可以通过 WebDAV 按需读取，所以获取到元数据的种子即视为已完成
+type QBittorrentHandler struct {
+	torrentService *services.TorrentService
+	cfg            *config.QBittorrentConfig
+}
+
+func NewQBittorrentHandler(torrentService *services.TorrentService, cfg *config.Config) *QBittorrentHandler {
+	return &QBittorrentHandler{
+		torrentService: torrentService,
+		cfg:            &cfg.QBittorrent,
+	}
+}
+
+// qbParam 读取表单或查询参数，qBittorrent 的接口两种方式都接受
+func qbParam(c *gin.Context, key string) (string, bool) {
+	if value, ok := c.GetPostForm(key); ok {
+		return value, true
+	}
+	return c.GetQuery(key)
+}
+
+// qbHashes 解析用 | 分隔的 hashes 参数，all 返回 nil
+func qbHashes(value string) []string {
+	if value == "all" {
+		return nil
+	}
+	var hashes []string
+	for _, hash := range strings.Split(value, "|") {
+		if hash = strings.ToLower(strings.TrimSpace(hash)); hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+func (h *QBittorrentHandler) AppVersion(c *gin.Context) {
+	c.String(http.StatusOK, qbAppVersion)
+}
+
+func (h *QBittorrentHandler) WebAPIVersion(c *gin.Context) {
+	c.String(http.StatusOK, qbWebAPIVersion)
+}
+
+// Preferences 返回 Sonarr/Radarr 会检查的部分设置，不限制分享率和做种时间
+func (h *QBittorrentHandler) Preferences(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"save_path":                h.rootPath(),
+		"temp_path_enabled":        false,
+		"queueing_enabled":         false,
+		"max_ratio_enabled":        false,
+		"max_ratio":                -1,
+		"max_ratio_act":            0,
+		"max_seeding_time_enabled": false,
+		"max_seeding_time":         -1,
+		"dht":                      true,
+		"auto_tmm_enabled":         false,
+	})
+}
+
+// rootPath WebDAV 挂载的根目录，去掉末尾的分隔符
+func (h *QBittorrentHandler) rootPath() string {
+	return strings.TrimRight(h.cfg.SavePath, `/\`)
+}
+
+// savePath 种子在 WebDAV 挂载中的目录
+func (h *QBittorrentHandler) savePath(magnetID string) string {
+	return h.rootPath() + "/" + magnetID
+}
+
+// contentPath 单文件种子指向文件本身，多文件种子指向种子目录
+func (h *QBittorrentHandler) contentPath(magnet *models.LibraryMagnet) string {
+	if magnet.FileCount == 1 && magnet.Name != "" {
+		return h.savePath(magnet.ID) + "/" + magnet.Name
+	}
+	return h.savePath(magnet.ID)
+}
+
+// qbState 把磁力链接状态映射为 qBittorrent 的种子状态
+func qbState(magnet *models.LibraryMagnet, state *services.TorrentState) string {
+	switch {
+	case magnet.Status == "error":
+		return "error"
+	case magnet.Status != "ready":
+		return "metaDL"
+	case state == nil:
+		return "pausedUP"
+	case state.OpenReaders > 0:
+		return "uploading"
+	default:
+		return "stalledUP"
+	}
+}
+
+func (h *QBittorrentHandler) torrentInfo(magnet *models.LibraryMagnet, categories map[uint]string, tags []string) qbTorrent {
+	state := h.torrentService.TorrentState(magnet.ID)
+	info := qbTorrent{
+		Hash:                     magnet.ID,
+		Name:                     magnetDisplayName(magnet),
+		MagnetURI:                magnet.MagnetURI,
+		Size:                     magnet.TotalSize,
+		TotalSize:                magnet.TotalSize,
+		State:                    qbState(magnet, state),
+		Tags:                     strings.Join(tags, ","),
+		SavePath:                 h.savePath(magnet.ID),
+		ContentPath:              h.contentPath(magnet),
+		AddedOn:                  magnet.CreatedAt.Unix(),
+		CompletionOn:             -1,
+		ETA:                      qbETAInfinity,
+		RatioLimit:               -2,
+		SeedingTimeLimit:         -2,
+		InactiveSeedingTimeLimit: -2,
+		AmountLeft:               magnet.TotalSize,
+		LastActivity:             magnet.LastAccessed.Unix(),
+	}
+	if magnet.CategoryID != nil {
+		info.Category = categories[*magnet.CategoryID]
+	}
+	if magnet.Status == "ready" {
+		info.Progress = 1
+		info.CompletionOn = magnet.UpdatedAt.Unix()
+		info.ETA = 0
+		info.AmountLeft = 0
+		info.Completed = magnet.TotalSize
+	}
+	if state != nil {
+		info.Downloaded = state.BytesDownloaded
+		info.Uploaded = state.BytesUploaded
+		info.DLSpeed = state.DownloadRate
+		info.UPSpeed = state.UploadRate
+		info.Ratio = state.Ratio
+		info.NumSeeds = state.Seeders
+		if leechers := state.ActivePeers - state.Seeders; leechers > 0 {
+			info.NumLeechs = leechers
+		}
+	}
+	return info
+}
+
+// TorrentsInfo 列出自己媒体库中的种子，支持 filter、category、hashes、sort、reverse、limit、offset
+func (h *QBittorrentHandler) TorrentsInfo(c *gin.Context) {
+	query := h.torrentService.OwnMagnets(middleware.CurrentUser(c.Request))
+	if value, ok := qbParam(c, "hashes"); ok {
+		if hashes := qbHashes(value); hashes != nil {
+			query = query.Where("magnets.id IN ?", hashes)
+		}
+	}
+	if value, ok := qbParam(c, "category"); ok {
+		if value == "" {
+			query = query.Where("library_entries.category_id IS NULL")
+		} else {
+			category, err := h.findCategory(value)
+			if err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			if category == nil {
+				c.JSON(http.StatusOK, []qbTorrent{})
+				return
+			}
+			query = query.Where("library_entries.category_id = ?", category.ID)
+		}
+	}
+
+	var magnets []models.LibraryMagnet
+	if err := query.Order("magnets.created_at, magnets.id").Scan(&magnets).Error; err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	categories, err := h.torrentService.CategoryPaths()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	entryIDs := make([]uint, 0, len(magnets))
+	for _, magnet := range magnets {
+		entryIDs = append(entryIDs, magnet.EntryID)
+	}
+	tags, err := h.torrentService.EntryTags(entryIDs)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	filter, _ := qbParam(c, "filter")
+	states := qbFilters[filter]
+	torrents := []qbTorrent{}
+	for i := range magnets {
+		info := h.torrentInfo(&magnets[i], categories, tags[magnets[i].EntryID])
+		if states == nil || states[info.State] {
+			torrents = append(torrents, info)
+		}
+	}
+
+	if field, _ := qbParam(c, "sort"); field != "" {
+		sortTorrents(torrents, field)
+	}
+	if reverse, _ := qbParam(c, "reverse"); reverse == "true" {
+		for i, j := 0, len(torrents)-1; i < j; i, j = i+1, j-1 {
+			torrents[i], torrents[j] = torrents[j], torrents[i]
+		}
+	}
+	if value, _ := qbParam(c, "offset"); value != "" {
+		offset, _ := strconv.Atoi(value)
+		if offset < 0 {
+			offset += len(torrents)
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		if offset > len(torrents) {
+			offset = len(torrents)
+		}
+		torrents = torrents[offset:]
+	}
+	if value, _ := qbParam(c, "limit"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit > 0 && limit < len(torrents) {
+			torrents = torrents[:limit]
+		}
+	}
+
+	c.JSON(http.StatusOK, torrents)
+}
+
+// sortTorrents 按 torrents/info 的 sort 参数排序，不支持的字段保持原顺序
+func sortTorrents(torrents []qbTorrent, field string) {
+	var less func(a, b *qbTorrent) bool
+	switch field {
+	case "name":
+		less = func(a, b *qbTorrent) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "hash":
+		less = func(a, b *qbTorrent) bool { return a.Hash < b.Hash }
+	case "size", "total_size":
+		less = func(a, b *qbTorrent) bool { return a.Size < b.Size }
+	case "added_on":
+		less = func(a, b *qbTorrent) bool { return a.AddedOn < b.AddedOn }
+	case "completion_on":
+		less = func(a, b *qbTorrent) bool { return a.CompletionOn < b.CompletionOn }
+	case "progress":
+		less = func(a, b *qbTorrent) bool { return a.Progress < b.Progress }
+	case "ratio":
+		less = func(a, b *qbTorrent) bool { return a.Ratio < b.Ratio }
+	case "state":
+		less = func(a, b *qbTorrent) bool { return a.State < b.State }
+	case "category":
+		less = func(a, b *qbTorrent) bool { return a.Category < b.Category }
+	default:
+		return
+	}
+	sort.SliceStable(torrents, func(i, j int) bool { return less(&torrents[i], &torrents[j]) })
+}
+
+// ownMagnet 获取用户自己的磁力链接详情，找不到时按 qBittorrent 的习惯返回 404
+func (h *QBittorrentHandler) ownMagnet(c *gin.Context) (*services.MagnetDetail, bool) {
+	hash, _ := qbParam(c, "hash")
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	user := middleware.CurrentUser(c.Request)
+
+	_, err := h.torrentService.GetOwnEntry(user, hash)
+	if err == nil {
+		var detail *services.MagnetDetail
+		if detail, err = h.torrentService.GetMagnetDetail(user, hash); err == nil {
+			return detail, true
+		}
+	}
+	if errors.Is(err, services.ErrNotFound) {
+		c.String(http.StatusNotFound, "Torrent hash was not found")
+	} else {
+		c.String(http.StatusInternalServerError, err.Error())
+	}
+	return nil, false
+}
+
+// TorrentsFiles 列出种子的文件，文件名包含种子名称
+func (h *QBittorrentHandler) TorrentsFiles(c *gin.Context) {
+	detail, ok := h.ownMagnet(c)
+	if !ok {
+		return
+	}
+
+	files := make([]qbFile, 0, len(detail.Files))
+	for _, file := range detail.Files {
+		item := qbFile{
+			Index:        file.FileIndex,
+			Name:         file.FilePath,
+			Size:         file.FileSize,
+			Priority:     1,
+			PieceRange:   []int{0, 0},
+			Availability: -1,
+		}
+		if detail.Status == "ready" {
+			item.Progress = 1
+			item.IsSeed = true
+		}
+		files = append(files, item)
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// TorrentsProperties 返回种子的属性
+func (h *QBittorrentHandler) TorrentsProperties(c *gin.Context) {
+	detail, ok := h.ownMagnet(c)
+	if !ok {
+		return
+	}
+
+	info := h.torrentInfo(detail.LibraryMagnet, nil, nil)
+	props := qbProperties{
+		Hash:           detail.ID,
+		Name:           info.Name,
+		SavePath:       info.SavePath,
+		CreationDate:   -1,
+		UpLimit:        -1,
+		DLLimit:        -1,
+		ShareRatio:     info.Ratio,
+		AdditionDate:   info.AddedOn,
+		CompletionDate: info.CompletionOn,
+		ETA:            info.ETA,
+		TotalSize:      detail.TotalSize,
+	}
+	if state := detail.Torrent; state != nil {
+		props.PieceSize = state.PieceLength
+		props.TotalUploaded = state.BytesUploaded
+		props.TotalDownloaded = state.BytesDownloaded
+		props.DLSpeed = state.DownloadRate
+		props.UpSpeed = state.UploadRate
+		props.NbConnections = state.ActivePeers
+		props.Peers = state.Peers
+		props.Seeds = state.Seeders
+		props.PiecesHave = state.PiecesComplete
+		props.PiecesNum = state.PiecesTotal
+	}
+	c.JSON(http.StatusOK, props)
+}
+
+// TorrentsAdd 添加种子：urls 中每行一个磁力链接，torrents 为上传的 .torrent 文件。
+// 支持 category（管理员使用不存在的分类时自动创建）、tags 和 rename，savepath 等其他参数会被忽略
+func (h *QBittorrentHandler) TorrentsAdd(c *gin.Context) {
+	user := middleware.CurrentUser(c.Request)
+
+	var upd services.MagnetUpdate
+	if value, _ := qbParam(c, "category"); value != "" {
+		var category *models.Category
+		var err error
+		if canManageCategories(user) {
+			category, err = h.torrentService.EnsureCategoryPath(value)
+		} else {
+			category, err = h.findCategory(value)
+		}
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if category == nil {
+			c.String(http.StatusConflict, "Incorrect category name")
+			return
+		}
+		upd.CategoryID = &category.ID
+	}
+	if value, _ := qbParam(c, "tags"); value != "" {
+		tags := strings.Split(value, ",")
+		upd.Tags = &tags
+	}
+	rename, _ := qbParam(c, "rename")
+
+	added, failed := 0, 0
+	add := func(magnet *models.LibraryMagnet, err error) {
+		if err == nil && (upd.CategoryID != nil || upd.Tags != nil) {
+			_, err = h.torrentService.UpdateMagnet(user, magnet.ID, upd)
+		}
+		if err != nil {
+			c.Error(err)
+			failed++
+			return
+		}
+		added++
+	}
+
+	urls, _ := qbParam(c, "urls")
+	for _, line := range strings.Split(urls, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		magnetURI, _, err := services.NormalizeMagnetURI(line)
+		if err != nil {
+			add(nil, err)
+			continue
+		}
+		add(h.torrentService.AddMagnet(user, magnetURI, rename))
+	}
+
+	if form, err := c.MultipartForm(); err == nil {
+		for _, header := range form.File["torrents"] {
+			add(h.addTorrentFile(user, header, rename))
+		}
+	}
+
+	if added == 0 {
+		c.String(http.StatusOK, "Fails.")
+		return
+	}
+	c.String(http.StatusOK, "Ok.")
+}
+
+func (h *QBittorrentHandler) addTorrentFile(user *config.UserConfig, header *multipart.FileHeader, name string) (*models.LibraryMagnet, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, qbMaxTorrentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > qbMaxTorrentSize {
+		return nil, fmt.Errorf("%w: torrent file too large", services.ErrInvalidInput)
+	}
+	file, err := services.ParseTorrentFile(data)
+	if err != nil {
+		return nil, err
+	}
+	return h.torrentService.AddTorrentFile(user, file, name)
+}
+
+// TorrentsDelete 从媒体库删除种子，deleteFiles=true 时同时删除已下载的数据
+func (h *QBittorrentHandler) TorrentsDelete(c *gin.Context) {
+	user := middleware.CurrentUser(c.Request)
+	value, _ := qbParam(c, "hashes")
+	deleteFiles, _ := qbParam(c, "deleteFiles")
+
+	hashes, err := h.selectHashes(user, value)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, hash := range hashes {
+		if _, err := h.torrentService.RemoveMagnet(user, hash, deleteFiles == "true"); err != nil && !errors.Is(err, services.ErrNotFound) {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+// selectHashes 解析 hashes 参数，all 表示用户自己的全部种子
+func (h *QBittorrentHandler) selectHashes(user *config.UserConfig, value string) ([]string, error) {
+	if value != "all" {
+		return qbHashes(value), nil
+	}
+
+	var magnets []models.LibraryMagnet
+	if err := h.torrentService.OwnMagnets(user).Scan(&magnets).Error; err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(magnets))
+	for _, magnet := range magnets {
+		hashes = append(hashes, magnet.ID)
+	}
+	return hashes, nil
+}
+
+// canManageCategories 和 REST API 一样只有管理员可以创建分类，其他用户只能使用已有的分类。
+// 未启用认证时会话中没有用户，不做限制
+func canManageCategories(user *config.UserConfig) bool {
+	return user == nil || user.Admin
+}
+
+// findCategory 按 qBittorrent 的分类名称（即分类路径）查找分类，不存在时返回 nil
+func (h *QBittorrentHandler) findCategory(name string) (*models.Category, error) {
+	category, rest, err := h.torrentService.ResolveCategoryPath(strings.Split(strings.Trim(name, "/"), "/"))
+	if err != nil || len(rest) > 0 {
+		return nil, err
+	}
+	return category, nil
+}
+
+// Categories 以分类路径作为 qBittorrent 的分类名称
+func (h *QBittorrentHandler) Categories(c *gin.Context) {
+	paths, err := h.torrentService.CategoryPaths()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	categories := make(map[string]qbCategory, len(paths))
+	for _, path := range paths {
+		categories[path] = qbCategory{Name: path, SavePath: h.rootPath()}
+	}
+	c.JSON(http.StatusOK, categories)
+}
+
+// CreateCategory 创建分类，名称中的 / 表示子分类，savePath 会被忽略。只有管理员可以创建
+func (h *QBittorrentHandler) CreateCategory(c *gin.Context) {
+	if !canManageCategories(middleware.CurrentUser(c.Request)) {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	name, _ := qbParam(c, "category")
+	if strings.TrimSpace(name) == "" {
+		c.String(http.StatusBadRequest, "Category name is empty")
+		return
+	}
+
+	existing, err := h.findCategory(name)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing != nil {
+		c.String(http.StatusConflict, "Unable to create category")
+		return
+	}
+	if _, err := h.torrentService.EnsureCategoryPath(name); err != nil {
+		if errors.Is(err, services.ErrInvalidInput) {
+			c.String(http.StatusConflict, "Category name is invalid")
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// SetCategory 修改种子的分类，category 为空表示移出分类
+func (h *QBittorrentHandler) SetCategory(c *gin.Context) {
+	user := middleware.CurrentUser(c.Request)
+	value, _ := qbParam(c, "hashes")
+	name, _ := qbParam(c, "category")
+
+	var categoryID uint
+	if name != "" {
+		category, err := h.findCategory(name)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if category == nil {
+			c.String(http.StatusConflict, "Incorrect category name")
+			return
+		}
+		categoryID = category.ID
+	}
+
+	hashes, err := h.selectHashes(user, value)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, hash := range hashes {
+		if _, err := h.torrentService.GetOwnEntry(user, hash); errors.Is(err, services.ErrNotFound) {
+			continue
+		}
+		if _, err := h.torrentService.UpdateMagnet(user, hash, services.MagnetUpdate{CategoryID: &categoryID}); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"fmt"
+	"magnet-webdav/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sizeUnits 大小参数支持的单位，按 1024 进制
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseMagnetQuery 解析磁力链接列表的查询参数
+func parseMagnetQuery(c *gin.Context) (services.MagnetQuery, error) {
+	var q services.MagnetQuery
+	var err error
+
+	for _, status := range c.QueryArray("status") {
+		for _, s := range strings.Split(status, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				q.Status = append(q.Status, s)
+			}
+		}
+	}
+	if q.CreatedAfter, err = parseTimeParam(c, "created_after"); err != nil {
+		return q, err
+	}
+	if q.CreatedBefore, err = parseTimeParam(c, "created_before"); err != nil {
+		return q, err
+	}
+	if q.MinSize, err = parseSizeParam(c, "min_size"); err != nil {
+		return q, err
+	}
+	if q.MaxSize, err = parseSizeParam(c, "max_size"); err != nil {
+		return q, err
+	}
+	for _, tags := range c.QueryArray("tag") {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				q.Tags = append(q.Tags, tag)
+			}
+		}
+	}
+	if value := c.Query("category"); value != "" {
+		category, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return q, fmt.Errorf("invalid category: %s", value)
+		}
+		q.Category = uint(category)
+	}
+	if value := c.Query("has_video"); value != "" {
+		hasVideo, err := strconv.ParseBool(value)
+		if err != nil {
+			return q, fmt.Errorf("invalid has_video: %s", value)
+		}
+		q.HasVideo = &hasVideo
+	}
+
+	q.Search = c.Query("q")
+	q.Sort = c.Query("sort")
+	q.Cursor = c.Query("cursor")
+	// 默认按最近访问时间倒序，与之前的行为一致
+	if q.Desc, err = parseOrderParam(c, q.Sort == ""); err != nil {
+		return q, err
+	}
+	if q.Limit, q.Offset, err = parsePageParams(c); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// parseFileQuery 解析文件列表的查询参数
+func parseFileQuery(c *gin.Context) (services.FileQuery, error) {
+	var q services.FileQuery
+	var err error
+
+	q.Search = c.Query("q")
+	q.MimeType = c.Query("type")
+	if q.MinSize, err = parseSizeParam(c, "min_size"); err != nil {
+		return q, err
+	}
+	if q.MaxSize, err = parseSizeParam(c, "max_size"); err != nil {
+		return q, err
+	}
+	q.Sort = c.Query("sort")
+	if q.Desc, err = parseOrderParam(c, false); err != nil {
+		return q, err
+	}
+	if q.Limit, q.Offset, err = parsePageParams(c); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// parseTimeParam 支持 RFC 3339 和 2006-01-02 两种格式
+func parseTimeParam(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid %s: %s", name, value)
+}
+
+// parseSizeParam 支持纯数字（字节）或带单位的大小，例如 700MB、1.5GB
+func parseSizeParam(c *gin.Context, name string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(c.Query(name)))
+	if value == "" {
+		return 0, nil
+	}
+
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", name, c.Query(name))
+	}
+	return int64(n * float64(factor)), nil
+}
+
+func parseOrderParam(c *gin.Context, defaultDesc bool) (bool, error) {
+	switch strings.ToLower(c.Query("order")) {
+	case "":
+		return defaultDesc, nil
+	case "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid order: %s", c.Query("order"))
+	}
+}
+
+func parsePageParams(c *gin.Context) (limit, offset int, err error) {
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("invalid limit: %s", value)
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset: %s", value)
+		}
+	}
+	return limit, offset, nil
+}
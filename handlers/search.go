@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchHandler struct {
+	searchService *services.SearchService
+}
+
+func NewSearchHandler(searchService *services.SearchService) *SearchHandler {
+	return &SearchHandler{
+		searchService: searchService,
+	}
+}
+
+// Search 在所有搜索源（或 provider 参数指定的搜索源，逗号分隔）中搜索
+func (h *SearchHandler) Search(c *gin.Context) {
+	var providers []string
+	if value := c.Query("provider"); value != "" {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				providers = append(providers, name)
+			}
+		}
+	}
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	resp, err := h.searchService.Search(c.Request.Context(), c.Query("q"), providers, limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListProviders 列出已配置的搜索源
+func (h *SearchHandler) ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": h.searchService.Providers()})
+}
+
+// AddResult 把搜索结果加入当前用户的媒体库
+func (h *SearchHandler) AddResult(c *gin.Context) {
+	var req services.SearchAdd
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	magnet, err := h.searchService.Add(c.Request.Context(), middleware.CurrentUser(c.Request), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, magnet)
+}
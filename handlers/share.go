@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareHandler struct {
+	shareService  *services.ShareLinkService
+	webdavHandler *WebDAVHandler
+}
+
+func NewShareHandler(shareService *services.ShareLinkService, webdavHandler *WebDAVHandler) *ShareHandler {
+	return &ShareHandler{
+		shareService:  shareService,
+		webdavHandler: webdavHandler,
+	}
+}
+
+type CreateShareLinkRequest struct {
+	ExpiresIn    string `json:"expires_in"` // Go duration 格式，例如 "24h"
+	MaxDownloads int    `json:"max_downloads"`
+	BindIP       string `json:"bind_ip"` // 绑定的客户端 IP，"self" 表示绑定当前请求的 IP
+}
+
+func (h *ShareHandler) CreateLink(c *gin.Context) {
+	// 请求体可选，全部使用默认值时可以不传
+	var req CreateShareLinkRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	fileIndex, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file index"})
+		return
+	}
+
+	opts := services.ShareLinkOptions{
+		MaxDownloads: req.MaxDownloads,
+		BoundIP:      req.BindIP,
+	}
+	if req.ExpiresIn != "" {
+		if opts.TTL, err = time.ParseDuration(req.ExpiresIn); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expires_in: " + err.Error()})
+			return
+		}
+	}
+	if opts.BoundIP == "self" {
+		opts.BoundIP = c.ClientIP()
+	}
+
+	link, err := h.shareService.CreateLink(middleware.CurrentUser(c.Request), c.Param("id"), fileIndex, opts)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	link.URL = requestBaseURL(c.Request) + link.URL
+	c.JSON(http.StatusCreated, link)
+}
+
+func (h *ShareHandler) ListLinks(c *gin.Context) {
+	links, err := h.shareService.ListLinks(middleware.CurrentUser(c.Request))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	baseURL := requestBaseURL(c.Request)
+	for i := range links {
+		if links[i].URL != "" {
+			links[i].URL = baseURL + links[i].URL
+		}
+	}
+
+	c.JSON(http.StatusOK, links)
+}
+
+func (h *ShareHandler) RevokeLink(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share link id"})
+		return
+	}
+
+	if err := h.shareService.RevokeLink(middleware.CurrentUser(c.Request), uint(id)); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+}
+
+// ServeLink 无需认证的分享链接下载入口，复用 WebDAV 的 Range 流式输出
+func (h *ShareHandler) ServeLink(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Share link not found")
+		return
+	}
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		c.String(http.StatusForbidden, "Invalid share link")
+		return
+	}
+
+	link, file, err := h.shareService.ResolveLink(uint(id), expires, c.Query("sig"), c.ClientIP())
+	if err != nil {
+		respondShareLinkError(c, err)
+		return
+	}
+
+	// 种子未就绪时不消耗下载次数
+	torr := h.webdavHandler.torrentService.GetTorrent(file.MagnetID)
+	if torr == nil || torr.Info() == nil {
+		c.String(http.StatusServiceUnavailable, "File not ready")
+		return
+	}
+
+	// 每个 GET 请求都计为一次下载，包括 Range 请求，否则分段请求可以绕过次数限制
+	if c.Request.Method == http.MethodGet {
+		if err := h.shareService.RecordDownload(link); err != nil {
+			respondShareLinkError(c, err)
+			return
+		}
+	}
+
+	log.Printf("Share link %d accessed from %s (%d/%d downloads)", link.ID, c.ClientIP(), link.Downloads, link.MaxDownloads)
+	c.Header("Content-Disposition", `inline; filename*=UTF-8''`+url.PathEscape(file.FileName))
+	h.webdavHandler.streamFile(c.Writer, c.Request, file.MagnetID, file.FilePath)
+}
+
+func respondShareLinkError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrShareLinkExpired), errors.Is(err, services.ErrShareLinkExhausted):
+		c.String(http.StatusGone, err.Error())
+	case errors.Is(err, services.ErrNotFound):
+		c.String(http.StatusNotFound, "File not found")
+	default:
+		c.String(http.StatusForbidden, "Invalid share link")
+	}
+}
+
+// requestBaseURL 根据请求推断外部访问地址
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
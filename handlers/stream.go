@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"magnet-webdav/middleware"
+	"magnet-webdav/services"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StreamHandler struct {
+	hlsService *services.HLSService
+}
+
+func NewStreamHandler(hlsService *services.HLSService) *StreamHandler {
+	return &StreamHandler{
+		hlsService: hlsService,
+	}
+}
+
+// ServeHLS 处理 /stream/<磁力链接>/<文件路径>/index.m3u8 和 /stream/<磁力链接>/<文件路径>/<n>.ts
+func (h *StreamHandler) ServeHLS(c *gin.Context) {
+	filePath, name := path.Split(strings.TrimPrefix(c.Param("path"), "/"))
+	filePath = strings.TrimSuffix(filePath, "/")
+	if filePath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	user := middleware.CurrentUser(c.Request)
+
+	if name == "index.m3u8" {
+		data, err := h.hlsService.Playlist(c.Request.Context(), user, c.Param("id"), filePath)
+		if err != nil {
+			respondLabelError(c, err, "File not found")
+			return
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "application/vnd.apple.mpegurl", data)
+		return
+	}
+
+	index, err := strconv.Atoi(strings.TrimSuffix(name, ".ts"))
+	if !strings.HasSuffix(name, ".ts") || err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	data, err := h.hlsService.Segment(c.Request.Context(), user, c.Param("id"), filePath, index)
+	if err != nil {
+		respondLabelError(c, err, "Segment not found")
+		return
+	}
+	c.Data(http.StatusOK, "video/mp2t", data)
+}
@@ -0,0 +1,521 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"magnet-webdav/config"
+	"magnet-webdav/middleware"
+	"magnet-webdav/models"
+	"magnet-webdav/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 兼容接口报告的 Transmission 版本
+const (
+	trVersion           = "4.0.5 (magnet-webdav)"
+	trRPCVersion        = 17
+	trRPCVersionMinimum = 14
+)
+
+// trSessionHeader Transmission 用于防止 CSRF 的请求头
+const trSessionHeader = "X-Transmission-Session-Id"
+
+// Transmission 的种子状态
+const (
+	trStatusStopped     = 0
+	trStatusDownloading = 4
+	trStatusSeeding     = 6
+)
+
+// trErrorLocal Transmission 的本地错误类型
+const trErrorLocal = 3
+
+// trRecentlyActive torrent-get 的 ids 为该值时返回最近活动的种子
+const (
+	trRecentlyActive       = "recently-active"
+	trRecentlyActiveWindow = time.Minute
+)
+
+// trFileFields 需要读取文件列表的字段
+var trFileFields = map[string]bool{"files": true, "fileStats": true, "priorities": true, "wanted": true}
+
+type trRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       json.RawMessage `json:"tag,omitempty"`
+}
+
+type trResponse struct {
+	Result    string          `json:"result"`
+	Arguments interface{}     `json:"arguments"`
+	Tag       json.RawMessage `json:"tag,omitempty"`
+}
+
+// trIDs torrent-get 等方法的 ids 参数：单个 ID、ID 列表（数字或 info hash）或 recently-active
+type trIDs struct {
+	All            bool
+	RecentlyActive bool
+	EntryIDs       []uint
+	Hashes         []string
+}
+
+func (ids *trIDs) UnmarshalJSON(data []byte) error {
+	var single interface{}
+	if err := json.Unmarshal(data, &single); err != nil {
+		return err
+	}
+	values, ok := single.([]interface{})
+	if !ok {
+		if single == trRecentlyActive {
+			ids.RecentlyActive = true
+			return nil
+		}
+		values = []interface{}{single}
+	}
+	for _, value := range values {
+		switch v := value.(type) {
+		case float64:
+			ids.EntryIDs = append(ids.EntryIDs, uint(v))
+		case string:
+			ids.Hashes = append(ids.Hashes, strings.ToLower(v))
+		default:
+			return fmt.Errorf("invalid torrent id: %v", value)
+		}
+	}
+	return nil
+}
+
+type trGetArgs struct {
+	IDs    *trIDs   `json:"ids"`
+	Fields []string `json:"fields"`
+	Format string   `json:"format"`
+}
+
+type trAddArgs struct {
+	Filename string   `json:"filename"`
+	Metainfo string   `json:"metainfo"`
+	Labels   []string `json:"labels"`
+}
+
+type trRemoveArgs struct {
+	IDs             *trIDs `json:"ids"`
+	DeleteLocalData bool   `json:"delete-local-data"`
+}
+
+type trSetArgs struct {
+	IDs            *trIDs    `json:"ids"`
+	Labels         *[]string `json:"labels"`
+	SeedRatioLimit *float64  `json:"seedRatioLimit"`
+	SeedRatioMode  *int      `json:"seedRatioMode"` // 0 使用全局设置，1 使用 seedRatioLimit，2 不限制
+}
+
+// TransmissionHandler Transmission RPC (/transmission/rpc) 的兼容实现。种子的 id 是用户媒体库条目的 ID，
+// 文件可以通过 WebDAV 按需读取，所以获取到元数据的种子即视为已完成
+type TransmissionHandler struct {
+	torrentService *services.TorrentService
+	cfg            *config.TransmissionConfig
+	sessionID      string
+}
+
+func NewTransmissionHandler(torrentService *services.TorrentService, cfg *config.Config) *TransmissionHandler {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return &TransmissionHandler{
+		torrentService: torrentService,
+		cfg:            &cfg.Transmission,
+		sessionID:      hex.EncodeToString(buf),
+	}
+}
+
+// ServeRPC 处理 RPC 请求。缺少或使用了错误的 X-Transmission-Session-Id 时返回 409 和正确的 ID，客户端会自动重试
+func (h *TransmissionHandler) ServeRPC(c *gin.Context) {
+	if c.GetHeader(trSessionHeader) != h.sessionID {
+		c.Header(trSessionHeader, h.sessionID)
+		c.String(http.StatusConflict, "Invalid session id")
+		return
+	}
+	if c.Request.Method != http.MethodPost {
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req trRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, trResponse{Result: "invalid request: " + err.Error(), Arguments: gin.H{}})
+		return
+	}
+	if len(req.Arguments) == 0 {
+		req.Arguments = json.RawMessage("{}")
+	}
+
+	user := middleware.CurrentUser(c.Request)
+	var arguments interface{}
+	var err error
+	switch req.Method {
+	case "session-get":
+		arguments = h.sessionGet()
+	case "torrent-get":
+		arguments, err = h.torrentGet(user, req.Arguments)
+	case "torrent-add":
+		arguments, err = h.torrentAdd(user, req.Arguments)
+	case "torrent-remove":
+		arguments, err = h.torrentRemove(user, req.Arguments)
+	case "torrent-set":
+		arguments, err = h.torrentSet(user, req.Arguments)
+	default:
+		err = errors.New("method name not recognized")
+	}
+
+	resp := trResponse{Result: "success", Arguments: arguments, Tag: req.Tag}
+	if err != nil {
+		resp.Result = err.Error()
+		resp.Arguments = gin.H{}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// downloadDir 种子在 WebDAV 挂载中的目录
+func (h *TransmissionHandler) downloadDir(magnetID string) string {
+	return strings.TrimRight(h.cfg.DownloadDir, `/\`) + "/" + magnetID
+}
+
+func (h *TransmissionHandler) sessionGet() gin.H {
+	return gin.H{
+		"version":                  trVersion,
+		"rpc-version":              trRPCVersion,
+		"rpc-version-minimum":      trRPCVersionMinimum,
+		"session-id":               h.sessionID,
+		"download-dir":             strings.TrimRight(h.cfg.DownloadDir, `/\`),
+		"start-added-torrents":     true,
+		"dht-enabled":              true,
+		"pex-enabled":              true,
+		"seedRatioLimit":           0,
+		"seedRatioLimited":         false,
+		"speed-limit-down-enabled": false,
+		"speed-limit-up-enabled":   false,
+		"alt-speed-enabled":        false,
+		"download-queue-enabled":   false,
+		"seed-queue-enabled":       false,
+	}
+}
+
+// selectMagnets 按 ids 查询用户自己的种子，ids 为 nil 表示全部
+func (h *TransmissionHandler) selectMagnets(user *config.UserConfig, ids *trIDs) ([]models.LibraryMagnet, error) {
+	query := h.torrentService.OwnMagnets(user)
+	if ids != nil {
+		switch {
+		case ids.RecentlyActive:
+			since := time.Now().Add(-trRecentlyActiveWindow)
+			query = query.Where("magnets.updated_at >= ? OR magnets.last_accessed >= ?", since, since)
+		case len(ids.EntryIDs) > 0 && len(ids.Hashes) > 0:
+			query = query.Where("library_entries.id IN ? OR magnets.id IN ?", ids.EntryIDs, ids.Hashes)
+		case len(ids.EntryIDs) > 0:
+			query = query.Where("library_entries.id IN ?", ids.EntryIDs)
+		case len(ids.Hashes) > 0:
+			query = query.Where("magnets.id IN ?", ids.Hashes)
+		default:
+			return []models.LibraryMagnet{}, nil
+		}
+	}
+
+	magnets := []models.LibraryMagnet{}
+	err := query.Order("library_entries.id").Scan(&magnets).Error
+	return magnets, err
+}
+
+func (h *TransmissionHandler) torrentGet(user *config.UserConfig, raw json.RawMessage) (interface{}, error) {
+	var args trGetArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if len(args.Fields) == 0 {
+		return nil, errors.New("no fields specified")
+	}
+
+	magnets, err := h.selectMagnets(user, args.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	entryIDs := make([]uint, 0, len(magnets))
+	magnetIDs := make([]string, 0, len(magnets))
+	for _, magnet := range magnets {
+		entryIDs = append(entryIDs, magnet.EntryID)
+		magnetIDs = append(magnetIDs, magnet.ID)
+	}
+	tags, err := h.torrentService.EntryTags(entryIDs)
+	if err != nil {
+		return nil, err
+	}
+	var files map[string][]models.File
+	for _, field := range args.Fields {
+		if trFileFields[field] {
+			if files, err = h.torrentService.MagnetFiles(magnetIDs); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	torrents := make([]map[string]interface{}, 0, len(magnets))
+	for i := range magnets {
+		all := h.torrentFields(&magnets[i], tags[magnets[i].EntryID], files[magnets[i].ID])
+		torrent := make(map[string]interface{}, len(args.Fields))
+		for _, field := range args.Fields {
+			if value, ok := all[field]; ok {
+				torrent[field] = value
+			}
+		}
+		torrents = append(torrents, torrent)
+	}
+
+	result := gin.H{"torrents": torrents}
+	if args.Format == "table" {
+		result["torrents"] = trTable(args.Fields, torrents)
+	}
+	if args.IDs != nil && args.IDs.RecentlyActive {
+		result["removed"] = []int{}
+	}
+	return result, nil
+}
+
+// trTable 把种子列表转换为 table 格式：第一行是字段名，之后每行是一个种子
+func trTable(fields []string, torrents []map[string]interface{}) [][]interface{} {
+	header := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		header = append(header, field)
+	}
+	table := [][]interface{}{header}
+	for _, torrent := range torrents {
+		row := make([]interface{}, 0, len(fields))
+		for _, field := range fields {
+			row = append(row, torrent[field])
+		}
+		table = append(table, row)
+	}
+	return table
+}
+
+// torrentFields 种子支持的全部字段，实时数据来自种子客户端
+func (h *TransmissionHandler) torrentFields(magnet *models.LibraryMagnet, tags []string, files []models.File) map[string]interface{} {
+	state := h.torrentService.TorrentState(magnet.ID)
+	ready := magnet.Status == "ready"
+
+	status := trStatusStopped
+	switch {
+	case magnet.Status == "pending":
+		status = trStatusDownloading
+	case ready && state != nil:
+		status = trStatusSeeding
+	}
+	percentDone, leftUntilDone, eta, doneDate := 0.0, magnet.TotalSize, -2, int64(0)
+	if ready {
+		percentDone, leftUntilDone, eta, doneDate = 1, 0, -1, magnet.UpdatedAt.Unix()
+	}
+	errorCode, errorString := 0, ""
+	if magnet.Status == "error" {
+		errorCode, errorString = trErrorLocal, magnet.Name
+	}
+	metadataPercent := 0.0
+	if magnet.Status != "pending" {
+		metadataPercent = 1
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	fields := map[string]interface{}{
+		"id":                      magnet.EntryID,
+		"hashString":              magnet.ID,
+		"name":                    magnetDisplayName(magnet),
+		"magnetLink":              magnet.MagnetURI,
+		"status":                  status,
+		"totalSize":               magnet.TotalSize,
+		"sizeWhenDone":            magnet.TotalSize,
+		"leftUntilDone":           leftUntilDone,
+		"haveValid":               magnet.TotalSize - leftUntilDone,
+		"percentDone":             percentDone,
+		"metadataPercentComplete": metadataPercent,
+		"eta":                     eta,
+		"error":                   errorCode,
+		"errorString":             errorString,
+		"isFinished":              ready && state == nil,
+		"isStalled":               false,
+		"addedDate":               magnet.CreatedAt.Unix(),
+		"doneDate":                doneDate,
+		"activityDate":            magnet.LastAccessed.Unix(),
+		"downloadDir":             h.downloadDir(magnet.ID),
+		"labels":                  tags,
+		"queuePosition":           0,
+		"rateDownload":            0,
+		"rateUpload":              0,
+		"uploadRatio":             0.0,
+		"uploadedEver":            int64(0),
+		"downloadedEver":          int64(0),
+		"peersConnected":          0,
+		"peersSendingToUs":        0,
+		"peersGettingFromUs":      0,
+		"pieceCount":              0,
+		"pieceSize":               int64(0),
+		"seedRatioLimit":          magnet.SeedRatio,
+		"seedRatioMode":           trSeedRatioMode(magnet.SeedMode),
+	}
+	if state != nil {
+		fields["uploadRatio"] = state.Ratio
+		fields["uploadedEver"] = state.BytesUploaded
+		fields["rateDownload"] = state.DownloadRate
+		fields["rateUpload"] = state.UploadRate
+		fields["downloadedEver"] = state.BytesDownloaded
+		fields["peersConnected"] = state.ActivePeers
+		fields["peersSendingToUs"] = state.Seeders
+		fields["pieceCount"] = state.PiecesTotal
+		fields["pieceSize"] = state.PieceLength
+	}
+
+	fileList := make([]gin.H, 0, len(files))
+	fileStats := make([]gin.H, 0, len(files))
+	priorities := make([]int, 0, len(files))
+	wanted := make([]bool, 0, len(files))
+	for _, file := range files {
+		completed := int64(0)
+		if ready {
+			completed = file.FileSize
+		}
+		fileList = append(fileList, gin.H{"name": file.FilePath, "length": file.FileSize, "bytesCompleted": completed})
+		fileStats = append(fileStats, gin.H{"bytesCompleted": completed, "wanted": true, "priority": 0})
+		priorities = append(priorities, 0)
+		wanted = append(wanted, true)
+	}
+	fields["files"] = fileList
+	fields["fileStats"] = fileStats
+	fields["priorities"] = priorities
+	fields["wanted"] = wanted
+	return fields
+}
+
+// trSeedRatioMode 把做种策略映射为 Transmission 的 seedRatioMode
+func trSeedRatioMode(seedMode string) int {
+	if seedMode == services.SeedModeRatio {
+		return 1
+	}
+	return 2
+}
+
+// torrentAdd 添加磁力链接（filename）或 base64 编码的 .torrent（metainfo），labels 作为标签
+func (h *TransmissionHandler) torrentAdd(user *config.UserConfig, raw json.RawMessage) (interface{}, error) {
+	var args trAddArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	var file *services.TorrentFile
+	var magnetURI, infoHash string
+	switch {
+	case args.Metainfo != "":
+		data, err := base64.StdEncoding.DecodeString(args.Metainfo)
+		if err != nil {
+			return nil, errors.New("invalid or corrupt torrent file")
+		}
+		if file, err = services.ParseTorrentFile(data); err != nil {
+			return nil, errors.New("invalid or corrupt torrent file")
+		}
+		infoHash = file.InfoHash
+	case strings.HasPrefix(args.Filename, "magnet:"):
+		var err error
+		if magnetURI, infoHash, err = services.NormalizeMagnetURI(args.Filename); err != nil {
+			return nil, errors.New("invalid or corrupt torrent file")
+		}
+	case args.Filename != "":
+		return nil, errors.New("only magnet links and metainfo are supported")
+	default:
+		return nil, errors.New("no filename or metainfo specified")
+	}
+
+	if _, err := h.torrentService.GetOwnEntry(user, infoHash); err == nil {
+		magnet, err := h.torrentService.GetLibraryMagnet(user, infoHash)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"torrent-duplicate": trAdded(magnet)}, nil
+	} else if !errors.Is(err, services.ErrNotFound) {
+		return nil, err
+	}
+
+	var magnet *models.LibraryMagnet
+	var err error
+	if file != nil {
+		magnet, err = h.torrentService.AddTorrentFile(user, file, "")
+	} else {
+		magnet, err = h.torrentService.AddMagnet(user, magnetURI, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(args.Labels) > 0 {
+		if _, err := h.torrentService.UpdateMagnet(user, magnet.ID, services.MagnetUpdate{Tags: &args.Labels}); err != nil {
+			return nil, err
+		}
+	}
+	return gin.H{"torrent-added": trAdded(magnet)}, nil
+}
+
+func trAdded(magnet *models.LibraryMagnet) gin.H {
+	return gin.H{"id": magnet.EntryID, "name": magnetDisplayName(magnet), "hashString": magnet.ID}
+}
+
+// torrentRemove 从媒体库删除种子，delete-local-data 为 true 时同时删除已下载的数据
+func (h *TransmissionHandler) torrentRemove(user *config.UserConfig, raw json.RawMessage) (interface{}, error) {
+	var args trRemoveArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	magnets, err := h.selectMagnets(user, args.IDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, magnet := range magnets {
+		if _, err := h.torrentService.RemoveMagnet(user, magnet.ID, args.DeleteLocalData); err != nil && !errors.Is(err, services.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return gin.H{}, nil
+}
+
+// torrentSet 修改种子的标签和分享率限制，其他参数会被忽略
+func (h *TransmissionHandler) torrentSet(user *config.UserConfig, raw json.RawMessage) (interface{}, error) {
+	var args trSetArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	var upd services.MagnetUpdate
+	upd.Tags = args.Labels
+	if args.SeedRatioMode != nil {
+		seedMode := services.SeedModeAlways
+		if *args.SeedRatioMode == 1 {
+			seedMode = services.SeedModeRatio
+		}
+		upd.SeedMode = &seedMode
+	}
+	upd.SeedRatio = args.SeedRatioLimit
+
+	magnets, err := h.selectMagnets(user, args.IDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, magnet := range magnets {
+		if _, err := h.torrentService.UpdateMagnet(user, magnet.ID, upd); err != nil {
+			return nil, err
+		}
+	}
+	return gin.H{}, nil
+}
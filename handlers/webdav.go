@@ -1,28 +1,46 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"magnet-webdav/config"
+	"magnet-webdav/media"
+	"magnet-webdav/metrics"
+	"magnet-webdav/middleware"
 	"magnet-webdav/models"
 	"magnet-webdav/services"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// davRoot WebDAV 根目录地址
+const davRoot = "/webdav/"
+
+// davMediaNamespace PROPFIND 中媒体信息属性的命名空间
+const davMediaNamespace = "urn:magnet-webdav:media"
+
 type WebDAVHandler struct {
-	torrentService *services.TorrentService
-	config         *config.Config
+	torrentService  *services.TorrentService
+	mediaService    *services.MediaService
+	subtitleService *services.SubtitleService
+	config          *config.Config
 }
 
-func NewWebDAVHandler(torrentService *services.TorrentService, config *config.Config) *WebDAVHandler {
+func NewWebDAVHandler(torrentService *services.TorrentService, mediaService *services.MediaService, subtitleService *services.SubtitleService, config *config.Config) *WebDAVHandler {
 	return &WebDAVHandler{
-		torrentService: torrentService,
-		config:         config,
+		torrentService:  torrentService,
+		mediaService:    mediaService,
+		subtitleService: subtitleService,
+		config:          config,
 	}
 }
 
@@ -32,6 +50,10 @@ func (h *WebDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleGet(w, r)
 	case "PROPFIND":
 		h.handlePropfind(w, r)
+	case "OPTIONS":
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+		w.WriteHeader(http.StatusOK)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -41,14 +63,29 @@ func (h *WebDAVHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/webdav/")
 	parts := strings.Split(path, "/")
 
-	if len(parts) < 2 {
-		h.serveDirectoryListing(parts[0], w, r)
+	if parts[0] == "" {
+		h.serveRootListing(w, r)
+		return
+	}
+	if isViewPath(path) {
+		h.handleViewGet(w, r, path)
 		return
 	}
 
 	magnetID := parts[0]
 	encodedFilePath := strings.Join(parts[1:], "/")
 
+	if encodedFilePath == "" {
+		h.serveDirectoryListing(davRoot, magnetID, w, r)
+		return
+	}
+
+	// 只允许访问当前用户可见的磁力链接
+	if !h.torrentService.CanAccess(middleware.CurrentUser(r), magnetID) {
+		http.Error(w, "File not found or not ready", http.StatusNotFound)
+		return
+	}
+
 	// Properly unescape filename
 	filePath, err := url.PathUnescape(encodedFilePath)
 	if err != nil {
@@ -56,6 +93,24 @@ func (h *WebDAVHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.streamFile(w, r, magnetID, filePath)
+}
+
+// streamFile 以支持 Range 的方式输出种子中的文件，调用方负责权限检查
+func (h *WebDAVHandler) streamFile(w http.ResponseWriter, r *http.Request, magnetID, filePath string) {
+	requestStart := time.Now()
+
+	// 由 SRT/ASS 字幕转换的虚拟 .vtt 文件
+	if vtt, err := h.subtitleService.FindWebVTT(magnetID, filePath); err == nil {
+		h.serveWebVTT(w, r, vtt)
+		return
+	}
+	// MKV 内嵌字幕提取的虚拟 .srt 文件
+	if sub, err := h.mediaService.FindEmbeddedSubtitle(magnetID, filePath); err == nil {
+		h.serveEmbeddedSubtitle(w, r, sub)
+		return
+	}
+
 	// Parse Range
 	var start, end int64
 	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
@@ -107,11 +162,66 @@ func (h *WebDAVHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 	reader.SetReadahead(2 * 1024 * 1024) // 2MB max prefetch
 
 	if r.Method == "GET" {
-		_, copyErr := io.CopyN(w, reader, end-start+1)
+		mw := &meteredWriter{Writer: w, start: requestStart}
+		_, copyErr := io.CopyN(mw, reader, end-start+1)
 		if copyErr != nil && copyErr != io.EOF {
 			log.Printf("Copy error: %v", copyErr)
 		}
+		metrics.StreamedBytes.Add(float64(mw.written), magnetID)
+	}
+}
+
+// serveWebVTT 输出转换后的字幕，内容在内存中，由 http.ServeContent 处理 Range 和条件请求
+func (h *WebDAVHandler) serveWebVTT(w http.ResponseWriter, r *http.Request, vtt *services.WebVTTFile) {
+	data, err := h.subtitleService.WebVTT(vtt)
+	if err != nil {
+		log.Printf("Error converting %s to WebVTT: %v", vtt.Source.FilePath, err)
+		if errors.Is(err, services.ErrNotReady) {
+			http.Error(w, "File not found or not ready", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Failed to convert subtitle", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", getMimeType(vtt.Path))
+	http.ServeContent(w, r, "", vtt.Source.UpdatedAt, bytes.NewReader(data))
+}
+
+// serveEmbeddedSubtitle 输出提取出的内嵌字幕，第一次请求时需要等待整个文件下载并提取完成
+func (h *WebDAVHandler) serveEmbeddedSubtitle(w http.ResponseWriter, r *http.Request, sub *services.EmbeddedSubtitle) {
+	data, err := h.mediaService.EmbeddedSubtitleData(r.Context(), sub)
+	if err != nil {
+		log.Printf("Error extracting subtitle track %d from %s: %v", sub.Track.Number, sub.Source.FilePath, err)
+		switch {
+		case errors.Is(err, services.ErrNotReady):
+			http.Error(w, "File not found or not ready", http.StatusServiceUnavailable)
+		case errors.Is(err, services.ErrNotFound):
+			http.Error(w, "File not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to extract subtitle", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", getMimeType(sub.Path))
+	http.ServeContent(w, r, "", sub.Source.UpdatedAt, bytes.NewReader(data))
+}
+
+// meteredWriter 统计写出的字节数，并记录首字节耗时
+type meteredWriter struct {
+	io.Writer
+	start   time.Time
+	written int64
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	if m.written == 0 && len(p) > 0 {
+		metrics.StreamTTFB.Observe(time.Since(m.start).Seconds())
 	}
+	n, err := m.Writer.Write(p)
+	m.written += int64(n)
+	return n, err
 }
 
 func (h *WebDAVHandler) handleConditionalRequest(w http.ResponseWriter, r *http.Request, filePath string, start, end int64) bool {
@@ -179,8 +289,7 @@ func isVideoFile(filePath string) bool {
 		".3gp":  true,
 	}
 
-	ext := strings.ToLower(filePath[strings.LastIndex(filePath, "."):])
-	return videoExtensions[ext]
+	return videoExtensions[strings.ToLower(path.Ext(filePath))]
 }
 
 // generateETag 生成 ETag 用于缓存验证
@@ -192,33 +301,237 @@ func generateETag(filePath string, start, end int64) string {
 
 func (h *WebDAVHandler) handlePropfind(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/webdav/")
-	xml := h.generatePropfindResponse(path)
+	resources, err := h.collectPropfindResources(r, path)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
 
 	// 设置正确的 XML 编码
 	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
 	w.Header().Set("DAV", "1, 2")
-	w.Write([]byte(xml))
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(h.generatePropfindResponse(resources)))
 }
 
-func (h *WebDAVHandler) serveDirectoryListing(magnetID string, w http.ResponseWriter, r *http.Request) {
-	var files []struct {
-		FileName string
-		FileSize int64
-		FilePath string
+// collectPropfindResources 按路径和 Depth 收集 PROPFIND 需要返回的资源
+func (h *WebDAVHandler) collectPropfindResources(r *http.Request, path string) ([]davResource, error) {
+	user := middleware.CurrentUser(r)
+	withChildren := r.Header.Get("Depth") != "0"
+
+	if isViewPath(path) {
+		return h.collectViewResources(r, path)
 	}
 
-	// 检查磁力链接状态
-	var magnet models.Magnet
+	parts := strings.SplitN(path, "/", 2)
+	magnetID := parts[0]
+
+	// 根目录：列出按标签和分类浏览的目录，以及当前用户可见的磁力链接
+	if magnetID == "" {
+		resources := []davResource{{Href: davRoot, DisplayName: "webdav", IsDir: true, Modified: time.Now()}}
+		if !withChildren {
+			return resources, nil
+		}
+		resources = append(resources, viewRootResources()...)
+
+		var magnets []models.LibraryMagnet
+		if err := h.torrentService.VisibleMagnets(user).Order("magnets.created_at").Scan(&magnets).Error; err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, magnet := range magnets {
+			if seen[magnet.ID] {
+				continue
+			}
+			seen[magnet.ID] = true
+			resources = append(resources, magnetResource(davRoot, &magnet))
+		}
+		return resources, nil
+	}
+
+	magnet, err := h.torrentService.GetLibraryMagnet(user, magnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	filePath := ""
+	if len(parts) == 2 {
+		filePath = parts[1]
+	}
+	return h.magnetResources(davRoot, magnet, filePath, withChildren)
+}
+
+// magnetResources 返回磁力链接目录或其中单个文件的资源，base 为磁力链接目录的上级地址
+func (h *WebDAVHandler) magnetResources(base string, magnet *models.LibraryMagnet, filePath string, withChildren bool) ([]davResource, error) {
 	db := h.torrentService.DB()
-	if err := db.Where("id = ?", magnetID).First(&magnet).Error; err != nil {
+
+	// 单个文件
+	if filePath != "" {
+		var file models.File
+		if err := db.Where("magnet_id = ? AND file_path = ?", magnet.ID, filePath).First(&file).Error; err != nil {
+			if vtt, vttErr := h.subtitleService.FindWebVTT(magnet.ID, filePath); vttErr == nil {
+				return []davResource{h.webVTTResource(base, vtt)}, nil
+			}
+			if sub, subErr := h.mediaService.FindEmbeddedSubtitle(magnet.ID, filePath); subErr == nil {
+				if resource, ok := h.embeddedSubtitleResource(base, sub); ok {
+					return []davResource{resource}, nil
+				}
+			}
+			return nil, err
+		}
+		resource := fileResource(base, &file)
+		resource.Media = h.mediaInfos(magnet.ID)[file.FileIndex]
+		return []davResource{resource}, nil
+	}
+
+	// 磁力链接目录：列出文件
+	resources := []davResource{magnetResource(base, magnet)}
+	if !withChildren {
+		return resources, nil
+	}
+
+	var files []models.File
+	if err := db.Where("magnet_id = ?", magnet.ID).Order("file_index").Find(&files).Error; err != nil {
+		return nil, err
+	}
+	infos := h.mediaInfos(magnet.ID)
+	vtts := make(map[int]*services.WebVTTFile)
+	for _, vtt := range services.WebVTTFiles(files) {
+		vtts[vtt.Source.FileIndex] = &vtt
+	}
+	embedded := h.embeddedSubtitles(magnet.ID, files)
+	for i := range files {
+		resource := fileResource(base, &files[i])
+		resource.Media = infos[files[i].FileIndex]
+		resources = append(resources, resource)
+		// 虚拟 .vtt 紧跟在原字幕后面
+		if vtt := vtts[files[i].FileIndex]; vtt != nil {
+			resources = append(resources, h.webVTTResource(base, vtt))
+		}
+		// 还没有提取的内嵌字幕不知道大小，报告 0 会让信任 getcontentlength 的客户端截断文件，所以提取后才列出
+		for _, sub := range embedded[files[i].FileIndex] {
+			if resource, ok := h.embeddedSubtitleResource(base, &sub); ok {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	return resources, nil
+}
+
+// webVTTResource 虚拟 .vtt 文件的资源，字幕还没下载完成时大小为估计值
+func (h *WebDAVHandler) webVTTResource(base string, vtt *services.WebVTTFile) davResource {
+	return davResource{
+		Href:        base + vtt.Source.MagnetID + "/" + url.PathEscape(vtt.Path),
+		DisplayName: path.Base(vtt.Path),
+		Size:        h.subtitleService.WebVTTSize(vtt),
+		ContentType: getMimeType(vtt.Path),
+		Modified:    vtt.Source.UpdatedAt,
+	}
+}
+
+// embeddedSubtitleResource 已提取的内嵌字幕的资源，还没有提取时返回 false
+func (h *WebDAVHandler) embeddedSubtitleResource(base string, sub *services.EmbeddedSubtitle) (davResource, bool) {
+	size, ok := h.mediaService.EmbeddedSubtitleSize(sub)
+	if !ok {
+		return davResource{}, false
+	}
+	return davResource{
+		Href:        base + sub.Source.MagnetID + "/" + url.PathEscape(sub.Path),
+		DisplayName: path.Base(sub.Path),
+		Size:        size,
+		ContentType: getMimeType(sub.Path),
+		Modified:    sub.Source.UpdatedAt,
+	}, true
+}
+
+// embeddedSubtitles 按文件序号分组的内嵌字幕，查询失败时不列出
+func (h *WebDAVHandler) embeddedSubtitles(magnetID string, files []models.File) map[int][]services.EmbeddedSubtitle {
+	subs, err := h.mediaService.EmbeddedSubtitles(magnetID, files)
+	if err != nil {
+		log.Printf("Failed to list embedded subtitles of %s: %v", magnetID, err)
+	}
+	result := make(map[int][]services.EmbeddedSubtitle)
+	for _, sub := range subs {
+		result[sub.Source.FileIndex] = append(result[sub.Source.FileIndex], sub)
+	}
+	return result
+}
+
+// mediaInfos 返回种子中已解析的媒体信息，查询失败时不影响 PROPFIND 的其他属性
+func (h *WebDAVHandler) mediaInfos(magnetID string) map[int]*models.MediaInfo {
+	infos, err := h.mediaService.MediaInfos(magnetID)
+	if err != nil {
+		log.Printf("Failed to load media info of %s: %v", magnetID, err)
+	}
+	return infos
+}
+
+func (h *WebDAVHandler) serveRootListing(w http.ResponseWriter, r *http.Request) {
+	var magnets []models.LibraryMagnet
+	if err := h.torrentService.VisibleMagnets(middleware.CurrentUser(r)).Order("magnets.last_accessed DESC").Scan(&magnets).Error; err != nil {
+		http.Error(w, "Failed to list magnets", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	page := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Magnet WebDAV</title>
+    <style>
+        body { font-family: Arial, "Microsoft YaHei", sans-serif; margin: 20px; }
+        ul { list-style: none; padding: 0; }
+        li { padding: 8px; border-bottom: 1px solid #eee; }
+        a { text-decoration: none; color: #0366d6; }
+        .size { color: #666; font-size: 0.9em; }
+    </style>
+</head>
+<body>
+    <h1>磁力链接列表</h1>
+    <ul>`
+
+	for _, view := range viewRootResources() {
+		page += fmt.Sprintf(`<li><a href="%s">%s</a></li>`, view.Href, html.EscapeString(view.DisplayName))
+	}
+
+	seen := make(map[string]bool)
+	for _, magnet := range magnets {
+		if seen[magnet.ID] {
+			continue
+		}
+		seen[magnet.ID] = true
+		page += fmt.Sprintf(`<li><a href="/webdav/%s/">%s</a> <span class="size">(%s, %s)</span></li>`,
+			magnet.ID, html.EscapeString(magnetDisplayName(&magnet)), formatFileSize(magnet.TotalSize), getStatusText(magnet.Status))
+	}
+
+	page += `</ul>
+    <div style="margin-top: 20px;">
+        <a href="/admin">返回管理界面</a>
+    </div>
+</body>
+</html>`
+
+	w.Write([]byte(page))
+}
+
+// serveDirectoryListing 输出磁力链接目录的 HTML 列表，base 为磁力链接目录的上级地址
+func (h *WebDAVHandler) serveDirectoryListing(base, magnetID string, w http.ResponseWriter, r *http.Request) {
+	var files []models.File
+
+	// 检查磁力链接状态
+	magnet, err := h.torrentService.GetLibraryMagnet(middleware.CurrentUser(r), magnetID)
+	if err != nil {
 		http.Error(w, "Magnet not found", http.StatusNotFound)
 		return
 	}
+	db := h.torrentService.DB()
 
 	// 设置正确的 HTML 编码
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-	html := `<!DOCTYPE html>
+	page := `<!DOCTYPE html>
 <html>
 <head>
     <meta charset="UTF-8">
@@ -237,40 +550,58 @@ func (h *WebDAVHandler) serveDirectoryListing(magnetID string, w http.ResponseWr
     </style>
 </head>
 <body>
-    <h1>文件列表 - ` + magnet.Name + `</h1>
+    <h1>文件列表 - ` + html.EscapeString(magnetDisplayName(magnet)) + `</h1>
     <div class="status status-` + magnet.Status + `">状态: ` + getStatusText(magnet.Status) + `</div>`
 
 	if magnet.Status != "ready" {
-		html += `<div class="warning">
+		page += `<div class="warning">
             <strong>注意:</strong> 磁力链接正在准备中，文件暂时不可访问。请稍后刷新页面。
         </div>`
 	}
 
-	html += `<ul>`
+	page += `<ul>`
 
 	db.Raw(`
-        SELECT file_name, file_size, file_path 
+        SELECT magnet_id, file_index, file_name, file_size, file_path 
         FROM files 
         WHERE magnet_id = ? 
         ORDER BY file_index`, magnetID).Scan(&files)
 
+	vtts := make(map[string]string)
+	for _, vtt := range services.WebVTTFiles(files) {
+		vtts[vtt.Source.FilePath] = vtt.Path
+	}
+	embedded := h.embeddedSubtitles(magnetID, files)
+
 	for _, file := range files {
 		// 正确编码文件名
-		fileName := file.FileName
-		fileURL := "/webdav/" + magnetID + "/" + url.PathEscape(file.FilePath)
+		fileName := html.EscapeString(file.FileName)
+		fileURL := html.EscapeString(base + magnetID + "/" + url.PathEscape(file.FilePath))
 		size := formatFileSize(file.FileSize)
 
 		// 如果磁力链接未就绪，禁用文件链接
 		if magnet.Status != "ready" {
-			html += fmt.Sprintf(`<li><span style="color: #999;">%s</span> <span class="size">(%s)</span></li>`,
+			page += fmt.Sprintf(`<li><span style="color: #999;">%s</span> <span class="size">(%s)</span></li>`,
 				fileName, size)
 		} else {
-			html += fmt.Sprintf(`<li><a href="%s">%s</a> <span class="size">(%s)</span></li>`,
-				fileURL, fileName, size)
+			player := ""
+			if isVideoFile(file.FilePath) {
+				player = fmt.Sprintf(` <a href="/player/?magnet=%s&amp;file=%d">[播放]</a>`, url.QueryEscape(magnetID), file.FileIndex)
+			}
+			page += fmt.Sprintf(`<li><a href="%s">%s</a> <span class="size">(%s)</span>%s</li>`,
+				fileURL, fileName, size, player)
+			if vttPath, ok := vtts[file.FilePath]; ok {
+				page += fmt.Sprintf(`<li><a href="%s">%s</a> <span class="size">(WebVTT)</span></li>`,
+					html.EscapeString(base+magnetID+"/"+url.PathEscape(vttPath)), html.EscapeString(path.Base(vttPath)))
+			}
+			for _, sub := range embedded[file.FileIndex] {
+				page += fmt.Sprintf(`<li><a href="%s">%s</a> <span class="size">(内嵌字幕)</span></li>`,
+					html.EscapeString(base+magnetID+"/"+url.PathEscape(sub.Path)), html.EscapeString(path.Base(sub.Path)))
+			}
 		}
 	}
 
-	html += `</ul>
+	page += `</ul>
     <div style="margin-top: 20px;">
         <a href="/admin">返回管理界面</a> | 
         <a href="javascript:location.reload()">刷新页面</a>
@@ -278,7 +609,7 @@ func (h *WebDAVHandler) serveDirectoryListing(magnetID string, w http.ResponseWr
 </body>
 </html>`
 
-	w.Write([]byte(html))
+	w.Write([]byte(page))
 }
 
 // 添加状态文本转换函数
@@ -295,20 +626,124 @@ func getStatusText(status string) string {
 	return status
 }
 
-func (h *WebDAVHandler) generatePropfindResponse(path string) string {
+// davResource PROPFIND 响应中的一个资源
+type davResource struct {
+	Href        string
+	DisplayName string
+	IsDir       bool
+	Size        int64
+	ContentType string
+	Modified    time.Time
+	Media       *models.MediaInfo // 已解析的视频文件，作为 M: 命名空间下的额外属性返回
+}
+
+func magnetDisplayName(magnet *models.LibraryMagnet) string {
+	if magnet.DisplayName != "" {
+		return magnet.DisplayName
+	}
+	return magnet.ID
+}
+
+func magnetResource(base string, magnet *models.LibraryMagnet) davResource {
+	return davResource{
+		Href:        base + magnet.ID + "/",
+		DisplayName: magnetDisplayName(magnet),
+		IsDir:       true,
+		Modified:    magnet.UpdatedAt,
+	}
+}
+
+func fileResource(base string, file *models.File) davResource {
+	return davResource{
+		Href:        base + file.MagnetID + "/" + url.PathEscape(file.FilePath),
+		DisplayName: file.FileName,
+		Size:        file.FileSize,
+		ContentType: getMimeType(file.FilePath),
+		Modified:    file.UpdatedAt,
+	}
+}
+
+func (h *WebDAVHandler) generatePropfindResponse(resources []davResource) string {
 	// 确保 PROPFIND 响应也使用 UTF-8
-	return `<?xml version="1.0" encoding="UTF-8"?>
-<D:multistatus xmlns:D="DAV:">
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:" xmlns:M="` + davMediaNamespace + `">`)
+
+	for _, res := range resources {
+		b.WriteString(`
 	<D:response>
-		<D:href>/webdav/` + path + `</D:href>
+		<D:href>` + xmlEscape(res.Href) + `</D:href>
 		<D:propstat>
 			<D:prop>
-				<D:resourcetype><D:collection/></D:resourcetype>
+				<D:displayname>` + xmlEscape(res.DisplayName) + `</D:displayname>`)
+		if res.IsDir {
+			b.WriteString(`
+				<D:resourcetype><D:collection/></D:resourcetype>`)
+		} else {
+			b.WriteString(`
+				<D:resourcetype/>
+				<D:getcontentlength>` + strconv.FormatInt(res.Size, 10) + `</D:getcontentlength>
+				<D:getcontenttype>` + xmlEscape(res.ContentType) + `</D:getcontenttype>`)
+		}
+		if !res.Modified.IsZero() {
+			b.WriteString(`
+				<D:getlastmodified>` + res.Modified.UTC().Format(http.TimeFormat) + `</D:getlastmodified>`)
+		}
+		if res.Media != nil {
+			writeMediaProps(&b, res.Media)
+		}
+		b.WriteString(`
 			</D:prop>
 			<D:status>HTTP/1.1 200 OK</D:status>
 		</D:propstat>
-	</D:response>
-</D:multistatus>`
+	</D:response>`)
+	}
+
+	b.WriteString(`
+</D:multistatus>`)
+	return b.String()
+}
+
+// writeMediaProps 输出时长（秒）、容器、视频编码和分辨率，以及音频和字幕轨道的语言列表
+func writeMediaProps(b *strings.Builder, info *models.MediaInfo) {
+	prop := func(name, value string) {
+		if value != "" {
+			b.WriteString(`
+				<M:` + name + `>` + xmlEscape(value) + `</M:` + name + `>`)
+		}
+	}
+
+	var audio, subtitles []string
+	for _, track := range info.Tracks {
+		lang := track.Language
+		if lang == "" {
+			lang = "und"
+		}
+		switch track.Type {
+		case media.TrackAudio:
+			audio = append(audio, lang)
+		case media.TrackSubtitle:
+			subtitles = append(subtitles, lang)
+		}
+	}
+
+	if info.Duration > 0 {
+		prop("duration", strconv.FormatFloat(info.Duration, 'f', 3, 64))
+	}
+	prop("container", info.Container)
+	prop("video-codec", info.VideoCodec)
+	if info.Width > 0 && info.Height > 0 {
+		prop("width", strconv.Itoa(info.Width))
+		prop("height", strconv.Itoa(info.Height))
+	}
+	prop("audio-languages", strings.Join(audio, ","))
+	prop("subtitle-languages", strings.Join(subtitles, ","))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
 }
 
 type rangeInfo struct {
@@ -357,7 +792,7 @@ func formatFileSize(bytes int64) string {
 }
 
 func getMimeType(filename string) string {
-	ext := strings.ToLower(filename[strings.LastIndex(filename, "."):])
+	ext := strings.ToLower(path.Ext(filename))
 	mimeTypes := map[string]string{
 		".mp4":  "video/mp4",
 		".mkv":  "video/x-matroska",
@@ -369,6 +804,7 @@ func getMimeType(filename string) string {
 		".srt":  "text/plain; charset=utf-8", // 字幕文件也设置编码
 		".ass":  "text/plain; charset=utf-8",
 		".ssa":  "text/plain; charset=utf-8",
+		".vtt":  "text/vtt; charset=utf-8",
 	}
 
 	if mime, exists := mimeTypes[ext]; exists {
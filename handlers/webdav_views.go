@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"magnet-webdav/middleware"
+	"magnet-webdav/models"
+	"magnet-webdav/services"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 按标签和分类浏览的虚拟目录
+const (
+	tagViewDir      = "by-tag"
+	categoryViewDir = "by-category"
+)
+
+// davView 虚拟目录路径的解析结果。magnet 不为空时路径指向视图中的磁力链接目录或其中的文件，
+// 否则指向虚拟目录本身
+type davView struct {
+	href     string // 虚拟目录地址，以 / 结尾
+	name     string
+	dirs     []davResource
+	magnets  []models.LibraryMagnet
+	magnet   *models.LibraryMagnet
+	filePath string
+}
+
+func isViewPath(path string) bool {
+	first := strings.SplitN(path, "/", 2)[0]
+	return first == tagViewDir || first == categoryViewDir
+}
+
+// viewRootResources 根目录下的虚拟目录
+func viewRootResources() []davResource {
+	return []davResource{
+		{Href: davRoot + tagViewDir + "/", DisplayName: tagViewDir, IsDir: true, Modified: time.Now()},
+		{Href: davRoot + categoryViewDir + "/", DisplayName: categoryViewDir, IsDir: true, Modified: time.Now()},
+	}
+}
+
+// resolveView 解析 by-tag/<标签>/<磁力链接>/<文件> 或 by-category/<分类>/.../<磁力链接>/<文件>
+func (h *WebDAVHandler) resolveView(r *http.Request, path string) (*davView, error) {
+	user := middleware.CurrentUser(r)
+
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	view := &davView{href: davRoot + parts[0] + "/", name: parts[0]}
+	var rest []string
+
+	if parts[0] == tagViewDir {
+		if len(parts) == 1 {
+			tags, err := h.torrentService.ListTags(user)
+			if err != nil {
+				return nil, err
+			}
+			for _, tag := range tags {
+				view.dirs = append(view.dirs, viewDirResource(view.href, tag.Name))
+			}
+			return view, nil
+		}
+
+		tag := parts[1]
+		magnets, err := h.torrentService.MagnetsWithTag(user, tag)
+		if err != nil {
+			return nil, err
+		}
+		if len(magnets) == 0 {
+			return nil, services.ErrNotFound
+		}
+		view.href += url.PathEscape(tag) + "/"
+		view.name = tag
+		view.magnets = magnets
+		rest = parts[2:]
+	} else {
+		category, remaining, err := h.torrentService.ResolveCategoryPath(parts[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		var parentID uint
+		if category != nil {
+			parentID = category.ID
+			view.name = category.Name
+			for _, name := range parts[1 : len(parts)-len(remaining)] {
+				view.href += url.PathEscape(name) + "/"
+			}
+			if view.magnets, err = h.torrentService.MagnetsInCategory(user, category.ID); err != nil {
+				return nil, err
+			}
+		}
+
+		// 只有分类目录下才有磁力链接
+		if len(remaining) > 0 && category == nil {
+			return nil, services.ErrNotFound
+		}
+		if len(remaining) == 0 {
+			children, err := h.torrentService.ChildCategories(parentID)
+			if err != nil {
+				return nil, err
+			}
+			for _, child := range children {
+				view.dirs = append(view.dirs, viewDirResource(view.href, child.Name))
+			}
+		}
+		rest = remaining
+	}
+
+	if len(rest) == 0 {
+		return view, nil
+	}
+
+	// 剩余路径的第一段是磁力链接，之后是文件路径
+	for i := range view.magnets {
+		if view.magnets[i].ID == rest[0] {
+			view.magnet = &view.magnets[i]
+			view.filePath = strings.Join(rest[1:], "/")
+			return view, nil
+		}
+	}
+	return nil, services.ErrNotFound
+}
+
+func viewDirResource(base, name string) davResource {
+	return davResource{Href: base + url.PathEscape(name) + "/", DisplayName: name, IsDir: true, Modified: time.Now()}
+}
+
+// uniqueMagnets 去掉同一个种子的重复条目（自己的和别人共享的）
+func uniqueMagnets(magnets []models.LibraryMagnet) []models.LibraryMagnet {
+	seen := make(map[string]bool, len(magnets))
+	unique := make([]models.LibraryMagnet, 0, len(magnets))
+	for _, magnet := range magnets {
+		if !seen[magnet.ID] {
+			seen[magnet.ID] = true
+			unique = append(unique, magnet)
+		}
+	}
+	return unique
+}
+
+// collectViewResources 虚拟目录的 PROPFIND
+func (h *WebDAVHandler) collectViewResources(r *http.Request, path string) ([]davResource, error) {
+	view, err := h.resolveView(r, path)
+	if err != nil {
+		return nil, err
+	}
+	withChildren := r.Header.Get("Depth") != "0"
+
+	if view.magnet != nil {
+		return h.magnetResources(view.href, view.magnet, view.filePath, withChildren)
+	}
+
+	resources := []davResource{{Href: view.href, DisplayName: view.name, IsDir: true, Modified: time.Now()}}
+	if !withChildren {
+		return resources, nil
+	}
+	resources = append(resources, view.dirs...)
+	for _, magnet := range uniqueMagnets(view.magnets) {
+		resources = append(resources, magnetResource(view.href, &magnet))
+	}
+	return resources, nil
+}
+
+// handleViewGet 虚拟目录的 GET，目录输出 HTML 列表，文件直接播放
+func (h *WebDAVHandler) handleViewGet(w http.ResponseWriter, r *http.Request, path string) {
+	view, err := h.resolveView(r, path)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if view.magnet != nil {
+		if view.filePath == "" {
+			h.serveDirectoryListing(view.href, view.magnet.ID, w, r)
+		} else {
+			h.streamFile(w, r, view.magnet.ID, view.filePath)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	page := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>` + html.EscapeString(view.name) + `</title>
+    <style>
+        body { font-family: Arial, "Microsoft YaHei", sans-serif; margin: 20px; }
+        ul { list-style: none; padding: 0; }
+        li { padding: 8px; border-bottom: 1px solid #eee; }
+        a { text-decoration: none; color: #0366d6; }
+        .size { color: #666; font-size: 0.9em; }
+    </style>
+</head>
+<body>
+    <h1>` + html.EscapeString(view.name) + `</h1>
+    <ul>`
+
+	for _, dir := range view.dirs {
+		page += fmt.Sprintf(`<li><a href="%s">%s/</a></li>`, dir.Href, html.EscapeString(dir.DisplayName))
+	}
+	for _, magnet := range uniqueMagnets(view.magnets) {
+		page += fmt.Sprintf(`<li><a href="%s%s/">%s</a> <span class="size">(%s, %s)</span></li>`,
+			view.href, magnet.ID, html.EscapeString(magnetDisplayName(&magnet)), formatFileSize(magnet.TotalSize), getStatusText(magnet.Status))
+	}
+
+	page += `</ul>
+    <div style="margin-top: 20px;">
+        <a href="/webdav/">返回根目录</a>
+    </div>
+</body>
+</html>`
+
+	w.Write([]byte(page))
+}
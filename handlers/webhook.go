@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: webhookService,
+	}
+}
+
+func (h *WebhookHandler) ListEndpoints(c *gin.Context) {
+	c.JSON(http.StatusOK, h.webhookService.Endpoints())
+}
+
+// ListDeliveries 查看投递记录，可按 status、endpoint、event 过滤
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	deliveries, err := h.webhookService.ListDeliveries(services.DeliveryFilter{
+		Status:   c.Query("status"),
+		Endpoint: c.Query("endpoint"),
+		Event:    c.Query("event"),
+		Limit:    limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *WebhookHandler) GetDelivery(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	delivery, err := h.webhookService.GetDelivery(uint(id))
+	if err != nil {
+		respondDeliveryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// RetryDelivery 立即重新投递，常用于端点故障恢复后重发失败的记录
+func (h *WebhookHandler) RetryDelivery(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	delivery, err := h.webhookService.RetryDelivery(uint(id))
+	if err != nil {
+		respondDeliveryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+func respondDeliveryError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
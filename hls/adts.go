@@ -0,0 +1,50 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+)
+
+// aacConfig AudioSpecificConfig 中 ADTS 头部需要的字段
+type aacConfig struct {
+	profile     byte // ADTS 的 profile，为 objectType - 1
+	sampleIndex byte
+	channels    byte
+}
+
+// parseAACConfig 解析 AudioSpecificConfig。HE-AAC（SBR/PS）按其 AAC-LC 核心输出，
+// 解码器会通过隐式信令识别 SBR
+func parseAACConfig(b []byte) (*aacConfig, error) {
+	if len(b) < 2 {
+		return nil, errors.New("invalid AudioSpecificConfig")
+	}
+	objectType := b[0] >> 3
+	sampleIndex := (b[0]&0x07)<<1 | b[1]>>7
+	channels := (b[1] >> 3) & 0x0F
+	switch {
+	case objectType == 5 || objectType == 29:
+		objectType = 2
+	case objectType < 1 || objectType > 4:
+		return nil, fmt.Errorf("unsupported AAC object type %d", objectType)
+	}
+	if sampleIndex > 12 {
+		return nil, fmt.Errorf("unsupported AAC sampling frequency index %d", sampleIndex)
+	}
+	return &aacConfig{profile: objectType - 1, sampleIndex: sampleIndex, channels: channels}, nil
+}
+
+// adts 在 AAC 原始帧前加上 7 字节的 ADTS 头部（不带 CRC）
+func (c *aacConfig) adts(frame []byte) []byte {
+	length := len(frame) + 7
+	out := make([]byte, 0, length)
+	out = append(out,
+		0xFF,
+		0xF1, // MPEG-4，layer 0，没有 CRC
+		c.profile<<6|c.sampleIndex<<2|(c.channels>>2)&0x01,
+		(c.channels&0x03)<<6|byte(length>>11)&0x03,
+		byte(length>>3),
+		byte(length&0x07)<<5|0x1F,
+		0xFC,
+	)
+	return append(out, frame...)
+}
@@ -0,0 +1,166 @@
+package hls
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// NAL 单元类型
+const (
+	h264NALSPS = 7
+	h264NALAUD = 9
+	hevcNALVPS = 32
+	hevcNALSPS = 33
+	hevcNALAUD = 35
+)
+
+var (
+	startCode = []byte{0x00, 0x00, 0x00, 0x01}
+	// h264AUD 和 hevcAUD 为访问单元分隔符，TS 中每帧以它开始
+	h264AUD = []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0}
+	hevcAUD = []byte{0x00, 0x00, 0x00, 0x01, 0x46, 0x01, 0x50}
+)
+
+var (
+	errVideoConfig = errors.New("invalid video decoder configuration")
+	errVideoFrame  = errors.New("invalid length-prefixed video frame")
+)
+
+// videoConfig avcC/hvcC 中的 NAL 长度字段大小和参数集
+type videoConfig struct {
+	hevc       bool
+	lengthSize int
+	paramSets  [][]byte
+}
+
+// parseAVCConfig 解析 AVCDecoderConfigurationRecord
+func parseAVCConfig(b []byte) (*videoConfig, error) {
+	if len(b) < 7 || b[0] != 1 {
+		return nil, errVideoConfig
+	}
+	config := &videoConfig{lengthSize: int(b[4]&0x03) + 1}
+	data := b[5:]
+	for _, mask := range []byte{0x1F, 0xFF} { // SPS 数量只占低 5 位，之后是 PPS
+		if len(data) < 1 {
+			return nil, errVideoConfig
+		}
+		count := int(data[0] & mask)
+		data = data[1:]
+		for i := 0; i < count; i++ {
+			nal, rest, ok := readLengthPrefixed(data)
+			if !ok {
+				return nil, errVideoConfig
+			}
+			config.paramSets = append(config.paramSets, nal)
+			data = rest
+		}
+	}
+	return config, nil
+}
+
+// parseHEVCConfig 解析 HEVCDecoderConfigurationRecord，取出 VPS、SPS、PPS
+func parseHEVCConfig(b []byte) (*videoConfig, error) {
+	if len(b) < 23 {
+		return nil, errVideoConfig
+	}
+	config := &videoConfig{hevc: true, lengthSize: int(b[21]&0x03) + 1}
+	arrays := int(b[22])
+	data := b[23:]
+	for i := 0; i < arrays; i++ {
+		if len(data) < 3 {
+			return nil, errVideoConfig
+		}
+		count := int(binary.BigEndian.Uint16(data[1:]))
+		data = data[3:]
+		for j := 0; j < count; j++ {
+			nal, rest, ok := readLengthPrefixed(data)
+			if !ok {
+				return nil, errVideoConfig
+			}
+			config.paramSets = append(config.paramSets, nal)
+			data = rest
+		}
+	}
+	return config, nil
+}
+
+// readLengthPrefixed 读取 16 位长度前缀的数据
+func readLengthPrefixed(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 2 {
+		return nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, nil, false
+	}
+	return data[2 : 2+n], data[2+n:], true
+}
+
+// nalType 返回 NAL 单元类型
+func (c *videoConfig) nalType(nal []byte) byte {
+	if c.hevc {
+		return (nal[0] >> 1) & 0x3F
+	}
+	return nal[0] & 0x1F
+}
+
+// annexB 把长度前缀的 NAL 单元转换为起始码格式，开头加上访问单元分隔符，
+// 关键帧中没有参数集时插入 avcC/hvcC 中的参数集
+func (c *videoConfig) annexB(frame []byte, keyframe bool) ([]byte, error) {
+	var nals [][]byte
+	hasParamSets := false
+	size := len(frame)
+	for len(frame) > 0 {
+		if len(frame) < c.lengthSize {
+			return nil, errVideoFrame
+		}
+		n := 0
+		for _, b := range frame[:c.lengthSize] {
+			n = n<<8 | int(b)
+		}
+		frame = frame[c.lengthSize:]
+		if n > len(frame) {
+			return nil, errVideoFrame
+		}
+		nal := frame[:n]
+		frame = frame[n:]
+		if len(nal) == 0 {
+			continue
+		}
+		typ := c.nalType(nal)
+		if c.hevc {
+			switch typ {
+			case hevcNALAUD:
+				continue
+			case hevcNALVPS, hevcNALSPS:
+				hasParamSets = true
+			}
+		} else {
+			switch typ {
+			case h264NALAUD:
+				continue
+			case h264NALSPS:
+				hasParamSets = true
+			}
+		}
+		nals = append(nals, nal)
+	}
+
+	out := make([]byte, 0, size+len(nals)*4+64)
+	if c.hevc {
+		out = append(out, hevcAUD...)
+	} else {
+		out = append(out, h264AUD...)
+	}
+	if keyframe && !hasParamSets {
+		for _, nal := range c.paramSets {
+			out = append(out, startCode...)
+			out = append(out, nal...)
+		}
+	}
+	for _, nal := range nals {
+		out = append(out, startCode...)
+		out = append(out, nal...)
+	}
+	return out, nil
+}
@@ -0,0 +1,93 @@
+// Package hls 把 media 解出的 H.264/H.265 和 AAC 帧封装为 MPEG-TS 切片，并生成 HLS 播放列表。
+// 只改变封装格式，不转码
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"magnet-webdav/media"
+	"math"
+	"time"
+)
+
+// timestampOffset 所有时间戳加上的偏移，避免 B 帧视频开头的 DTS 为负数
+const timestampOffset = 10 * time.Second
+
+// Muxer 解析后的解码配置，同一个文件的所有切片共用
+type Muxer struct {
+	video     *videoConfig
+	videoType byte
+	audio     *aacConfig
+}
+
+// NewMuxer 解析索引中的 avcC/hvcC 和 AudioSpecificConfig
+func NewMuxer(ix *media.StreamIndex) (*Muxer, error) {
+	m := &Muxer{}
+	var err error
+	switch ix.Video.Codec {
+	case "h264":
+		m.videoType = streamTypeH264
+		m.video, err = parseAVCConfig(ix.Video.Config)
+	case "hevc":
+		m.videoType = streamTypeHEVC
+		m.video, err = parseHEVCConfig(ix.Video.Config)
+	default:
+		return nil, fmt.Errorf("%w: video codec %s", media.ErrUnsupportedCodec, ix.Video.Codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ix.Audio != nil {
+		if m.audio, err = parseAACConfig(ix.Audio.Config); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// WriteSegment 把一段的帧封装为 MPEG-TS，frames 需要按 DTS 排序
+func (m *Muxer) WriteSegment(w io.Writer, frames []media.Frame) error {
+	tw := newTSWriter(w, m.videoType, m.audio != nil)
+	if err := tw.writeTables(); err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		pts := timestamp90k(frame.PTS)
+		if frame.Video {
+			data, err := m.video.annexB(frame.Data, frame.Keyframe)
+			if err != nil {
+				return err
+			}
+			if err := tw.writePES(pidVideo, streamIDVideo, pts, timestamp90k(frame.DTS), data, frame.Keyframe, true); err != nil {
+				return err
+			}
+		} else if m.audio != nil {
+			if err := tw.writePES(pidAudio, streamIDAudio, pts, pts, m.audio.adts(frame.Data), false, false); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+// timestamp90k 转换为 90kHz 时钟，TS 中的时间戳为 33 位，循环不影响播放
+func timestamp90k(d time.Duration) int64 {
+	return int64((d+timestampOffset)*9/100000) & (1<<33 - 1)
+}
+
+// WritePlaylist 输出点播播放列表，第 i 段的地址为 <i>.ts
+func WritePlaylist(w io.Writer, segments []media.Segment) error {
+	var target float64
+	for _, segment := range segments {
+		target = math.Max(target, segment.Duration.Seconds())
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", int(math.Ceil(target)))
+	for i, segment := range segments {
+		fmt.Fprintf(bw, "#EXTINF:%.3f,\n%d.ts\n", segment.Duration.Seconds(), i)
+	}
+	bw.WriteString("#EXT-X-ENDLIST\n")
+	return bw.Flush()
+}
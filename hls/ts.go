@@ -0,0 +1,247 @@
+package hls
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// MPEG-TS 常量
+const (
+	tsPacketSize  = 188
+	tsPayloadSize = tsPacketSize - 4
+
+	pidPAT   = 0x0000
+	pidPMT   = 0x1000
+	pidVideo = 0x0100
+	pidAudio = 0x0101
+
+	streamTypeH264 = 0x1B
+	streamTypeHEVC = 0x24
+	streamTypeAAC  = 0x0F
+
+	streamIDVideo = 0xE0
+	streamIDAudio = 0xC0
+)
+
+// tsWriter 输出 188 字节的 TS 包，为每个 PID 维护连续计数器
+type tsWriter struct {
+	w         *bufio.Writer
+	counters  map[uint16]byte
+	videoType byte
+	audio     bool
+	packet    [tsPacketSize]byte
+}
+
+func newTSWriter(w io.Writer, videoType byte, audio bool) *tsWriter {
+	return &tsWriter{
+		w:         bufio.NewWriterSize(w, 64<<10),
+		counters:  make(map[uint16]byte),
+		videoType: videoType,
+		audio:     audio,
+	}
+}
+
+// Flush 写出缓冲的数据
+func (t *tsWriter) Flush() error {
+	return t.w.Flush()
+}
+
+// writeTables 输出 PAT 和 PMT，每个切片开头都需要
+func (t *tsWriter) writeTables() error {
+	// PAT：节目 1 的 PMT
+	pat := []byte{
+		0x00,       // table_id
+		0xB0, 0x0D, // section_syntax_indicator, section_length = 13
+		0x00, 0x01, // transport_stream_id
+		0xC1,       // version 0, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		0x00, 0x01, // program_number
+		0xE0 | byte(pidPMT>>8), byte(pidPMT & 0xFF),
+	}
+	if err := t.writeSection(pidPAT, pat); err != nil {
+		return err
+	}
+
+	// PMT：PCR 在视频 PID 上
+	streams := []byte{t.videoType, 0xE0 | byte(pidVideo>>8), byte(pidVideo & 0xFF), 0xF0, 0x00}
+	if t.audio {
+		streams = append(streams, streamTypeAAC, 0xE0|byte(pidAudio>>8), byte(pidAudio&0xFF), 0xF0, 0x00)
+	}
+	sectionLength := 9 + len(streams) + 4
+	pmt := []byte{
+		0x02, // table_id
+		0xB0 | byte(sectionLength>>8), byte(sectionLength),
+		0x00, 0x01, // program_number
+		0xC1,
+		0x00, 0x00,
+		0xE0 | byte(pidVideo>>8), byte(pidVideo & 0xFF), // PCR_PID
+		0xF0, 0x00, // program_info_length
+	}
+	return t.writeSection(pidPMT, append(pmt, streams...))
+}
+
+// writeSection 输出一个 PSI 表，末尾加上 CRC32
+func (t *tsWriter) writeSection(pid uint16, section []byte) error {
+	payload := make([]byte, 0, len(section)+5)
+	payload = append(payload, 0x00) // pointer_field
+	payload = append(payload, section...)
+	payload = binary.BigEndian.AppendUint32(payload, crc32MPEG2(section))
+	// PSI 表剩余空间用 0xFF 填充，而不是自适应字段
+	for len(payload) < tsPayloadSize {
+		payload = append(payload, 0xFF)
+	}
+	_, err := t.writePacket(pid, true, nil, payload)
+	return err
+}
+
+// writePES 把一帧封装为 PES 并拆分为 TS 包，pts 和 dts 的单位为 90kHz。
+// pcr 为 true 时在第一个包中写入 PCR，keyframe 时设置 random_access_indicator
+func (t *tsWriter) writePES(pid uint16, streamID byte, pts, dts int64, data []byte, keyframe, pcr bool) error {
+	header := make([]byte, 0, 19)
+	header = append(header, 0x00, 0x00, 0x01, streamID)
+	headerDataLength := 5
+	flags := byte(0x80)
+	if dts != pts {
+		headerDataLength = 10
+		flags = 0xC0
+	}
+	// 视频的 PES 长度可以为 0（不限长度），音频帧不会超过 65535 字节
+	length := 3 + headerDataLength + len(data)
+	if streamID == streamIDVideo || length > 0xFFFF {
+		length = 0
+	}
+	header = append(header, byte(length>>8), byte(length), 0x80, flags, byte(headerDataLength))
+	if dts != pts {
+		header = appendTimestamp(header, 0x3, pts)
+		header = appendTimestamp(header, 0x1, dts)
+	} else {
+		header = appendTimestamp(header, 0x2, pts)
+	}
+
+	payload := append(header, data...)
+	var adaptation []byte
+	if keyframe || pcr {
+		adaptation = []byte{0x00}
+		if keyframe {
+			adaptation[0] |= 0x40
+		}
+		if pcr {
+			adaptation[0] |= 0x10
+			adaptation = appendPCR(adaptation, dts)
+		}
+	}
+
+	first := true
+	for len(payload) > 0 {
+		n, err := t.writePacket(pid, first, adaptation, payload)
+		if err != nil {
+			return err
+		}
+		payload = payload[n:]
+		first = false
+		adaptation = nil
+	}
+	return nil
+}
+
+// writePacket 输出一个 TS 包，返回写入的负载字节数。负载不足一个包时用自适应字段填充
+func (t *tsWriter) writePacket(pid uint16, start bool, adaptation, payload []byte) (int, error) {
+	p := t.packet[:0]
+	b1 := byte(pid>>8) & 0x1F
+	if start {
+		b1 |= 0x40
+	}
+	counter := t.counters[pid]
+	t.counters[pid] = (counter + 1) & 0x0F
+
+	space := tsPayloadSize
+	if adaptation != nil {
+		space -= 1 + len(adaptation)
+	}
+	n := len(payload)
+	if n > space {
+		n = space
+	}
+	if pad := space - n; pad > 0 {
+		if adaptation == nil {
+			// 新建的自适应字段：长度字节占 1 字节，剩余为标志和填充
+			if pad > 1 {
+				adaptation = append([]byte{0x00}, stuffing(pad-2)...)
+			} else {
+				adaptation = []byte{}
+			}
+		} else {
+			adaptation = append(append([]byte{}, adaptation...), stuffing(pad)...)
+		}
+	}
+
+	control := byte(0x10) // 只有负载
+	if adaptation != nil {
+		control = 0x30
+	}
+	p = append(p, 0x47, b1, byte(pid), control|counter)
+	if adaptation != nil {
+		p = append(p, byte(len(adaptation)))
+		p = append(p, adaptation...)
+	}
+	p = append(p, payload[:n]...)
+	_, err := t.w.Write(p)
+	return n, err
+}
+
+func stuffing(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = 0xFF
+	}
+	return b
+}
+
+// appendTimestamp 按 PES 头部的格式写入 33 位时间戳，prefix 为 4 位标记
+func appendTimestamp(b []byte, prefix byte, ts int64) []byte {
+	return append(b,
+		prefix<<4|byte(ts>>29)&0x0E|1,
+		byte(ts>>22),
+		byte(ts>>14)&0xFE|1,
+		byte(ts>>7),
+		byte(ts<<1)&0xFE|1,
+	)
+}
+
+// appendPCR 写入 PCR，base 为 90kHz 时钟，扩展部分为 0
+func appendPCR(b []byte, base int64) []byte {
+	return append(b,
+		byte(base>>25),
+		byte(base>>17),
+		byte(base>>9),
+		byte(base>>1),
+		byte(base<<7)|0x7E,
+		0x00,
+	)
+}
+
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crc32MPEG2 PSI 表使用的 CRC32（不反转、初值全 1、结果不取反）
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"magnet-webdav/config"
 	"magnet-webdav/database"
 	"magnet-webdav/handlers"
+	"magnet-webdav/metrics"
 	"magnet-webdav/middleware"
 	"magnet-webdav/services"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -28,6 +31,13 @@ const (
 )
 
 func main() {
+	// 子命令在解析全局参数之前处理
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
 	if *version {
@@ -47,7 +57,6 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
-	defer database.Close()
 
 	// 初始化服务
 	torrentService := services.NewTorrentService(cfg, db)
@@ -57,12 +66,61 @@ func main() {
 		log.Fatal("Failed to start torrent service:", err)
 	}
 
+	shareService, err := services.NewShareLinkService(cfg, torrentService)
+	if err != nil {
+		log.Fatal("Failed to initialize share link service:", err)
+	}
+
+	authGuard := services.NewAuthGuard(cfg, db)
+
+	webhookService, err := services.NewWebhookService(cfg, torrentService)
+	if err != nil {
+		log.Fatal("Failed to initialize webhook service:", err)
+	}
+	webhookService.Start()
+
+	feedService := services.NewFeedService(cfg, torrentService)
+	feedService.Start()
+	searchService := services.NewSearchService(cfg, torrentService)
+	mediaService := services.NewMediaService(cfg, torrentService)
+	mediaService.Start()
+
+	healthService := services.NewHealthService(cfg, torrentService)
+	healthService.AddCheck("database", func(context.Context) error {
+		return database.HealthCheck()
+	})
+
+	// 注册按需采集的指标
+	metrics.Register(metrics.CollectorFunc(torrentService.CollectMetrics))
+	metrics.Register(metrics.CollectorFunc(database.CollectMetrics))
+
 	// 初始化处理器
-	apiHandler := handlers.NewAPIHandler(torrentService)
-	webdavHandler := handlers.NewWebDAVHandler(torrentService, cfg)
+	webdavHandler := handlers.NewWebDAVHandler(torrentService, mediaService, services.NewSubtitleService(torrentService), cfg)
+	h := &appHandlers{
+		api:          handlers.NewAPIHandler(torrentService),
+		webdav:       webdavHandler,
+		share:        handlers.NewShareHandler(shareService, webdavHandler),
+		admin:        handlers.NewAdminHandler(authGuard),
+		events:       handlers.NewEventsHandler(torrentService),
+		webhook:      handlers.NewWebhookHandler(webhookService),
+		export:       handlers.NewExportHandler(torrentService, services.NewImportService(torrentService)),
+		feed:         handlers.NewFeedHandler(feedService),
+		search:       handlers.NewSearchHandler(searchService),
+		media:        handlers.NewMediaHandler(mediaService),
+		stream:       handlers.NewStreamHandler(services.NewHLSService(cfg, torrentService)),
+		playback:     handlers.NewPlaybackHandler(services.NewPlaybackService(torrentService)),
+		qbit:         handlers.NewQBittorrentHandler(torrentService, cfg),
+		transmission: handlers.NewTransmissionHandler(torrentService, cfg),
+		aria2:        handlers.NewAria2Handler(torrentService, authGuard, cfg),
+		health: handlers.NewHealthHandler(healthService, gin.H{
+			"version":  AppVersion,
+			"database": cfg.Database.Driver,
+			"auth":     cfg.Auth.Enabled,
+		}),
+	}
 
 	// 设置路由
-	router := setupRouter(apiHandler, webdavHandler, cfg)
+	router := setupRouter(h, authGuard, cfg)
 
 	// 启动 HTTP 服务器
 	server := &http.Server{
@@ -71,44 +129,183 @@ func main() {
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 	}
+	// 事件流是长连接，关闭时需要主动结束，否则 Shutdown 会一直等到超时
+	server.RegisterOnShutdown(h.events.Close)
+
+	scheme := "http"
+	stopWatch := make(chan struct{})
+	var reloader *certReloader
+	if cfg.Server.TLSEnabled() {
+		scheme = "https"
+		reloader, err = newCertReloader(cfg.Server.TLSCert, cfg.Server.TLSKey)
+		if err != nil {
+			log.Fatal("Failed to load TLS certificate:", err)
+		}
+		server.TLSConfig, err = buildTLSConfig(&cfg.Server, reloader)
+		if err != nil {
+			log.Fatal("Failed to configure TLS:", err)
+		}
+		go reloader.Watch(cfg.Server.TLSReloadInterval, stopWatch)
+	}
 
 	log.Printf("Starting %s v%s", AppName, AppVersion)
-	log.Printf("Server running on :%s", cfg.Server.Port)
+	log.Printf("Server running on :%s (%s)", cfg.Server.Port, scheme)
 	log.Printf("Database: %s", cfg.Database.Driver)
-	log.Printf("WebDAV URL: http://localhost:%s/webdav/", cfg.Server.Port)
-	log.Printf("Admin interface: http://localhost:%s/admin", cfg.Server.Port)
+	log.Printf("WebDAV URL: %s://localhost:%s/webdav/", scheme, cfg.Server.Port)
+	log.Printf("Admin interface: %s://localhost:%s/admin", scheme, cfg.Server.Port)
 
 	if cfg.Auth.Enabled {
-		log.Printf("WebDAV Authentication: Enabled (username: %s)", cfg.Auth.Username)
+		log.Printf("WebDAV Authentication: Enabled (%s, %d users, default: %s)", cfg.Auth.Scheme, len(cfg.Auth.AllUsers()), cfg.Auth.Username)
 	} else {
 		log.Printf("WebDAV Authentication: Disabled")
 	}
+	if cfg.Server.TLSClientCA != "" {
+		log.Printf("TLS client certificate authentication: %s", cfg.Server.TLSClientAuth)
+	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.Server.TLSEnabled() {
+			// 证书由 TLSConfig.GetCertificate 提供
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal("Server failed to start:", err)
 		}
 	}()
 
-	// 等待中断信号
+	// HTTP 跳转到 HTTPS
+	servers := []*http.Server{server}
+	if cfg.Server.TLSEnabled() && cfg.Server.HTTPRedirectPort != "" {
+		redirectServer := newRedirectServer(&cfg.Server)
+		servers = append(servers, redirectServer)
+		log.Printf("HTTP redirect running on :%s", cfg.Server.HTTPRedirectPort)
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Redirect server failed to start:", err)
+			}
+		}()
+	}
+
+	// 只提供健康检查的 HTTP 端口
+	if cfg.Server.HealthPort != "" {
+		healthServer := newHealthServer(&cfg.Server, h.health)
+		servers = append(servers, healthServer)
+		log.Printf("Health checks running on :%s (http)", cfg.Server.HealthPort)
+		go func() {
+			if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Health server failed to start:", err)
+			}
+		}()
+	}
+
+	// 等待中断信号，SIGHUP 用于重新加载证书
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if reloader == nil {
+			log.Println("Received SIGHUP, TLS is not enabled")
+			continue
+		}
+		if err := reloader.Reload(); err != nil {
+			log.Printf("TLS certificate reload failed: %v", err)
+		}
+	}
+	close(stopWatch)
 
 	log.Println("Shutting down server...")
+	shutdown(servers, torrentService, webhookService, feedService, mediaService, cfg.Server.ShutdownTimeout)
+	log.Println("Server shutdown complete")
+}
 
-	// 清理资源
+// shutdown 按顺序关闭服务：停止接收连接，停止拉取订阅和媒体解析，等待进行中的流结束，保存状态，关闭种子客户端，停止 Webhook 投递，最后关闭数据库
+func shutdown(servers []*http.Server, torrentService *services.TorrentService, webhookService *services.WebhookService, feedService *services.FeedService, mediaService *services.MediaService, grace time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	// 停止接收新连接并等待进行中的请求完成，超时后强制断开
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Graceful shutdown timed out, closing %d remaining streams: %v", torrentService.OpenReaders(""), err)
+			srv.Close()
+		}
+	}
+
+	// 订阅拉取会添加种子，需要在关闭客户端之前停止
+	feedService.Stop()
+	// 媒体解析持有读取器，需要在等待读取器之前停止
+	mediaService.Stop()
+
+	// 强制断开后处理器需要一点时间关闭读取器
+	readerCtx, readerCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer readerCancel()
+	if err := torrentService.WaitForReaders(readerCtx, ""); err != nil {
+		log.Printf("%d torrent readers still open, closing anyway", torrentService.OpenReaders(""))
+	}
+
+	// 保存种子状态后关闭客户端
 	torrentService.Stop()
 
-	log.Println("Server shutdown complete")
+	// 未完成的投递保存在数据库中，下次启动时继续
+	webhookService.Stop()
+
+	if err := database.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+}
+
+// newHealthServer 只提供 /healthz 和 /readyz 的 HTTP 服务。启用 HTTPS（尤其是要求客户端证书）时，
+// 容器健康检查和探针无法直接访问主端口
+func newHealthServer(cfg *config.ServerConfig, health *handlers.HealthHandler) *http.Server {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/healthz", health.Liveness)
+	router.GET("/readyz", health.Readiness)
+	return &http.Server{
+		Addr:        ":" + cfg.HealthPort,
+		Handler:     router,
+		ReadTimeout: cfg.ReadTimeout,
+	}
+}
+
+// appHandlers 路由使用的处理器集合
+type appHandlers struct {
+	api          *handlers.APIHandler
+	webdav       *handlers.WebDAVHandler
+	share        *handlers.ShareHandler
+	admin        *handlers.AdminHandler
+	events       *handlers.EventsHandler
+	webhook      *handlers.WebhookHandler
+	health       *handlers.HealthHandler
+	export       *handlers.ExportHandler
+	feed         *handlers.FeedHandler
+	search       *handlers.SearchHandler
+	media        *handlers.MediaHandler
+	stream       *handlers.StreamHandler
+	playback     *handlers.PlaybackHandler
+	qbit         *handlers.QBittorrentHandler
+	transmission *handlers.TransmissionHandler
+	aria2        *handlers.Aria2Handler
 }
 
-func setupRouter(apiHandler *handlers.APIHandler, webdavHandler *handlers.WebDAVHandler, cfg *config.Config) http.Handler {
+func setupRouter(h *appHandlers, authGuard *services.AuthGuard, cfg *config.Config) http.Handler {
 	gin.SetMode(cfg.GetGinMode())
 
 	// 配置自定义恢复中间件
 	router := gin.New()
+
+	// 只有来自可信代理的请求才使用 X-Forwarded-For 作为客户端 IP
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatal("Invalid trusted proxies:", err)
+	}
+
 	router.Use(gin.Logger())
+	router.Use(middleware.MetricsMiddleware())
 	router.Use(gin.CustomRecovery(func(c *gin.Context, err interface{}) {
 		log.Printf("Panic recovered: %v", err)
 		c.JSON(500, gin.H{
@@ -116,37 +313,151 @@ func setupRouter(apiHandler *handlers.APIHandler, webdavHandler *handlers.WebDAV
 		})
 	}))
 
-	// 健康检查
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":    "healthy",
-			"version":   AppVersion,
-			"database":  cfg.Database.Driver,
-			"auth":      cfg.Auth.Enabled,
-		})
-	})
+	// 健康检查：/healthz 存活探针，/readyz 就绪探针
+	router.GET("/healthz", h.health.Liveness)
+	router.GET("/readyz", h.health.Readiness)
+	router.GET("/health", h.health.Health)
+
+	// API 和 WebDAV 共用同一个认证中间件，Digest nonce 在两者之间通用
+	authMiddleware := middleware.AuthMiddleware(cfg, authGuard)
 
-	// API 路由（不需要认证）
+	// Prometheus 指标：使用 metrics_token 或管理员账号访问
+	metricsHandler := gin.WrapH(metrics.Default.Handler())
+	router.GET("/metrics", middleware.MetricsToken(cfg, metricsHandler), authMiddleware, middleware.RequireAdmin(cfg), metricsHandler)
+
+	// API 路由（启用认证时按用户隔离媒体库）
 	api := router.Group("/api")
+	if cfg.Auth.Enabled {
+		api.Use(authMiddleware)
+	}
 	{
-		api.POST("/magnets", apiHandler.AddMagnet)
-		api.GET("/magnets", apiHandler.ListMagnets)
-		api.GET("/magnets/:id/files", apiHandler.ListFiles)
-		api.DELETE("/magnets/:id", apiHandler.RemoveMagnet)
-		api.GET("/stats", apiHandler.GetStats)
+		api.POST("/magnets", h.api.AddMagnet)
+		api.GET("/magnets", h.api.ListMagnets)
+		api.POST("/magnets/batch", h.api.BatchMagnets)
+		api.GET("/magnets/:id", h.api.GetMagnet)
+		api.PATCH("/magnets/:id", h.api.UpdateMagnet)
+		api.GET("/magnets/:id/files", h.api.ListFiles)
+		api.DELETE("/magnets/:id", h.api.RemoveMagnet)
+		api.GET("/magnets/:id/shares", h.api.ListShares)
+		api.POST("/magnets/:id/shares", h.api.ShareMagnet)
+		api.DELETE("/magnets/:id/shares/:share_id", h.api.UnshareMagnet)
+		api.POST("/magnets/:id/files/:index/share", h.share.CreateLink)
+		api.GET("/magnets/:id/media", h.media.ListMediaInfo)
+		api.GET("/magnets/:id/files/:index/media", h.media.GetMediaInfo)
+		api.POST("/magnets/:id/files/:index/media", h.media.ProbeMedia)
+		api.GET("/magnets/:id/files/:index/player", h.playback.GetPlayer)
+		api.GET("/magnets/:id/files/:index/state", h.playback.GetFileState)
+		api.GET("/magnets/:id/files/:index/progress", h.playback.GetProgress)
+		api.PUT("/magnets/:id/files/:index/progress", h.playback.SaveProgress)
+		api.DELETE("/magnets/:id/files/:index/progress", h.playback.DeleteProgress)
+		api.GET("/progress", h.playback.ListProgress)
+		api.GET("/share-links", h.share.ListLinks)
+		api.DELETE("/share-links/:id", h.share.RevokeLink)
+		api.GET("/tags", h.api.ListTags)
+		api.GET("/categories", h.api.ListCategories)
+		api.GET("/stats", h.api.GetStats)
+		api.GET("/export", h.export.Export)
+		api.POST("/import", h.export.Import)
+		api.GET("/import/:id", h.export.GetImport)
+		api.GET("/feeds", h.feed.ListFeeds)
+		api.POST("/feeds", h.feed.CreateFeed)
+		api.GET("/feeds/:id", h.feed.GetFeed)
+		api.PATCH("/feeds/:id", h.feed.UpdateFeed)
+		api.DELETE("/feeds/:id", h.feed.DeleteFeed)
+		api.POST("/feeds/:id/refresh", h.feed.RefreshFeed)
+		api.GET("/feeds/:id/items", h.feed.ListFeedItems)
+		api.GET("/search", h.search.Search)
+		api.GET("/search/providers", h.search.ListProviders)
+		api.POST("/search/add", h.search.AddResult)
+		api.GET("/events", h.events.Stream)
+	}
+
+	// 管理员 API
+	admin := api.Group("/admin")
+	admin.Use(middleware.RequireAdmin(cfg))
+	{
+		admin.GET("/bans", h.admin.ListBans)
+		admin.DELETE("/bans/:scope/:key", h.admin.ClearBan)
+		admin.GET("/auth-events", h.admin.ListAuthEvents)
+		admin.GET("/webhooks", h.webhook.ListEndpoints)
+		admin.GET("/webhooks/deliveries", h.webhook.ListDeliveries)
+		admin.GET("/webhooks/deliveries/:id", h.webhook.GetDelivery)
+		admin.POST("/webhooks/deliveries/:id/retry", h.webhook.RetryDelivery)
+		admin.PATCH("/tags/:id", h.api.RenameTag)
+		admin.DELETE("/tags/:id", h.api.DeleteTag)
+		admin.POST("/categories", h.api.CreateCategory)
+		admin.PATCH("/categories/:id", h.api.UpdateCategory)
+		admin.DELETE("/categories/:id", h.api.DeleteCategory)
+	}
+
+	// qBittorrent Web API 兼容接口，使用 Cookie 会话认证
+	if cfg.QBittorrent.Enabled {
+		sessionAuth := middleware.NewSessionAuth(cfg, authGuard)
+		qbit := router.Group("/api/v2")
+		qbit.POST("/auth/login", sessionAuth.Login)
+
+		authed := qbit.Group("", sessionAuth.Middleware())
+		{
+			authed.POST("/auth/logout", sessionAuth.Logout)
+			authed.GET("/app/version", h.qbit.AppVersion)
+			authed.GET("/app/webapiVersion", h.qbit.WebAPIVersion)
+			authed.GET("/app/preferences", h.qbit.Preferences)
+			for _, method := range []string{http.MethodGet, http.MethodPost} {
+				authed.Handle(method, "/torrents/info", h.qbit.TorrentsInfo)
+				authed.Handle(method, "/torrents/files", h.qbit.TorrentsFiles)
+				authed.Handle(method, "/torrents/properties", h.qbit.TorrentsProperties)
+				authed.Handle(method, "/torrents/categories", h.qbit.Categories)
+			}
+			authed.POST("/torrents/add", h.qbit.TorrentsAdd)
+			authed.POST("/torrents/delete", h.qbit.TorrentsDelete)
+			authed.POST("/torrents/createCategory", h.qbit.CreateCategory)
+			authed.POST("/torrents/setCategory", h.qbit.SetCategory)
+		}
+	}
+
+	// Transmission RPC 兼容接口，和 API 使用相同的认证
+	if cfg.Transmission.Enabled {
+		transmission := router.Group("/transmission")
+		if cfg.Auth.Enabled {
+			transmission.Use(authMiddleware)
+		}
+		transmission.POST("/rpc", h.transmission.ServeRPC)
+		transmission.GET("/rpc", h.transmission.ServeRPC)
+	}
+
+	// aria2 JSON-RPC 兼容接口，通过 aria2.secret 认证，错误的密钥和其他认证方式一样会锁定 IP
+	if cfg.Aria2.Enabled {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodOptions} {
+			router.Handle(method, "/jsonrpc", h.aria2.ServeRPC)
+		}
 	}
 
+	// 分享链接（通过签名校验，不需要认证）
+	router.GET("/s/:id", h.share.ServeLink)
+	router.HEAD("/s/:id", h.share.ServeLink)
+
 	// WebDAV 路由（需要认证）
 	webdavGroup := router.Group("/webdav")
 	if cfg.Auth.Enabled {
-		webdavGroup.Use(middleware.AuthMiddleware(cfg))
+		webdavGroup.Use(authMiddleware)
 	}
 	{
-		webdavGroup.Any("/*path", gin.WrapH(webdavHandler))
+		webdavGroup.Any("/*path", gin.WrapH(h.webdav))
+		// gin 的 Any 不包含 WebDAV 扩展方法
+		webdavGroup.Handle("PROPFIND", "/*path", gin.WrapH(h.webdav))
+	}
+
+	// HLS 播放（和 API 使用相同的认证）
+	streamGroup := router.Group("/stream")
+	if cfg.Auth.Enabled {
+		streamGroup.Use(authMiddleware)
 	}
+	streamGroup.GET("/:id/*path", h.stream.ServeHLS)
 
 	// 管理界面（不需要认证）
 	router.Static("/admin", "./web/admin")
+	// 播放页，视频和接口的请求由浏览器带上认证信息
+	router.Static("/player", "./web/player")
 	router.GET("/", func(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/admin")
 	})
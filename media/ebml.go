@@ -0,0 +1,163 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ebmlElement 文件中的一个 EBML 元素，Size 为 -1 表示大小未知（直播流的 Segment 和 Cluster）
+type ebmlElement struct {
+	ID     uint32
+	Offset int64 // 元素头部在文件中的位置
+	Header int64
+	Size   int64
+}
+
+// DataStart 元素内容的起始位置
+func (e ebmlElement) DataStart() int64 {
+	return e.Offset + e.Header
+}
+
+// End 元素的结束位置，大小未知或超出父元素时返回 parentEnd
+func (e ebmlElement) End(parentEnd int64) int64 {
+	if e.Size < 0 || e.DataStart()+e.Size > parentEnd {
+		return parentEnd
+	}
+	return e.DataStart() + e.Size
+}
+
+// ebmlChild 内存中解析出的子元素
+type ebmlChild struct {
+	ID   uint32
+	Data []byte
+}
+
+var errEBMLTruncated = errors.New("ebml: truncated element")
+
+// parseEBMLVint 解析变长整数，keepMarker 为 true 时保留长度标记位（用于元素 ID）
+func parseEBMLVint(buf []byte, maxLen int, keepMarker bool) (uint64, int, error) {
+	if len(buf) == 0 {
+		return 0, 0, errEBMLTruncated
+	}
+	length := 1
+	for mask := byte(0x80); length <= maxLen && buf[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > maxLen {
+		return 0, 0, fmt.Errorf("ebml: invalid vint 0x%02x", buf[0])
+	}
+	if len(buf) < length {
+		return 0, 0, errEBMLTruncated
+	}
+	value := uint64(buf[0])
+	if !keepMarker {
+		value &= uint64(0xFF) >> length
+	}
+	for i := 1; i < length; i++ {
+		value = value<<8 | uint64(buf[i])
+	}
+	return value, length, nil
+}
+
+// parseEBMLHeader 解析元素 ID 和大小，返回头部长度
+func parseEBMLHeader(buf []byte) (uint32, int64, int, error) {
+	id, idLen, err := parseEBMLVint(buf, 4, true)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	size, sizeLen, err := parseEBMLVint(buf[idLen:], 8, false)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// 所有数据位都为 1 表示大小未知
+	if size == uint64(1)<<(7*sizeLen)-1 {
+		return uint32(id), -1, idLen + sizeLen, nil
+	}
+	if size > math.MaxInt64/2 {
+		return 0, 0, 0, fmt.Errorf("ebml: element size %d too large", size)
+	}
+	return uint32(id), int64(size), idLen + sizeLen, nil
+}
+
+// readEBMLElement 读取 offset 处的元素头部
+func readEBMLElement(r io.ReadSeeker, offset int64) (ebmlElement, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return ebmlElement{}, err
+	}
+	var buf [12]byte
+	n, err := io.ReadFull(r, buf[:])
+	if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && n >= 2) {
+		return ebmlElement{}, err
+	}
+	id, size, header, err := parseEBMLHeader(buf[:n])
+	if err != nil {
+		return ebmlElement{}, err
+	}
+	return ebmlElement{ID: id, Offset: offset, Header: int64(header), Size: size}, nil
+}
+
+// readEBMLData 读取元素的内容，大小未知或超过 maxElementSize 时返回错误
+func readEBMLData(r io.ReadSeeker, e ebmlElement) ([]byte, error) {
+	if e.Size < 0 {
+		return nil, fmt.Errorf("ebml: element 0x%X has unknown size", e.ID)
+	}
+	if e.Size > maxElementSize {
+		return nil, fmt.Errorf("ebml: element 0x%X too large: %d bytes", e.ID, e.Size)
+	}
+	if _, err := r.Seek(e.DataStart(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, e.Size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// parseEBMLChildren 解析内存中的子元素列表
+func parseEBMLChildren(data []byte) ([]ebmlChild, error) {
+	var children []ebmlChild
+	for len(data) > 0 {
+		id, size, header, err := parseEBMLHeader(data)
+		if err != nil {
+			return children, err
+		}
+		if size < 0 || int64(len(data)-header) < size {
+			return children, errEBMLTruncated
+		}
+		children = append(children, ebmlChild{ID: id, Data: data[header : int64(header)+size]})
+		data = data[int64(header)+size:]
+	}
+	return children, nil
+}
+
+func ebmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+// ebmlString 字符串元素可能以 0 填充
+func ebmlString(data []byte) string {
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+	}
+	return string(data)
+}
@@ -0,0 +1,99 @@
+// Package media 解析 MP4 和 Matroska 容器的元数据。解析时只读取需要的字节范围，
+// 所以可以直接用在种子文件的读取器上，不需要下载整个文件
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// 容器格式
+const (
+	ContainerMP4      = "mp4"
+	ContainerMatroska = "matroska"
+	ContainerWebM     = "webm"
+)
+
+// 轨道类型
+const (
+	TrackVideo    = "video"
+	TrackAudio    = "audio"
+	TrackSubtitle = "subtitle"
+)
+
+// ErrUnsupported 不是 MP4 或 Matroska 文件
+var ErrUnsupported = errors.New("unsupported container")
+
+// maxElementSize 读入内存解析的单个元数据元素（MP4 的 box 或 Matroska 的元素）的最大大小
+const maxElementSize = 16 << 20
+
+// Track 容器中的一条轨道
+type Track struct {
+	Number     int    `json:"number"` // MP4 为 track ID，Matroska 为 TrackNumber
+	Type       string `json:"type"`   // video / audio / subtitle
+	Codec      string `json:"codec"`  // 规范化的编码名称，例如 h264、aac、subrip，无法识别时为容器中的原始编码 ID
+	Language   string `json:"language,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Default    bool   `json:"default"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+}
+
+// Info 容器的元数据
+type Info struct {
+	Container string
+	Duration  float64 // 秒，未知时为 0
+	Tracks    []Track
+}
+
+// Video 返回第一条视频轨道，没有时返回 nil
+func (info *Info) Video() *Track {
+	for i := range info.Tracks {
+		if info.Tracks[i].Type == TrackVideo {
+			return &info.Tracks[i]
+		}
+	}
+	return nil
+}
+
+// Probeable 根据扩展名判断文件是否可能是支持的容器
+func Probeable(filePath string) bool {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".mp4", ".m4v", ".mov", ".mkv", ".webm", ".mka":
+		return true
+	}
+	return false
+}
+
+// Probe 根据文件头识别容器并解析元数据，size 为文件大小
+func Probe(r io.ReadSeeker, size int64) (*Info, error) {
+	var head [12]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case head[0] == 0x1A && head[1] == 0x45 && head[2] == 0xDF && head[3] == 0xA3:
+		return probeMatroska(r, size)
+	case isMP4Box(string(head[4:8])):
+		return probeMP4(r, size)
+	}
+	return nil, ErrUnsupported
+}
+
+// language 规范化语言代码，und 表示未知
+func language(lang string) string {
+	lang = strings.TrimRight(strings.TrimSpace(lang), "\x00")
+	if lang == "und" {
+		return ""
+	}
+	return lang
+}
@@ -0,0 +1,399 @@
+package media
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// Matroska 元素 ID
+const (
+	mkvEBMLHeader           = 0x1A45DFA3
+	mkvDocType              = 0x4282
+	mkvSegment              = 0x18538067
+	mkvSeekHead             = 0x114D9B74
+	mkvSeek                 = 0x4DBB
+	mkvSeekID               = 0x53AB
+	mkvSeekPosition         = 0x53AC
+	mkvInfo                 = 0x1549A966
+	mkvTimecodeScale        = 0x2AD7B1
+	mkvDuration             = 0x4489
+	mkvTracks               = 0x1654AE6B
+	mkvTrackEntry           = 0xAE
+	mkvTrackNumber          = 0xD7
+	mkvTrackType            = 0x83
+	mkvCodecID              = 0x86
+	mkvCodecPrivate         = 0x63A2
+	mkvLanguage             = 0x22B59C
+	mkvLanguageBCP47        = 0x22B59D
+	mkvName                 = 0x536E
+	mkvFlagDefault          = 0x88
+	mkvVideo                = 0xE0
+	mkvPixelWidth           = 0xB0
+	mkvPixelHeight          = 0xBA
+	mkvAudio                = 0xE1
+	mkvSamplingFreq         = 0xB5
+	mkvChannels             = 0x9F
+	mkvContentEncodings     = 0x6D80
+	mkvContentEncoding      = 0x6240
+	mkvContentCompression   = 0x5034
+	mkvContentCompAlgo      = 0x4254
+	mkvContentCompSettings  = 0x4255
+	mkvCluster              = 0x1F43B675
+	mkvClusterTimestamp     = 0xE7
+	mkvSimpleBlock          = 0xA3
+	mkvBlockGroup           = 0xA0
+	mkvBlock                = 0xA1
+	mkvBlockDuration        = 0x9B
+	mkvReferenceBlock       = 0xFB
+	mkvCues                 = 0x1C53BB6B
+	mkvChapters             = 0x1043A770
+	mkvTags                 = 0x1254C367
+	mkvAttachments          = 0x1941A469
+	mkvDefaultTimecodeScale = 1000000
+)
+
+// 内容压缩算法
+const (
+	mkvCompressionNone            = -1
+	mkvCompressionZlib            = 0
+	mkvCompressionHeaderStripping = 3
+)
+
+// matroskaTrack Track 加上解析数据块需要的编码私有数据和压缩方式
+type matroskaTrack struct {
+	Track
+	CodecID      string
+	CodecPrivate []byte
+	Compression  int    // mkvCompression*，其他算法不支持
+	CompSettings []byte // 头部剥离时被去掉的字节
+}
+
+// matroskaSegment Segment 中 Cluster 之前的元数据
+type matroskaSegment struct {
+	DocType       string
+	DataStart     int64 // Segment 内容的起始位置，SeekHead 中的位置相对于它
+	End           int64
+	TimecodeScale uint64 // 纳秒
+	Duration      float64
+	Tracks        []matroskaTrack
+	FirstCluster  int64 // 第一个 Cluster 的位置，没有时为 -1
+	Cues          int64 // Cues 的位置，没有时为 -1
+}
+
+// readMatroskaSegment 顺序读取 Segment 的顶层元素直到第一个 Cluster，
+// Info 或 Tracks 不在 Cluster 之前时通过 SeekHead 定位
+func readMatroskaSegment(r io.ReadSeeker, size int64) (*matroskaSegment, error) {
+	header, err := readEBMLElement(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if header.ID != mkvEBMLHeader {
+		return nil, ErrUnsupported
+	}
+	data, err := readEBMLData(r, header)
+	if err != nil {
+		return nil, err
+	}
+	seg := &matroskaSegment{DocType: ContainerMatroska, TimecodeScale: mkvDefaultTimecodeScale, FirstCluster: -1, Cues: -1}
+	children, _ := parseEBMLChildren(data)
+	for _, child := range children {
+		if child.ID == mkvDocType {
+			seg.DocType = ebmlString(child.Data)
+		}
+	}
+
+	segment, err := readEBMLElement(r, header.End(size))
+	if err != nil {
+		return nil, err
+	}
+	if segment.ID != mkvSegment {
+		return nil, errors.New("matroska: segment not found")
+	}
+	seg.DataStart = segment.DataStart()
+	seg.End = segment.End(size)
+
+	var infoFound, tracksFound bool
+	seekPositions := make(map[uint32]int64)
+	for offset := seg.DataStart; seg.End-offset >= 2; {
+		element, err := readEBMLElement(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		if element.ID == mkvCluster {
+			seg.FirstCluster = offset
+			break
+		}
+		if element.Size < 0 {
+			break
+		}
+		switch element.ID {
+		case mkvCues:
+			seg.Cues = offset
+		case mkvSeekHead:
+			data, err := readEBMLData(r, element)
+			if err != nil {
+				return nil, err
+			}
+			seg.parseSeekHead(data, seekPositions)
+		case mkvInfo:
+			data, err := readEBMLData(r, element)
+			if err != nil {
+				return nil, err
+			}
+			seg.parseInfo(data)
+			infoFound = true
+		case mkvTracks:
+			data, err := readEBMLData(r, element)
+			if err != nil {
+				return nil, err
+			}
+			seg.parseTracks(data)
+			tracksFound = true
+		}
+		offset = element.End(seg.End)
+	}
+
+	load := func(id uint32, parse func([]byte)) error {
+		position, ok := seekPositions[id]
+		if !ok || position >= seg.End {
+			return nil
+		}
+		element, err := readEBMLElement(r, position)
+		if err != nil || element.ID != id {
+			return err
+		}
+		data, err := readEBMLData(r, element)
+		if err != nil {
+			return err
+		}
+		parse(data)
+		return nil
+	}
+	// Cues 通常在所有 Cluster 之后，只能通过 SeekHead 找到
+	if position, ok := seekPositions[mkvCues]; ok && seg.Cues < 0 && position < seg.End {
+		seg.Cues = position
+	}
+	if !infoFound {
+		if err := load(mkvInfo, seg.parseInfo); err != nil {
+			return nil, err
+		}
+	}
+	if !tracksFound {
+		if err := load(mkvTracks, seg.parseTracks); err != nil {
+			return nil, err
+		}
+	}
+	return seg, nil
+}
+
+func (seg *matroskaSegment) parseSeekHead(data []byte, positions map[uint32]int64) {
+	seeks, _ := parseEBMLChildren(data)
+	for _, seek := range seeks {
+		if seek.ID != mkvSeek {
+			continue
+		}
+		var id uint32
+		position := int64(-1)
+		fields, _ := parseEBMLChildren(seek.Data)
+		for _, field := range fields {
+			switch field.ID {
+			case mkvSeekID:
+				id = uint32(ebmlUint(field.Data))
+			case mkvSeekPosition:
+				position = int64(ebmlUint(field.Data))
+			}
+		}
+		if id != 0 && position >= 0 {
+			positions[id] = seg.DataStart + position
+		}
+	}
+}
+
+func (seg *matroskaSegment) parseInfo(data []byte) {
+	var duration float64
+	fields, _ := parseEBMLChildren(data)
+	for _, field := range fields {
+		switch field.ID {
+		case mkvTimecodeScale:
+			if scale := ebmlUint(field.Data); scale > 0 {
+				seg.TimecodeScale = scale
+			}
+		case mkvDuration:
+			duration = ebmlFloat(field.Data)
+		}
+	}
+	// Duration 的单位是 TimecodeScale
+	seg.Duration = duration * float64(seg.TimecodeScale) / 1e9
+}
+
+func (seg *matroskaSegment) parseTracks(data []byte) {
+	entries, _ := parseEBMLChildren(data)
+	for _, entry := range entries {
+		if entry.ID != mkvTrackEntry {
+			continue
+		}
+		track := matroskaTrack{Track: Track{Default: true, Language: "eng"}, Compression: mkvCompressionNone}
+		var trackType uint64
+		var bcp47 string
+		fields, _ := parseEBMLChildren(entry.Data)
+		for _, field := range fields {
+			switch field.ID {
+			case mkvTrackNumber:
+				track.Number = int(ebmlUint(field.Data))
+			case mkvTrackType:
+				trackType = ebmlUint(field.Data)
+			case mkvCodecID:
+				track.CodecID = ebmlString(field.Data)
+			case mkvCodecPrivate:
+				track.CodecPrivate = field.Data
+			case mkvLanguage:
+				track.Language = ebmlString(field.Data)
+			case mkvLanguageBCP47:
+				bcp47 = ebmlString(field.Data)
+			case mkvName:
+				track.Name = ebmlString(field.Data)
+			case mkvFlagDefault:
+				track.Default = ebmlUint(field.Data) != 0
+			case mkvVideo:
+				video, _ := parseEBMLChildren(field.Data)
+				for _, v := range video {
+					switch v.ID {
+					case mkvPixelWidth:
+						track.Width = int(ebmlUint(v.Data))
+					case mkvPixelHeight:
+						track.Height = int(ebmlUint(v.Data))
+					}
+				}
+			case mkvContentEncodings:
+				track.parseContentEncodings(field.Data)
+			case mkvAudio:
+				audio, _ := parseEBMLChildren(field.Data)
+				for _, a := range audio {
+					switch a.ID {
+					case mkvSamplingFreq:
+						track.SampleRate = int(ebmlFloat(a.Data))
+					case mkvChannels:
+						track.Channels = int(ebmlUint(a.Data))
+					}
+				}
+			}
+		}
+
+		switch trackType {
+		case 1:
+			track.Type = TrackVideo
+		case 2:
+			track.Type = TrackAudio
+		case 17:
+			track.Type = TrackSubtitle
+		default:
+			continue
+		}
+		// LanguageBCP47 存在时优先于 Language
+		if bcp47 != "" {
+			track.Language = bcp47
+		}
+		track.Language = language(track.Language)
+		track.Codec = matroskaCodec(track.CodecID)
+		seg.Tracks = append(seg.Tracks, track)
+	}
+}
+
+// parseContentEncodings 读取第一个内容压缩设置，ContentCompAlgo 缺省为 zlib
+func (track *matroskaTrack) parseContentEncodings(data []byte) {
+	encodings, _ := parseEBMLChildren(data)
+	for _, encoding := range encodings {
+		if encoding.ID != mkvContentEncoding {
+			continue
+		}
+		fields, _ := parseEBMLChildren(encoding.Data)
+		for _, field := range fields {
+			if field.ID != mkvContentCompression {
+				continue
+			}
+			track.Compression = mkvCompressionZlib
+			settings, _ := parseEBMLChildren(field.Data)
+			for _, setting := range settings {
+				switch setting.ID {
+				case mkvContentCompAlgo:
+					track.Compression = int(ebmlUint(setting.Data))
+				case mkvContentCompSettings:
+					track.CompSettings = setting.Data
+				}
+			}
+			return
+		}
+	}
+}
+
+// probeMatroska 解析 Matroska/WebM 的时长和轨道
+func probeMatroska(r io.ReadSeeker, size int64) (*Info, error) {
+	seg, err := readMatroskaSegment(r, size)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Container: ContainerMatroska, Duration: seg.Duration}
+	if seg.DocType == "webm" {
+		info.Container = ContainerWebM
+	}
+	for _, track := range seg.Tracks {
+		info.Tracks = append(info.Tracks, track.Track)
+	}
+	return info, nil
+}
+
+// matroskaCodec 把 Matroska 的 CodecID 映射为规范化的编码名称
+func matroskaCodec(codecID string) string {
+	switch codecID {
+	case "V_MPEG4/ISO/AVC":
+		return "h264"
+	case "V_MPEGH/ISO/HEVC":
+		return "hevc"
+	case "V_AV1":
+		return "av1"
+	case "V_VP8":
+		return "vp8"
+	case "V_VP9":
+		return "vp9"
+	case "V_MPEG4/ISO/ASP", "V_MPEG4/ISO/SP", "V_MPEG4/ISO/AP":
+		return "mpeg4"
+	case "V_MPEG2":
+		return "mpeg2video"
+	case "A_AC3":
+		return "ac3"
+	case "A_EAC3":
+		return "eac3"
+	case "A_DTS":
+		return "dts"
+	case "A_TRUEHD":
+		return "truehd"
+	case "A_FLAC":
+		return "flac"
+	case "A_OPUS":
+		return "opus"
+	case "A_VORBIS":
+		return "vorbis"
+	case "A_MPEG/L3":
+		return "mp3"
+	case "A_MPEG/L2":
+		return "mp2"
+	case "S_TEXT/UTF8":
+		return "subrip"
+	case "S_TEXT/ASS", "S_TEXT/SSA", "S_ASS", "S_SSA":
+		return "ass"
+	case "S_TEXT/WEBVTT":
+		return "webvtt"
+	case "S_HDMV/PGS":
+		return "pgs"
+	case "S_VOBSUB":
+		return "dvdsub"
+	}
+	switch {
+	case strings.HasPrefix(codecID, "A_AAC"):
+		return "aac"
+	case strings.HasPrefix(codecID, "A_PCM"):
+		return "pcm"
+	}
+	return codecID
+}
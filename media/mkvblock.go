@@ -0,0 +1,269 @@
+package media
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// matroskaBlock 一个 SimpleBlock 或 Block，带 lacing 的块拆分为多帧
+type matroskaBlock struct {
+	Cluster  int64 // 所在 Cluster 的位置
+	Track    uint64
+	Timecode int64 // 绝对时间，单位 TimecodeScale
+	Duration int64 // BlockDuration，没有时为 0
+	Keyframe bool
+	Frames   [][]byte
+}
+
+// walkMatroskaClusters 从 offset 开始依次读取 Cluster，want 为 true 的轨道的块交给 fn 处理，
+// fn 返回 errStopWalk 时停止。遇到文件末尾时正常结束
+func walkMatroskaClusters(r io.ReadSeeker, seg *matroskaSegment, offset int64, want func(track uint64) bool, fn func(block *matroskaBlock) error) error {
+	for seg.End-offset >= 2 {
+		element, err := readEBMLElement(r, offset)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if element.ID != mkvCluster {
+			if element.Size < 0 {
+				return nil
+			}
+			offset = element.End(seg.End)
+			continue
+		}
+		offset, err = walkMatroskaCluster(r, element, seg.End, want, fn)
+		if errors.Is(err, errStopWalk) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMatroskaTopLevel 大小未知的 Cluster 遇到这些元素时结束
+func isMatroskaTopLevel(id uint32) bool {
+	switch id {
+	case mkvCluster, mkvCues, mkvChapters, mkvTags, mkvAttachments, mkvSeekHead, mkvInfo, mkvTracks:
+		return true
+	}
+	return false
+}
+
+// walkMatroskaCluster 读取 Cluster 中的块，返回下一个顶层元素的位置
+func walkMatroskaCluster(r io.ReadSeeker, cluster ebmlElement, segmentEnd int64, want func(track uint64) bool, fn func(block *matroskaBlock) error) (int64, error) {
+	end := cluster.End(segmentEnd)
+	var clusterTime int64
+	for offset := cluster.DataStart(); end-offset >= 2; {
+		child, err := readEBMLElement(r, offset)
+		if err != nil {
+			return 0, err
+		}
+		if cluster.Size < 0 && isMatroskaTopLevel(child.ID) {
+			return offset, nil
+		}
+		if child.Size < 0 {
+			return 0, fmt.Errorf("matroska: element 0x%X in cluster has unknown size", child.ID)
+		}
+
+		var block *matroskaBlock
+		switch child.ID {
+		case mkvClusterTimestamp:
+			data, err := readEBMLData(r, child)
+			if err != nil {
+				return 0, err
+			}
+			clusterTime = int64(ebmlUint(data))
+		case mkvSimpleBlock:
+			if block, err = readMatroskaBlock(r, child, want); err != nil {
+				return 0, err
+			}
+		case mkvBlockGroup:
+			if block, err = readMatroskaBlockGroup(r, child, want); err != nil {
+				return 0, err
+			}
+		}
+		if block != nil {
+			block.Cluster = cluster.Offset
+			block.Timecode += clusterTime
+			if err := fn(block); err != nil {
+				return 0, err
+			}
+		}
+		offset = child.End(end)
+	}
+	return end, nil
+}
+
+// readMatroskaBlockGroup BlockGroup 中的 Block 和 BlockDuration 顺序不固定，先找出两者再读取。
+// 没有 ReferenceBlock 的块是关键帧
+func readMatroskaBlockGroup(r io.ReadSeeker, group ebmlElement, want func(track uint64) bool) (*matroskaBlock, error) {
+	end := group.End(group.DataStart() + group.Size)
+	var blockElement *ebmlElement
+	var duration int64
+	keyframe := true
+	for offset := group.DataStart(); end-offset >= 2; {
+		child, err := readEBMLElement(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		if child.Size < 0 {
+			return nil, fmt.Errorf("matroska: element 0x%X in block group has unknown size", child.ID)
+		}
+		switch child.ID {
+		case mkvBlock:
+			found := child
+			blockElement = &found
+		case mkvBlockDuration:
+			data, err := readEBMLData(r, child)
+			if err != nil {
+				return nil, err
+			}
+			duration = int64(ebmlUint(data))
+		case mkvReferenceBlock:
+			keyframe = false
+		}
+		offset = child.End(end)
+	}
+	if blockElement == nil {
+		return nil, nil
+	}
+	block, err := readMatroskaBlock(r, *blockElement, want)
+	if block != nil {
+		block.Duration = duration
+		block.Keyframe = keyframe
+	}
+	return block, err
+}
+
+// readMatroskaBlock 解析块头部，是 want 的轨道时读取内容并拆分 lacing，否则返回 nil。
+// 返回的 Timecode 相对于 Cluster
+func readMatroskaBlock(r io.ReadSeeker, element ebmlElement, want func(track uint64) bool) (*matroskaBlock, error) {
+	if _, err := r.Seek(element.DataStart(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	head := make([]byte, 11)
+	if element.Size < int64(len(head)) {
+		head = head[:element.Size]
+	}
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	trackNumber, n, err := parseEBMLVint(head, 8, false)
+	if err != nil || len(head) < n+3 || !want(trackNumber) {
+		return nil, nil
+	}
+
+	dataSize := element.Size - int64(n+3)
+	if dataSize > maxElementSize {
+		return nil, fmt.Errorf("matroska: block too large: %d bytes", dataSize)
+	}
+	if _, err := r.Seek(element.DataStart()+int64(n+3), io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, dataSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	flags := head[n+2]
+	frames, err := splitMatroskaLacing(data, flags&0x06)
+	if err != nil {
+		return nil, err
+	}
+	return &matroskaBlock{
+		Track:    trackNumber,
+		Timecode: int64(int16(uint16(head[n])<<8 | uint16(head[n+1]))),
+		Keyframe: flags&0x80 != 0,
+		Frames:   frames,
+	}, nil
+}
+
+var errMatroskaLacing = errors.New("matroska: invalid lacing")
+
+// splitMatroskaLacing 按 Xiph、固定大小或 EBML lacing 拆分块内容
+func splitMatroskaLacing(data []byte, lacing byte) ([][]byte, error) {
+	if lacing == 0 {
+		return [][]byte{data}, nil
+	}
+	if len(data) < 1 {
+		return nil, errMatroskaLacing
+	}
+	count := int(data[0]) + 1
+	data = data[1:]
+
+	sizes := make([]int, count)
+	switch lacing {
+	case 0x02: // Xiph
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(data) == 0 {
+					return nil, errMatroskaLacing
+				}
+				b := data[0]
+				data = data[1:]
+				sizes[i] += int(b)
+				if b != 0xFF {
+					break
+				}
+			}
+		}
+	case 0x04: // 固定大小
+		if len(data)%count != 0 {
+			return nil, errMatroskaLacing
+		}
+		for i := range sizes[:count-1] {
+			sizes[i] = len(data) / count
+		}
+	case 0x06: // EBML，第一帧为无符号数，之后为与前一帧的有符号差值
+		first, n, err := parseEBMLVint(data, 8, false)
+		if err != nil {
+			return nil, errMatroskaLacing
+		}
+		data = data[n:]
+		sizes[0] = int(first)
+		for i := 1; i < count-1; i++ {
+			raw, n, err := parseEBMLVint(data, 8, false)
+			if err != nil {
+				return nil, errMatroskaLacing
+			}
+			data = data[n:]
+			sizes[i] = sizes[i-1] + int(int64(raw)-(int64(1)<<(7*n-1)-1))
+		}
+	}
+
+	frames := make([][]byte, count)
+	for i := 0; i < count-1; i++ {
+		if sizes[i] < 0 || sizes[i] > len(data) {
+			return nil, errMatroskaLacing
+		}
+		frames[i] = data[:sizes[i]]
+		data = data[sizes[i]:]
+	}
+	frames[count-1] = data
+	return frames, nil
+}
+
+// decode 还原 ContentEncoding 压缩的帧内容
+func (track *matroskaTrack) decode(data []byte) ([]byte, error) {
+	switch track.Compression {
+	case mkvCompressionNone:
+		return data, nil
+	case mkvCompressionHeaderStripping:
+		return append(append([]byte{}, track.CompSettings...), data...), nil
+	case mkvCompressionZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("matroska: track %d: %w", track.Number, err)
+		}
+		defer zr.Close()
+		return io.ReadAll(io.LimitReader(zr, maxElementSize))
+	}
+	return nil, fmt.Errorf("matroska: track %d uses unsupported compression %d", track.Number, track.Compression)
+}
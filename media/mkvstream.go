@@ -0,0 +1,267 @@
+package media
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cues 中用到的元素 ID
+const (
+	mkvCuePoint           = 0xBB
+	mkvCueTime            = 0xB3
+	mkvCueTrackPositions  = 0xB7
+	mkvCueTrack           = 0xF7
+	mkvCueClusterPosition = 0xF1
+)
+
+// aacFrameSamples 每个 AAC 帧的采样数，用于计算 lacing 中后续帧的时间
+const aacFrameSamples = 1024
+
+// aacSampleRates AudioSpecificConfig 中采样率下标对应的采样率
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// matroskaCuePoint 视频关键帧的时间（单位 TimecodeScale）和所在 Cluster 的位置
+type matroskaCuePoint struct {
+	Time    int64
+	Cluster int64
+}
+
+// matroskaStream 每段开始的 Cue
+type matroskaStream struct {
+	seg          *matroskaSegment
+	video, audio *matroskaTrack
+	starts       []matroskaCuePoint
+}
+
+// buildMatroskaStreamIndex 根据 Cues 中视频轨道的关键帧划分段，没有 Cues 的文件不支持
+func buildMatroskaStreamIndex(r io.ReadSeeker, size int64, target time.Duration) (*StreamIndex, error) {
+	seg, err := readMatroskaSegment(r, size)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Track, len(seg.Tracks))
+	for i, track := range seg.Tracks {
+		infos[i] = track.Track
+	}
+	videoIndex, audioIndex, err := pickStreamTracks(infos)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &matroskaStream{seg: seg, video: &seg.Tracks[videoIndex]}
+	if len(stream.video.CodecPrivate) == 0 {
+		return nil, fmt.Errorf("%w: %s track without codec private data", ErrUnsupportedCodec, stream.video.Codec)
+	}
+	ix := &StreamIndex{Video: StreamTrack{Track: stream.video.Track, Config: stream.video.CodecPrivate}, reader: stream}
+	if audioIndex >= 0 {
+		audio := &seg.Tracks[audioIndex]
+		if config := matroskaAACConfig(audio); config != nil {
+			stream.audio = audio
+			ix.Audio = &StreamTrack{Track: audio.Track, Config: config}
+		}
+	}
+
+	if seg.FirstCluster < 0 || seg.Cues < 0 {
+		return nil, fmt.Errorf("%w: matroska without cues", ErrUnsupported)
+	}
+	points, err := readMatroskaCues(r, seg, uint64(stream.video.Number))
+	if err != nil {
+		return nil, err
+	}
+
+	scale := time.Duration(seg.TimecodeScale)
+	keyframes := make([]time.Duration, len(points))
+	for i, point := range points {
+		keyframes[i] = time.Duration(point.Time) * scale
+	}
+	// 第一段总是从第一个 Cluster 开始，包括第一个 Cue 之前的数据
+	stream.starts = []matroskaCuePoint{{Cluster: seg.FirstCluster}}
+	for i, k := range segmentBoundaries(keyframes, target) {
+		if i > 0 {
+			stream.starts = append(stream.starts, points[k])
+		}
+	}
+
+	clustersEnd := seg.End
+	if last := stream.starts[len(stream.starts)-1]; seg.Cues > last.Cluster && seg.Cues < clustersEnd {
+		clustersEnd = seg.Cues
+	}
+	for k, start := range stream.starts {
+		segment := Segment{Offset: start.Cluster, End: clustersEnd}
+		if k > 0 {
+			segment.Start = time.Duration(start.Time) * scale
+			ix.Segments[k-1].Duration = segment.Start - ix.Segments[k-1].Start
+			ix.Segments[k-1].End = start.Cluster
+		}
+		ix.Segments = append(ix.Segments, segment)
+	}
+	lastSegment := &ix.Segments[len(ix.Segments)-1]
+	lastSegment.Duration = time.Duration(seg.Duration*float64(time.Second)) - lastSegment.Start
+	if lastSegment.Duration <= 0 {
+		lastSegment.Duration = target
+	}
+	return ix, nil
+}
+
+// readMatroskaCues 读取指定轨道的 CuePoint，按时间排序
+func readMatroskaCues(r io.ReadSeeker, seg *matroskaSegment, track uint64) ([]matroskaCuePoint, error) {
+	element, err := readEBMLElement(r, seg.Cues)
+	if err != nil {
+		return nil, err
+	}
+	if element.ID != mkvCues {
+		return nil, fmt.Errorf("%w: matroska without cues", ErrUnsupported)
+	}
+	data, err := readEBMLData(r, element)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []matroskaCuePoint
+	cuePoints, _ := parseEBMLChildren(data)
+	for _, cuePoint := range cuePoints {
+		if cuePoint.ID != mkvCuePoint {
+			continue
+		}
+		var cueTime int64
+		var positions [][]ebmlChild
+		fields, _ := parseEBMLChildren(cuePoint.Data)
+		for _, field := range fields {
+			switch field.ID {
+			case mkvCueTime:
+				cueTime = int64(ebmlUint(field.Data))
+			case mkvCueTrackPositions:
+				children, _ := parseEBMLChildren(field.Data)
+				positions = append(positions, children)
+			}
+		}
+		for _, position := range positions {
+			var cueTrack uint64
+			cluster := int64(-1)
+			for _, field := range position {
+				switch field.ID {
+				case mkvCueTrack:
+					cueTrack = ebmlUint(field.Data)
+				case mkvCueClusterPosition:
+					cluster = seg.DataStart + int64(ebmlUint(field.Data))
+				}
+			}
+			if cueTrack == track && cluster >= 0 && cluster < seg.End {
+				points = append(points, matroskaCuePoint{Time: cueTime, Cluster: cluster})
+			}
+		}
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%w: matroska without cues for track %d", ErrUnsupported, track)
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time < points[j].Time
+	})
+	return points, nil
+}
+
+// matroskaAACConfig 返回 AudioSpecificConfig。旧式的 A_AAC/MPEG4/LC 等没有 CodecPrivate，
+// 根据 CodecID、采样率和声道数生成
+func matroskaAACConfig(track *matroskaTrack) []byte {
+	if len(track.CodecPrivate) >= 2 {
+		return track.CodecPrivate
+	}
+	objectType := 2 // LC
+	switch {
+	case strings.HasSuffix(track.CodecID, "/MAIN"):
+		objectType = 1
+	case strings.HasSuffix(track.CodecID, "/SSR"):
+		objectType = 3
+	case strings.HasSuffix(track.CodecID, "/LTP"):
+		objectType = 4
+	}
+	for index, rate := range aacSampleRates {
+		if rate == track.SampleRate && track.Channels > 0 && track.Channels < 8 {
+			return []byte{byte(objectType<<3 | index>>1), byte((index&1)<<7 | track.Channels<<3)}
+		}
+	}
+	return nil
+}
+
+// readSegment 从这一段的第一个 Cluster 开始读取，视频从关键帧开始，读到下一段的关键帧所在的 Cluster 结束，
+// 音频按块的时间归入这一段
+func (s *matroskaStream) readSegment(r io.ReadSeeker, index int) ([]Frame, error) {
+	scale := time.Duration(s.seg.TimecodeScale)
+	first := index == 0
+	last := index == len(s.starts)-1
+	var startTime, nextTime time.Duration
+	if !first {
+		startTime = time.Duration(s.starts[index].Time) * scale
+	}
+	if !last {
+		nextTime = time.Duration(s.starts[index+1].Time) * scale
+	}
+	inRange := func(t time.Duration) bool {
+		return (first || t >= startTime) && (last || t < nextTime)
+	}
+
+	var audioFrameDuration time.Duration
+	if s.audio != nil && s.audio.SampleRate > 0 {
+		audioFrameDuration = time.Duration(aacFrameSamples) * time.Second / time.Duration(s.audio.SampleRate)
+	}
+	want := func(track uint64) bool {
+		return track == uint64(s.video.Number) || (s.audio != nil && track == uint64(s.audio.Number))
+	}
+
+	var frames []Frame
+	started := first
+	videoDone := false
+	stopCluster := int64(-1)
+	err := walkMatroskaClusters(r, s.seg, s.starts[index].Cluster, want, func(block *matroskaBlock) error {
+		if stopCluster >= 0 && block.Cluster != stopCluster {
+			return errStopWalk
+		}
+		pts := time.Duration(block.Timecode) * scale
+
+		if block.Track == uint64(s.video.Number) {
+			switch {
+			case videoDone:
+				return nil
+			case !started:
+				if !block.Keyframe || pts < startTime {
+					return nil
+				}
+				started = true
+			case !last && block.Keyframe && pts >= nextTime:
+				videoDone = true
+				stopCluster = block.Cluster
+				return nil
+			}
+			for i, frame := range block.Frames {
+				data, err := s.video.decode(frame)
+				if err != nil {
+					return err
+				}
+				frames = append(frames, Frame{Video: true, PTS: pts, DTS: pts, Keyframe: block.Keyframe && i == 0, Data: data})
+			}
+			return nil
+		}
+
+		// lacing 的音频块整体按第一帧的时间归入一段，避免跨越段边界的块丢失后半部分
+		if !inRange(pts) {
+			return nil
+		}
+		for i, frame := range block.Frames {
+			data, err := s.audio.decode(frame)
+			if err != nil {
+				return err
+			}
+			t := pts + time.Duration(i)*audioFrameDuration
+			frames = append(frames, Frame{PTS: t, DTS: t, Data: data})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fixVideoDTS(frames)
+	return frames, nil
+}
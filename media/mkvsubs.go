@@ -0,0 +1,77 @@
+package media
+
+import (
+	"io"
+	"time"
+)
+
+// SubtitleBlock 文本字幕轨道中的一个数据块
+type SubtitleBlock struct {
+	Start    time.Duration
+	Duration time.Duration // 0 表示块中没有记录时长（SimpleBlock）
+	Data     []byte
+}
+
+// SubtitleTrack 文本字幕轨道及其全部数据块
+type SubtitleTrack struct {
+	Track
+	Blocks []SubtitleBlock
+}
+
+// IsTextSubtitle 是否为可以提取为文本的字幕编码，PGS 等图形字幕不支持
+func IsTextSubtitle(codec string) bool {
+	return codec == "subrip" || codec == "ass"
+}
+
+// ReadMatroskaSubtitles 顺序读取所有 Cluster，取出 SubRip 和 ASS 字幕轨道的数据块。
+// 只有字幕块的内容会读入内存，其他轨道的块通过 Seek 跳过，但块头部分布在整个文件中，仍然需要下载整个文件
+func ReadMatroskaSubtitles(r io.ReadSeeker, size int64) ([]SubtitleTrack, error) {
+	seg, err := readMatroskaSegment(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[uint64]int) // TrackNumber 到 result 下标
+	var tracks []matroskaTrack
+	var result []SubtitleTrack
+	for _, track := range seg.Tracks {
+		if track.Type != TrackSubtitle || !IsTextSubtitle(track.Codec) {
+			continue
+		}
+		indexes[uint64(track.Number)] = len(result)
+		tracks = append(tracks, track)
+		result = append(result, SubtitleTrack{Track: track.Track})
+	}
+	if len(result) == 0 || seg.FirstCluster < 0 {
+		return result, nil
+	}
+
+	scale := time.Duration(seg.TimecodeScale)
+	want := func(track uint64) bool {
+		_, ok := indexes[track]
+		return ok
+	}
+	err = walkMatroskaClusters(r, seg, seg.FirstCluster, want, func(block *matroskaBlock) error {
+		index := indexes[block.Track]
+		start := time.Duration(block.Timecode) * scale
+		if start < 0 {
+			start = 0
+		}
+		for _, frame := range block.Frames {
+			data, err := tracks[index].decode(frame)
+			if err != nil {
+				return err
+			}
+			result[index].Blocks = append(result[index].Blocks, SubtitleBlock{
+				Start:    start,
+				Duration: time.Duration(block.Duration) * scale,
+				Data:     data,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,479 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// mp4Box MP4 (ISO BMFF) 的 box，Offset 为 box 头部在文件中的位置
+type mp4Box struct {
+	Type   string
+	Offset int64
+	Size   int64 // 包含头部的总大小
+	Header int64 // 头部长度，8 或 16
+}
+
+// Body 返回 box 内容的起止位置
+func (b mp4Box) Body() (int64, int64) {
+	return b.Offset + b.Header, b.Offset + b.Size
+}
+
+// isMP4Box 文件开头常见的顶层 box
+func isMP4Box(typ string) bool {
+	switch typ {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "styp", "pnot":
+		return true
+	}
+	return false
+}
+
+// readMP4BoxHeader 读取 offset 处的 box 头部，size 为 0 的 box 延伸到 end
+func readMP4BoxHeader(r io.ReadSeeker, offset, end int64) (mp4Box, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return mp4Box{}, err
+	}
+	var buf [16]byte
+	if _, err := io.ReadFull(r, buf[:8]); err != nil {
+		return mp4Box{}, err
+	}
+	box := mp4Box{Type: string(buf[4:8]), Offset: offset, Size: int64(binary.BigEndian.Uint32(buf[:4])), Header: 8}
+	switch box.Size {
+	case 0:
+		box.Size = end - offset
+	case 1:
+		if _, err := io.ReadFull(r, buf[8:16]); err != nil {
+			return mp4Box{}, err
+		}
+		box.Size = int64(binary.BigEndian.Uint64(buf[8:16]))
+		box.Header = 16
+	}
+	if box.Size < box.Header || offset+box.Size > end {
+		return mp4Box{}, fmt.Errorf("invalid mp4 box %q at %d", box.Type, offset)
+	}
+	return box, nil
+}
+
+// walkMP4Boxes 依次读取 [start, end) 中的子 box，fn 返回 errStopWalk 时停止
+func walkMP4Boxes(r io.ReadSeeker, start, end int64, fn func(box mp4Box) error) error {
+	for offset := start; end-offset >= 8; {
+		box, err := readMP4BoxHeader(r, offset, end)
+		if err != nil {
+			return err
+		}
+		if err := fn(box); err != nil {
+			if errors.Is(err, errStopWalk) {
+				return nil
+			}
+			return err
+		}
+		offset += box.Size
+	}
+	return nil
+}
+
+// errStopWalk 遍历回调用来提前结束遍历
+var errStopWalk = errors.New("stop walk")
+
+// readMP4BoxBody 读取 box 的内容，超过 maxElementSize 时返回错误
+func readMP4BoxBody(r io.ReadSeeker, box mp4Box) ([]byte, error) {
+	start, end := box.Body()
+	if end-start > maxElementSize {
+		return nil, fmt.Errorf("mp4 box %q too large: %d bytes", box.Type, end-start)
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// probeMP4 找到 moov 并解析其中的 mvhd 和各 trak，跳过 mdat 等大 box，不读取它们的内容
+func probeMP4(r io.ReadSeeker, size int64) (*Info, error) {
+	info := &Info{Container: ContainerMP4}
+	found := false
+	err := walkMP4Boxes(r, 0, size, func(box mp4Box) error {
+		if box.Type != "moov" {
+			return nil
+		}
+		found = true
+		if err := parseMoov(r, box, info); err != nil {
+			return err
+		}
+		return errStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("mp4: moov box not found")
+	}
+	return info, nil
+}
+
+func parseMoov(r io.ReadSeeker, moov mp4Box, info *Info) error {
+	var maxTrackDuration float64
+	start, end := moov.Body()
+	err := walkMP4Boxes(r, start, end, func(box mp4Box) error {
+		switch box.Type {
+		case "mvhd":
+			data, err := readMP4BoxBody(r, box)
+			if err != nil {
+				return err
+			}
+			timescale, duration := parseMP4TimeHeader(data, 12)
+			if timescale > 0 {
+				info.Duration = float64(duration) / float64(timescale)
+			}
+		case "trak":
+			track, duration, err := parseTrak(r, box)
+			if err != nil {
+				return err
+			}
+			if track != nil {
+				info.Tracks = append(info.Tracks, *track)
+			}
+			if duration > maxTrackDuration {
+				maxTrackDuration = duration
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// 分片 MP4 的 mvhd 中时长通常为 0
+	if info.Duration == 0 {
+		info.Duration = maxTrackDuration
+	}
+	return nil
+}
+
+// parseMP4TimeHeader 解析 mvhd 和 mdhd 共用的 timescale 和 duration 字段，
+// v0Offset 为 version 0 时 timescale 的偏移
+func parseMP4TimeHeader(data []byte, v0Offset int) (uint32, uint64) {
+	if len(data) < 4 {
+		return 0, 0
+	}
+	if data[0] == 1 {
+		offset := v0Offset + 8
+		if len(data) < offset+12 {
+			return 0, 0
+		}
+		duration := binary.BigEndian.Uint64(data[offset+4:])
+		if duration == ^uint64(0) {
+			duration = 0
+		}
+		return binary.BigEndian.Uint32(data[offset:]), duration
+	}
+	if len(data) < v0Offset+8 {
+		return 0, 0
+	}
+	duration := uint64(binary.BigEndian.Uint32(data[v0Offset+4:]))
+	if duration == 0xFFFFFFFF {
+		duration = 0
+	}
+	return binary.BigEndian.Uint32(data[v0Offset:]), duration
+}
+
+// parseTrak 解析一条轨道，不是音视频和字幕的轨道返回 nil
+func parseTrak(r io.ReadSeeker, trak mp4Box) (*Track, float64, error) {
+	track := &Track{}
+	var handler string
+	var duration float64
+
+	var walk func(start, end int64) error
+	walk = func(start, end int64) error {
+		return walkMP4Boxes(r, start, end, func(box mp4Box) error {
+			switch box.Type {
+			case "mdia", "minf", "stbl":
+				return walk(box.Body())
+			case "tkhd":
+				data, err := readMP4BoxBody(r, box)
+				if err != nil {
+					return err
+				}
+				parseTkhd(data, track)
+			case "mdhd":
+				data, err := readMP4BoxBody(r, box)
+				if err != nil {
+					return err
+				}
+				timescale, d := parseMP4TimeHeader(data, 12)
+				if timescale > 0 {
+					duration = float64(d) / float64(timescale)
+				}
+				langOffset := 20
+				if len(data) > 0 && data[0] == 1 {
+					langOffset = 32
+				}
+				if len(data) >= langOffset+2 {
+					track.Language = language(mp4Language(binary.BigEndian.Uint16(data[langOffset:])))
+				}
+			case "hdlr":
+				data, err := readMP4BoxBody(r, box)
+				if err != nil {
+					return err
+				}
+				if len(data) >= 12 {
+					handler = string(data[8:12])
+				}
+			case "stsd":
+				data, err := readMP4BoxBody(r, box)
+				if err != nil {
+					return err
+				}
+				parseStsd(data, track)
+			}
+			return nil
+		})
+	}
+	start, end := trak.Body()
+	if err := walk(start, end); err != nil {
+		return nil, 0, err
+	}
+
+	switch handler {
+	case "vide":
+		track.Type = TrackVideo
+	case "soun":
+		track.Type = TrackAudio
+	case "sbtl", "subt", "text", "clcp":
+		track.Type = TrackSubtitle
+	default:
+		return nil, duration, nil
+	}
+	return track, duration, nil
+}
+
+// parseTkhd 读取 track ID、是否启用和显示尺寸
+func parseTkhd(data []byte, track *Track) {
+	if len(data) < 4 {
+		return
+	}
+	track.Default = data[3]&1 != 0
+	idOffset, sizeOffset := 12, 76
+	if data[0] == 1 {
+		idOffset, sizeOffset = 20, 88
+	}
+	if len(data) >= idOffset+4 {
+		track.Number = int(binary.BigEndian.Uint32(data[idOffset:]))
+	}
+	// 宽高为 16.16 定点数
+	if len(data) >= sizeOffset+8 {
+		track.Width = int(binary.BigEndian.Uint32(data[sizeOffset:]) >> 16)
+		track.Height = int(binary.BigEndian.Uint32(data[sizeOffset+4:]) >> 16)
+	}
+}
+
+// mp4Language 解码 mdhd 中打包的 ISO 639-2/T 语言代码
+func mp4Language(packed uint16) string {
+	if packed == 0 || packed == 0x7FFF {
+		return ""
+	}
+	return string([]byte{
+		byte((packed>>10)&0x1F) + 0x60,
+		byte((packed>>5)&0x1F) + 0x60,
+		byte(packed&0x1F) + 0x60,
+	})
+}
+
+// parseStsd 从第一个 sample entry 中读取编码和音视频参数，返回 sample entry 的类型和其中的子 box
+func parseStsd(data []byte, track *Track) (string, []byte) {
+	if len(data) < 16 {
+		return "", nil
+	}
+	entry := data[8:]
+	entrySize := int(binary.BigEndian.Uint32(entry))
+	if entrySize < 8 || entrySize > len(entry) {
+		return "", nil
+	}
+	format := string(entry[4:8])
+	body := entry[8:entrySize]
+
+	var children []byte
+	switch {
+	case isVisualSampleEntry(format) && len(body) >= 78:
+		// VisualSampleEntry 中的宽高是编码尺寸，tkhd 中的是显示尺寸，优先使用编码尺寸
+		track.Width = int(binary.BigEndian.Uint16(body[24:]))
+		track.Height = int(binary.BigEndian.Uint16(body[26:]))
+		children = body[78:]
+	case isAudioSampleEntry(format) && len(body) >= 28:
+		track.Channels = int(binary.BigEndian.Uint16(body[16:]))
+		track.SampleRate = int(binary.BigEndian.Uint32(body[24:]) >> 16)
+		// QuickTime 的 SoundDescription version 1 和 2 有额外字段
+		childOffset := 28
+		switch binary.BigEndian.Uint16(body[8:]) {
+		case 1:
+			childOffset += 16
+		case 2:
+			childOffset += 36
+		}
+		if len(body) >= childOffset {
+			children = body[childOffset:]
+		}
+	}
+
+	// 加密的轨道在 sinf/frma 中记录原始编码
+	if format == "encv" || format == "enca" {
+		if sinf := findMP4Child(children, "sinf"); sinf != nil {
+			if frma := findMP4Child(sinf, "frma"); len(frma) >= 4 {
+				format = string(frma[:4])
+			}
+		}
+	}
+
+	track.Codec = mp4Codec(format, children)
+	return format, children
+}
+
+func isVisualSampleEntry(format string) bool {
+	switch format {
+	case "avc1", "avc3", "hvc1", "hev1", "av01", "vp08", "vp09", "mp4v", "encv":
+		return true
+	}
+	return false
+}
+
+func isAudioSampleEntry(format string) bool {
+	switch format {
+	case "mp4a", "ac-3", "ec-3", "Opus", "fLaC", ".mp3", "alac", "enca":
+		return true
+	}
+	return false
+}
+
+// findMP4Child 在内存中的子 box 列表中查找指定类型，返回其内容
+func findMP4Child(data []byte, typ string) []byte {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			return nil
+		}
+		if string(data[4:8]) == typ {
+			return data[8:size]
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// mp4Codec 把 sample entry 的类型映射为规范化的编码名称，mp4a 需要根据 esds 中的 objectTypeIndication 区分
+func mp4Codec(format string, children []byte) string {
+	switch format {
+	case "avc1", "avc3":
+		return "h264"
+	case "hvc1", "hev1":
+		return "hevc"
+	case "av01":
+		return "av1"
+	case "vp08":
+		return "vp8"
+	case "vp09":
+		return "vp9"
+	case "mp4v":
+		return "mpeg4"
+	case "ac-3":
+		return "ac3"
+	case "ec-3":
+		return "eac3"
+	case "Opus":
+		return "opus"
+	case "fLaC":
+		return "flac"
+	case ".mp3":
+		return "mp3"
+	case "alac":
+		return "alac"
+	case "tx3g":
+		return "mov_text"
+	case "wvtt":
+		return "webvtt"
+	case "stpp":
+		return "ttml"
+	case "c608":
+		return "eia_608"
+	case "mp4a":
+		objectType, _ := parseESDS(findMP4Child(children, "esds"))
+		switch objectType {
+		case 0x69, 0x6B:
+			return "mp3"
+		case 0xA5:
+			return "ac3"
+		case 0xA6:
+			return "eac3"
+		}
+		return "aac"
+	}
+	return format
+}
+
+// parseESDS 读取 esds 中 DecoderConfigDescriptor 的 objectTypeIndication 和 DecoderSpecificInfo
+// （AAC 为 AudioSpecificConfig），无法解析时返回 0
+func parseESDS(esds []byte) (byte, []byte) {
+	if len(esds) < 4 {
+		return 0, nil
+	}
+	data := esds[4:]
+
+	readDescriptor := func() (byte, []byte) {
+		if len(data) < 2 {
+			return 0, nil
+		}
+		tag := data[0]
+		length, i := 0, 1
+		for ; i < 5 && i < len(data); i++ {
+			length = length<<7 | int(data[i]&0x7F)
+			if data[i]&0x80 == 0 {
+				i++
+				break
+			}
+		}
+		if i+length > len(data) {
+			return 0, nil
+		}
+		body := data[i : i+length]
+		data = data[i+length:]
+		return tag, body
+	}
+
+	tag, body := readDescriptor()
+	if tag != 0x03 || len(body) < 3 {
+		return 0, nil
+	}
+	flags := body[2]
+	offset := 3
+	if flags&0x80 != 0 {
+		offset += 2
+	}
+	if flags&0x40 != 0 && len(body) > offset {
+		offset += 1 + int(body[offset])
+	}
+	if flags&0x20 != 0 {
+		offset += 2
+	}
+	if offset >= len(body) {
+		return 0, nil
+	}
+	data = body[offset:]
+	tag, body = readDescriptor()
+	if tag != 0x04 || len(body) < 1 {
+		return 0, nil
+	}
+	objectType := body[0]
+
+	// objectTypeIndication 之后有 12 字节的流类型、缓冲区大小和码率
+	if len(body) <= 13 {
+		return objectType, nil
+	}
+	data = body[13:]
+	tag, info := readDescriptor()
+	if tag != 0x05 {
+		return objectType, nil
+	}
+	return objectType, info
+}
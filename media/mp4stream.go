@@ -0,0 +1,507 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// mp4Sample 样本表展开后的一个样本，时间单位为轨道的 timescale
+type mp4Sample struct {
+	Offset int64
+	Size   uint32
+	DTS    int64
+	CTS    int32 // PTS - DTS
+	Sync   bool
+}
+
+// mp4Edit 编辑列表中的一项，Duration 的单位为影片的 timescale，MediaTime 为 -1 表示空白
+type mp4Edit struct {
+	Duration  int64
+	MediaTime int64
+}
+
+// mp4StreamTrack 轨道的解码配置和全部样本
+type mp4StreamTrack struct {
+	StreamTrack
+	Timescale uint32
+	Shift     int64 // 编辑列表造成的偏移，PTS = DTS + CTS - Shift
+	End       int64 // 最后一个样本结束时的 DTS
+	Samples   []mp4Sample
+	edits     []mp4Edit
+}
+
+func (t *mp4StreamTrack) pts(i int) time.Duration {
+	return scaleTime(t.Samples[i].DTS+int64(t.Samples[i].CTS)-t.Shift, t.Timescale)
+}
+
+func (t *mp4StreamTrack) dts(i int) time.Duration {
+	return scaleTime(t.Samples[i].DTS-t.Shift, t.Timescale)
+}
+
+// mp4Stream 每段对应的视频和音频样本范围
+type mp4Stream struct {
+	video, audio *mp4StreamTrack
+	videoRanges  [][2]int
+	audioRanges  [][2]int
+}
+
+// buildMP4StreamIndex 展开音视频轨道的样本表，按同步样本划分段。分片 MP4 不支持
+func buildMP4StreamIndex(r io.ReadSeeker, size int64, target time.Duration) (*StreamIndex, error) {
+	var moov *mp4Box
+	err := walkMP4Boxes(r, 0, size, func(box mp4Box) error {
+		if box.Type != "moov" {
+			return nil
+		}
+		moov = &box
+		return errStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+	if moov == nil {
+		return nil, errors.New("mp4: moov box not found")
+	}
+
+	var movieTimescale uint32
+	var tracks []*mp4StreamTrack
+	fragmented := false
+	start, end := moov.Body()
+	err = walkMP4Boxes(r, start, end, func(box mp4Box) error {
+		switch box.Type {
+		case "mvhd":
+			data, err := readMP4BoxBody(r, box)
+			if err != nil {
+				return err
+			}
+			movieTimescale, _ = parseMP4TimeHeader(data, 12)
+		case "mvex":
+			fragmented = true
+		case "trak":
+			track, err := readMP4StreamTrack(r, box)
+			if err != nil {
+				return err
+			}
+			if track != nil {
+				tracks = append(tracks, track)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]Track, len(tracks))
+	for i, track := range tracks {
+		infos[i] = track.Track
+	}
+	videoIndex, audioIndex, err := pickStreamTracks(infos)
+	if err != nil {
+		return nil, err
+	}
+	stream := &mp4Stream{video: tracks[videoIndex]}
+	if audioIndex >= 0 && len(tracks[audioIndex].Samples) > 0 {
+		stream.audio = tracks[audioIndex]
+	}
+	if len(stream.video.Samples) == 0 {
+		if fragmented {
+			return nil, fmt.Errorf("%w: fragmented mp4", ErrUnsupported)
+		}
+		return nil, errors.New("mp4: video track has no samples")
+	}
+	for _, track := range []*mp4StreamTrack{stream.video, stream.audio} {
+		if track != nil {
+			track.applyEdits(movieTimescale)
+		}
+	}
+
+	ix := &StreamIndex{Video: stream.video.StreamTrack, reader: stream}
+	if stream.audio != nil {
+		ix.Audio = &stream.audio.StreamTrack
+	}
+	stream.split(ix, target)
+	return ix, nil
+}
+
+// readMP4StreamTrack 读取轨道的样本表，不是音视频的轨道返回 nil
+func readMP4StreamTrack(r io.ReadSeeker, trak mp4Box) (*mp4StreamTrack, error) {
+	track := &mp4StreamTrack{}
+	tables := make(map[string][]byte)
+	var handler, format string
+	var children []byte
+
+	var walk func(start, end int64) error
+	walk = func(start, end int64) error {
+		return walkMP4Boxes(r, start, end, func(box mp4Box) error {
+			switch box.Type {
+			case "mdia", "minf", "stbl", "edts":
+				return walk(box.Body())
+			case "tkhd", "mdhd", "hdlr", "stsd", "stts", "ctts", "stss", "stsz", "stsc", "stco", "co64", "elst":
+				data, err := readMP4BoxBody(r, box)
+				if err != nil {
+					return err
+				}
+				tables[box.Type] = data
+			}
+			return nil
+		})
+	}
+	start, end := trak.Body()
+	if err := walk(start, end); err != nil {
+		return nil, err
+	}
+
+	if data := tables["hdlr"]; len(data) >= 12 {
+		handler = string(data[8:12])
+	}
+	switch handler {
+	case "vide":
+		track.Type = TrackVideo
+	case "soun":
+		track.Type = TrackAudio
+	default:
+		return nil, nil
+	}
+	parseTkhd(tables["tkhd"], &track.Track)
+	track.Timescale, _ = parseMP4TimeHeader(tables["mdhd"], 12)
+	if track.Timescale == 0 {
+		return nil, fmt.Errorf("mp4: track %d has no timescale", track.Number)
+	}
+	format, children = parseStsd(tables["stsd"], &track.Track)
+
+	switch format {
+	case "avc1", "avc3":
+		track.Config = findMP4Child(children, "avcC")
+	case "hvc1", "hev1":
+		track.Config = findMP4Child(children, "hvcC")
+	case "mp4a":
+		_, track.Config = parseESDS(findMP4Child(children, "esds"))
+	}
+	if (isStreamVideo(&track.Track) || isStreamAudio(&track.Track)) && track.Config == nil {
+		// 缺少解码配置时无法封装，当作不支持的编码
+		track.Codec = format
+	}
+
+	var err error
+	if track.Samples, track.End, err = expandMP4Samples(tables); err != nil {
+		return nil, fmt.Errorf("mp4: track %d: %w", track.Number, err)
+	}
+	track.edits = parseMP4Edits(tables["elst"])
+	return track, nil
+}
+
+// mp4Table 返回 full box 中的表项数量和表项数据，长度不足时返回 false
+func mp4Table(data []byte, headerSize, entrySize int) (int, []byte, bool) {
+	if len(data) < headerSize {
+		return 0, nil, false
+	}
+	count := int(binary.BigEndian.Uint32(data[headerSize-4:]))
+	entries := data[headerSize:]
+	if count < 0 || count > len(entries)/entrySize {
+		return 0, nil, false
+	}
+	return count, entries, true
+}
+
+var errMP4SampleTable = errors.New("invalid sample table")
+
+// expandMP4Samples 根据 stsz、stsc、stco/co64、stts、ctts 和 stss 计算每个样本的位置、大小和时间，
+// 同时返回最后一个样本结束时的 DTS
+func expandMP4Samples(tables map[string][]byte) ([]mp4Sample, int64, error) {
+	stsz := tables["stsz"]
+	if len(stsz) < 12 {
+		return nil, 0, nil
+	}
+	uniformSize := binary.BigEndian.Uint32(stsz[4:])
+	count := int(binary.BigEndian.Uint32(stsz[8:]))
+	if count == 0 {
+		return nil, 0, nil
+	}
+	if (uniformSize == 0 && count > (len(stsz)-12)/4) || count > maxElementSize/4 {
+		return nil, 0, errMP4SampleTable
+	}
+	samples := make([]mp4Sample, count)
+	for i := range samples {
+		samples[i].Size = uniformSize
+		if uniformSize == 0 {
+			samples[i].Size = binary.BigEndian.Uint32(stsz[12+4*i:])
+		}
+	}
+
+	// 块的位置
+	var chunkOffsets []int64
+	if co64, ok := tables["co64"]; ok {
+		n, entries, ok := mp4Table(co64, 8, 8)
+		if !ok {
+			return nil, 0, errMP4SampleTable
+		}
+		chunkOffsets = make([]int64, n)
+		for i := range chunkOffsets {
+			chunkOffsets[i] = int64(binary.BigEndian.Uint64(entries[8*i:]))
+		}
+	} else {
+		n, entries, ok := mp4Table(tables["stco"], 8, 4)
+		if !ok {
+			return nil, 0, errMP4SampleTable
+		}
+		chunkOffsets = make([]int64, n)
+		for i := range chunkOffsets {
+			chunkOffsets[i] = int64(binary.BigEndian.Uint32(entries[4*i:]))
+		}
+	}
+
+	// 样本到块的映射
+	n, stsc, ok := mp4Table(tables["stsc"], 8, 12)
+	if !ok {
+		return nil, 0, errMP4SampleTable
+	}
+	si := 0
+	for e := 0; e < n && si < count; e++ {
+		first := int(binary.BigEndian.Uint32(stsc[12*e:]))
+		perChunk := int(binary.BigEndian.Uint32(stsc[12*e+4:]))
+		next := len(chunkOffsets) + 1
+		if e+1 < n {
+			next = int(binary.BigEndian.Uint32(stsc[12*(e+1):]))
+		}
+		for chunk := first; chunk < next && chunk >= 1 && chunk <= len(chunkOffsets); chunk++ {
+			offset := chunkOffsets[chunk-1]
+			for k := 0; k < perChunk && si < count; k++ {
+				samples[si].Offset = offset
+				offset += int64(samples[si].Size)
+				si++
+			}
+		}
+	}
+	if si < count {
+		return nil, 0, errMP4SampleTable
+	}
+
+	// 解码时间
+	n, stts, ok := mp4Table(tables["stts"], 8, 8)
+	if !ok {
+		return nil, 0, errMP4SampleTable
+	}
+	var dts, delta int64
+	si = 0
+	for e := 0; e < n && si < count; e++ {
+		sampleCount := int(binary.BigEndian.Uint32(stts[8*e:]))
+		delta = int64(binary.BigEndian.Uint32(stts[8*e+4:]))
+		for k := 0; k < sampleCount && si < count; k++ {
+			samples[si].DTS = dts
+			dts += delta
+			si++
+		}
+	}
+	// stts 不完整时剩余样本沿用最后的间隔
+	for ; si < count; si++ {
+		samples[si].DTS = dts
+		dts += delta
+	}
+
+	// 显示时间偏移，version 0 也按有符号数处理，和常见的写入实现一致
+	if ctts, ok := tables["ctts"]; ok {
+		n, entries, ok := mp4Table(ctts, 8, 8)
+		if !ok {
+			return nil, 0, errMP4SampleTable
+		}
+		si = 0
+		for e := 0; e < n && si < count; e++ {
+			sampleCount := int(binary.BigEndian.Uint32(entries[8*e:]))
+			offset := int32(binary.BigEndian.Uint32(entries[8*e+4:]))
+			for k := 0; k < sampleCount && si < count; k++ {
+				samples[si].CTS = offset
+				si++
+			}
+		}
+	}
+
+	// 同步样本，没有 stss 时全部是同步样本
+	if stss, ok := tables["stss"]; ok {
+		n, entries, ok := mp4Table(stss, 8, 4)
+		if !ok {
+			return nil, 0, errMP4SampleTable
+		}
+		for e := 0; e < n; e++ {
+			if i := int(binary.BigEndian.Uint32(entries[4*e:])) - 1; i >= 0 && i < count {
+				samples[i].Sync = true
+			}
+		}
+	} else {
+		for i := range samples {
+			samples[i].Sync = true
+		}
+	}
+	return samples, dts, nil
+}
+
+// parseMP4Edits 读取 elst 中的编辑列表
+func parseMP4Edits(elst []byte) []mp4Edit {
+	if len(elst) < 8 {
+		return nil
+	}
+	entrySize := 12
+	if elst[0] == 1 {
+		entrySize = 20
+	}
+	n, entries, ok := mp4Table(elst, 8, entrySize)
+	if !ok {
+		return nil
+	}
+	edits := make([]mp4Edit, n)
+	for i := range edits {
+		entry := entries[entrySize*i:]
+		if entrySize == 20 {
+			edits[i] = mp4Edit{Duration: int64(binary.BigEndian.Uint64(entry)), MediaTime: int64(binary.BigEndian.Uint64(entry[8:]))}
+		} else {
+			edits[i] = mp4Edit{Duration: int64(binary.BigEndian.Uint32(entry)), MediaTime: int64(int32(binary.BigEndian.Uint32(entry[4:])))}
+		}
+	}
+	return edits
+}
+
+// applyEdits 只处理开头的空白编辑和第一段的起始时间，这是 B 帧视频和音频前导最常见的用法
+func (t *mp4StreamTrack) applyEdits(movieTimescale uint32) {
+	var delay int64
+	for _, edit := range t.edits {
+		if edit.MediaTime == -1 {
+			delay += edit.Duration
+			continue
+		}
+		t.Shift = edit.MediaTime
+		break
+	}
+	if delay > 0 && movieTimescale > 0 {
+		t.Shift -= delay * int64(t.Timescale) / int64(movieTimescale)
+	}
+}
+
+// split 在视频同步样本处划分段，音频样本按显示时间归入对应的段
+func (s *mp4Stream) split(ix *StreamIndex, target time.Duration) {
+	video := s.video
+	var syncSamples []int
+	var keyframes []time.Duration
+	for i := range video.Samples {
+		if video.Samples[i].Sync {
+			syncSamples = append(syncSamples, i)
+			keyframes = append(keyframes, video.pts(i))
+		}
+	}
+
+	var starts []int // 每段第一个视频样本
+	for _, k := range segmentBoundaries(keyframes, target) {
+		starts = append(starts, syncSamples[k])
+	}
+	// 第一个同步样本之前的样本并入第一段
+	if len(starts) == 0 {
+		starts = []int{0}
+	}
+	starts[0] = 0
+
+	videoEnd := scaleTime(video.End-video.Shift, video.Timescale)
+	for k, first := range starts {
+		last := len(video.Samples)
+		if k+1 < len(starts) {
+			last = starts[k+1]
+		}
+		segment := Segment{}
+		if k > 0 {
+			segment.Start = video.pts(first)
+		}
+		s.videoRanges = append(s.videoRanges, [2]int{first, last})
+		ix.Segments = append(ix.Segments, segment)
+		if k > 0 {
+			ix.Segments[k-1].Duration = segment.Start - ix.Segments[k-1].Start
+		}
+	}
+	lastSegment := &ix.Segments[len(ix.Segments)-1]
+	lastSegment.Duration = videoEnd - lastSegment.Start
+
+	if s.audio != nil {
+		audio := s.audio
+		begin := 0
+		for k := range ix.Segments {
+			end := len(audio.Samples)
+			if k+1 < len(ix.Segments) {
+				next := ix.Segments[k+1].Start
+				end = begin + sort.Search(len(audio.Samples)-begin, func(i int) bool {
+					return audio.pts(begin+i) >= next
+				})
+			}
+			s.audioRanges = append(s.audioRanges, [2]int{begin, end})
+			begin = end
+		}
+	}
+
+	// 每段数据在文件中的范围
+	for k := range ix.Segments {
+		offset, end := int64(-1), int64(0)
+		extend := func(track *mp4StreamTrack, r [2]int) {
+			for i := r[0]; i < r[1]; i++ {
+				sample := track.Samples[i]
+				if offset < 0 || sample.Offset < offset {
+					offset = sample.Offset
+				}
+				if sample.Offset+int64(sample.Size) > end {
+					end = sample.Offset + int64(sample.Size)
+				}
+			}
+		}
+		extend(video, s.videoRanges[k])
+		if s.audio != nil {
+			extend(s.audio, s.audioRanges[k])
+		}
+		ix.Segments[k].Offset, ix.Segments[k].End = offset, end
+	}
+}
+
+// readSegment 按文件中的位置顺序读取这一段的样本，减少来回跳转
+func (s *mp4Stream) readSegment(r io.ReadSeeker, index int) ([]Frame, error) {
+	type ref struct {
+		track *mp4StreamTrack
+		index int
+	}
+	var refs []ref
+	for i := s.videoRanges[index][0]; i < s.videoRanges[index][1]; i++ {
+		refs = append(refs, ref{s.video, i})
+	}
+	if s.audio != nil {
+		for i := s.audioRanges[index][0]; i < s.audioRanges[index][1]; i++ {
+			refs = append(refs, ref{s.audio, i})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].track.Samples[refs[i].index].Offset < refs[j].track.Samples[refs[j].index].Offset
+	})
+
+	frames := make([]Frame, 0, len(refs))
+	position := int64(-1)
+	for _, ref := range refs {
+		sample := ref.track.Samples[ref.index]
+		if sample.Size > maxElementSize {
+			return nil, fmt.Errorf("mp4: sample too large: %d bytes", sample.Size)
+		}
+		if sample.Offset != position {
+			if _, err := r.Seek(sample.Offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		data := make([]byte, sample.Size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		position = sample.Offset + int64(sample.Size)
+
+		frames = append(frames, Frame{
+			Video:    ref.track == s.video,
+			PTS:      ref.track.pts(ref.index),
+			DTS:      ref.track.dts(ref.index),
+			Keyframe: sample.Sync,
+			Data:     data,
+		})
+	}
+	return frames, nil
+}
@@ -0,0 +1,174 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ErrUnsupportedCodec 文件中没有可以直接封装为 HLS 的 H.264/H.265 视频轨道
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
+// Frame 一帧音频或视频，Data 为容器中的原始数据：视频为带长度前缀的 NAL 单元，音频为 AAC 原始帧
+type Frame struct {
+	Video    bool
+	PTS      time.Duration
+	DTS      time.Duration
+	Keyframe bool
+	Data     []byte
+}
+
+// Segment 以关键帧开始的一段，Offset 和 End 为这一段的数据在文件中的大致范围，用于提前下载对应分片
+type Segment struct {
+	Start    time.Duration
+	Duration time.Duration
+	Offset   int64
+	End      int64
+}
+
+// StreamTrack 用于封装的轨道，Config 为解码配置：
+// H.264/H.265 为 avcC/hvcC，AAC 为 AudioSpecificConfig
+type StreamTrack struct {
+	Track
+	Config []byte
+}
+
+// StreamIndex 文件中可以封装的音视频轨道和按关键帧划分的段
+type StreamIndex struct {
+	Video    StreamTrack
+	Audio    *StreamTrack // 没有 AAC 音轨时为 nil
+	Segments []Segment
+	reader   segmentReader
+}
+
+// segmentReader 各容器读取一段中所有帧的实现
+type segmentReader interface {
+	readSegment(r io.ReadSeeker, index int) ([]Frame, error)
+}
+
+// BuildStreamIndex 读取 MP4 的样本表或 Matroska 的 Cues，按 target 时长在关键帧处划分段，
+// 只需要读取文件头部和索引所在的位置
+func BuildStreamIndex(r io.ReadSeeker, size int64, target time.Duration) (*StreamIndex, error) {
+	var head [12]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case head[0] == 0x1A && head[1] == 0x45 && head[2] == 0xDF && head[3] == 0xA3:
+		return buildMatroskaStreamIndex(r, size, target)
+	case isMP4Box(string(head[4:8])):
+		return buildMP4StreamIndex(r, size, target)
+	}
+	return nil, ErrUnsupported
+}
+
+// ReadSegment 读取一段中的所有帧，按 DTS 排序
+func (ix *StreamIndex) ReadSegment(r io.ReadSeeker, index int) ([]Frame, error) {
+	if index < 0 || index >= len(ix.Segments) {
+		return nil, fmt.Errorf("segment %d out of range", index)
+	}
+	frames, err := ix.reader.readSegment(r, index)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].DTS < frames[j].DTS
+	})
+	return frames, nil
+}
+
+// isStreamVideo 可以封装为 HLS 的视频编码
+func isStreamVideo(track *Track) bool {
+	return track.Type == TrackVideo && (track.Codec == "h264" || track.Codec == "hevc")
+}
+
+// isStreamAudio 可以封装为 HLS 的音频编码
+func isStreamAudio(track *Track) bool {
+	return track.Type == TrackAudio && track.Codec == "aac"
+}
+
+// pickStreamTracks 选择第一条可以封装的视频轨道和音频轨道，优先选择默认轨道
+func pickStreamTracks(tracks []Track) (video, audio int, err error) {
+	video, audio = -1, -1
+	for i := range tracks {
+		switch {
+		case isStreamVideo(&tracks[i]):
+			if video < 0 || (!tracks[video].Default && tracks[i].Default) {
+				video = i
+			}
+		case isStreamAudio(&tracks[i]):
+			if audio < 0 || (!tracks[audio].Default && tracks[i].Default) {
+				audio = i
+			}
+		}
+	}
+	if video < 0 {
+		codec := "none"
+		for i := range tracks {
+			if tracks[i].Type == TrackVideo {
+				codec = tracks[i].Codec
+				break
+			}
+		}
+		return -1, -1, fmt.Errorf("%w: video codec %s", ErrUnsupportedCodec, codec)
+	}
+	return video, audio, nil
+}
+
+// segmentBoundaries 在关键帧时间中选出每段的起点，相邻起点至少相隔 target，第一段总是从 0 开始
+func segmentBoundaries(keyframes []time.Duration, target time.Duration) []int {
+	var starts []int
+	var last time.Duration
+	for i, t := range keyframes {
+		if len(starts) == 0 || t-last >= target {
+			starts = append(starts, i)
+			last = t
+		}
+	}
+	return starts
+}
+
+// fixVideoDTS 容器中只有 PTS 时（Matroska）生成 DTS：把 PTS 排序后依次分配，
+// 再整体提前到不晚于对应的 PTS
+func fixVideoDTS(frames []Frame) {
+	var pts []time.Duration
+	for _, frame := range frames {
+		if frame.Video {
+			pts = append(pts, frame.PTS)
+		}
+	}
+	sort.Slice(pts, func(i, j int) bool { return pts[i] < pts[j] })
+
+	var shift time.Duration
+	n := 0
+	for _, frame := range frames {
+		if frame.Video {
+			if d := pts[n] - frame.PTS; d > shift {
+				shift = d
+			}
+			n++
+		}
+	}
+	n = 0
+	for i := range frames {
+		if frames[i].Video {
+			frames[i].DTS = pts[n] - shift
+			n++
+		}
+	}
+}
+
+// scaleTime 把 timescale 为单位的时间转换为 time.Duration，避免大数值相乘溢出
+func scaleTime(value int64, timescale uint32) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	ts := int64(timescale)
+	return time.Duration(value/ts)*time.Second + time.Duration(value%ts)*time.Second/time.Duration(ts)
+}
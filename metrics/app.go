@@ -0,0 +1,17 @@
+package metrics
+
+// 应用内各处记录的指标
+var (
+	HTTPRequests = NewCounterVec("magnet_webdav_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	HTTPDuration = NewHistogramVec("magnet_webdav_http_request_duration_seconds",
+		"HTTP request latency by route and method.", DefaultBuckets, "route", "method")
+
+	StreamedBytes = NewCounterVec("magnet_webdav_stream_bytes_total",
+		"Bytes streamed to clients per magnet.", "magnet")
+	StreamTTFB = NewHistogramVec("magnet_webdav_stream_ttfb_seconds",
+		"Time to first byte of GET file streams.", DefaultBuckets)
+
+	MetadataWait = NewHistogramVec("magnet_webdav_torrent_metadata_wait_seconds",
+		"Time spent waiting for torrent metadata, by result.", []float64{1, 2, 5, 10, 15, 20, 30, 60}, "result")
+)
@@ -0,0 +1,259 @@
+// Package metrics 实现 Prometheus 文本格式的指标导出，只包含本项目用到的计数器、直方图和按需采集的仪表
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector 在每次抓取时输出指标
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// CollectorFunc 把函数适配为 Collector
+type CollectorFunc func(w *Writer)
+
+func (f CollectorFunc) Collect(w *Writer) { f(w) }
+
+// Registry 指标注册表
+type Registry struct {
+	collectors []Collector
+	mutex      sync.RWMutex
+}
+
+// Default 全局注册表，/metrics 输出其中的所有指标
+var Default = &Registry{}
+
+// Register 注册采集器
+func (r *Registry) Register(c Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Register 注册到全局注册表
+func Register(c Collector) {
+	Default.Register(c)
+}
+
+// Handler 返回输出 Prometheus 文本格式的 HTTP 处理器
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.RLock()
+		collectors := append([]Collector(nil), r.collectors...)
+		r.mutex.RUnlock()
+
+		writer := &Writer{}
+		for _, c := range collectors {
+			c.Collect(writer)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(writer.b.String()))
+	})
+}
+
+// Writer 按 Prometheus 文本格式写入指标
+type Writer struct {
+	b strings.Builder
+}
+
+// Header 写入 HELP 和 TYPE 行，每个指标只需写一次
+func (w *Writer) Header(name, help, metricType string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, metricType)
+}
+
+// Sample 写入一个样本，labels 为名称和值交替排列
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			fmt.Fprintf(&w.b, `%s="%s"`, labels[i], escapeLabel(labels[i+1]))
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteByte(' ')
+	w.b.WriteString(formatValue(value))
+	w.b.WriteByte('\n')
+}
+
+// Gauge 写入只有一个样本的仪表
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.Header(name, help, "gauge")
+	w.Sample(name, value)
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]*vecValue
+	mutex  sync.Mutex
+}
+
+type vecValue struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec 创建计数器并注册到全局注册表
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*vecValue)}
+	Register(c)
+	return c
+}
+
+// Add 增加计数，labelValues 顺序与创建时的标签一致
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	v, ok := c.values[key]
+	if !ok {
+		v = &vecValue{labelValues: labelValues}
+		c.values[key] = v
+	}
+	v.value += delta
+}
+
+// Inc 计数加一
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Delete 删除一组标签对应的样本，例如种子被删除后
+func (c *CounterVec) Delete(labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.values, strings.Join(labelValues, "\xff"))
+}
+
+func (c *CounterVec) Collect(w *Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	w.Header(c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		v := c.values[key]
+		w.Sample(c.name, v.value, zipLabels(c.labels, v.labelValues)...)
+	}
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogramValue
+	mutex   sync.Mutex
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// DefaultBuckets 以秒为单位的默认延迟分桶
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// NewHistogramVec 创建直方图并注册到全局注册表
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	Register(h)
+	return h
+}
+
+// Observe 记录一个观测值
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.sum += value
+}
+
+func (h *HistogramVec) Collect(w *Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	w.Header(h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		labels := zipLabels(h.labels, v.labelValues)
+		for i, bound := range h.buckets {
+			w.Sample(h.name+"_bucket", float64(v.counts[i]), append(labels, "le", formatValue(bound))...)
+		}
+		w.Sample(h.name+"_bucket", float64(v.count), append(labels, "le", "+Inf")...)
+		w.Sample(h.name+"_sum", v.sum, labels...)
+		w.Sample(h.name+"_count", float64(v.count), labels...)
+	}
+}
+
+func zipLabels(names, values []string) []string {
+	labels := make([]string, 0, len(names)*2)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		labels = append(labels, name, value)
+	}
+	return labels
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
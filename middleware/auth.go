@@ -1,16 +1,52 @@
 package middleware
 
 import (
+	"context"
 	"encoding/base64"
 	"magnet-webdav/config"
+	"magnet-webdav/services"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware WebDAV 认证中间件
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+type userContextKey struct{}
+
+// CurrentUser 返回请求对应的已认证用户，未启用认证时返回 nil
+func CurrentUser(r *http.Request) *config.UserConfig {
+	user, _ := r.Context().Value(userContextKey{}).(*config.UserConfig)
+	return user
+}
+
+// setCurrentUser 将已认证用户写入请求上下文，供 gin 和 net/http 处理器共同使用
+func setCurrentUser(c *gin.Context, user *config.UserConfig) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), userContextKey{}, user))
+	c.Set("user", user.Username)
+}
+
+// authRealm 认证域，Digest 的 HA1 依赖该值
+const authRealm = "Magnet WebDAV"
+
+// AuthMiddleware WebDAV 认证中间件，guard 用于统计失败次数并锁定暴力破解。
+// 返回的中间件持有 Digest nonce 状态，多个路由组应共用同一个实例
+func AuthMiddleware(cfg *config.Config, guard *services.AuthGuard) gin.HandlerFunc {
+	digest := newDigestAuth(authRealm, cfg.Auth.NonceTTL)
+
+	// challenge 按配置返回 Basic 和/或 Digest 质询
+	challenge := func(c *gin.Context, stale bool) {
+		if cfg.Auth.DigestEnabled() {
+			for _, header := range digest.challenges(stale) {
+				c.Writer.Header().Add("WWW-Authenticate", header)
+			}
+		}
+		if cfg.Auth.BasicEnabled() {
+			c.Writer.Header().Add("WWW-Authenticate", `Basic realm="`+authRealm+`"`)
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+
 	return func(c *gin.Context) {
 		// 检查是否启用认证
 		if !cfg.Auth.Enabled {
@@ -18,44 +54,132 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		clientIP := c.ClientIP()
+
+		// 双向 TLS：已验证的客户端证书直接对应用户
+		if user := clientCertUser(c.Request, &cfg.Auth); user != nil {
+			setCurrentUser(c, user)
+			c.Next()
+			return
+		}
+
 		// 检查认证头
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.Header("WWW-Authenticate", `Basic realm="Magnet WebDAV"`)
-			c.AbortWithStatus(http.StatusUnauthorized)
+			if abortIfLocked(c, guard, clientIP, "") {
+				return
+			}
+			challenge(c, false)
 			return
 		}
 
-		// 解析 Basic Auth
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Basic" {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
+		var user *config.UserConfig
+		var username string
+		switch {
+		case cfg.Auth.DigestEnabled() && strings.HasPrefix(authHeader, "Digest "):
+			result := digest.verify(c.Request.Method, c.Request.RequestURI, authHeader, &cfg.Auth)
+			username = result.username
+			if abortIfLocked(c, guard, clientIP, username) {
+				return
+			}
+			if result.stale {
+				// nonce 过期或 nc 重复不算失败，客户端会用新 nonce 自动重试
+				challenge(c, true)
+				return
+			}
+			if result.ok {
+				user = result.user
+			}
 
-		// 解码认证信息
-		payload, err := base64.StdEncoding.DecodeString(parts[1])
-		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		case cfg.Auth.BasicEnabled() && strings.HasPrefix(authHeader, "Basic "):
+			// 解析 Basic Auth
+			var password string
+			var ok bool
+			username, password, ok = parseBasicAuth(authHeader)
+			if abortIfLocked(c, guard, clientIP, username) {
+				return
+			}
+
+			// 验证用户名和密码
+			if candidate := cfg.Auth.FindUser(username); ok && candidate != nil && password == candidate.Password {
+				user = candidate
+			}
+
+		default:
+			if abortIfLocked(c, guard, clientIP, "") {
+				return
+			}
+			challenge(c, false)
 			return
 		}
 
-		pair := strings.SplitN(string(payload), ":", 2)
-		if len(pair) != 2 {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		if user == nil {
+			guard.RecordFailure(clientIP, username)
+			challenge(c, false)
 			return
 		}
 
-		// 验证用户名和密码
-		username := pair[0]
-		password := pair[1]
+		// 认证通过
+		guard.RecordSuccess(clientIP, username)
+		setCurrentUser(c, user)
+		c.Next()
+	}
+}
+
+// clientCertUser 根据已验证的客户端证书的 Common Name 查找用户
+func clientCertUser(r *http.Request, auth *config.AuthConfig) *config.UserConfig {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return auth.FindUser(r.TLS.VerifiedChains[0][0].Subject.CommonName)
+}
 
-		if username != cfg.Auth.Username || password != cfg.Auth.Password {
-			c.AbortWithStatus(http.StatusUnauthorized)
+// RequireAdmin 只允许管理员访问，未启用认证时不做限制
+func RequireAdmin(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.Auth.Enabled {
+			c.Next()
 			return
 		}
 
-		// 认证通过
+		user := CurrentUser(c.Request)
+		if user == nil || !user.Admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+			return
+		}
 		c.Next()
 	}
 }
+
+// abortIfLocked IP 或用户名处于锁定期时直接返回 429
+func abortIfLocked(c *gin.Context, guard *services.AuthGuard, clientIP, username string) bool {
+	remaining := guard.Check(clientIP, username)
+	if remaining <= 0 {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts"})
+	return true
+}
+
+// parseBasicAuth 解析 Basic 认证头
+func parseBasicAuth(authHeader string) (username, password string, ok bool) {
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Basic" {
+		return "", "", false
+	}
+
+	// 解码认证信息
+	payload, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+
+	pair := strings.SplitN(string(payload), ":", 2)
+	if len(pair) != 2 {
+		return "", "", false
+	}
+
+	return pair[0], pair[1], true
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"magnet-webdav/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestAlgorithms 支持的摘要算法，按优先级排列（RFC 7616 要求 SHA-256 优先）
+var digestAlgorithms = []string{"SHA-256", "MD5"}
+
+// maxDigestNonces 同时保留的 nonce 上限。每次质询都会签发新 nonce，超过上限时淘汰最早签发的
+const maxDigestNonces = 10000
+
+// digestNonce 服务端签发的 nonce 状态
+type digestNonce struct {
+	issued time.Time
+	lastNC uint64
+}
+
+// digestAuth RFC 7616 Digest 认证，nonce 保存在内存中并校验 nc 防止重放
+type digestAuth struct {
+	realm    string
+	nonceTTL time.Duration
+	nonces   map[string]*digestNonce
+	order    []string // 按签发顺序排列的 nonce，用于清理过期和超出上限的 nonce
+	mutex    sync.Mutex
+}
+
+func newDigestAuth(realm string, nonceTTL time.Duration) *digestAuth {
+	return &digestAuth{
+		realm:    realm,
+		nonceTTL: nonceTTL,
+		nonces:   make(map[string]*digestNonce),
+	}
+}
+
+// challenges 生成 WWW-Authenticate 头，每个算法一条
+func (d *digestAuth) challenges(stale bool) []string {
+	var headers []string
+	for _, algorithm := range digestAlgorithms {
+		header := fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=%s, nonce="%s", opaque="%s", userhash=true`,
+			d.realm, algorithm, d.newNonce(), hashHex(algorithm, d.realm))
+		if stale {
+			header += ", stale=true"
+		}
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+func (d *digestAuth) newNonce() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	nonce := hex.EncodeToString(buf)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// 最早签发的 nonce 在最前面，依次清理已过期、已删除或超出上限的
+	now := time.Now()
+	for len(d.order) > 0 {
+		state, ok := d.nonces[d.order[0]]
+		if ok && now.Sub(state.issued) <= d.nonceTTL && len(d.nonces) < maxDigestNonces {
+			break
+		}
+		delete(d.nonces, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.nonces[nonce] = &digestNonce{issued: now}
+	d.order = append(d.order, nonce)
+	return nonce
+}
+
+// checkNonce 校验 nonce 是否有效且 nc 递增。nonce 过期或 nc 没有递增时返回 stale，
+// 并发请求的客户端（例如 WebDAV 客户端）到达服务端的顺序可能和 nc 不一致，要求它用新 nonce 重试而不是算作失败
+func (d *digestAuth) checkNonce(nonce string, nc uint64) (valid, stale bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	state, ok := d.nonces[nonce]
+	if !ok {
+		// 未知 nonce 可能是服务重启前签发的，让客户端用新 nonce 重试
+		return false, true
+	}
+	if time.Since(state.issued) > d.nonceTTL {
+		delete(d.nonces, nonce)
+		return false, true
+	}
+	if nc <= state.lastNC {
+		return false, true
+	}
+	state.lastNC = nc
+	return true, false
+}
+
+// digestResult Digest 认证的结果
+type digestResult struct {
+	username string
+	user     *config.UserConfig
+	ok       bool
+	stale    bool
+}
+
+// verify 校验 Authorization: Digest 头
+func (d *digestAuth) verify(method, requestURI, header string, auth *config.AuthConfig) digestResult {
+	params := parseDigestParams(strings.TrimPrefix(header, "Digest "))
+
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	if hashFunc(algorithm) == nil || params["qop"] != "auth" || params["realm"] != d.realm {
+		return digestResult{username: params["username"]}
+	}
+
+	// 启用 userhash 时客户端发送的是 H(username:realm)
+	var user *config.UserConfig
+	username := params["username"]
+	if params["userhash"] == "true" {
+		for _, u := range auth.AllUsers() {
+			if hashHex(algorithm, u.Username+":"+d.realm) == username {
+				found := u
+				user = &found
+				break
+			}
+		}
+		if user != nil {
+			username = user.Username
+		}
+	} else {
+		user = auth.FindUser(username)
+	}
+
+	// uri 必须和实际请求一致，防止把响应挪用到其他资源
+	if params["uri"] != requestURI {
+		return digestResult{username: username}
+	}
+
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil {
+		return digestResult{username: username}
+	}
+
+	if user == nil {
+		return digestResult{username: username}
+	}
+
+	ha1 := hashHex(algorithm, user.Username+":"+d.realm+":"+user.Password)
+	ha2 := hashHex(algorithm, method+":"+params["uri"])
+	expected := hashHex(algorithm, strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return digestResult{username: username}
+	}
+
+	// 密码正确后再检查 nonce，过期的 nonce 和重复的 nc 不计入失败次数
+	valid, stale := d.checkNonce(params["nonce"], nc)
+	if !valid {
+		return digestResult{username: username, stale: stale}
+	}
+
+	return digestResult{username: username, user: user, ok: true}
+}
+
+// parseDigestParams 解析 key="value", key=value 形式的参数列表
+func parseDigestParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			// 带引号的值，处理反斜杠转义
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func hashFunc(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	default:
+		return nil
+	}
+}
+
+func hashHex(algorithm, data string) string {
+	h := hashFunc(algorithm)()
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"magnet-webdav/config"
+	"magnet-webdav/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware 按路由模板和方法统计请求数和耗时
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// 使用路由模板而不是实际路径，避免标签数量失控
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		metrics.HTTPRequests.Inc(route, c.Request.Method, strconv.Itoa(c.Writer.Status()))
+		metrics.HTTPDuration.Observe(time.Since(start).Seconds(), route, c.Request.Method)
+	}
+}
+
+// MetricsToken 请求带有配置的 Bearer token 时直接调用 handler，跳过后面的认证中间件；
+// 没有 token 时交给后面的认证和管理员检查。指标的 magnet 标签包含所有用户的 info hash，不能对普通用户开放
+func MetricsToken(cfg *config.Config, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := cfg.Server.MetricsToken
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			handler(c)
+			c.Abort()
+			return
+		}
+		// 配置了 token 但未启用认证时，没有其他方式证明身份
+		if ok || !cfg.Auth.Enabled {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid metrics token"})
+			return
+		}
+		c.Next()
+	}
+}
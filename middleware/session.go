@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"magnet-webdav/config"
+	"magnet-webdav/services"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionCookie qBittorrent 使用的会话 Cookie 名称
+const sessionCookie = "SID"
+
+// authSession 登录会话
+type authSession struct {
+	user     *config.UserConfig
+	lastSeen time.Time
+}
+
+// SessionAuth qBittorrent 风格的 Cookie 会话认证：POST 用户名和密码登录，之后通过 SID Cookie 认证。
+// 会话保存在内存中，重启后客户端会自动重新登录
+type SessionAuth struct {
+	cfg      *config.Config
+	guard    *services.AuthGuard
+	ttl      time.Duration
+	sessions map[string]*authSession
+	mutex    sync.Mutex
+}
+
+func NewSessionAuth(cfg *config.Config, guard *services.AuthGuard) *SessionAuth {
+	return &SessionAuth{
+		cfg:      cfg,
+		guard:    guard,
+		ttl:      cfg.QBittorrent.SessionTTL,
+		sessions: make(map[string]*authSession),
+	}
+}
+
+// Login 校验表单中的 username 和 password，成功时设置 SID Cookie。
+// 和 qBittorrent 一样，密码错误时返回 200 和 "Fails."
+func (a *SessionAuth) Login(c *gin.Context) {
+	if !a.cfg.Auth.Enabled {
+		a.setCookie(c, a.newSession(nil))
+		c.String(http.StatusOK, "Ok.")
+		return
+	}
+
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+	clientIP := c.ClientIP()
+	if remaining := a.guard.Check(clientIP, username); remaining > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+		c.String(http.StatusForbidden, "Your IP address has been banned after too many failed authentication attempts.")
+		return
+	}
+
+	user := a.cfg.Auth.FindUser(username)
+	if user == nil || subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) != 1 {
+		a.guard.RecordFailure(clientIP, username)
+		c.String(http.StatusOK, "Fails.")
+		return
+	}
+
+	a.guard.RecordSuccess(clientIP, username)
+	a.setCookie(c, a.newSession(user))
+	c.String(http.StatusOK, "Ok.")
+}
+
+// Logout 删除当前会话
+func (a *SessionAuth) Logout(c *gin.Context) {
+	if sid, err := c.Cookie(sessionCookie); err == nil {
+		a.mutex.Lock()
+		delete(a.sessions, sid)
+		a.mutex.Unlock()
+	}
+	c.SetCookie(sessionCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.Status(http.StatusOK)
+}
+
+// Middleware 要求请求带有有效的 SID Cookie，否则返回 403，未启用认证时不做限制
+func (a *SessionAuth) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.cfg.Auth.Enabled {
+			c.Next()
+			return
+		}
+
+		sid, err := c.Cookie(sessionCookie)
+		if err != nil {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		a.mutex.Lock()
+		session, ok := a.sessions[sid]
+		if ok && time.Since(session.lastSeen) > a.ttl {
+			delete(a.sessions, sid)
+			ok = false
+		}
+		if ok {
+			session.lastSeen = time.Now()
+		}
+		a.mutex.Unlock()
+
+		if !ok {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		setCurrentUser(c, session.user)
+		c.Next()
+	}
+}
+
+// newSession 创建会话并清理过期的会话
+func (a *SessionAuth) newSession(user *config.UserConfig) string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	sid := hex.EncodeToString(buf)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	for key, session := range a.sessions {
+		if now.Sub(session.lastSeen) > a.ttl {
+			delete(a.sessions, key)
+		}
+	}
+	a.sessions[sid] = &authSession{user: user, lastSeen: now}
+	return sid
+}
+
+func (a *SessionAuth) setCookie(c *gin.Context, sid string) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    sid,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -15,6 +15,11 @@ type Magnet struct {
 	UpdatedAt    time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 	LastAccessed time.Time `json:"last_accessed" gorm:"autoCreateTime;index"`
 	AccessCount  int64     `json:"access_count" gorm:"default:0"`
+	// 最近一次保存的下载进度
+	BytesCompleted int64 `json:"bytes_completed" gorm:"default:0"`
+	// 做种策略：always 始终上传，never 不上传，ratio 分享率达到 SeedRatio 后停止上传
+	SeedMode  string  `json:"seed_mode" gorm:"size:16;default:'always'"`
+	SeedRatio float64 `json:"seed_ratio" gorm:"default:0"`
 }
 
 type File struct {
@@ -34,3 +39,175 @@ type Stats struct {
 	TotalFiles     int64 `json:"total_files"`
 	ActiveTorrents int   `json:"active_torrents"`
 }
+
+// LibraryEntry 用户媒体库条目，同一个种子可以被多个用户各自收藏
+type LibraryEntry struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	MagnetID  string    `json:"magnet_id" gorm:"size:64;not null;uniqueIndex:idx_entry_owner_magnet"`
+	Owner     string    `json:"owner" gorm:"size:128;not null;uniqueIndex:idx_entry_owner_magnet;index"`
+	Name      string    `json:"name" gorm:"size:512"` // 用户自定义名称，为空时使用种子名称
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+
+	// 所属分类，为空表示未分类
+	CategoryID *uint `json:"category_id" gorm:"index"`
+}
+
+// MagnetShare 媒体库条目的共享记录
+type MagnetShare struct {
+	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	EntryID    uint      `json:"entry_id" gorm:"not null;index"`
+	TargetType string    `json:"target_type" gorm:"size:16;not null"` // user / group
+	Target     string    `json:"target" gorm:"size:128;not null;index"`
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// Tag 标签，名称全局唯一，通过 EntryTag 关联到用户的媒体库条目
+type Tag struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string    `json:"name" gorm:"size:64;not null;uniqueIndex"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// EntryTag 媒体库条目和标签的多对多关联
+type EntryTag struct {
+	EntryID uint `json:"entry_id" gorm:"primaryKey"`
+	TagID   uint `json:"tag_id" gorm:"primaryKey;index"`
+}
+
+// Category 分类，通过 ParentID 组成树，同一父分类下名称唯一
+type Category struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string    `json:"name" gorm:"size:64;not null"`
+	ParentID  *uint     `json:"parent_id" gorm:"index"` // 为空表示顶级分类
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// LibraryMagnet 用户视角下的磁力链接：种子信息加上所属的媒体库条目
+type LibraryMagnet struct {
+	Magnet
+	EntryID     uint   `json:"entry_id"`
+	Owner       string `json:"owner"`
+	DisplayName string `json:"display_name"`
+	CategoryID  *uint  `json:"category_id"`
+}
+
+// ShareLink 单个文件的签名分享链接
+type ShareLink struct {
+	ID           uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	MagnetID     string     `json:"magnet_id" gorm:"size:64;not null;index"`
+	FileIndex    int        `json:"file_index" gorm:"not null"`
+	FileName     string     `json:"file_name" gorm:"size:512"`
+	Owner        string     `json:"owner" gorm:"size:128;not null;index"`
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null;index"`
+	MaxDownloads int        `json:"max_downloads" gorm:"default:0"` // 0 表示不限制
+	Downloads    int        `json:"downloads" gorm:"default:0"`
+	BoundIP      string     `json:"bound_ip" gorm:"size:64"` // 为空表示不绑定 IP
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	URL          string     `json:"url,omitempty" gorm:"-"`
+}
+
+// AuthEvent 认证相关的安全事件，例如锁定和解除锁定
+type AuthEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Type      string    `json:"type" gorm:"size:32;not null;index"` // lockout / unlock
+	Scope     string    `json:"scope" gorm:"size:16;not null"`      // ip / user
+	Key       string    `json:"key" gorm:"size:128;not null;index"`
+	Failures  int       `json:"failures"`
+	Until     time.Time `json:"until"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+}
+
+// WebhookDelivery Webhook 投递记录，同时作为持久化的投递队列
+type WebhookDelivery struct {
+	ID            uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	Endpoint      string     `json:"endpoint" gorm:"size:128;not null;index"`
+	URL           string     `json:"url" gorm:"size:1024;not null"`
+	Event         string     `json:"event" gorm:"size:64;not null;index"`
+	MagnetID      string     `json:"magnet_id" gorm:"size:64;index"`
+	Payload       string     `json:"payload" gorm:"type:text"`
+	Status        string     `json:"status" gorm:"size:16;not null;index:idx_delivery_status_next"` // pending / succeeded / failed
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index:idx_delivery_status_next"`
+	ResponseCode  int        `json:"response_code"`
+	LastError     string     `json:"last_error" gorm:"size:1024"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"autoCreateTime;index"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// Feed RSS/Atom 订阅，定时拉取并把匹配的条目加入 Owner 的媒体库
+type Feed struct {
+	ID            uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	Owner         string     `json:"owner" gorm:"size:128;not null;index"`
+	Name          string     `json:"name" gorm:"size:256"`
+	URL           string     `json:"url" gorm:"size:2048;not null"`
+	Interval      int        `json:"interval" gorm:"not null"`  // 拉取间隔（秒）
+	Include       string     `json:"include" gorm:"size:1024"`  // 标题需要匹配的正则，为空表示全部
+	Exclude       string     `json:"exclude" gorm:"size:1024"`  // 标题匹配时跳过的正则
+	MinSize       int64      `json:"min_size" gorm:"default:0"` // 字节，0 表示不限制
+	MaxSize       int64      `json:"max_size" gorm:"default:0"` // 字节，0 表示不限制
+	CategoryID    *uint      `json:"category_id" gorm:"index"`  // 新条目加入的分类
+	Enabled       bool       `json:"enabled" gorm:"not null"`   // 停用的订阅只能手动刷新
+	LastFetchedAt *time.Time `json:"last_fetched_at"`
+	LastError     string     `json:"last_error" gorm:"size:1024"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// FeedItem 处理过的订阅条目，用于记住已经见过的条目。失败的条目在下次拉取时重试
+type FeedItem struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	FeedID    uint      `json:"feed_id" gorm:"not null;uniqueIndex:idx_feed_item_guid"`
+	GUID      string    `json:"guid" gorm:"size:512;not null;uniqueIndex:idx_feed_item_guid"`
+	Title     string    `json:"title" gorm:"size:512"`
+	MagnetID  string    `json:"magnet_id" gorm:"size:64"`       // 对应的磁力链接，未能解析时为空
+	Status    string    `json:"status" gorm:"size:16;not null"` // added / skipped / failed
+	Reason    string    `json:"reason" gorm:"size:512"`         // 跳过或失败的原因
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// MediaInfo 视频文件的容器元数据，由内置的 MP4/Matroska 解析器生成
+type MediaInfo struct {
+	ID         uint         `json:"id" gorm:"primaryKey;autoIncrement"`
+	MagnetID   string       `json:"magnet_id" gorm:"size:64;not null;uniqueIndex:idx_media_info_file"`
+	FileIndex  int          `json:"file_index" gorm:"not null;uniqueIndex:idx_media_info_file"`
+	Container  string       `json:"container" gorm:"size:16"`
+	Duration   float64      `json:"duration"` // 秒
+	VideoCodec string       `json:"video_codec" gorm:"size:32"`
+	Width      int          `json:"width"`
+	Height     int          `json:"height"`
+	Tracks     []MediaTrack `json:"tracks" gorm:"serializer:json;type:text"`
+	Error      string       `json:"error,omitempty" gorm:"size:1024"` // 解析失败的原因，成功时为空
+	CreatedAt  time.Time    `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time    `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// MediaTrack 容器中的一条轨道
+type MediaTrack struct {
+	Number     int    `json:"number"`
+	Type       string `json:"type"` // video / audio / subtitle
+	Codec      string `json:"codec"`
+	Language   string `json:"language,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Default    bool   `json:"default"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+}
+
+// PlaybackProgress 用户在播放页中的播放位置，每个用户每个文件一条
+type PlaybackProgress struct {
+	ID        uint      `json:"-" gorm:"primaryKey;autoIncrement"`
+	Owner     string    `json:"-" gorm:"size:128;not null;uniqueIndex:idx_progress_file"`
+	MagnetID  string    `json:"magnet_id" gorm:"size:64;not null;uniqueIndex:idx_progress_file;index"`
+	FileIndex int       `json:"file_index" gorm:"not null;uniqueIndex:idx_progress_file"`
+	Position  float64   `json:"position"` // 秒
+	Duration  float64   `json:"duration"` // 秒，播放器报告的总时长
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime;index"`
+}
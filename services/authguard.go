@@ -0,0 +1,232 @@
+package services
+
+import (
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 锁定范围
+const (
+	LockoutScopeIP   = "ip"
+	LockoutScopeUser = "user"
+)
+
+// failureState 单个 IP 或用户名的失败计数
+type failureState struct {
+	failures    int
+	lockouts    int // 已被锁定的次数，用于计算指数退避
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Ban 当前生效的锁定
+type Ban struct {
+	Scope    string    `json:"scope"`
+	Key      string    `json:"key"`
+	Failures int       `json:"failures"`
+	Lockouts int       `json:"lockouts"`
+	Until    time.Time `json:"until"`
+}
+
+// AuthGuard 按 IP 和用户名统计认证失败次数，超过阈值后按指数时长锁定
+type AuthGuard struct {
+	cfg    *config.LockoutConfig
+	db     *gorm.DB
+	states map[string]map[string]*failureState
+	mutex  sync.Mutex
+}
+
+func NewAuthGuard(cfg *config.Config, db *gorm.DB) *AuthGuard {
+	return &AuthGuard{
+		cfg: &cfg.Auth.Lockout,
+		db:  db,
+		states: map[string]map[string]*failureState{
+			LockoutScopeIP:   make(map[string]*failureState),
+			LockoutScopeUser: make(map[string]*failureState),
+		},
+	}
+}
+
+// Check 返回 IP 或用户名剩余的锁定时长，未锁定时返回 0
+func (g *AuthGuard) Check(ip, username string) time.Duration {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	var remaining time.Duration
+	for scope, key := range map[string]string{LockoutScopeIP: ip, LockoutScopeUser: username} {
+		if key == "" {
+			continue
+		}
+		if state, ok := g.states[scope][key]; ok && state.lockedUntil.After(now) {
+			if d := state.lockedUntil.Sub(now); d > remaining {
+				remaining = d
+			}
+		}
+	}
+	return remaining
+}
+
+// RecordFailure 记录一次认证失败，达到阈值时锁定并写入安全事件
+func (g *AuthGuard) RecordFailure(ip, username string) {
+	log.Printf("Authentication failed: ip=%s user=%q", ip, username)
+	// 释放锁之后再写数据库，避免其他认证请求等待写入
+	g.recordEvents(g.countFailure(ip, username))
+}
+
+// countFailure 增加失败计数，返回需要记录的锁定事件
+func (g *AuthGuard) countFailure(ip, username string) []models.AuthEvent {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	g.prune(now)
+
+	var events []models.AuthEvent
+	for scope, key := range map[string]string{LockoutScopeIP: ip, LockoutScopeUser: username} {
+		if key == "" {
+			continue
+		}
+
+		state, ok := g.states[scope][key]
+		if !ok {
+			state = &failureState{}
+			g.states[scope][key] = state
+		}
+		if now.Sub(state.lastFailure) > g.cfg.FailureWindow {
+			state.failures = 0
+		}
+		state.failures++
+		state.lastFailure = now
+
+		maxFailures := g.cfg.MaxFailures
+		if scope == LockoutScopeIP {
+			maxFailures = g.cfg.MaxIPFailures
+		}
+		if state.failures < maxFailures {
+			continue
+		}
+
+		// 锁定时长按锁定次数翻倍，不超过上限
+		duration := g.cfg.BaseDuration << state.lockouts
+		if duration <= 0 || duration > g.cfg.MaxDuration {
+			duration = g.cfg.MaxDuration
+		}
+		state.lockouts++
+		state.lockedUntil = now.Add(duration)
+
+		log.Printf("Authentication lockout: %s=%s failures=%d duration=%s", scope, key, state.failures, duration)
+		events = append(events, authEvent("lockout", scope, key, state.failures, state.lockedUntil))
+		state.failures = 0
+	}
+	return events
+}
+
+// RecordSuccess 认证成功后清除失败计数，但保留已生效的锁定
+func (g *AuthGuard) RecordSuccess(ip, username string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for scope, key := range map[string]string{LockoutScopeIP: ip, LockoutScopeUser: username} {
+		if state, ok := g.states[scope][key]; ok && !state.lockedUntil.After(time.Now()) {
+			delete(g.states[scope], key)
+		}
+	}
+}
+
+// ListBans 列出当前生效的锁定
+func (g *AuthGuard) ListBans() []Ban {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	g.prune(now)
+
+	bans := []Ban{}
+	for scope, states := range g.states {
+		for key, state := range states {
+			if state.lockedUntil.After(now) {
+				bans = append(bans, Ban{
+					Scope:    scope,
+					Key:      key,
+					Failures: state.failures,
+					Lockouts: state.lockouts,
+					Until:    state.lockedUntil,
+				})
+			}
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.After(bans[j].Until) })
+	return bans
+}
+
+// ClearBan 解除锁定并清空计数，返回是否存在该记录
+func (g *AuthGuard) ClearBan(scope, key string) bool {
+	if !g.deleteState(scope, key) {
+		return false
+	}
+
+	log.Printf("Authentication lockout cleared: %s=%s", scope, key)
+	g.recordEvents([]models.AuthEvent{authEvent("unlock", scope, key, 0, time.Now())})
+	return true
+}
+
+// deleteState 删除 IP 或用户名的计数，返回是否存在该记录
+func (g *AuthGuard) deleteState(scope, key string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	states, ok := g.states[scope]
+	if !ok {
+		return false
+	}
+	if _, ok := states[key]; !ok {
+		return false
+	}
+	delete(states, key)
+	return true
+}
+
+// ListEvents 返回最近的安全事件
+func (g *AuthGuard) ListEvents(limit int) ([]models.AuthEvent, error) {
+	events := []models.AuthEvent{}
+	err := g.db.Order("created_at DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// prune 删除已过期且超出统计窗口的计数，调用方需持有锁
+func (g *AuthGuard) prune(now time.Time) {
+	for _, states := range g.states {
+		for key, state := range states {
+			if !state.lockedUntil.After(now) && now.Sub(state.lastFailure) > g.cfg.MaxDuration {
+				delete(states, key)
+			}
+		}
+	}
+}
+
+func authEvent(eventType, scope, key string, failures int, until time.Time) models.AuthEvent {
+	return models.AuthEvent{
+		Type:     eventType,
+		Scope:    scope,
+		Key:      key,
+		Failures: failures,
+		Until:    until,
+	}
+}
+
+// recordEvents 写入安全事件，调用方不能持有锁
+func (g *AuthGuard) recordEvents(events []models.AuthEvent) {
+	for i := range events {
+		if err := g.db.Create(&events[i]).Error; err != nil {
+			log.Printf("Failed to record auth event: %v", err)
+		}
+	}
+}
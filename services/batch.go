@@ -0,0 +1,275 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 批量操作
+const (
+	BatchAdd      = "add"
+	BatchRemove   = "remove"
+	BatchRetry    = "retry"
+	BatchReverify = "reverify"
+)
+
+// 批量操作中单项的结果
+const (
+	BatchItemOK         = "ok"
+	BatchItemSkipped    = "skipped"
+	BatchItemFailed     = "failed"
+	BatchItemRolledBack = "rolled_back"
+)
+
+// maxBatchSize 单次批量操作的最大条目数
+const maxBatchSize = 100
+
+// ErrBatchFailed 批量操作中有条目失败，整个操作已回滚
+var ErrBatchFailed = errors.New("batch failed")
+
+// BatchResult 批量操作中单个条目的结果
+type BatchResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	URI    string `json:"uri,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchItem 批量操作的内部状态，事务提交后根据它执行种子客户端上的操作
+type batchItem struct {
+	result  *BatchResult
+	entry   *models.LibraryEntry
+	created bool // add: 新建了磁力记录
+}
+
+// BatchMagnets 批量添加磁力链接，或批量删除、重试、重新校验磁力链接。
+// 数据库修改在同一个事务中完成，任一条目失败时全部回滚并返回 ErrBatchFailed，
+// 结果中会标明失败的条目；种子客户端上的操作在事务提交后执行。
+// 删除在事务中只检查条目，提交后逐个交给 RemoveMagnet，和单个删除一样等待读取器并按 deleteData 删除数据
+func (s *TorrentService) BatchMagnets(user *config.UserConfig, action string, uris, ids []string, deleteData bool) ([]BatchResult, error) {
+	var keys []string
+	switch action {
+	case BatchAdd:
+		keys = uris
+	case BatchRemove, BatchRetry, BatchReverify:
+		keys = ids
+	default:
+		return nil, fmt.Errorf("%w: unsupported action %s", ErrInvalidInput, action)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no items", ErrInvalidInput)
+	}
+	if len(keys) > maxBatchSize {
+		return nil, fmt.Errorf("%w: at most %d items per batch", ErrInvalidInput, maxBatchSize)
+	}
+
+	results := make([]BatchResult, len(keys))
+	items := make([]batchItem, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		results[i] = BatchResult{Index: i, Status: BatchItemOK}
+		items[i].result = &results[i]
+
+		key = strings.TrimSpace(key)
+		if action == BatchAdd {
+			results[i].URI = key
+			if !strings.Contains(key, "btih:") {
+				results[i].Status, results[i].Error = BatchItemFailed, "not a magnet link with btih"
+				continue
+			}
+			results[i].ID = s.extractInfoHash(key)
+		} else {
+			results[i].ID = strings.ToLower(key)
+		}
+
+		// 同一个种子在一次请求中只处理一次
+		if seen[results[i].ID] {
+			results[i].Status = BatchItemSkipped
+			continue
+		}
+		seen[results[i].ID] = true
+	}
+
+	// 重试和重新校验不修改条目，只要求可见
+	if action == BatchRetry || action == BatchReverify {
+		for i := range results {
+			if results[i].Status == BatchItemOK && !s.CanAccess(user, results[i].ID) {
+				results[i].Status, results[i].Error = BatchItemFailed, ErrNotFound.Error()
+			}
+		}
+	}
+
+	if !failed(results) {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			for i := range items {
+				if items[i].result.Status != BatchItemOK {
+					continue
+				}
+				if err := s.batchItemTx(tx, user, action, &items[i]); err != nil {
+					items[i].result.Status, items[i].result.Error = BatchItemFailed, err.Error()
+					return ErrBatchFailed
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrBatchFailed) {
+			return nil, err
+		}
+	}
+
+	if failed(results) {
+		for i := range results {
+			if results[i].Status == BatchItemOK {
+				results[i].Status = BatchItemRolledBack
+			}
+		}
+		return results, ErrBatchFailed
+	}
+
+	for i := range items {
+		if items[i].result.Status == BatchItemOK {
+			s.batchItemCommitted(user, action, &items[i], deleteData)
+		}
+	}
+	return results, nil
+}
+
+// batchItemTx 在事务中处理单个条目的数据库修改
+func (s *TorrentService) batchItemTx(tx *gorm.DB, user *config.UserConfig, action string, item *batchItem) error {
+	infoHash := item.result.ID
+	owner := s.ownerName(user)
+
+	switch action {
+	case BatchAdd:
+		var count int64
+		if err := tx.Model(&models.Magnet{}).Where("id = ?", infoHash).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			magnet := &models.Magnet{
+				ID:        infoHash,
+				MagnetURI: item.result.URI,
+				Status:    "pending",
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			}
+			if err := tx.Create(magnet).Error; err != nil {
+				return fmt.Errorf("failed to create magnet record: %w", err)
+			}
+			item.created = true
+		}
+
+		item.entry = &models.LibraryEntry{MagnetID: infoHash, Owner: owner}
+		return tx.Where("magnet_id = ? AND owner = ?", infoHash, owner).FirstOrCreate(item.entry).Error
+
+	case BatchRemove:
+		var entry models.LibraryEntry
+		err := tx.Where("magnet_id = ? AND owner = ?", infoHash, owner).First(&entry).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		item.entry = &entry
+		return nil
+
+	case BatchRetry:
+		var magnet models.Magnet
+		if err := tx.Select("id", "status").Where("id = ?", infoHash).First(&magnet).Error; err != nil {
+			return err
+		}
+		// 只重试出错的种子
+		if magnet.Status != "error" {
+			item.result.Status = BatchItemSkipped
+			return nil
+		}
+		return tx.Model(&models.Magnet{}).Where("id = ?", infoHash).
+			Updates(map[string]interface{}{"status": "pending", "updated_at": time.Now()}).Error
+
+	case BatchReverify:
+		torr := s.GetTorrent(infoHash)
+		if torr == nil || torr.Info() == nil {
+			return fmt.Errorf("torrent is not active")
+		}
+		return nil
+	}
+	return nil
+}
+
+// batchItemCommitted 事务提交后更新种子客户端并发布事件
+func (s *TorrentService) batchItemCommitted(user *config.UserConfig, action string, item *batchItem, deleteData bool) {
+	infoHash := item.result.ID
+
+	switch action {
+	case BatchAdd:
+		if item.created {
+			go s.addTorrentToClient(item.result.URI, infoHash)
+		}
+		s.events.Publish(Event{
+			Type:     EventMagnetAdded,
+			MagnetID: infoHash,
+			Owner:    item.entry.Owner,
+			Data:     map[string]interface{}{"status": "pending"},
+		})
+
+	case BatchRemove:
+		// 检查之后条目可能已被并发删除
+		if _, err := s.RemoveMagnet(user, infoHash, deleteData); err != nil {
+			item.result.Status, item.result.Error = BatchItemFailed, err.Error()
+		}
+
+	case BatchRetry:
+		var magnet models.Magnet
+		if err := s.db.Select("id", "magnet_uri").Where("id = ?", infoHash).First(&magnet).Error; err != nil {
+			log.Printf("Failed to load magnet %s for retry: %v", infoHash, err)
+			return
+		}
+		s.dropTorrent(infoHash)
+		s.publish(EventStatusChanged, infoHash, map[string]interface{}{"status": "pending"})
+		go s.addTorrentToClient(magnet.MagnetURI, infoHash)
+
+	case BatchReverify:
+		torr := s.GetTorrent(infoHash)
+		if torr == nil {
+			return
+		}
+		s.pending.Add(1)
+		go func() {
+			defer s.pending.Done()
+			if err := torr.VerifyDataContext(s.ctx); err != nil {
+				log.Printf("Failed to verify torrent %s: %v", infoHash, err)
+			}
+		}()
+	}
+}
+
+// dropTorrent 从客户端移除种子
+func (s *TorrentService) dropTorrent(infoHash string) {
+	s.mutex.Lock()
+	torr := s.activeTorrents[infoHash]
+	delete(s.activeTorrents, infoHash)
+	s.mutex.Unlock()
+	s.rates.remove(infoHash)
+
+	if torr != nil {
+		torr.Drop()
+	}
+}
+
+func failed(results []BatchResult) bool {
+	for _, result := range results {
+		if result.Status == BatchItemFailed {
+			return true
+		}
+	}
+	return false
+}
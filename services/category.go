@@ -0,0 +1,350 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// CategoryNode 分类树中的节点，Count 为当前用户在该分类（不含子分类）中可见的磁力链接数
+type CategoryNode struct {
+	models.Category
+	Path     string          `json:"path"`
+	Count    int64           `json:"count"`
+	Children []*CategoryNode `json:"children"`
+}
+
+// CategoryUpdate 修改分类，nil 表示不修改；ParentID 为 0 表示移动到顶级
+type CategoryUpdate struct {
+	Name     *string `json:"name"`
+	ParentID *uint   `json:"parent_id"`
+}
+
+// GetCategory 获取分类
+func (s *TorrentService) GetCategory(id uint) (*models.Category, error) {
+	var category models.Category
+	err := s.db.Where("id = ?", id).First(&category).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// CategoryTree 返回完整的分类树，子分类按名称排序
+func (s *TorrentService) CategoryTree(user *config.UserConfig) ([]*CategoryNode, error) {
+	var categories []models.Category
+	if err := s.db.Order("name").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		CategoryID uint
+		Count      int64
+	}
+	err := s.VisibleEntries(user).
+		Select("library_entries.category_id AS category_id, COUNT(*) AS count").
+		Where("library_entries.category_id IS NOT NULL").
+		Group("library_entries.category_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[uint]*CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &CategoryNode{Category: category, Children: []*CategoryNode{}}
+	}
+	for _, c := range counts {
+		if node := nodes[c.CategoryID]; node != nil {
+			node.Count = c.Count
+		}
+	}
+
+	roots := []*CategoryNode{}
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if parent := parentNode(nodes, category.ParentID); parent != nil {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	setCategoryPaths(roots, "")
+	return roots, nil
+}
+
+func parentNode(nodes map[uint]*CategoryNode, parentID *uint) *CategoryNode {
+	if parentID == nil {
+		return nil
+	}
+	return nodes[*parentID]
+}
+
+func setCategoryPaths(nodes []*CategoryNode, prefix string) {
+	for _, node := range nodes {
+		node.Path = prefix + node.Name
+		setCategoryPaths(node.Children, node.Path+"/")
+	}
+}
+
+// CreateCategory 创建分类，parentID 为 0 表示顶级分类
+func (s *TorrentService) CreateCategory(name string, parentID uint) (*models.Category, error) {
+	name, err := validateLabel("category", name)
+	if err != nil {
+		return nil, err
+	}
+
+	category := models.Category{Name: name}
+	if parentID != 0 {
+		if _, err := s.GetCategory(parentID); err != nil {
+			return nil, fmt.Errorf("%w: unknown parent category %d", ErrInvalidInput, parentID)
+		}
+		category.ParentID = &parentID
+	}
+	if err := s.checkSiblingName(category.ParentID, name, 0); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(&category).Error; err != nil {
+		return nil, fmt.Errorf("failed to create category: %w", err)
+	}
+	return &category, nil
+}
+
+// UpdateCategory 重命名或移动分类，不能移动到自己的子分类下
+func (s *TorrentService) UpdateCategory(id uint, upd CategoryUpdate) (*models.Category, error) {
+	category, err := s.GetCategory(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if upd.Name != nil {
+		if category.Name, err = validateLabel("category", *upd.Name); err != nil {
+			return nil, err
+		}
+	}
+	if upd.ParentID != nil {
+		category.ParentID = nil
+		if *upd.ParentID != 0 {
+			if _, err := s.GetCategory(*upd.ParentID); err != nil {
+				return nil, fmt.Errorf("%w: unknown parent category %d", ErrInvalidInput, *upd.ParentID)
+			}
+			subtree, err := s.categorySubtree(id)
+			if err != nil {
+				return nil, err
+			}
+			for _, descendant := range subtree {
+				if descendant == *upd.ParentID {
+					return nil, fmt.Errorf("%w: cannot move a category into itself or its subcategories", ErrInvalidInput)
+				}
+			}
+			category.ParentID = upd.ParentID
+		}
+	}
+	if err := s.checkSiblingName(category.ParentID, category.Name, id); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Model(category).Updates(map[string]interface{}{
+		"name":      category.Name,
+		"parent_id": category.ParentID,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// DeleteCategory 删除没有子分类的分类，其中的条目移动到上级分类
+func (s *TorrentService) DeleteCategory(id uint) error {
+	category, err := s.GetCategory(id)
+	if err != nil {
+		return err
+	}
+
+	var children int64
+	if err := s.db.Model(&models.Category{}).Where("parent_id = ?", id).Count(&children).Error; err != nil {
+		return err
+	}
+	if children > 0 {
+		return fmt.Errorf("%w: category has subcategories", ErrInvalidInput)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.LibraryEntry{}).Where("category_id = ?", id).
+			Update("category_id", category.ParentID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(category).Error
+	})
+}
+
+// CategoryPath 返回分类从顶级开始的路径，用 / 分隔
+func (s *TorrentService) CategoryPath(id uint) (string, error) {
+	var names []string
+	seen := make(map[uint]bool)
+	for next := &id; next != nil && !seen[*next]; {
+		seen[*next] = true
+		category, err := s.GetCategory(*next)
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		names = append(names, category.Name)
+		next = category.ParentID
+	}
+
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, "/"), nil
+}
+
+// CategoryPaths 返回所有分类 ID 到路径的映射
+func (s *TorrentService) CategoryPaths() (map[uint]string, error) {
+	var categories []models.Category
+	if err := s.db.Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*models.Category, len(categories))
+	for i := range categories {
+		byID[categories[i].ID] = &categories[i]
+	}
+	paths := make(map[uint]string, len(categories))
+	for _, category := range categories {
+		names := []string{category.Name}
+		seen := map[uint]bool{category.ID: true}
+		for parentID := category.ParentID; parentID != nil && !seen[*parentID]; {
+			parent := byID[*parentID]
+			if parent == nil {
+				break
+			}
+			seen[parent.ID] = true
+			names = append([]string{parent.Name}, names...)
+			parentID = parent.ParentID
+		}
+		paths[category.ID] = strings.Join(names, "/")
+	}
+	return paths, nil
+}
+
+// EnsureCategoryPath 按路径查找分类，不存在的层级会被创建
+func (s *TorrentService) EnsureCategoryPath(categoryPath string) (*models.Category, error) {
+	var id uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		id, err = ensureCategoryPath(tx, categoryPath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetCategory(id)
+}
+
+// ResolveCategoryPath 按名称逐级查找分类，返回最后一个匹配的分类和剩余的路径。
+// 第一级就不匹配时返回 nil
+func (s *TorrentService) ResolveCategoryPath(parts []string) (*models.Category, []string, error) {
+	var current *models.Category
+	for i, name := range parts {
+		query := s.db.Where("name = ?", name)
+		if current == nil {
+			query = query.Where("parent_id IS NULL")
+		} else {
+			query = query.Where("parent_id = ?", current.ID)
+		}
+
+		var category models.Category
+		err := query.First(&category).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return current, parts[i:], nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		current = &category
+	}
+	return current, nil, nil
+}
+
+// ChildCategories 返回直接子分类，parentID 为 0 表示顶级分类
+func (s *TorrentService) ChildCategories(parentID uint) ([]models.Category, error) {
+	query := s.db.Order("name")
+	if parentID == 0 {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", parentID)
+	}
+
+	var categories []models.Category
+	err := query.Find(&categories).Error
+	return categories, err
+}
+
+// MagnetsInCategory 返回直接属于该分类（不含子分类）的全部可见磁力链接，用于 WebDAV 目录
+func (s *TorrentService) MagnetsInCategory(user *config.UserConfig, id uint) ([]models.LibraryMagnet, error) {
+	magnets := []models.LibraryMagnet{}
+	err := s.VisibleMagnets(user).
+		Where("library_entries.category_id = ?", id).
+		Order("display_name, library_entries.id").
+		Scan(&magnets).Error
+	return magnets, err
+}
+
+// categorySubtree 返回分类及其所有子分类的 ID
+func (s *TorrentService) categorySubtree(id uint) ([]uint, error) {
+	var categories []models.Category
+	if err := s.db.Select("id", "parent_id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	children := make(map[uint][]uint)
+	for _, category := range categories {
+		if category.ParentID != nil {
+			children[*category.ParentID] = append(children[*category.ParentID], category.ID)
+		}
+	}
+
+	ids := []uint{id}
+	seen := map[uint]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// checkSiblingName 检查同一父分类下是否已有同名分类，exceptID 为正在修改的分类
+func (s *TorrentService) checkSiblingName(parentID *uint, name string, exceptID uint) error {
+	query := s.db.Model(&models.Category{}).Where("name = ? AND id <> ?", name, exceptID)
+	if parentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentID)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: category %q already exists", ErrInvalidInput, name)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package services
+
+import "syscall"
+
+// freeSpace 返回目录所在文件系统对普通用户可用的剩余字节数
+func freeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package services
+
+import "golang.org/x/sys/windows"
+
+// freeSpace 返回目录所在磁盘对当前用户可用的剩余字节数
+func freeSpace(dir string) (uint64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"magnet-webdav/media"
+	"magnet-webdav/models"
+	"magnet-webdav/subtitle"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// subtitleCacheDirName 下载目录中保存提取出的内嵌字幕的子目录
+	subtitleCacheDirName = ".subtitles"
+	// embeddedExtractReadahead 提取时顺序读取整个文件，使用较大的预读
+	embeddedExtractReadahead = 8 << 20
+	// embeddedCueMaxDuration 没有记录时长的字幕块最多显示的时间，下一条字幕更早开始时到下一条为止
+	embeddedCueMaxDuration = 5 * time.Second
+)
+
+// EmbeddedSubtitle MKV 中的文本字幕轨道对应的虚拟 .srt 文件
+type EmbeddedSubtitle struct {
+	Path   string
+	Source *models.File
+	Track  models.MediaTrack
+}
+
+// EmbeddedSubtitlePath 字幕轨道对应的虚拟文件路径，格式为 <文件名>.<语言>.<轨道号>.srt。
+// 语言来自文件本身，不是语言代码时使用 und
+func EmbeddedSubtitlePath(filePath string, track models.MediaTrack) string {
+	lang := track.Language
+	if !isLanguageTag(lang) {
+		lang = "und"
+	}
+	return strings.TrimSuffix(filePath, path.Ext(filePath)) + "." + lang + "." + strconv.Itoa(track.Number) + ".srt"
+}
+
+// isLanguageTag 判断是否为 ISO 639 或 BCP 47 形式的语言代码，只包含字母、数字和连字符
+func isLanguageTag(lang string) bool {
+	if lang == "" || len(lang) > 35 {
+		return false
+	}
+	for _, r := range lang {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// extraction 正在进行的提取，同一文件的并发请求等待同一个结果
+type extraction struct {
+	done chan struct{}
+	err  error
+}
+
+// subtitleCacheDir 种子的内嵌字幕缓存目录
+func (s *TorrentService) subtitleCacheDir(infoHash string) string {
+	return filepath.Join(s.cfg.Torrent.DownloadDir, subtitleCacheDirName, infoHash)
+}
+
+// removeSubtitleCache 删除种子提取出的内嵌字幕
+func (s *TorrentService) removeSubtitleCache(infoHash string) {
+	if err := os.RemoveAll(s.subtitleCacheDir(infoHash)); err != nil {
+		log.Printf("Failed to remove subtitle cache of %s: %v", infoHash, err)
+	}
+}
+
+// EmbeddedSubtitles 返回已解析的 MKV 文件中文本字幕轨道对应的虚拟文件，还没有解析的文件不会列出。
+// 种子中已有同名文件时不生成
+func (s *MediaService) EmbeddedSubtitles(magnetID string, files []models.File) ([]EmbeddedSubtitle, error) {
+	infos, err := s.MediaInfos(magnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(files))
+	for _, file := range files {
+		existing[file.FilePath] = true
+	}
+
+	var result []EmbeddedSubtitle
+	for i := range files {
+		info := infos[files[i].FileIndex]
+		if info == nil || (info.Container != media.ContainerMatroska && info.Container != media.ContainerWebM) {
+			continue
+		}
+		for _, track := range info.Tracks {
+			if track.Type != media.TrackSubtitle || !media.IsTextSubtitle(track.Codec) {
+				continue
+			}
+			subPath := EmbeddedSubtitlePath(files[i].FilePath, track)
+			if existing[subPath] {
+				continue
+			}
+			existing[subPath] = true
+			result = append(result, EmbeddedSubtitle{Path: subPath, Source: &files[i], Track: track})
+		}
+	}
+	return result, nil
+}
+
+// FindEmbeddedSubtitle 查找虚拟 .srt 文件对应的字幕轨道，subPath 不是虚拟文件时返回 ErrNotFound。调用方负责权限检查
+func (s *MediaService) FindEmbeddedSubtitle(magnetID, subPath string) (*EmbeddedSubtitle, error) {
+	if strings.ToLower(path.Ext(subPath)) != ".srt" {
+		return nil, ErrNotFound
+	}
+	var files []models.File
+	if err := s.db.Where("magnet_id = ?", magnetID).Order("file_index").Find(&files).Error; err != nil {
+		return nil, err
+	}
+	subs, err := s.EmbeddedSubtitles(magnetID, files)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		if sub.Path == subPath {
+			return &sub, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// embeddedCachePath 提取结果的缓存文件
+func (s *MediaService) embeddedCachePath(file *models.File, trackNumber int) string {
+	name := strconv.Itoa(file.FileIndex) + "." + strconv.Itoa(trackNumber) + ".srt"
+	return filepath.Join(s.torrentService.subtitleCacheDir(file.MagnetID), name)
+}
+
+// EmbeddedSubtitleSize 已提取的字幕大小，还没有提取时返回 false
+func (s *MediaService) EmbeddedSubtitleSize(sub *EmbeddedSubtitle) (int64, bool) {
+	info, err := os.Stat(s.embeddedCachePath(sub.Source, sub.Track.Number))
+	if err != nil {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// EmbeddedSubtitleData 返回字幕内容。没有缓存时提取文件中的所有文本字幕轨道，
+// ctx 只控制等待时间，调用方放弃后提取在后台继续，完成后写入缓存
+func (s *MediaService) EmbeddedSubtitleData(ctx context.Context, sub *EmbeddedSubtitle) ([]byte, error) {
+	cachePath := s.embeddedCachePath(sub.Source, sub.Track.Number)
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return data, nil
+	}
+
+	job := s.startExtraction(sub.Source)
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: extracting subtitles from %s", ErrNotReady, sub.Source.FileName)
+	}
+	if job.err != nil {
+		return nil, job.err
+	}
+
+	data, err := os.ReadFile(cachePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: subtitle track %d", ErrNotFound, sub.Track.Number)
+	}
+	return data, err
+}
+
+// startExtraction 开始提取文件中的字幕，已有相同文件的提取时返回正在进行的那个
+func (s *MediaService) startExtraction(file *models.File) *extraction {
+	key := file.MagnetID + "/" + strconv.Itoa(file.FileIndex)
+
+	s.extractMu.Lock()
+	defer s.extractMu.Unlock()
+	if job, ok := s.extractions[key]; ok {
+		return job
+	}
+	job := &extraction{done: make(chan struct{})}
+	s.extractions[key] = job
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		job.err = s.extractSubtitles(file)
+		if job.err != nil {
+			log.Printf("Failed to extract subtitles from %s: %v", file.FileName, job.err)
+		}
+
+		s.extractMu.Lock()
+		delete(s.extractions, key)
+		s.extractMu.Unlock()
+		close(job.done)
+	}()
+	return job
+}
+
+// extractSubtitles 读取整个文件，把每个文本字幕轨道转换为 SRT 写入缓存目录
+func (s *MediaService) extractSubtitles(file *models.File) error {
+	torrFile, reader, err := s.torrentService.GetFileStream(file.MagnetID, file.FilePath, 0, 0)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotReady, err)
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.ExtractTimeout)
+	defer cancel()
+	reader.SetContext(ctx)
+	reader.SetReadahead(embeddedExtractReadahead)
+
+	tracks, err := media.ReadMatroskaSubtitles(reader, torrFile.Length())
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: extracting subtitles from %s timed out", ErrNotReady, file.FileName)
+		}
+		return err
+	}
+
+	dir := s.torrentService.subtitleCacheDir(file.MagnetID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, track := range tracks {
+		if err := writeEmbeddedSubtitle(s.embeddedCachePath(file, track.Number), track); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEmbeddedSubtitle 先写入临时文件再重命名，读取方不会看到写了一半的文件
+func writeEmbeddedSubtitle(cachePath string, track media.SubtitleTrack) error {
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := subtitle.WriteSRT(tmp, embeddedCues(track)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cachePath)
+}
+
+// embeddedCues 把数据块转换为字幕，ASS 块只保留文本字段
+func embeddedCues(track media.SubtitleTrack) []subtitle.Cue {
+	blocks := track.Blocks
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Start < blocks[j].Start
+	})
+
+	cues := make([]subtitle.Cue, 0, len(blocks))
+	for i, block := range blocks {
+		text := string(block.Data)
+		if track.Codec == "ass" {
+			text = subtitle.MatroskaASSText(block.Data)
+		}
+
+		end := block.Start + block.Duration
+		if block.Duration == 0 {
+			end = block.Start + embeddedCueMaxDuration
+			if i+1 < len(blocks) && blocks[i+1].Start > block.Start && blocks[i+1].Start < end {
+				end = blocks[i+1].Start
+			}
+		}
+		cues = append(cues, subtitle.Cue{Start: block.Start, End: end, Text: text})
+	}
+	return cues
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	EventMagnetAdded      = "magnet.added"
+	EventMetadataReceived = "magnet.metadata_received"
+	EventStatusChanged    = "magnet.status_changed"
+	EventFilesSynced      = "magnet.files_synced"
+	EventMagnetRemoved    = "magnet.removed"
+	EventStreamStarted    = "stream.started"
+	EventStreamStopped    = "stream.stopped"
+)
+
+// eventHistorySize 保留的最近事件数，用于 SSE 断线重连时补发
+const eventHistorySize = 256
+
+// subscriberBuffer 每个订阅者的缓冲区大小，缓冲区满时丢弃事件而不是阻塞发布者
+const subscriberBuffer = 64
+
+// Event 媒体库和种子事件
+type Event struct {
+	ID       uint64                 `json:"id"`
+	Type     string                 `json:"type"`
+	MagnetID string                 `json:"magnet_id"`
+	Owner    string                 `json:"owner,omitempty"` // 只和某个用户的媒体库条目相关的事件
+	Time     time.Time              `json:"time"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventBus 进程内事件总线
+type EventBus struct {
+	subscribers map[*Subscription]struct{}
+	history     []Event
+	nextID      uint64
+	mutex       sync.Mutex
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscription 事件订阅，使用完毕后需要调用 Close
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	bus     *EventBus
+	dropped uint64
+}
+
+// Subscribe 订阅之后发布的所有事件
+func (b *EventBus) Subscribe() *Subscription {
+	return b.SubscribeBuffered(subscriberBuffer)
+}
+
+// SubscribeBuffered 使用指定缓冲区大小订阅，用于不能轻易丢弃事件的订阅者
+func (b *EventBus) SubscribeBuffered(size int) *Subscription {
+	ch := make(chan Event, size)
+	sub := &Subscription{C: ch, ch: ch, bus: b}
+
+	b.mutex.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mutex.Unlock()
+	return sub
+}
+
+// Close 取消订阅并关闭通道
+func (sub *Subscription) Close() {
+	b := sub.bus
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Dropped 返回因缓冲区已满而丢弃的事件数
+func (sub *Subscription) Dropped() uint64 {
+	sub.bus.mutex.Lock()
+	defer sub.bus.mutex.Unlock()
+	return sub.dropped
+}
+
+// Publish 发布事件，分配递增的 ID
+func (b *EventBus) Publish(event Event) Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextID++
+	event.ID = b.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped++
+		}
+	}
+	return event
+}
+
+// Since 返回 ID 大于 lastID 的历史事件
+func (b *EventBus) Since(lastID uint64) []Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var events []Event
+	for _, event := range b.history {
+		if event.ID > lastID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Events 返回种子服务的事件总线
+func (s *TorrentService) Events() *EventBus {
+	return s.events
+}
+
+func (s *TorrentService) publish(eventType, magnetID string, data map[string]interface{}) {
+	s.events.Publish(Event{Type: eventType, MagnetID: magnetID, Data: data})
+}
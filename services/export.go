@@ -0,0 +1,474 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"gorm.io/gorm"
+)
+
+// ExportVersion 导出格式的版本
+const ExportVersion = 1
+
+// exportManifestName tar 导出中保存媒体库信息的文件
+const exportManifestName = "library.json"
+
+// infoHashPattern 导入时接受的 info hash 格式
+var infoHashPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// LibraryExport 导出的媒体库
+type LibraryExport struct {
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Magnets    []ExportMagnet `json:"magnets"`
+}
+
+// ExportMagnet 导出的磁力链接以及用户的条目信息
+type ExportMagnet struct {
+	ID          string       `json:"id"`
+	MagnetURI   string       `json:"magnet_uri"`
+	Name        string       `json:"name"`
+	DisplayName string       `json:"display_name,omitempty"` // 用户自定义名称
+	Status      string       `json:"status"`
+	TotalSize   int64        `json:"total_size"`
+	CreatedAt   time.Time    `json:"created_at"`
+	SeedMode    string       `json:"seed_mode,omitempty"`
+	SeedRatio   float64      `json:"seed_ratio,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	Category    string       `json:"category,omitempty"` // 分类路径
+	Files       []ExportFile `json:"files"`
+	Metainfo    []byte       `json:"metainfo,omitempty"` // .torrent 文件内容，JSON 中为 base64
+}
+
+// ExportFile 导出的文件记录
+type ExportFile struct {
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Index    int    `json:"index"`
+	MimeType string `json:"mime_type"`
+}
+
+// ImportProgress 导入进度
+type ImportProgress struct {
+	Total      int      `json:"total"`
+	Processed  int      `json:"processed"`
+	Added      int      `json:"added"`
+	Duplicates int      `json:"duplicates"` // 媒体库中已有相同 info hash 的条目
+	Failed     int      `json:"failed"`
+	Errors     []string `json:"errors"`
+}
+
+// ExportLibrary 导出用户自己的媒体库条目，withMetainfo 为 true 时附带 .torrent 文件内容
+func (s *TorrentService) ExportLibrary(user *config.UserConfig, withMetainfo bool) (*LibraryExport, error) {
+	var magnets []models.LibraryMagnet
+	err := s.VisibleMagnets(user).
+		Where("library_entries.owner = ?", s.ownerName(user)).
+		Order("library_entries.id").
+		Scan(&magnets).Error
+	if err != nil {
+		return nil, err
+	}
+
+	export := &LibraryExport{Version: ExportVersion, ExportedAt: time.Now(), Magnets: []ExportMagnet{}}
+	for i := range magnets {
+		magnet := &magnets[i]
+		item := ExportMagnet{
+			ID:        magnet.ID,
+			MagnetURI: magnet.MagnetURI,
+			Name:      magnet.Name,
+			Status:    magnet.Status,
+			TotalSize: magnet.TotalSize,
+			CreatedAt: magnet.CreatedAt,
+			SeedMode:  magnet.SeedMode,
+			SeedRatio: magnet.SeedRatio,
+			Files:     []ExportFile{},
+		}
+		if magnet.DisplayName != magnet.Name {
+			item.DisplayName = magnet.DisplayName
+		}
+		if item.Tags, err = s.entryTags(magnet.EntryID); err != nil {
+			return nil, err
+		}
+		if magnet.CategoryID != nil {
+			if item.Category, err = s.CategoryPath(*magnet.CategoryID); err != nil {
+				return nil, err
+			}
+		}
+
+		var files []models.File
+		if err := s.db.Where("magnet_id = ?", magnet.ID).Order("file_index").Find(&files).Error; err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			item.Files = append(item.Files, ExportFile{
+				Path:     file.FilePath,
+				Name:     file.FileName,
+				Size:     file.FileSize,
+				Index:    file.FileIndex,
+				MimeType: file.MimeType,
+			})
+		}
+
+		if withMetainfo {
+			if item.Metainfo, err = s.readMetainfo(magnet.ID); err != nil {
+				return nil, err
+			}
+		}
+		export.Magnets = append(export.Magnets, item)
+	}
+	return export, nil
+}
+
+// WriteExportTar 把导出写成 tar：每个种子一个 <info hash>.torrent，
+// 其余信息（包括没有种子文件的磁力链接）保存在 library.json 中
+func WriteExportTar(w io.Writer, export *LibraryExport) error {
+	tw := tar.NewWriter(w)
+	manifest := *export
+	manifest.Magnets = make([]ExportMagnet, len(export.Magnets))
+
+	for i, magnet := range export.Magnets {
+		if len(magnet.Metainfo) > 0 {
+			if err := writeTarFile(tw, magnet.ID+".torrent", magnet.Metainfo, export.ExportedAt); err != nil {
+				return err
+			}
+		}
+		magnet.Metainfo = nil
+		manifest.Magnets[i] = magnet
+	}
+
+	data, err := json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, exportManifestName, data, export.ExportedAt); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ReadLibraryImport 读取 JSON 或 tar 格式的导入文件，根据内容自动识别格式。
+// tar 中没有出现在 library.json 里的 .torrent 文件会作为新的磁力链接导入
+func ReadLibraryImport(r io.Reader) (*LibraryExport, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var export LibraryExport
+		if err := json.Unmarshal(trimmed, &export); err != nil {
+			return nil, fmt.Errorf("%w: invalid JSON: %v", ErrInvalidInput, err)
+		}
+		return &export, nil
+	}
+
+	export := &LibraryExport{}
+	torrents := make(map[string][]byte)
+	var order []string
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: not a JSON export or tar archive: %v", ErrInvalidInput, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(header.Name)
+		switch {
+		case name == exportManifestName:
+			if err := json.Unmarshal(content, export); err != nil {
+				return nil, fmt.Errorf("%w: invalid %s: %v", ErrInvalidInput, exportManifestName, err)
+			}
+		case strings.HasSuffix(strings.ToLower(name), ".torrent"):
+			mi, err := metainfo.Load(bytes.NewReader(content))
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid torrent file %s: %v", ErrInvalidInput, header.Name, err)
+			}
+			infoHash := mi.HashInfoBytes().HexString()
+			if _, ok := torrents[infoHash]; !ok {
+				order = append(order, infoHash)
+			}
+			torrents[infoHash] = content
+		}
+	}
+
+	// 把种子文件合并到 library.json 中对应的条目
+	for i := range export.Magnets {
+		id := strings.ToLower(export.Magnets[i].ID)
+		if content, ok := torrents[id]; ok {
+			export.Magnets[i].Metainfo = content
+			delete(torrents, id)
+		}
+	}
+	for _, infoHash := range order {
+		if content, ok := torrents[infoHash]; ok {
+			export.Magnets = append(export.Magnets, ExportMagnet{ID: infoHash, Metainfo: content})
+		}
+	}
+	return export, nil
+}
+
+// ImportLibrary 把导出的媒体库导入到用户的媒体库，按 info hash 去重：
+// 用户已有的条目保持不变，其他用户已添加的种子只新建条目。
+// 每个磁力链接单独提交，失败的条目记录在结果中，progress 在每处理完一个后调用。
+// 和 REST API 一样只有管理员可以创建分类，其他用户导入时不存在的分类会被跳过并记录在结果中
+func (s *TorrentService) ImportLibrary(user *config.UserConfig, lib *LibraryExport, progress func(ImportProgress)) (ImportProgress, error) {
+	result := ImportProgress{Total: len(lib.Magnets), Errors: []string{}}
+	if lib.Version > ExportVersion {
+		return result, fmt.Errorf("%w: unsupported export version %d", ErrInvalidInput, lib.Version)
+	}
+
+	owner := s.ownerName(user)
+	createCategories := user == nil || user.Admin
+	for i := range lib.Magnets {
+		item := &lib.Magnets[i]
+		if item.Category != "" && !createCategories {
+			if _, err := findCategoryPath(s.db, item.Category, false); errors.Is(err, ErrNotFound) {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: category %q does not exist, imported without category", item.ID, item.Category))
+				item.Category = ""
+			}
+		}
+		added, err := s.importMagnet(owner, item, createCategories)
+		switch {
+		case err != nil:
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", item.ID, err))
+		case added:
+			result.Added++
+		default:
+			result.Duplicates++
+		}
+		result.Processed++
+		if progress != nil {
+			progress(result)
+		}
+	}
+	return result, nil
+}
+
+// importMagnet 导入单个磁力链接，返回是否新建了条目。createCategories 为 false 时只使用已有的分类
+func (s *TorrentService) importMagnet(owner string, item *ExportMagnet, createCategories bool) (bool, error) {
+	item.ID = strings.ToLower(strings.TrimSpace(item.ID))
+
+	// 有种子文件时以种子文件为准补全信息
+	var info *metainfo.Info
+	if len(item.Metainfo) > 0 {
+		mi, err := metainfo.Load(bytes.NewReader(item.Metainfo))
+		if err != nil {
+			return false, fmt.Errorf("invalid torrent file: %w", err)
+		}
+		infoHash := mi.HashInfoBytes()
+		if item.ID == "" {
+			item.ID = infoHash.HexString()
+		}
+		if item.ID != infoHash.HexString() {
+			return false, fmt.Errorf("torrent file info hash %s does not match", infoHash.HexString())
+		}
+		parsed, err := mi.UnmarshalInfo()
+		if err != nil {
+			return false, fmt.Errorf("invalid torrent file: %w", err)
+		}
+		info = &parsed
+		if item.Name == "" {
+			item.Name = info.BestName()
+		}
+		if item.MagnetURI == "" {
+			item.MagnetURI = mi.Magnet(&infoHash, info).String()
+		}
+		item.TotalSize = info.TotalLength()
+	}
+	if !infoHashPattern.MatchString(item.ID) {
+		return false, fmt.Errorf("invalid info hash")
+	}
+	if item.MagnetURI == "" {
+		item.MagnetURI = "magnet:?xt=urn:btih:" + item.ID
+	}
+	if s.extractInfoHash(item.MagnetURI) != item.ID {
+		return false, fmt.Errorf("magnet link does not match info hash")
+	}
+
+	var tags []string
+	if len(item.Tags) > 0 {
+		var err error
+		if tags, err = normalizeTags(item.Tags); err != nil {
+			return false, err
+		}
+	}
+	if item.SeedMode != "" && !ValidSeedMode(item.SeedMode) {
+		return false, fmt.Errorf("unsupported seed_mode %s", item.SeedMode)
+	}
+
+	created := false
+	added := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.LibraryEntry{}).Where("magnet_id = ? AND owner = ?", item.ID, owner).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		if err := tx.Model(&models.Magnet{}).Where("id = ?", item.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			// 导出文件中的文件列表只用于展示，不能证明文件属于这个种子，不写入数据库。
+			// 有种子文件时按元数据写入文件列表，否则等获取到元数据后再同步
+			var files []models.File
+			if info != nil {
+				fileInfos := info.UpvertedFiles()
+				for i, filePath := range metainfoFilePaths(info) {
+					files = append(files, models.File{
+						MagnetID:  item.ID,
+						FilePath:  filePath,
+						FileName:  path.Base(filePath),
+						FileSize:  fileInfos[i].Length,
+						FileIndex: i,
+						MimeType:  s.getMimeType(filePath),
+						CreatedAt: time.Now(),
+					})
+				}
+			}
+
+			magnet := &models.Magnet{
+				ID:        item.ID,
+				MagnetURI: item.MagnetURI,
+				Name:      item.Name,
+				TotalSize: item.TotalSize,
+				FileCount: len(files),
+				Status:    "pending",
+				SeedMode:  item.SeedMode,
+				SeedRatio: item.SeedRatio,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			}
+			if magnet.SeedMode == "" {
+				magnet.SeedMode = SeedModeAlways
+			}
+			if err := tx.Create(magnet).Error; err != nil {
+				return fmt.Errorf("failed to create magnet record: %w", err)
+			}
+			if len(files) > 0 {
+				if err := tx.Create(&files).Error; err != nil {
+					return err
+				}
+			}
+			created = true
+		}
+
+		entry := models.LibraryEntry{MagnetID: item.ID, Owner: owner, Name: item.DisplayName}
+		if item.Category != "" {
+			categoryID, err := findCategoryPath(tx, item.Category, createCategories)
+			if err != nil {
+				return err
+			}
+			entry.CategoryID = &categoryID
+		}
+		if err := tx.Create(&entry).Error; err != nil {
+			return fmt.Errorf("failed to create library entry: %w", err)
+		}
+		if err := setEntryTags(tx, entry.ID, tags); err != nil {
+			return err
+		}
+		added = true
+		return nil
+	})
+	if err != nil || !added {
+		return false, err
+	}
+
+	if len(item.Metainfo) > 0 {
+		if err := s.storeMetainfo(item.ID, item.Metainfo); err != nil {
+			return true, err
+		}
+	}
+	// 命令行导入时没有启动客户端，下次启动服务时会恢复这些种子
+	if created && s.client != nil {
+		go s.addTorrentToClient(item.MagnetURI, item.ID)
+	}
+
+	s.events.Publish(Event{
+		Type:     EventMagnetAdded,
+		MagnetID: item.ID,
+		Owner:    owner,
+		Data:     map[string]interface{}{"name": item.Name, "status": "pending", "imported": true},
+	})
+	return true, nil
+}
+
+// ensureCategoryPath 按路径逐级查找分类，不存在的自动创建，返回最后一级的 ID
+func ensureCategoryPath(tx *gorm.DB, categoryPath string) (uint, error) {
+	return findCategoryPath(tx, categoryPath, true)
+}
+
+// findCategoryPath 按路径逐级查找分类，返回最后一级的 ID。不存在的层级在 create 为 true 时自动创建，
+// 否则返回 ErrNotFound
+func findCategoryPath(tx *gorm.DB, categoryPath string, create bool) (uint, error) {
+	var parentID *uint
+	for _, name := range strings.Split(categoryPath, "/") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		name, err := validateLabel("category", name)
+		if err != nil {
+			return 0, err
+		}
+
+		query := tx.Where("name = ?", name)
+		if parentID == nil {
+			query = query.Where("parent_id IS NULL")
+		} else {
+			query = query.Where("parent_id = ?", *parentID)
+		}
+		var category models.Category
+		err = query.First(&category).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if !create {
+				return 0, ErrNotFound
+			}
+			category = models.Category{Name: name, ParentID: parentID}
+			err = tx.Create(&category).Error
+		}
+		if err != nil {
+			return 0, err
+		}
+		id := category.ID
+		parentID = &id
+	}
+
+	if parentID == nil {
+		return 0, fmt.Errorf("%w: empty category path", ErrInvalidInput)
+	}
+	return *parentID, nil
+}
@@ -0,0 +1,538 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 订阅条目状态
+const (
+	FeedItemAdded   = "added"
+	FeedItemSkipped = "skipped"
+	FeedItemFailed  = "failed"
+)
+
+const (
+	// feedCheckInterval 检查哪些订阅到期需要拉取的间隔
+	feedCheckInterval = time.Minute
+	// maxFeedSize 订阅内容的最大大小
+	maxFeedSize = 10 << 20
+	// maxTorrentFileSize 从订阅下载的 .torrent 文件的最大大小
+	maxTorrentFileSize = 16 << 20
+	// maxFeedGUIDLength 超过该长度的 guid 使用 SHA-1 保存
+	maxFeedGUIDLength = 512
+)
+
+// FeedUpdate 创建或修改订阅的字段，nil 表示使用默认值或不修改
+type FeedUpdate struct {
+	Name       *string `json:"name"`
+	URL        *string `json:"url"`
+	Interval   *int    `json:"interval"` // 秒，0 表示使用 feed.default_interval
+	Include    *string `json:"include"`
+	Exclude    *string `json:"exclude"`
+	MinSize    *int64  `json:"min_size"`
+	MaxSize    *int64  `json:"max_size"`
+	CategoryID *uint   `json:"category_id"` // 0 表示不设置分类
+	Enabled    *bool   `json:"enabled"`
+}
+
+// FeedResult 一次拉取的结果
+type FeedResult struct {
+	Entries int      `json:"entries"` // 订阅中的条目数
+	New     int      `json:"new"`     // 之前没有处理过的条目数
+	Added   int      `json:"added"`
+	Skipped int      `json:"skipped"`
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors"`
+}
+
+// FeedService 定时拉取 RSS/Atom 订阅，把符合条件的新条目加入订阅者的媒体库
+type FeedService struct {
+	cfg            *config.FeedConfig
+	auth           *config.AuthConfig
+	userAgent      string
+	torrentService *TorrentService
+	db             *gorm.DB
+	client         *http.Client
+	pollMutex      sync.Mutex // 同一时间只拉取一个订阅，避免定时任务和手动刷新重复添加
+	ctx            context.Context
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+}
+
+func NewFeedService(cfg *config.Config, torrentService *TorrentService) *FeedService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &FeedService{
+		cfg:            &cfg.Feed,
+		auth:           &cfg.Auth,
+		userAgent:      cfg.Torrent.UserAgent,
+		torrentService: torrentService,
+		db:             torrentService.DB(),
+		client:         &http.Client{Timeout: cfg.Feed.Timeout, CheckRedirect: stopAtMagnetRedirect},
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Start 启动定时拉取
+func (s *FeedService) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(feedCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			s.pollDue()
+
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止定时拉取，进行中的请求会被取消
+func (s *FeedService) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// ListFeeds 列出用户自己的订阅
+func (s *FeedService) ListFeeds(user *config.UserConfig) ([]models.Feed, error) {
+	feeds := []models.Feed{}
+	err := s.db.Where("owner = ?", s.torrentService.ownerName(user)).Order("id").Find(&feeds).Error
+	return feeds, err
+}
+
+// GetFeed 获取用户自己的订阅，不存在或属于其他用户时返回 ErrNotFound
+func (s *FeedService) GetFeed(user *config.UserConfig, id uint) (*models.Feed, error) {
+	var feed models.Feed
+	err := s.db.Where("id = ? AND owner = ?", id, s.torrentService.ownerName(user)).First(&feed).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// CreateFeed 创建订阅，创建后会在下一轮检查时立即拉取
+func (s *FeedService) CreateFeed(user *config.UserConfig, upd FeedUpdate) (*models.Feed, error) {
+	if upd.URL == nil {
+		return nil, fmt.Errorf("%w: url is required", ErrInvalidInput)
+	}
+	feed := &models.Feed{
+		Owner:   s.torrentService.ownerName(user),
+		Enabled: true,
+	}
+	if err := s.applyFeedUpdate(feed, upd); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(feed).Error; err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// UpdateFeed 修改订阅，修改地址后会重新拉取
+func (s *FeedService) UpdateFeed(user *config.UserConfig, id uint, upd FeedUpdate) (*models.Feed, error) {
+	feed, err := s.GetFeed(user, id)
+	if err != nil {
+		return nil, err
+	}
+	previousURL := feed.URL
+	if err := s.applyFeedUpdate(feed, upd); err != nil {
+		return nil, err
+	}
+	if feed.URL != previousURL {
+		feed.LastFetchedAt = nil
+		feed.LastError = ""
+	}
+	if err := s.db.Save(feed).Error; err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// DeleteFeed 删除订阅和它的条目记录，已加入媒体库的磁力链接不受影响
+func (s *FeedService) DeleteFeed(user *config.UserConfig, id uint) error {
+	feed, err := s.GetFeed(user, id)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("feed_id = ?", feed.ID).Delete(&models.FeedItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(feed).Error
+	})
+}
+
+// ListItems 按处理时间倒序列出订阅的条目记录，status 为空表示全部
+func (s *FeedService) ListItems(user *config.UserConfig, id uint, status string, limit int) ([]models.FeedItem, error) {
+	feed, err := s.GetFeed(user, id)
+	if err != nil {
+		return nil, err
+	}
+	query := s.db.Where("feed_id = ?", feed.ID).Order("updated_at DESC, id DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	items := []models.FeedItem{}
+	if err := query.Limit(limit).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Refresh 立即拉取订阅，停用的订阅也可以手动刷新
+func (s *FeedService) Refresh(user *config.UserConfig, id uint) (*FeedResult, error) {
+	feed, err := s.GetFeed(user, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.poll(feed), nil
+}
+
+// applyFeedUpdate 校验并应用修改
+func (s *FeedService) applyFeedUpdate(feed *models.Feed, upd FeedUpdate) error {
+	if upd.Name != nil {
+		feed.Name = strings.TrimSpace(*upd.Name)
+	}
+	if upd.URL != nil {
+		u, err := url.Parse(strings.TrimSpace(*upd.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: url must be an http:// or https:// address", ErrInvalidInput)
+		}
+		feed.URL = u.String()
+	}
+	if upd.Interval != nil {
+		feed.Interval = *upd.Interval
+	}
+	if feed.Interval == 0 {
+		feed.Interval = int(s.cfg.DefaultInterval / time.Second)
+	}
+	if time.Duration(feed.Interval)*time.Second < s.cfg.MinInterval {
+		return fmt.Errorf("%w: interval must be at least %d seconds", ErrInvalidInput, int(s.cfg.MinInterval/time.Second))
+	}
+	if upd.Include != nil {
+		feed.Include = *upd.Include
+	}
+	if upd.Exclude != nil {
+		feed.Exclude = *upd.Exclude
+	}
+	for _, pattern := range []string{feed.Include, feed.Exclude} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%w: invalid pattern %q: %v", ErrInvalidInput, pattern, err)
+		}
+	}
+	if upd.MinSize != nil {
+		feed.MinSize = *upd.MinSize
+	}
+	if upd.MaxSize != nil {
+		feed.MaxSize = *upd.MaxSize
+	}
+	if feed.MinSize < 0 || feed.MaxSize < 0 || (feed.MaxSize > 0 && feed.MinSize > feed.MaxSize) {
+		return fmt.Errorf("%w: invalid size limits", ErrInvalidInput)
+	}
+	if upd.CategoryID != nil {
+		feed.CategoryID = nil
+		if *upd.CategoryID != 0 {
+			if _, err := s.torrentService.GetCategory(*upd.CategoryID); err != nil {
+				return fmt.Errorf("%w: unknown category %d", ErrInvalidInput, *upd.CategoryID)
+			}
+			feed.CategoryID = upd.CategoryID
+		}
+	}
+	if upd.Enabled != nil {
+		feed.Enabled = *upd.Enabled
+	}
+	return nil
+}
+
+// pollDue 拉取所有到期的订阅
+func (s *FeedService) pollDue() {
+	var feeds []models.Feed
+	if err := s.db.Where("enabled = ?", true).Order("id").Find(&feeds).Error; err != nil {
+		log.Printf("Failed to load feeds: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range feeds {
+		if s.ctx.Err() != nil {
+			return
+		}
+		feed := &feeds[i]
+		if feed.LastFetchedAt != nil && feed.LastFetchedAt.Add(time.Duration(feed.Interval)*time.Second).After(now) {
+			continue
+		}
+		result := s.poll(feed)
+		if result.Added > 0 || result.Failed > 0 {
+			log.Printf("Feed %d (%s): %d added, %d skipped, %d failed", feed.ID, feed.URL, result.Added, result.Skipped, result.Failed)
+		}
+	}
+}
+
+// poll 拉取订阅并处理新条目，结果记录到订阅的 last_fetched_at 和 last_error
+func (s *FeedService) poll(feed *models.Feed) *FeedResult {
+	s.pollMutex.Lock()
+	defer s.pollMutex.Unlock()
+
+	result := &FeedResult{Errors: []string{}}
+	err := s.pollFeed(feed, result)
+
+	now := time.Now()
+	lastError := ""
+	if err != nil {
+		lastError = truncate(err.Error(), 1024)
+		result.Errors = append(result.Errors, err.Error())
+		log.Printf("Failed to fetch feed %d (%s): %v", feed.ID, feed.URL, err)
+	}
+	feed.LastFetchedAt = &now
+	feed.LastError = lastError
+	if err := s.db.Model(&models.Feed{}).Where("id = ?", feed.ID).Updates(map[string]interface{}{
+		"last_fetched_at": now,
+		"last_error":      lastError,
+	}).Error; err != nil {
+		log.Printf("Failed to update feed %d: %v", feed.ID, err)
+	}
+	return result
+}
+
+func (s *FeedService) pollFeed(feed *models.Feed, result *FeedResult) error {
+	user := s.auth.FindUser(feed.Owner)
+	if user == nil {
+		return fmt.Errorf("owner %s no longer exists", feed.Owner)
+	}
+	include, err := regexp.Compile(feed.Include)
+	if err != nil {
+		return err
+	}
+	exclude, err := regexp.Compile(feed.Exclude)
+	if err != nil {
+		return err
+	}
+
+	body, err := s.fetch(feed.URL, maxFeedSize)
+	if err != nil {
+		return err
+	}
+	entries, err := ParseFeed(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	result.Entries = len(entries)
+
+	for _, entry := range entries {
+		if s.ctx.Err() != nil {
+			return s.ctx.Err()
+		}
+		guid := feedGUID(entry.GUID)
+
+		var seen models.FeedItem
+		err := s.db.Where("feed_id = ? AND guid = ?", feed.ID, guid).First(&seen).Error
+		if err == nil && seen.Status != FeedItemFailed {
+			continue
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		result.New++
+
+		item := models.FeedItem{FeedID: feed.ID, GUID: guid, Title: truncate(entry.Title, 512)}
+		reason, err := s.processEntry(user, feed, entry, include, exclude, &item)
+		switch {
+		case err != nil:
+			item.Status = FeedItemFailed
+			item.Reason = truncate(err.Error(), 512)
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", entry.Title, err))
+		case reason != "":
+			item.Status = FeedItemSkipped
+			item.Reason = reason
+			result.Skipped++
+		default:
+			item.Status = FeedItemAdded
+			result.Added++
+		}
+
+		if err := s.db.Where("feed_id = ? AND guid = ?", feed.ID, guid).
+			Assign(map[string]interface{}{
+				"title":     item.Title,
+				"magnet_id": item.MagnetID,
+				"status":    item.Status,
+				"reason":    item.Reason,
+			}).FirstOrCreate(&item).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processEntry 过滤并添加条目，返回跳过的原因；返回错误时条目会在下次拉取时重试
+func (s *FeedService) processEntry(user *config.UserConfig, feed *models.Feed, entry FeedEntry, include, exclude *regexp.Regexp, item *models.FeedItem) (string, error) {
+	if !include.MatchString(entry.Title) {
+		return "title does not match include pattern", nil
+	}
+	if feed.Exclude != "" && exclude.MatchString(entry.Title) {
+		return "title matches exclude pattern", nil
+	}
+	if reason := sizeFilter(feed, entry.Size); reason != "" {
+		return reason, nil
+	}
+
+	magnetURI, infoHash, file, err := s.resolveEntry(entry)
+	if err != nil {
+		return "", err
+	}
+	item.MagnetID = infoHash
+	if entry.Size == 0 && file != nil {
+		if reason := sizeFilter(feed, file.Size); reason != "" {
+			return reason, nil
+		}
+	}
+
+	if _, err := s.torrentService.GetOwnEntry(user, infoHash); err == nil {
+		return "already in library", nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+
+	if file != nil {
+		_, err = s.torrentService.AddTorrentFile(user, file, "")
+	} else {
+		_, err = s.torrentService.AddMagnet(user, magnetURI, "")
+	}
+	if err != nil {
+		return "", err
+	}
+	if feed.CategoryID != nil {
+		if _, err := s.torrentService.UpdateMagnet(user, infoHash, MagnetUpdate{CategoryID: feed.CategoryID}); err != nil {
+			log.Printf("Failed to set category of %s from feed %d: %v", infoHash, feed.ID, err)
+		}
+	}
+	return "", nil
+}
+
+// resolveEntry 返回条目的磁力链接和 infoHash。只有 .torrent 地址时下载并解析种子文件
+func (s *FeedService) resolveEntry(entry FeedEntry) (string, string, *TorrentFile, error) {
+	if entry.MagnetURI != "" {
+		magnetURI, infoHash, err := NormalizeMagnetURI(entry.MagnetURI)
+		return magnetURI, infoHash, nil, err
+	}
+	if entry.TorrentURL == "" {
+		return "", "", nil, errors.New("no magnet link or torrent file")
+	}
+
+	data, redirect, err := fetchURL(s.ctx, s.client, s.userAgent, entry.TorrentURL, maxTorrentFileSize)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if redirect != "" {
+		magnetURI, infoHash, err := NormalizeMagnetURI(redirect)
+		return magnetURI, infoHash, nil, err
+	}
+	file, err := ParseTorrentFile(data)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return file.MagnetURI, file.InfoHash, file, nil
+}
+
+// fetch 下载订阅或种子文件，超过 limit 字节时返回错误
+func (s *FeedService) fetch(rawURL string, limit int64) ([]byte, error) {
+	data, _, err := fetchURL(s.ctx, s.client, s.userAgent, rawURL, limit)
+	return data, err
+}
+
+// fetchURL 下载 rawURL 的内容，超过 limit 字节时返回错误。重定向到磁力链接时返回该磁力链接，
+// 部分索引器的 .torrent 下载地址会这样跳转
+func fetchURL(ctx context.Context, client *http.Client, userAgent, rawURL string, limit int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if location := resp.Header.Get("Location"); strings.HasPrefix(location, "magnet:") {
+		return nil, location, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("GET %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > limit {
+		return nil, "", fmt.Errorf("GET %s: response larger than %d bytes", rawURL, limit)
+	}
+	return data, "", nil
+}
+
+// stopAtMagnetRedirect 作为 http.Client 的 CheckRedirect，遇到磁力链接时停止跟随，交给 fetchURL 处理
+func stopAtMagnetRedirect(req *http.Request, via []*http.Request) error {
+	if req.URL.Scheme == "magnet" {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// sizeFilter 检查大小限制，大小未知时不过滤
+func sizeFilter(feed *models.Feed, size int64) string {
+	if size <= 0 {
+		return ""
+	}
+	if feed.MinSize > 0 && size < feed.MinSize {
+		return fmt.Sprintf("size %d below min_size", size)
+	}
+	if feed.MaxSize > 0 && size > feed.MaxSize {
+		return fmt.Sprintf("size %d above max_size", size)
+	}
+	return ""
+}
+
+// feedGUID 过长的 guid 用 SHA-1 代替，保证能放进唯一索引
+func feedGUID(guid string) string {
+	if len(guid) <= maxFeedGUIDLength {
+		return guid
+	}
+	sum := sha1.Sum([]byte(guid))
+	return "sha1:" + hex.EncodeToString(sum[:])
+}
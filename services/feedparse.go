@@ -0,0 +1,222 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// feedInfoHashPattern 订阅中 40 位十六进制或 32 位 base32 的 v1 infoHash
+var feedInfoHashPattern = regexp.MustCompile(`^([0-9a-fA-F]{40}|[A-Za-z2-7]{32})$`)
+
+// FeedEntry 从订阅中解析出的条目
+type FeedEntry struct {
+	GUID       string
+	Title      string
+	MagnetURI  string // 条目直接给出的磁力链接
+	TorrentURL string // 没有磁力链接时需要下载的 .torrent 地址
+	Size       int64  // 订阅声明的大小，0 表示未知
+	// torznab 的 seeders 和 peers 属性，-1 表示未知
+	Seeders     int
+	Peers       int
+	PublishedAt *time.Time
+}
+
+// feedDocument 同时兼容 RSS 2.0、RSS 1.0 (RDF) 和 Atom
+type feedDocument struct {
+	XMLName      xml.Name
+	ChannelItems []feedXMLItem `xml:"channel>item"`
+	Items        []feedXMLItem `xml:"item"`
+	Entries      []feedXMLItem `xml:"entry"`
+	// Torznab/Newznab 出错时返回 <error code="..." description="..."/>
+	ErrorCode        string `xml:"code,attr"`
+	ErrorDescription string `xml:"description,attr"`
+}
+
+type feedXMLItem struct {
+	Title      string             `xml:"title"`
+	GUID       string             `xml:"guid"`
+	ID         string             `xml:"id"`
+	Links      []feedXMLLink      `xml:"link"`
+	Enclosures []feedXMLEnclosure `xml:"enclosure"`
+	Attrs      []feedXMLAttr      `xml:"http://torznab.com/schemas/2015/feed attr"` // torznab:attr
+	InfoHash   string             `xml:"infoHash"`                                  // 部分站点使用的扩展元素，例如 nyaa:infoHash
+	PubDate    string             `xml:"pubDate"`
+	Published  string             `xml:"published"`
+}
+
+// feedXMLLink RSS 的 link 是文本，Atom 的 link 使用 href 等属性
+type feedXMLLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+	Text   string `xml:",chardata"`
+}
+
+type feedXMLEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+type feedXMLAttr struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// ParseFeed 解析 RSS 或 Atom 订阅，识别 enclosure、magnet: 链接和 torznab 属性
+func ParseFeed(r io.Reader) ([]FeedEntry, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	var doc feedDocument
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid feed: %w", err)
+	}
+	switch strings.ToLower(doc.XMLName.Local) {
+	case "rss", "rdf", "feed":
+	case "error":
+		return nil, fmt.Errorf("feed error %s: %s", doc.ErrorCode, doc.ErrorDescription)
+	default:
+		return nil, fmt.Errorf("invalid feed: unexpected root element %s", doc.XMLName.Local)
+	}
+
+	items := append(append(doc.ChannelItems, doc.Items...), doc.Entries...)
+	entries := make([]FeedEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, item.entry())
+	}
+	return entries, nil
+}
+
+// entry 按 torznab 属性、enclosure、link、guid 的顺序查找磁力链接或 .torrent 地址
+func (item feedXMLItem) entry() FeedEntry {
+	entry := FeedEntry{Title: strings.TrimSpace(item.Title), Seeders: -1, Peers: -1}
+
+	attrs := make(map[string]string, len(item.Attrs))
+	for _, attr := range item.Attrs {
+		attrs[strings.ToLower(attr.Name)] = strings.TrimSpace(attr.Value)
+	}
+
+	var candidates []string
+	if value := attrs["magneturl"]; value != "" {
+		candidates = append(candidates, value)
+	}
+	for _, enclosure := range item.Enclosures {
+		candidates = append(candidates, strings.TrimSpace(enclosure.URL))
+		if entry.Size == 0 {
+			entry.Size = enclosure.Length
+		}
+	}
+	for _, link := range item.Links {
+		if link.Href == "" {
+			candidates = append(candidates, strings.TrimSpace(link.Text))
+			continue
+		}
+		switch link.Rel {
+		case "", "alternate", "enclosure":
+			candidates = append(candidates, strings.TrimSpace(link.Href))
+			if link.Rel == "enclosure" && entry.Size == 0 {
+				entry.Size = link.Length
+			}
+		}
+	}
+	candidates = append(candidates, strings.TrimSpace(item.GUID))
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, "magnet:") {
+			entry.MagnetURI = candidate
+			break
+		}
+	}
+	if entry.MagnetURI == "" {
+		infoHash := attrs["infohash"]
+		if infoHash == "" {
+			infoHash = strings.TrimSpace(item.InfoHash)
+		}
+		if feedInfoHashPattern.MatchString(infoHash) {
+			entry.MagnetURI = "magnet:?xt=urn:btih:" + infoHash
+			if entry.Title != "" {
+				entry.MagnetURI += "&dn=" + url.QueryEscape(entry.Title)
+			}
+		}
+	}
+	if entry.MagnetURI == "" {
+		entry.TorrentURL = torrentURL(item, candidates)
+	}
+
+	if size, err := strconv.ParseInt(attrs["size"], 10, 64); err == nil && size > 0 {
+		entry.Size = size
+	}
+	if seeders, err := strconv.Atoi(attrs["seeders"]); err == nil && seeders >= 0 {
+		entry.Seeders = seeders
+	}
+	if peers, err := strconv.Atoi(attrs["peers"]); err == nil && peers >= 0 {
+		entry.Peers = peers
+	}
+	entry.PublishedAt = parseFeedTime(item.PubDate, item.Published)
+
+	for _, id := range []string{item.GUID, item.ID, entry.MagnetURI, entry.TorrentURL, entry.Title} {
+		if id = strings.TrimSpace(id); id != "" {
+			entry.GUID = id
+			break
+		}
+	}
+	return entry
+}
+
+// feedTimeLayouts RSS 的 pubDate 和 Atom 的 published 常见的时间格式
+var feedTimeLayouts = []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"}
+
+// parseFeedTime 解析第一个非空的时间，无法识别时返回 nil
+func parseFeedTime(values ...string) *time.Time {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		for _, layout := range feedTimeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return &t
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// torrentURL 优先使用 bittorrent 类型的 enclosure，其次是以 .torrent 结尾的链接
+func torrentURL(item feedXMLItem, candidates []string) string {
+	for _, enclosure := range item.Enclosures {
+		if enclosure.Type == "application/x-bittorrent" && isHTTPURL(enclosure.URL) {
+			return strings.TrimSpace(enclosure.URL)
+		}
+	}
+	for _, link := range item.Links {
+		if link.Type == "application/x-bittorrent" && isHTTPURL(link.Href) {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	for _, candidate := range candidates {
+		if isHTTPURL(candidate) {
+			if u, err := url.Parse(candidate); err == nil && strings.HasSuffix(strings.ToLower(u.Path), ".torrent") {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+func isHTTPURL(value string) bool {
+	value = strings.TrimSpace(value)
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// 全文搜索的实现方式，按数据库驱动选择，建立索引失败时退回子串匹配
+const (
+	fullTextLike     = ""         // LIKE/ILIKE 子串匹配，不使用索引
+	fullTextFTS5     = "fts5"     // SQLite FTS5 外部内容表，trigram 分词
+	fullTextNgram    = "ngram"    // MySQL FULLTEXT 索引，ngram 分词
+	fullTextTrigram  = "pg_trgm"  // PostgreSQL pg_trgm GIN 索引，加速 ILIKE
+	fullTextContains = "contains" // SQL Server 全文索引，按词前缀匹配
+)
+
+// sqlServerFullTextCatalog SQL Server 全文索引使用的目录
+const sqlServerFullTextCatalog = "magnet_webdav"
+
+// textColumn 参与搜索的文本列
+type textColumn struct {
+	table  string
+	column string
+}
+
+var (
+	magnetNameColumn = textColumn{"magnets", "name"}
+	entryNameColumn  = textColumn{"library_entries", "name"}
+	fileNameColumn   = textColumn{"files", "file_name"}
+
+	textColumns = []textColumn{magnetNameColumn, entryNameColumn, fileNameColumn}
+)
+
+func (c textColumn) String() string {
+	return c.table + "." + c.column
+}
+
+// fullTextIndex 当前使用的全文搜索方式。minTerm 为分词器能匹配的最短关键词（字符数），
+// 更短的关键词仍然使用子串匹配
+type fullTextIndex struct {
+	mode    string
+	minTerm int
+}
+
+// setupFullTextSearch 按数据库驱动建立全文索引，失败时记录日志并使用子串匹配
+func (s *TorrentService) setupFullTextSearch() {
+	var index fullTextIndex
+	var err error
+	switch s.db.Dialector.Name() {
+	case "sqlite":
+		index, err = setupSQLiteFTS(s.db)
+	case "mysql":
+		index, err = setupMySQLFullText(s.db)
+	case "postgres":
+		index, err = setupPostgresTrigram(s.db)
+	case "sqlserver":
+		index, err = setupSQLServerFullText(s.db)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Full-text search unavailable, falling back to substring matching: %v", err)
+		return
+	}
+	s.fullText = index
+	log.Printf("Full-text search enabled: %s", index.mode)
+}
+
+// setupSQLiteFTS 为每个文本列建立 FTS5 外部内容表，由触发器保持同步。
+// 不带 rowid 别名的表（magnets）在 VACUUM 和迁移重建表后 rowid 可能变化，所以每次启动都重建索引。
+// 需要使用 sqlite_fts5 构建标签编译 go-sqlite3。不支持 FTS5 时以前留下的触发器已在迁移前删除（database.InitDB），
+// 建立失败时删除已创建的触发器
+func setupSQLiteFTS(db *gorm.DB) (fullTextIndex, error) {
+	if !sqliteHasFTS5(db) {
+		return fullTextIndex{}, fmt.Errorf("SQLite is built without FTS5, rebuild with -tags sqlite_fts5")
+	}
+
+	for _, col := range textColumns {
+		fts := col.table + "_fts"
+		statements := []string{
+			fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %[1]s USING fts5(%[2]s, content='%[3]s', tokenize='trigram')", fts, col.column, col.table),
+			fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_ai AFTER INSERT ON %[3]s BEGIN "+
+				"INSERT INTO %[1]s(rowid, %[2]s) VALUES (new.rowid, new.%[2]s); END", fts, col.column, col.table),
+			fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_ad AFTER DELETE ON %[3]s BEGIN "+
+				"INSERT INTO %[1]s(%[1]s, rowid, %[2]s) VALUES ('delete', old.rowid, old.%[2]s); END", fts, col.column, col.table),
+			fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_au AFTER UPDATE OF %[2]s ON %[3]s BEGIN "+
+				"INSERT INTO %[1]s(%[1]s, rowid, %[2]s) VALUES ('delete', old.rowid, old.%[2]s); "+
+				"INSERT INTO %[1]s(rowid, %[2]s) VALUES (new.rowid, new.%[2]s); END", fts, col.column, col.table),
+			fmt.Sprintf("INSERT INTO %[1]s(%[1]s) VALUES ('rebuild')", fts),
+		}
+		for _, statement := range statements {
+			if err := db.Exec(statement).Error; err != nil {
+				dropSQLiteFTSTriggers(db)
+				return fullTextIndex{}, err
+			}
+		}
+	}
+	// trigram 分词至少需要 3 个字符
+	return fullTextIndex{mode: fullTextFTS5, minTerm: 3}, nil
+}
+
+// sqliteHasFTS5 判断 SQLite 是否编译了 FTS5
+func sqliteHasFTS5(db *gorm.DB) bool {
+	var enabled int
+	err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled).Error
+	return err == nil && enabled == 1
+}
+
+// dropSQLiteFTSTriggers 删除同步 FTS5 索引的触发器，退回子串匹配时不再维护索引
+func dropSQLiteFTSTriggers(db *gorm.DB) {
+	for _, col := range textColumns {
+		for _, suffix := range []string{"ai", "ad", "au"} {
+			if err := db.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s_fts_%s", col.table, suffix)).Error; err != nil {
+				log.Printf("Failed to drop full-text trigger on %s: %v", col.table, err)
+			}
+		}
+	}
+}
+
+// setupMySQLFullText 建立使用 ngram 分词的 FULLTEXT 索引，ngram 对中日韩文字同样有效
+func setupMySQLFullText(db *gorm.DB) (fullTextIndex, error) {
+	var tokenSize int
+	if err := db.Raw("SELECT @@ngram_token_size").Scan(&tokenSize).Error; err != nil {
+		return fullTextIndex{}, err
+	}
+	for _, col := range textColumns {
+		name := fmt.Sprintf("idx_%s_%s_ft", col.table, col.column)
+		if db.Migrator().HasIndex(col.table, name) {
+			continue
+		}
+		statement := fmt.Sprintf("ALTER TABLE %s ADD FULLTEXT INDEX %s (%s) WITH PARSER ngram", col.table, name, col.column)
+		if err := db.Exec(statement).Error; err != nil {
+			return fullTextIndex{}, err
+		}
+	}
+	return fullTextIndex{mode: fullTextNgram, minTerm: tokenSize}, nil
+}
+
+// setupPostgresTrigram 启用 pg_trgm 并建立 GIN 索引，ILIKE 子串匹配可以直接使用这些索引。
+// 没有创建扩展的权限时需要管理员先执行 CREATE EXTENSION pg_trgm
+func setupPostgresTrigram(db *gorm.DB) (fullTextIndex, error) {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return fullTextIndex{}, err
+	}
+	for _, col := range textColumns {
+		statement := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%[1]s_%[2]s_trgm ON %[1]s USING gin (%[2]s gin_trgm_ops)", col.table, col.column)
+		if err := db.Exec(statement).Error; err != nil {
+			return fullTextIndex{}, err
+		}
+	}
+	return fullTextIndex{mode: fullTextTrigram}, nil
+}
+
+// setupSQLServerFullText 建立全文目录和索引，需要安装全文搜索组件。
+// 全文索引以表的主键作为键索引，每个表只能有一个全文索引
+func setupSQLServerFullText(db *gorm.DB) (fullTextIndex, error) {
+	var installed int
+	if err := db.Raw("SELECT CAST(FULLTEXTSERVICEPROPERTY('IsFullTextInstalled') AS int)").Scan(&installed).Error; err != nil {
+		return fullTextIndex{}, err
+	}
+	if installed != 1 {
+		return fullTextIndex{}, fmt.Errorf("full-text search is not installed")
+	}
+
+	statement := fmt.Sprintf("IF NOT EXISTS (SELECT 1 FROM sys.fulltext_catalogs WHERE name = '%[1]s') CREATE FULLTEXT CATALOG %[1]s", sqlServerFullTextCatalog)
+	if err := db.Exec(statement).Error; err != nil {
+		return fullTextIndex{}, err
+	}
+	for _, col := range textColumns {
+		var count int64
+		if err := db.Raw("SELECT COUNT(*) FROM sys.fulltext_indexes WHERE object_id = OBJECT_ID(?)", col.table).Scan(&count).Error; err != nil {
+			return fullTextIndex{}, err
+		}
+		if count > 0 {
+			continue
+		}
+		var key string
+		if err := db.Raw("SELECT name FROM sys.indexes WHERE object_id = OBJECT_ID(?) AND is_primary_key = 1", col.table).Scan(&key).Error; err != nil {
+			return fullTextIndex{}, err
+		}
+		if key == "" {
+			return fullTextIndex{}, fmt.Errorf("table %s has no primary key", col.table)
+		}
+		statement := fmt.Sprintf("CREATE FULLTEXT INDEX ON %s (%s) KEY INDEX [%s] ON %s WITH CHANGE_TRACKING AUTO",
+			col.table, col.column, key, sqlServerFullTextCatalog)
+		if err := db.Exec(statement).Error; err != nil {
+			return fullTextIndex{}, err
+		}
+	}
+	return fullTextIndex{mode: fullTextContains}, nil
+}
+
+// textCondition 返回列匹配关键词的条件和参数。FTS5、MySQL 和 PostgreSQL 按子串匹配，
+// SQL Server 的 CONTAINS 按词前缀匹配；关键词短于分词器的最小长度时使用子串匹配
+func (s *TorrentService) textCondition(col textColumn, term string) (string, interface{}) {
+	mode := s.fullText.mode
+	if utf8.RuneCountInString(term) < s.fullText.minTerm {
+		mode = fullTextLike
+	}
+	// 关键词放在双引号中作为短语，去掉其中的引号
+	phrase := strings.TrimSpace(strings.ReplaceAll(term, `"`, " "))
+	if phrase == "" {
+		mode = fullTextLike
+	}
+
+	switch mode {
+	case fullTextFTS5:
+		return fmt.Sprintf("%[1]s.rowid IN (SELECT rowid FROM %[1]s_fts WHERE %[1]s_fts MATCH ?)", col.table), `"` + phrase + `"`
+	case fullTextNgram:
+		return fmt.Sprintf("MATCH (%s) AGAINST (? IN BOOLEAN MODE)", col), `"` + phrase + `"`
+	case fullTextContains:
+		return fmt.Sprintf("CONTAINS (%s, ?)", col), `"` + phrase + `*"`
+	}
+	// pg_trgm 的 GIN 索引直接用于 ILIKE
+	return fmt.Sprintf("%s %s ? ESCAPE '!'", col, s.likeOperator()), likePattern(term)
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"magnet-webdav/config"
+	"os"
+	"sync"
+	"time"
+)
+
+// 健康检查状态
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+// healthCheckTimeout 单项检查的超时时间
+const healthCheckTimeout = 3 * time.Second
+
+// HealthCheckFunc 单项就绪检查，返回 nil 表示通过
+type HealthCheckFunc func(ctx context.Context) error
+
+// CheckResult 单项检查结果
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// HealthReport 就绪检查报告，任意一项失败时整体为 fail
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// OK 是否全部检查通过
+func (r *HealthReport) OK() bool {
+	return r.Status == HealthStatusOK
+}
+
+type namedCheck struct {
+	name  string
+	check HealthCheckFunc
+}
+
+// HealthService 汇总数据库、种子客户端和存储的就绪检查
+type HealthService struct {
+	cfg    *config.Config
+	checks []namedCheck
+	mutex  sync.RWMutex
+}
+
+// NewHealthService 创建健康检查服务，内置种子客户端和存储相关检查
+func NewHealthService(cfg *config.Config, torrentService *TorrentService) *HealthService {
+	h := &HealthService{cfg: cfg}
+	h.AddCheck("torrent_client", func(context.Context) error {
+		return torrentService.ClientStatus()
+	})
+	h.AddCheck("storage_writable", func(context.Context) error {
+		return checkWritable(cfg.Torrent.DownloadDir)
+	})
+	h.AddCheck("disk_space", func(context.Context) error {
+		return checkFreeSpace(cfg.Torrent.DownloadDir, cfg.Torrent.MinFreeSpace)
+	})
+	return h
+}
+
+// AddCheck 注册一项就绪检查
+func (h *HealthService) AddCheck(name string, check HealthCheckFunc) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// Ready 并发执行所有检查，超时的检查视为失败
+func (h *HealthService) Ready(ctx context.Context) HealthReport {
+	h.mutex.RLock()
+	checks := append([]namedCheck(nil), h.checks...)
+	h.mutex.RUnlock()
+
+	report := HealthReport{Status: HealthStatusOK, Checks: make(map[string]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := runCheck(ctx, c.check)
+
+			result := CheckResult{Status: HealthStatusOK, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = HealthStatusFail
+				result.Error = err.Error()
+			}
+
+			mutex.Lock()
+			report.Checks[c.name] = result
+			if err != nil {
+				report.Status = HealthStatusFail
+			}
+			mutex.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// runCheck 在超时时间内执行检查，检查本身不支持取消时也能按时返回
+func runCheck(ctx context.Context, check HealthCheckFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check timed out after %s", healthCheckTimeout)
+	}
+}
+
+// ClientStatus 检查种子客户端是否在运行并监听端口
+func (s *TorrentService) ClientStatus() error {
+	s.mutex.RLock()
+	client := s.client
+	s.mutex.RUnlock()
+
+	if client == nil {
+		return errors.New("torrent client not started")
+	}
+	select {
+	case <-client.Closed():
+		return errors.New("torrent client closed")
+	default:
+	}
+	if len(client.ListenAddrs()) == 0 {
+		return errors.New("torrent client not listening")
+	}
+	return nil
+}
+
+// checkWritable 通过创建临时文件确认目录可写
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("storage not writable: %w", err)
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(name)
+	if err != nil {
+		return fmt.Errorf("storage not writable: %w", err)
+	}
+	return nil
+}
+
+// checkFreeSpace 检查目录所在磁盘的剩余空间，minFree 小于 0 时不检查
+func checkFreeSpace(dir string, minFree int64) error {
+	if minFree < 0 {
+		return nil
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get free disk space: %w", err)
+	}
+	if free < uint64(minFree) {
+		return fmt.Errorf("free disk space %d bytes below threshold %d bytes", free, minFree)
+	}
+	return nil
+}
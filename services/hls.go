@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"magnet-webdav/config"
+	"magnet-webdav/hls"
+	"magnet-webdav/media"
+	"magnet-webdav/models"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+	"gorm.io/gorm"
+)
+
+const (
+	// hlsCacheSize 缓存的切片索引数，MP4 的索引包含每个样本的位置，长视频可能有几 MB
+	hlsCacheSize = 8
+	// hlsIndexReadahead 建立索引时的预读大小，只需要读取文件头部和 Cues
+	hlsIndexReadahead = 256 << 10
+)
+
+// hlsStream 文件的切片索引和封装器，同一个文件的播放列表和切片共用
+type hlsStream struct {
+	index *media.StreamIndex
+	muxer *hls.Muxer
+}
+
+// HLSService 把种子中的 MP4/MKV 文件按关键帧切片，封装为 MPEG-TS，不需要下载完整文件
+type HLSService struct {
+	cfg            *config.MediaConfig
+	torrentService *TorrentService
+
+	streams map[string]*hlsStream
+	order   []string
+	mutex   sync.Mutex
+}
+
+func NewHLSService(cfg *config.Config, torrentService *TorrentService) *HLSService {
+	return &HLSService{
+		cfg:            &cfg.Media,
+		torrentService: torrentService,
+		streams:        make(map[string]*hlsStream),
+	}
+}
+
+// Playlist 返回文件的 m3u8 播放列表
+func (s *HLSService) Playlist(ctx context.Context, user *config.UserConfig, magnetID, filePath string) ([]byte, error) {
+	_, stream, err := s.stream(ctx, user, magnetID, filePath)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := hls.WritePlaylist(&buf, stream.index.Segments); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Segment 返回第 index 段的 MPEG-TS 数据。读取前把这一段的分片设为最高优先级，
+// 并提前下载下一段
+func (s *HLSService) Segment(ctx context.Context, user *config.UserConfig, magnetID, filePath string, index int) ([]byte, error) {
+	file, stream, err := s.stream(ctx, user, magnetID, filePath)
+	if err != nil {
+		return nil, err
+	}
+	segments := stream.index.Segments
+	if index < 0 || index >= len(segments) {
+		return nil, ErrNotFound
+	}
+
+	torrFile, reader, err := s.torrentService.GetFileStream(file.MagnetID, file.FilePath, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotReady, err)
+	}
+	defer reader.Close()
+
+	segment := segments[index]
+	s.torrentService.prioritizeRange(torrFile, segment.Offset, segment.End, types.PiecePriorityNow)
+	if index+1 < len(segments) {
+		next := segments[index+1]
+		s.torrentService.prioritizeRange(torrFile, next.Offset, next.End, types.PiecePriorityHigh)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.HLSTimeout)
+	defer cancel()
+	reader.SetContext(ctx)
+	reader.SetReadahead(segment.End - segment.Offset)
+
+	frames, err := stream.index.ReadSegment(reader, index)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: reading segment %d of %s timed out", ErrNotReady, index, file.FileName)
+		}
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := stream.muxer.WriteSegment(&buf, frames); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// stream 检查权限后返回文件的切片索引，没有缓存时读取文件头部建立索引
+func (s *HLSService) stream(ctx context.Context, user *config.UserConfig, magnetID, filePath string) (*models.File, *hlsStream, error) {
+	if !s.torrentService.CanAccess(user, magnetID) {
+		return nil, nil, ErrNotFound
+	}
+	var file models.File
+	err := s.torrentService.DB().Where("magnet_id = ? AND file_path = ?", magnetID, filePath).First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !media.Probeable(file.FilePath) {
+		return nil, nil, fmt.Errorf("%w: %s is not an MP4 or Matroska file", ErrInvalidInput, file.FileName)
+	}
+
+	key := subtitleCacheKey(&file)
+	if stream, ok := s.cached(key); ok {
+		return &file, stream, nil
+	}
+
+	torrFile, reader, err := s.torrentService.GetFileStream(file.MagnetID, file.FilePath, 0, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrNotReady, err)
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.HLSTimeout)
+	defer cancel()
+	reader.SetContext(ctx)
+	reader.SetReadahead(hlsIndexReadahead)
+
+	index, err := media.BuildStreamIndex(reader, torrFile.Length(), s.cfg.HLSSegmentDuration)
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return nil, nil, fmt.Errorf("%w: indexing %s timed out", ErrNotReady, file.FileName)
+		case errors.Is(err, media.ErrUnsupported), errors.Is(err, media.ErrUnsupportedCodec):
+			return nil, nil, fmt.Errorf("%w: %s: %v", ErrInvalidInput, file.FileName, err)
+		}
+		return nil, nil, err
+	}
+	muxer, err := hls.NewMuxer(index)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %v", ErrInvalidInput, file.FileName, err)
+	}
+
+	stream := &hlsStream{index: index, muxer: muxer}
+	s.store(key, stream)
+	return &file, stream, nil
+}
+
+func (s *HLSService) cached(key string) (*hlsStream, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stream, ok := s.streams[key]
+	return stream, ok
+}
+
+func (s *HLSService) store(key string, stream *hlsStream) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.streams[key]; ok {
+		return
+	}
+	s.streams[key] = stream
+	s.order = append(s.order, key)
+	for len(s.order) > hlsCacheSize {
+		delete(s.streams, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// prioritizeRange 提高文件中 [start, end) 所在分片的下载优先级，已完成或优先级更高的分片不变
+func (s *TorrentService) prioritizeRange(file *torrent.File, start, end int64, priority types.PiecePriority) {
+	torr := file.Torrent()
+	info := torr.Info()
+	if info == nil || end <= start {
+		return
+	}
+	pieceLength := info.PieceLength
+	first := int((file.Offset() + start) / pieceLength)
+	last := int((file.Offset() + end - 1) / pieceLength)
+	for i := first; i <= last && i < torr.NumPieces(); i++ {
+		piece := torr.Piece(i)
+		if state := piece.State(); !state.Complete && state.Priority < priority {
+			piece.SetPriority(priority)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"magnet-webdav/config"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 导入任务状态
+const (
+	ImportRunning  = "running"
+	ImportFinished = "finished"
+	ImportFailed   = "failed"
+)
+
+// importJobRetention 结束的导入任务保留多久以便查询结果
+const importJobRetention = time.Hour
+
+// ImportJob 后台导入任务
+type ImportJob struct {
+	ID         string         `json:"id"`
+	Owner      string         `json:"owner"`
+	Status     string         `json:"status"`
+	Progress   ImportProgress `json:"progress"`
+	Error      string         `json:"error,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+// ImportService 在后台执行导入并记录进度
+type ImportService struct {
+	torrentService *TorrentService
+	jobs           map[string]*ImportJob
+	nextID         int
+	mutex          sync.Mutex
+}
+
+func NewImportService(torrentService *TorrentService) *ImportService {
+	return &ImportService{
+		torrentService: torrentService,
+		jobs:           make(map[string]*ImportJob),
+	}
+}
+
+// Start 启动导入任务，返回任务的快照
+func (s *ImportService) Start(user *config.UserConfig, lib *LibraryExport) ImportJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cleanup()
+
+	s.nextID++
+	job := &ImportJob{
+		ID:        strconv.Itoa(s.nextID),
+		Owner:     s.torrentService.ownerName(user),
+		Status:    ImportRunning,
+		Progress:  ImportProgress{Total: len(lib.Magnets), Errors: []string{}},
+		StartedAt: time.Now(),
+	}
+	s.jobs[job.ID] = job
+
+	go func() {
+		result, err := s.torrentService.ImportLibrary(user, lib, func(progress ImportProgress) {
+			s.mutex.Lock()
+			job.Progress = progress
+			s.mutex.Unlock()
+		})
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		now := time.Now()
+		job.Progress = result
+		job.FinishedAt = &now
+		job.Status = ImportFinished
+		if err != nil {
+			job.Status = ImportFailed
+			job.Error = err.Error()
+		}
+	}()
+	return s.snapshot(job)
+}
+
+// Get 返回用户自己的导入任务
+func (s *ImportService) Get(user *config.UserConfig, id string) (ImportJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Owner != s.torrentService.ownerName(user) {
+		return ImportJob{}, ErrNotFound
+	}
+	return s.snapshot(job), nil
+}
+
+// snapshot 复制任务，调用方需要持有锁
+func (s *ImportService) snapshot(job *ImportJob) ImportJob {
+	copied := *job
+	copied.Progress.Errors = append([]string{}, job.Progress.Errors...)
+	return copied
+}
+
+// cleanup 删除结束较久的任务，调用方需要持有锁
+func (s *ImportService) cleanup() {
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > importJobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
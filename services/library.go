@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/metrics"
+	"magnet-webdav/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound 记录不存在或当前用户无权访问
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidInput 请求参数不合法
+var ErrInvalidInput = errors.New("invalid input")
+
+// ErrNotReady 种子不在客户端中，或需要的数据在超时前没有下载完成
+var ErrNotReady = errors.New("not ready")
+
+// removeReadersTimeout 删除种子时等待读取器关闭的最长时间
+const removeReadersTimeout = 10 * time.Second
+
+// 共享目标类型
+const (
+	ShareTargetUser  = "user"
+	ShareTargetGroup = "group"
+)
+
+// libraryMagnetColumns 查询 LibraryMagnet 时使用的列
+const libraryMagnetColumns = "magnets.*, library_entries.id AS entry_id, library_entries.owner AS owner, library_entries.category_id AS category_id, " +
+	"COALESCE(NULLIF(library_entries.name, ''), magnets.name) AS display_name"
+
+// ownerName 返回新条目的所有者，未启用认证时使用默认用户
+func (s *TorrentService) ownerName(user *config.UserConfig) string {
+	if user == nil {
+		return s.cfg.Auth.Username
+	}
+	return user.Username
+}
+
+// VisibleEntries 返回当前用户可见的媒体库条目查询，user 为 nil 表示不做过滤
+func (s *TorrentService) VisibleEntries(user *config.UserConfig) *gorm.DB {
+	query := s.db.Model(&models.LibraryEntry{})
+	if user == nil {
+		return query
+	}
+
+	shared := s.db.Model(&models.MagnetShare{}).Select("entry_id").
+		Where("target_type = ? AND target = ?", ShareTargetUser, user.Username)
+	if len(user.Groups) > 0 {
+		shared = shared.Or("target_type = ? AND target IN ?", ShareTargetGroup, user.Groups)
+	}
+
+	return query.Where("library_entries.owner = ? OR library_entries.id IN (?)", user.Username, shared)
+}
+
+// VisibleMagnets 返回当前用户可见的 LibraryMagnet 查询，每个磁力链接只出现一次
+func (s *TorrentService) VisibleMagnets(user *config.UserConfig) *gorm.DB {
+	return s.uniqueVisibleEntries(user).
+		Select(libraryMagnetColumns).
+		Joins("JOIN magnets ON magnets.id = library_entries.magnet_id")
+}
+
+// uniqueVisibleEntries 返回当前用户可见的媒体库条目查询，同一个磁力链接有多个可见条目时
+// （例如自己添加的同时又被其他用户共享）只保留一个：优先用户自己的条目，否则取最早的条目
+func (s *TorrentService) uniqueVisibleEntries(user *config.UserConfig) *gorm.DB {
+	query := s.db.Model(&models.LibraryEntry{})
+	if user == nil {
+		first := s.db.Model(&models.LibraryEntry{}).Select("MIN(id)").Group("magnet_id")
+		return query.Where("library_entries.id IN (?)", first)
+	}
+
+	own := s.db.Model(&models.LibraryEntry{}).Select("magnet_id").Where("owner = ?", user.Username)
+	firstShared := s.VisibleEntries(user).
+		Select("MIN(library_entries.id)").
+		Where("library_entries.magnet_id NOT IN (?)", own).
+		Group("library_entries.magnet_id")
+	return query.Where("library_entries.owner = ? OR library_entries.id IN (?)", user.Username, firstShared)
+}
+
+// OwnMagnets 返回用户自己的 LibraryMagnet 查询，不包含其他用户共享的条目
+func (s *TorrentService) OwnMagnets(user *config.UserConfig) *gorm.DB {
+	return s.db.Model(&models.LibraryEntry{}).
+		Select(libraryMagnetColumns).
+		Joins("JOIN magnets ON magnets.id = library_entries.magnet_id").
+		Where("library_entries.owner = ?", s.ownerName(user))
+}
+
+// VisibleMagnetIDs 返回当前用户可见的磁力链接 ID 子查询
+func (s *TorrentService) VisibleMagnetIDs(user *config.UserConfig) *gorm.DB {
+	return s.VisibleEntries(user).Distinct("library_entries.magnet_id")
+}
+
+// CanAccess 判断用户是否可以访问指定磁力链接
+func (s *TorrentService) CanAccess(user *config.UserConfig, magnetID string) bool {
+	var count int64
+	s.VisibleEntries(user).Where("library_entries.magnet_id = ?", magnetID).Count(&count)
+	return count > 0
+}
+
+// GetLibraryMagnet 获取用户视角下的磁力链接，优先返回用户自己的条目
+func (s *TorrentService) GetLibraryMagnet(user *config.UserConfig, magnetID string) (*models.LibraryMagnet, error) {
+	var magnets []models.LibraryMagnet
+	err := s.VisibleMagnets(user).
+		Where("library_entries.magnet_id = ?", magnetID).
+		Order("library_entries.id").
+		Scan(&magnets).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(magnets) == 0 {
+		return nil, ErrNotFound
+	}
+
+	owner := s.ownerName(user)
+	for i := range magnets {
+		if magnets[i].Owner == owner {
+			return &magnets[i], nil
+		}
+	}
+	return &magnets[0], nil
+}
+
+// GetOwnEntry 获取用户自己的媒体库条目
+func (s *TorrentService) GetOwnEntry(user *config.UserConfig, magnetID string) (*models.LibraryEntry, error) {
+	var entry models.LibraryEntry
+	err := s.db.Where("magnet_id = ? AND owner = ?", magnetID, s.ownerName(user)).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ensureLibraryEntry 为用户创建媒体库条目，已存在时直接返回
+func (s *TorrentService) ensureLibraryEntry(infoHash, owner, name string) (*models.LibraryEntry, error) {
+	entry := models.LibraryEntry{MagnetID: infoHash, Owner: owner}
+	err := s.db.Where("magnet_id = ? AND owner = ?", infoHash, owner).
+		Attrs(models.LibraryEntry{Name: name}).
+		FirstOrCreate(&entry).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to create library entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// RemoveResult 删除磁力链接的结果
+type RemoveResult struct {
+	Orphaned    bool `json:"orphaned"`     // 已没有任何条目，种子已从客户端移除
+	DataDeleted bool `json:"data_deleted"` // 已删除下载的数据
+}
+
+// RemoveMagnet 删除用户自己的条目。没有其他条目时同时删除种子记录、从客户端移除种子，
+// 并在 deleteData 为 true 时删除已下载的数据；否则只删除条目
+func (s *TorrentService) RemoveMagnet(user *config.UserConfig, magnetID string, deleteData bool) (*RemoveResult, error) {
+	entry, err := s.GetOwnEntry(user, magnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 从客户端和磁盘上移除种子之前，先根据种子的元数据取出下载的文件列表
+	var paths []string
+	if deleteData {
+		if paths, err = s.torrentDataPaths(magnetID); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &RemoveResult{}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result.Orphaned, err = removeEntry(tx, entry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Orphaned {
+		s.dropTorrent(magnetID)
+		s.removeMetainfo(magnetID)
+		s.removeSubtitleCache(magnetID)
+		metrics.StreamedBytes.Delete(magnetID)
+
+		// 种子移除后读取会失败，等待正在进行的流式传输关闭读取器
+		ctx, cancel := context.WithTimeout(s.ctx, removeReadersTimeout)
+		if err := s.WaitForReaders(ctx, magnetID); err != nil {
+			log.Printf("Readers of %s still open after %v", magnetID, removeReadersTimeout)
+		}
+		cancel()
+
+		if deleteData {
+			if err := s.removeTorrentData(magnetID, paths); err != nil {
+				log.Printf("Failed to delete data of %s: %v", magnetID, err)
+			} else {
+				result.DataDeleted = true
+			}
+		}
+	}
+
+	s.events.Publish(Event{
+		Type:     EventMagnetRemoved,
+		MagnetID: magnetID,
+		Owner:    entry.Owner,
+		Data: map[string]interface{}{
+			"entry_id":     entry.ID,
+			"orphaned":     result.Orphaned,
+			"data_deleted": result.DataDeleted,
+		},
+	})
+	return result, nil
+}
+
+// removeEntry 在事务中删除条目及其共享和标签，没有其他条目时同时删除种子和文件记录，
+// 返回该种子是否已没有任何条目
+func removeEntry(tx *gorm.DB, entry *models.LibraryEntry) (bool, error) {
+	if err := tx.Where("entry_id = ?", entry.ID).Delete(&models.MagnetShare{}).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Where("entry_id = ?", entry.ID).Delete(&models.EntryTag{}).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Delete(entry).Error; err != nil {
+		return false, err
+	}
+
+	var remaining int64
+	if err := tx.Model(&models.LibraryEntry{}).Where("magnet_id = ?", entry.MagnetID).Count(&remaining).Error; err != nil {
+		return false, err
+	}
+	if remaining > 0 {
+		return false, nil
+	}
+
+	if err := tx.Where("magnet_id = ?", entry.MagnetID).Delete(&models.File{}).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Where("magnet_id = ?", entry.MagnetID).Delete(&models.MediaInfo{}).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Where("magnet_id = ?", entry.MagnetID).Delete(&models.PlaybackProgress{}).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Where("id = ?", entry.MagnetID).Delete(&models.Magnet{}).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ListShares 列出用户自己条目的共享记录
+func (s *TorrentService) ListShares(user *config.UserConfig, magnetID string) ([]models.MagnetShare, error) {
+	entry, err := s.GetOwnEntry(user, magnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var shares []models.MagnetShare
+	if err := s.db.Where("entry_id = ?", entry.ID).Order("id").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// ShareMagnet 将用户自己的条目共享给其他用户或分组
+func (s *TorrentService) ShareMagnet(user *config.UserConfig, magnetID, targetType, target string) (*models.MagnetShare, error) {
+	switch targetType {
+	case ShareTargetUser:
+		if s.cfg.Auth.FindUser(target) == nil {
+			return nil, fmt.Errorf("unknown user: %s", target)
+		}
+	case ShareTargetGroup:
+		if target == "" {
+			return nil, fmt.Errorf("group name is required")
+		}
+	default:
+		return nil, fmt.Errorf("invalid share target type: %s", targetType)
+	}
+
+	entry, err := s.GetOwnEntry(user, magnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	share := models.MagnetShare{EntryID: entry.ID, TargetType: targetType, Target: target}
+	err = s.db.Where("entry_id = ? AND target_type = ? AND target = ?", entry.ID, targetType, target).
+		FirstOrCreate(&share).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share: %w", err)
+	}
+	return &share, nil
+}
+
+// UnshareMagnet 删除用户自己条目的共享记录
+func (s *TorrentService) UnshareMagnet(user *config.UserConfig, magnetID string, shareID uint) error {
+	entry, err := s.GetOwnEntry(user, magnetID)
+	if err != nil {
+		return err
+	}
+
+	result := s.db.Where("id = ? AND entry_id = ?", shareID, entry.ID).Delete(&models.MagnetShare{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// backfillLibraryEntries 为升级前没有条目的磁力链接补建默认用户的条目
+func (s *TorrentService) backfillLibraryEntries() error {
+	var orphans []models.Magnet
+	err := s.db.Where("id NOT IN (?)", s.db.Model(&models.LibraryEntry{}).Select("magnet_id")).
+		Find(&orphans).Error
+	if err != nil {
+		return err
+	}
+
+	for _, magnet := range orphans {
+		if _, err := s.ensureLibraryEntry(magnet.ID, s.cfg.Auth.Username, ""); err != nil {
+			return err
+		}
+	}
+
+	if len(orphans) > 0 {
+		log.Printf("Assigned %d magnets to default user %s", len(orphans), s.cfg.Auth.Username)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"fmt"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// EventMagnetUpdated 媒体库条目的名称、标签、分类或做种策略被修改
+const EventMagnetUpdated = "magnet.updated"
+
+// maxLabelLength 标签和分类名称的最大长度
+const maxLabelLength = 64
+
+// TorrentState 种子在客户端中的实时状态
+type TorrentState struct {
+	HasInfo         bool    `json:"has_info"`
+	Peers           int     `json:"peers"`
+	ActivePeers     int     `json:"active_peers"`
+	Seeders         int     `json:"seeders"`
+	PiecesComplete  int     `json:"pieces_complete"`
+	PiecesTotal     int     `json:"pieces_total"`
+	PieceLength     int64   `json:"piece_length"`
+	BytesCompleted  int64   `json:"bytes_completed"`
+	Progress        float64 `json:"progress"`
+	BytesDownloaded int64   `json:"bytes_downloaded"`
+	BytesUploaded   int64   `json:"bytes_uploaded"`
+	DownloadRate    int64   `json:"download_rate"` // 字节/秒，根据前后两次查询之间的差值计算
+	UploadRate      int64   `json:"upload_rate"`
+	Ratio           float64 `json:"ratio"`
+	OpenReaders     int     `json:"open_readers"`
+}
+
+// MagnetDetail 单个磁力链接的详情：数据库记录、标签、文件和实时状态。
+// Torrent 为 nil 表示种子当前不在客户端中
+type MagnetDetail struct {
+	*models.LibraryMagnet
+	Tags     []string      `json:"tags"`
+	Category string        `json:"category,omitempty"` // 分类路径，例如 电影/动作
+	Files    []models.File `json:"files"`
+	Torrent  *TorrentState `json:"torrent"`
+}
+
+// MagnetUpdate 可修改的字段，nil 表示不修改
+type MagnetUpdate struct {
+	DisplayName *string   `json:"display_name"`
+	Tags        *[]string `json:"tags"`
+	CategoryID  *uint     `json:"category_id"` // 0 表示移出分类
+	SeedMode    *string   `json:"seed_mode"`
+	SeedRatio   *float64  `json:"seed_ratio"`
+}
+
+// GetMagnetDetail 获取用户可见的磁力链接详情
+func (s *TorrentService) GetMagnetDetail(user *config.UserConfig, magnetID string) (*MagnetDetail, error) {
+	magnet, err := s.GetLibraryMagnet(user, magnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &MagnetDetail{LibraryMagnet: magnet, Files: []models.File{}}
+	if detail.Tags, err = s.entryTags(magnet.EntryID); err != nil {
+		return nil, err
+	}
+	if magnet.CategoryID != nil {
+		if detail.Category, err = s.CategoryPath(*magnet.CategoryID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.db.Where("magnet_id = ?", magnetID).Order("file_index").Find(&detail.Files).Error; err != nil {
+		return nil, err
+	}
+	detail.Torrent = s.TorrentState(magnetID)
+	return detail, nil
+}
+
+// TorrentState 读取种子的实时状态，种子不在客户端中时返回 nil
+func (s *TorrentService) TorrentState(infoHash string) *TorrentState {
+	torr := s.GetTorrent(infoHash)
+	if torr == nil {
+		return nil
+	}
+
+	stats := torr.Stats()
+	state := &TorrentState{
+		HasInfo:         torr.Info() != nil,
+		Peers:           stats.TotalPeers,
+		ActivePeers:     stats.ActivePeers,
+		Seeders:         stats.ConnectedSeeders,
+		PiecesComplete:  stats.PiecesComplete,
+		BytesDownloaded: stats.BytesReadUsefulData.Int64(),
+		BytesUploaded:   stats.BytesWrittenData.Int64(),
+		OpenReaders:     s.readers.count(infoHash),
+	}
+	state.DownloadRate, state.UploadRate = s.rates.update(infoHash, state.BytesDownloaded, state.BytesUploaded)
+	if state.HasInfo {
+		state.PiecesTotal = torr.NumPieces()
+		state.PieceLength = torr.Info().PieceLength
+		state.BytesCompleted = torr.BytesCompleted()
+		if length := torr.Length(); length > 0 {
+			state.Progress = float64(state.BytesCompleted) / float64(length)
+		}
+		state.Ratio = shareRatio(torr)
+	}
+	return state
+}
+
+// UpdateMagnet 修改用户自己条目的名称、标签和分类，以及种子的做种策略
+func (s *TorrentService) UpdateMagnet(user *config.UserConfig, magnetID string, upd MagnetUpdate) (*MagnetDetail, error) {
+	var tags []string
+	if upd.Tags != nil {
+		var err error
+		if tags, err = normalizeTags(*upd.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if upd.SeedMode != nil && !ValidSeedMode(*upd.SeedMode) {
+		return nil, fmt.Errorf("%w: unsupported seed_mode %s", ErrInvalidInput, *upd.SeedMode)
+	}
+	if upd.SeedRatio != nil && *upd.SeedRatio < 0 {
+		return nil, fmt.Errorf("%w: seed_ratio must not be negative", ErrInvalidInput)
+	}
+	if upd.CategoryID != nil && *upd.CategoryID != 0 {
+		if _, err := s.GetCategory(*upd.CategoryID); err != nil {
+			return nil, fmt.Errorf("%w: unknown category %d", ErrInvalidInput, *upd.CategoryID)
+		}
+	}
+
+	entry, err := s.GetOwnEntry(user, magnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if upd.DisplayName != nil {
+			if err := tx.Model(entry).Update("name", strings.TrimSpace(*upd.DisplayName)).Error; err != nil {
+				return err
+			}
+		}
+		if upd.Tags != nil {
+			if err := setEntryTags(tx, entry.ID, tags); err != nil {
+				return err
+			}
+		}
+		if upd.CategoryID != nil {
+			var categoryID *uint
+			if *upd.CategoryID != 0 {
+				categoryID = upd.CategoryID
+			}
+			if err := tx.Model(entry).Update("category_id", categoryID).Error; err != nil {
+				return err
+			}
+		}
+
+		seeding := map[string]interface{}{}
+		if upd.SeedMode != nil {
+			seeding["seed_mode"] = *upd.SeedMode
+		}
+		if upd.SeedRatio != nil {
+			seeding["seed_ratio"] = *upd.SeedRatio
+		}
+		if len(seeding) > 0 {
+			return tx.Model(&models.Magnet{}).Where("id = ?", magnetID).Updates(seeding).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if upd.SeedMode != nil || upd.SeedRatio != nil {
+		s.refreshSeedingPolicy(magnetID)
+	}
+
+	detail, err := s.GetMagnetDetail(user, magnetID)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish(Event{
+		Type:     EventMagnetUpdated,
+		MagnetID: magnetID,
+		Owner:    entry.Owner,
+		Data: map[string]interface{}{
+			"name":       detail.DisplayName,
+			"tags":       detail.Tags,
+			"category":   detail.Category,
+			"seed_mode":  detail.SeedMode,
+			"seed_ratio": detail.SeedRatio,
+		},
+	})
+	return detail, nil
+}
+
+// entryTags 返回条目的标签名称，按名称排序
+func (s *TorrentService) entryTags(entryID uint) ([]string, error) {
+	tags := []string{}
+	err := s.db.Model(&models.Tag{}).
+		Joins("JOIN entry_tags ON entry_tags.tag_id = tags.id").
+		Where("entry_tags.entry_id = ?", entryID).
+		Order("tags.name").
+		Pluck("tags.name", &tags).Error
+	return tags, err
+}
+
+// setEntryTags 用给定的标签替换条目现有的标签，不存在的标签会自动创建
+func setEntryTags(tx *gorm.DB, entryID uint, names []string) error {
+	if err := tx.Where("entry_id = ?", entryID).Delete(&models.EntryTag{}).Error; err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		tag := models.Tag{Name: name}
+		if err := tx.Where("name = ?", name).FirstOrCreate(&tag).Error; err != nil {
+			return fmt.Errorf("failed to create tag %s: %w", name, err)
+		}
+		if err := tx.Create(&models.EntryTag{EntryID: entryID, TagID: tag.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeTags 去掉首尾空白、空的和重复的标签
+func normalizeTags(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	tags := []string{}
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		name, err := validateLabel("tag", name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[name] {
+			seen[name] = true
+			tags = append(tags, name)
+		}
+	}
+	return tags, nil
+}
+
+// validateLabel 检查标签或分类名称。名称会作为 WebDAV 目录名使用，所以不允许包含 /
+func validateLabel(kind, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "", fmt.Errorf("%w: %s name is required", ErrInvalidInput, kind)
+	case utf8.RuneCountInString(name) > maxLabelLength:
+		return "", fmt.Errorf("%w: %s %q is longer than %d characters", ErrInvalidInput, kind, name, maxLabelLength)
+	case strings.Contains(name, "/"), name == ".", name == "..":
+		return "", fmt.Errorf("%w: invalid %s name %q", ErrInvalidInput, kind, name)
+	}
+	return name, nil
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/media"
+	"magnet-webdav/models"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// mediaProbeReadahead 解析时的预读大小，元数据通常只有几十 KB，不需要下载更多数据
+const mediaProbeReadahead = 256 << 10
+
+// MediaService 解析种子中 MP4/MKV 文件的时长、编码和轨道，结果保存在 media_infos 表中
+type MediaService struct {
+	cfg            *config.MediaConfig
+	torrentService *TorrentService
+	db             *gorm.DB
+
+	// 等待自动解析的种子
+	pending   map[string]struct{}
+	pendingMu sync.Mutex
+	wake      chan struct{}
+
+	// 正在提取内嵌字幕的文件
+	extractions map[string]*extraction
+	extractMu   sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewMediaService(cfg *config.Config, torrentService *TorrentService) *MediaService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MediaService{
+		cfg:            &cfg.Media,
+		torrentService: torrentService,
+		db:             torrentService.DB(),
+		pending:        make(map[string]struct{}),
+		wake:           make(chan struct{}, 1),
+		extractions:    make(map[string]*extraction),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Start 启用 media.auto_probe 时，在种子文件列表同步后自动解析还没有记录的文件
+func (s *MediaService) Start() {
+	if !s.cfg.AutoProbe {
+		return
+	}
+
+	sub := s.torrentService.Events().Subscribe()
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		defer sub.Close()
+		for {
+			select {
+			case event := <-sub.C:
+				if event.Type == EventFilesSynced {
+					s.schedule(event.MagnetID)
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case <-s.wake:
+				s.probePending()
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止自动解析，进行中的解析会被取消
+func (s *MediaService) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *MediaService) schedule(magnetID string) {
+	s.pendingMu.Lock()
+	s.pending[magnetID] = struct{}{}
+	s.pendingMu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// probePending 逐个解析等待中的种子里还没有记录的文件
+func (s *MediaService) probePending() {
+	for {
+		s.pendingMu.Lock()
+		var magnetID string
+		for id := range s.pending {
+			magnetID = id
+			break
+		}
+		delete(s.pending, magnetID)
+		s.pendingMu.Unlock()
+		if magnetID == "" {
+			return
+		}
+
+		var files []models.File
+		err := s.db.Where("magnet_id = ? AND file_index NOT IN (?)", magnetID,
+			s.db.Model(&models.MediaInfo{}).Select("file_index").Where("magnet_id = ?", magnetID)).
+			Order("file_index").Find(&files).Error
+		if err != nil {
+			log.Printf("Failed to list files of %s for media probing: %v", magnetID, err)
+			continue
+		}
+		for i := range files {
+			if s.ctx.Err() != nil {
+				return
+			}
+			if !media.Probeable(files[i].FilePath) {
+				continue
+			}
+			if _, err := s.probe(&files[i]); err != nil {
+				log.Printf("Failed to probe %s in %s: %v", files[i].FilePath, magnetID, err)
+			}
+		}
+	}
+}
+
+// GetMediaInfo 返回文件的媒体信息，没有记录或 refresh 为 true 时立即解析。
+// 文件不是 MP4/MKV 时返回 ErrInvalidInput
+func (s *MediaService) GetMediaInfo(user *config.UserConfig, magnetID string, fileIndex int, refresh bool) (*models.MediaInfo, error) {
+	if !s.torrentService.CanAccess(user, magnetID) {
+		return nil, ErrNotFound
+	}
+	var file models.File
+	err := s.db.Where("magnet_id = ? AND file_index = ?", magnetID, fileIndex).First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !media.Probeable(file.FilePath) {
+		return nil, fmt.Errorf("%w: %s is not an MP4 or Matroska file", ErrInvalidInput, file.FileName)
+	}
+
+	if !refresh {
+		var info models.MediaInfo
+		err := s.db.Where("magnet_id = ? AND file_index = ?", magnetID, fileIndex).First(&info).Error
+		if err == nil {
+			return &info, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	return s.probe(&file)
+}
+
+// ListMediaInfo 列出种子中已经解析过的文件
+func (s *MediaService) ListMediaInfo(user *config.UserConfig, magnetID string) ([]models.MediaInfo, error) {
+	if !s.torrentService.CanAccess(user, magnetID) {
+		return nil, ErrNotFound
+	}
+	infos := []models.MediaInfo{}
+	err := s.db.Where("magnet_id = ?", magnetID).Order("file_index").Find(&infos).Error
+	return infos, err
+}
+
+// MediaInfos 返回种子中解析成功的文件，键为文件序号，调用方负责权限检查
+func (s *MediaService) MediaInfos(magnetID string) (map[int]*models.MediaInfo, error) {
+	var infos []models.MediaInfo
+	if err := s.db.Where("magnet_id = ? AND error = ?", magnetID, "").Find(&infos).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[int]*models.MediaInfo, len(infos))
+	for i := range infos {
+		result[infos[i].FileIndex] = &infos[i]
+	}
+	return result, nil
+}
+
+// probe 读取文件的元数据并保存。文件格式无法解析时保存失败原因，
+// 超时或种子不可用时不保存，下次请求时重新解析
+func (s *MediaService) probe(file *models.File) (*models.MediaInfo, error) {
+	torrFile, reader, err := s.torrentService.GetFileStream(file.MagnetID, file.FilePath, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotReady, err)
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.ProbeTimeout)
+	defer cancel()
+	reader.SetContext(ctx)
+	reader.SetReadahead(mediaProbeReadahead)
+
+	info, err := media.Probe(reader, torrFile.Length())
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: probing %s timed out", ErrNotReady, file.FileName)
+	}
+
+	record := models.MediaInfo{MagnetID: file.MagnetID, FileIndex: file.FileIndex}
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.Container = info.Container
+		record.Duration = info.Duration
+		if video := info.Video(); video != nil {
+			record.VideoCodec = video.Codec
+			record.Width = video.Width
+			record.Height = video.Height
+		}
+		record.Tracks = make([]models.MediaTrack, 0, len(info.Tracks))
+		for _, track := range info.Tracks {
+			record.Tracks = append(record.Tracks, models.MediaTrack(track))
+		}
+	}
+
+	var existing models.MediaInfo
+	err = s.db.Where("magnet_id = ? AND file_index = ?", file.MagnetID, file.FileIndex).First(&existing).Error
+	if err == nil {
+		record.ID = existing.ID
+		record.CreatedAt = existing.CreatedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err := s.db.Save(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
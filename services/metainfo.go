@@ -0,0 +1,126 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// metainfoDirName 下载目录中保存 .torrent 文件的子目录。
+// 有了种子文件，重启或导入后不需要重新从 peer 获取元数据
+const metainfoDirName = ".metainfo"
+
+func (s *TorrentService) metainfoPath(infoHash string) string {
+	return filepath.Join(s.cfg.Torrent.DownloadDir, metainfoDirName, infoHash+".torrent")
+}
+
+// TorrentFile 解析后的 .torrent 文件
+type TorrentFile struct {
+	MagnetURI string
+	InfoHash  string
+	Name      string
+	Size      int64
+	Data      []byte
+}
+
+// NormalizeMagnetURI 校验磁力链接，返回使用十六进制 infoHash 的磁力链接和 infoHash
+func NormalizeMagnetURI(magnetURI string) (string, string, error) {
+	magnet, err := metainfo.ParseMagnetUri(strings.TrimSpace(magnetURI))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: invalid magnet link: %v", ErrInvalidInput, err)
+	}
+	return magnet.String(), magnet.InfoHash.HexString(), nil
+}
+
+// ParseTorrentFile 解析 .torrent 文件，得到对应的磁力链接、infoHash 和总大小
+func ParseTorrentFile(data []byte) (*TorrentFile, error) {
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid torrent file: %v", ErrInvalidInput, err)
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid torrent file: %v", ErrInvalidInput, err)
+	}
+	hash := mi.HashInfoBytes()
+	return &TorrentFile{
+		MagnetURI: mi.Magnet(&hash, &info).String(),
+		InfoHash:  hash.HexString(),
+		Name:      info.BestName(),
+		Size:      info.TotalLength(),
+		Data:      data,
+	}, nil
+}
+
+// AddTorrentFile 保存 .torrent 文件并把种子加入用户的媒体库，不需要再从 peer 获取元数据
+func (s *TorrentService) AddTorrentFile(user *config.UserConfig, file *TorrentFile, name string) (*models.LibraryMagnet, error) {
+	if err := s.storeMetainfo(file.InfoHash, file.Data); err != nil {
+		return nil, err
+	}
+	return s.AddMagnet(user, file.MagnetURI, name)
+}
+
+// addToClient 把种子加入客户端，有保存的种子文件时直接使用，否则通过磁力链接获取元数据
+func (s *TorrentService) addToClient(magnetURI, infoHash string) (*torrent.Torrent, error) {
+	if mi, err := metainfo.LoadFromFile(s.metainfoPath(infoHash)); err == nil {
+		return s.client.AddTorrent(mi)
+	}
+	return s.client.AddMagnet(magnetURI)
+}
+
+// saveMetainfo 保存已获取元数据的种子的 .torrent 文件
+func (s *TorrentService) saveMetainfo(infoHash string, torr *torrent.Torrent) error {
+	mi := torr.Metainfo()
+	var buf bytes.Buffer
+	if err := mi.Write(&buf); err != nil {
+		return err
+	}
+	return s.storeMetainfo(infoHash, buf.Bytes())
+}
+
+// storeMetainfo 校验并保存 .torrent 文件内容，infoHash 必须和内容一致
+func (s *TorrentService) storeMetainfo(infoHash string, data []byte) error {
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid torrent file: %w", err)
+	}
+	if hash := mi.HashInfoBytes().HexString(); hash != infoHash {
+		return fmt.Errorf("torrent file info hash %s does not match %s", hash, infoHash)
+	}
+
+	path := s.metainfoPath(infoHash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readMetainfo 返回保存的 .torrent 文件内容，不存在时返回 nil
+func (s *TorrentService) readMetainfo(infoHash string) ([]byte, error) {
+	data, err := os.ReadFile(s.metainfoPath(infoHash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// removeMetainfo 删除保存的 .torrent 文件
+func (s *TorrentService) removeMetainfo(infoHash string) {
+	if err := os.Remove(s.metainfoPath(infoHash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove torrent file of %s: %v", infoHash, err)
+	}
+}
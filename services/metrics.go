@@ -0,0 +1,174 @@
+package services
+
+import (
+	"io/fs"
+	"magnet-webdav/metrics"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// storageUsageTTL 遍历下载目录开销较大，结果缓存一段时间
+const storageUsageTTL = time.Minute
+
+// transferSample 上一次抓取时的传输量，用于计算速率
+type transferSample struct {
+	at         time.Time
+	downloaded int64
+	uploaded   int64
+}
+
+// torrentMetrics 种子相关指标的采集状态
+type torrentMetrics struct {
+	samples      map[string]transferSample
+	storageBytes int64
+	storageAt    time.Time
+	mutex        sync.Mutex
+}
+
+func newTorrentMetrics() *torrentMetrics {
+	return &torrentMetrics{samples: make(map[string]transferSample)}
+}
+
+// torrentSnapshot 采集时单个种子的状态
+type torrentSnapshot struct {
+	infoHash       string
+	hasInfo        bool
+	totalPeers     int
+	activePeers    int
+	seeders        int
+	piecesComplete int
+	piecesTotal    int
+	downloaded     int64
+	uploaded       int64
+}
+
+// CollectMetrics 输出种子、读取器和存储相关的指标
+func (s *TorrentService) CollectMetrics(w *metrics.Writer) {
+	s.mutex.RLock()
+	snapshots := make([]torrentSnapshot, 0, len(s.activeTorrents))
+	for infoHash, torr := range s.activeTorrents {
+		stats := torr.Stats()
+		snapshot := torrentSnapshot{
+			infoHash:       infoHash,
+			hasInfo:        torr.Info() != nil,
+			totalPeers:     stats.TotalPeers,
+			activePeers:    stats.ActivePeers,
+			seeders:        stats.ConnectedSeeders,
+			piecesComplete: stats.PiecesComplete,
+			downloaded:     stats.BytesReadUsefulData.Int64(),
+			uploaded:       stats.BytesWrittenData.Int64(),
+		}
+		if snapshot.hasInfo {
+			snapshot.piecesTotal = torr.NumPieces()
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	s.mutex.RUnlock()
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].infoHash < snapshots[j].infoHash })
+
+	w.Gauge("magnet_webdav_torrents_active", "Torrents loaded in the torrent client.", float64(len(snapshots)))
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(t *torrentSnapshot) float64
+	}{
+		{"magnet_webdav_torrent_peers", "Known peers per torrent.", func(t *torrentSnapshot) float64 { return float64(t.totalPeers) }},
+		{"magnet_webdav_torrent_active_peers", "Connected peers per torrent.", func(t *torrentSnapshot) float64 { return float64(t.activePeers) }},
+		{"magnet_webdav_torrent_seeders", "Connected seeders per torrent.", func(t *torrentSnapshot) float64 { return float64(t.seeders) }},
+		{"magnet_webdav_torrent_pieces_complete", "Verified pieces per torrent.", func(t *torrentSnapshot) float64 { return float64(t.piecesComplete) }},
+		{"magnet_webdav_torrent_pieces", "Total pieces per torrent, 0 until metadata is received.", func(t *torrentSnapshot) float64 { return float64(t.piecesTotal) }},
+	}
+	for _, g := range gauges {
+		w.Header(g.name, g.help, "gauge")
+		for i := range snapshots {
+			w.Sample(g.name, g.value(&snapshots[i]), "magnet", snapshots[i].infoHash)
+		}
+	}
+
+	w.Header("magnet_webdav_torrent_downloaded_bytes_total", "Useful payload bytes downloaded per torrent.", "counter")
+	for _, t := range snapshots {
+		w.Sample("magnet_webdav_torrent_downloaded_bytes_total", float64(t.downloaded), "magnet", t.infoHash)
+	}
+	w.Header("magnet_webdav_torrent_uploaded_bytes_total", "Payload bytes uploaded per torrent.", "counter")
+	for _, t := range snapshots {
+		w.Sample("magnet_webdav_torrent_uploaded_bytes_total", float64(t.uploaded), "magnet", t.infoHash)
+	}
+
+	s.collectRates(w, snapshots)
+
+	w.Header("magnet_webdav_open_readers", "Open file readers per torrent.", "gauge")
+	readers := s.readers.snapshot()
+	infoHashes := make([]string, 0, len(readers))
+	for infoHash := range readers {
+		infoHashes = append(infoHashes, infoHash)
+	}
+	sort.Strings(infoHashes)
+	for _, infoHash := range infoHashes {
+		w.Sample("magnet_webdav_open_readers", float64(readers[infoHash]), "magnet", infoHash)
+	}
+
+	w.Gauge("magnet_webdav_storage_used_bytes", "Bytes used by downloaded torrent data.", float64(s.StorageUsage()))
+}
+
+// collectRates 根据两次抓取之间的差值计算下载和上传速率
+func (s *TorrentService) collectRates(w *metrics.Writer, snapshots []torrentSnapshot) {
+	s.metrics.mutex.Lock()
+	defer s.metrics.mutex.Unlock()
+
+	now := time.Now()
+	current := make(map[string]transferSample, len(snapshots))
+
+	w.Header("magnet_webdav_torrent_download_rate_bytes", "Download rate per torrent since the previous scrape.", "gauge")
+	for _, t := range snapshots {
+		var rate float64
+		if prev, ok := s.metrics.samples[t.infoHash]; ok {
+			if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+				rate = float64(t.downloaded-prev.downloaded) / elapsed
+			}
+		}
+		w.Sample("magnet_webdav_torrent_download_rate_bytes", rate, "magnet", t.infoHash)
+	}
+
+	w.Header("magnet_webdav_torrent_upload_rate_bytes", "Upload rate per torrent since the previous scrape.", "gauge")
+	for _, t := range snapshots {
+		var rate float64
+		if prev, ok := s.metrics.samples[t.infoHash]; ok {
+			if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+				rate = float64(t.uploaded-prev.uploaded) / elapsed
+			}
+		}
+		w.Sample("magnet_webdav_torrent_upload_rate_bytes", rate, "magnet", t.infoHash)
+		current[t.infoHash] = transferSample{at: now, downloaded: t.downloaded, uploaded: t.uploaded}
+	}
+
+	// 已移除的种子不再保留采样
+	s.metrics.samples = current
+}
+
+// StorageUsage 返回下载目录占用的字节数，结果缓存一分钟
+func (s *TorrentService) StorageUsage() int64 {
+	s.metrics.mutex.Lock()
+	defer s.metrics.mutex.Unlock()
+
+	if time.Since(s.metrics.storageAt) < storageUsageTTL {
+		return s.metrics.storageBytes
+	}
+
+	var total int64
+	filepath.WalkDir(s.cfg.Torrent.DownloadDir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+
+	s.metrics.storageBytes = total
+	s.metrics.storageAt = time.Now()
+	return total
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"gorm.io/gorm"
+)
+
+// progressListLimit 播放记录列表返回的最大条数
+const progressListLimit = 100
+
+// subtitleLanguagePattern 字幕文件名中的语言部分，例如 chi、en、zh-Hans
+var subtitleLanguagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z]{2,4})?$`)
+
+// PlayerSubtitle 播放页可以加载的字幕，Path 为 WebVTT 文件（种子中的 .vtt 或 SRT/ASS 转换后的虚拟文件）的路径
+type PlayerSubtitle struct {
+	Path     string `json:"path"`
+	Label    string `json:"label"`
+	Language string `json:"language,omitempty"`
+}
+
+// PlayerInfo 播放页需要的文件、字幕和当前用户的播放位置
+type PlayerInfo struct {
+	File      models.File              `json:"file"`
+	Subtitles []PlayerSubtitle         `json:"subtitles"`
+	Progress  *models.PlaybackProgress `json:"progress"`
+}
+
+// FileState 文件在客户端中的实时下载状态，Ranges 和 Partial 为已完成和正在下载的分片覆盖的字节范围（相对文件开头）
+type FileState struct {
+	Length         int64      `json:"length"`
+	BytesCompleted int64      `json:"bytes_completed"`
+	Ranges         [][2]int64 `json:"ranges"`
+	Partial        [][2]int64 `json:"partial"`
+	ActivePeers    int        `json:"active_peers"`
+	Seeders        int        `json:"seeders"`
+}
+
+// PlaybackService 播放页的文件信息和每个用户的播放位置
+type PlaybackService struct {
+	torrentService *TorrentService
+	db             *gorm.DB
+}
+
+func NewPlaybackService(torrentService *TorrentService) *PlaybackService {
+	return &PlaybackService{
+		torrentService: torrentService,
+		db:             torrentService.DB(),
+	}
+}
+
+// Player 返回播放页的文件信息、同目录下的字幕和播放位置
+func (s *PlaybackService) Player(user *config.UserConfig, magnetID string, fileIndex int) (*PlayerInfo, error) {
+	file, err := s.file(user, magnetID, fileIndex)
+	if err != nil {
+		return nil, err
+	}
+	var files []models.File
+	if err := s.db.Where("magnet_id = ?", magnetID).Order("file_index").Find(&files).Error; err != nil {
+		return nil, err
+	}
+	progress, err := s.GetProgress(user, magnetID, fileIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &PlayerInfo{File: *file, Subtitles: siblingSubtitles(files, file), Progress: progress}, nil
+}
+
+// siblingSubtitles 返回和视频在同一目录、文件名以视频文件名（不含扩展名）开头的字幕。
+// 没有这样的字幕时返回同一目录下的全部字幕
+func siblingSubtitles(files []models.File, video *models.File) []PlayerSubtitle {
+	dir := path.Dir(video.FilePath)
+	base := strings.TrimSuffix(path.Base(video.FilePath), path.Ext(video.FilePath))
+
+	// 种子中的 .vtt 直接使用，SRT/ASS 使用转换后的虚拟文件
+	vttPaths := make(map[string]bool)
+	for _, file := range files {
+		if strings.EqualFold(path.Ext(file.FilePath), ".vtt") {
+			vttPaths[file.FilePath] = true
+		}
+	}
+	for _, vtt := range WebVTTFiles(files) {
+		vttPaths[vtt.Path] = true
+	}
+
+	var matched, others []PlayerSubtitle
+	for vttPath := range vttPaths {
+		if path.Dir(vttPath) != dir {
+			continue
+		}
+		name := strings.TrimSuffix(path.Base(vttPath), path.Ext(vttPath))
+		sub := PlayerSubtitle{Path: vttPath, Label: name}
+		if rest, ok := strings.CutPrefix(name, base); ok && (rest == "" || rest[0] == '.') {
+			if rest = strings.TrimPrefix(rest, "."); rest != "" {
+				sub.Label = rest
+				if language := rest[strings.LastIndex(rest, ".")+1:]; subtitleLanguagePattern.MatchString(language) {
+					sub.Language = language
+				}
+			}
+			matched = append(matched, sub)
+		} else {
+			others = append(others, sub)
+		}
+	}
+
+	result := matched
+	if len(result) == 0 {
+		result = others
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	if result == nil {
+		result = []PlayerSubtitle{}
+	}
+	return result
+}
+
+// GetProgress 返回用户在文件上的播放位置，没有记录时位置为 0
+func (s *PlaybackService) GetProgress(user *config.UserConfig, magnetID string, fileIndex int) (*models.PlaybackProgress, error) {
+	if _, err := s.file(user, magnetID, fileIndex); err != nil {
+		return nil, err
+	}
+	progress := models.PlaybackProgress{Owner: s.torrentService.ownerName(user), MagnetID: magnetID, FileIndex: fileIndex}
+	err := s.db.Where("owner = ? AND magnet_id = ? AND file_index = ?", progress.Owner, magnetID, fileIndex).First(&progress).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// SaveProgress 保存用户在文件上的播放位置，单位为秒
+func (s *PlaybackService) SaveProgress(user *config.UserConfig, magnetID string, fileIndex int, position, duration float64) (*models.PlaybackProgress, error) {
+	if math.IsNaN(position) || math.IsInf(position, 0) || position < 0 {
+		return nil, fmt.Errorf("%w: invalid position", ErrInvalidInput)
+	}
+	if math.IsNaN(duration) || math.IsInf(duration, 0) || duration < 0 {
+		return nil, fmt.Errorf("%w: invalid duration", ErrInvalidInput)
+	}
+	if _, err := s.file(user, magnetID, fileIndex); err != nil {
+		return nil, err
+	}
+
+	progress := models.PlaybackProgress{Owner: s.torrentService.ownerName(user), MagnetID: magnetID, FileIndex: fileIndex}
+	err := s.db.Where("owner = ? AND magnet_id = ? AND file_index = ?", progress.Owner, magnetID, fileIndex).
+		Assign(map[string]interface{}{
+			"position": position,
+			"duration": duration,
+		}).FirstOrCreate(&progress).Error
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// DeleteProgress 删除用户在文件上的播放位置，例如播放结束后
+func (s *PlaybackService) DeleteProgress(user *config.UserConfig, magnetID string, fileIndex int) error {
+	if _, err := s.file(user, magnetID, fileIndex); err != nil {
+		return err
+	}
+	return s.db.Where("owner = ? AND magnet_id = ? AND file_index = ?", s.torrentService.ownerName(user), magnetID, fileIndex).
+		Delete(&models.PlaybackProgress{}).Error
+}
+
+// ListProgress 列出用户最近的播放记录，只包括仍然可见的磁力链接
+func (s *PlaybackService) ListProgress(user *config.UserConfig) ([]models.PlaybackProgress, error) {
+	visible := s.torrentService.VisibleEntries(user).Select("library_entries.magnet_id")
+	progress := []models.PlaybackProgress{}
+	err := s.db.Where("owner = ? AND magnet_id IN (?)", s.torrentService.ownerName(user), visible).
+		Order("updated_at DESC").Limit(progressListLimit).Find(&progress).Error
+	return progress, err
+}
+
+// FileState 返回文件的实时下载状态，种子不在客户端中或还没有元数据时返回 ErrNotReady
+func (s *PlaybackService) FileState(user *config.UserConfig, magnetID string, fileIndex int) (*FileState, error) {
+	file, err := s.file(user, magnetID, fileIndex)
+	if err != nil {
+		return nil, err
+	}
+	state := s.torrentService.FileState(magnetID, file.FilePath)
+	if state == nil {
+		return nil, fmt.Errorf("%w: torrent %s is not active", ErrNotReady, magnetID)
+	}
+	return state, nil
+}
+
+// file 检查权限后返回文件记录
+func (s *PlaybackService) file(user *config.UserConfig, magnetID string, fileIndex int) (*models.File, error) {
+	if !s.torrentService.CanAccess(user, magnetID) {
+		return nil, ErrNotFound
+	}
+	var file models.File
+	err := s.db.Where("magnet_id = ? AND file_index = ?", magnetID, fileIndex).First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// FileState 读取种子中文件的分片完成情况，种子不在客户端中、还没有元数据或找不到文件时返回 nil
+func (s *TorrentService) FileState(infoHash, filePath string) *FileState {
+	torr := s.GetTorrent(infoHash)
+	if torr == nil || torr.Info() == nil {
+		return nil
+	}
+	var target *torrent.File
+	for _, f := range torr.Files() {
+		if f.Path() == filePath {
+			target = f
+			break
+		}
+	}
+	if target == nil {
+		return nil
+	}
+
+	stats := torr.Stats()
+	state := &FileState{
+		Length:         target.Length(),
+		BytesCompleted: target.BytesCompleted(),
+		Ranges:         [][2]int64{},
+		Partial:        [][2]int64{},
+		ActivePeers:    stats.ActivePeers,
+		Seeders:        stats.ConnectedSeeders,
+	}
+	var offset int64
+	for _, piece := range target.State() {
+		start, end := offset, offset+piece.Bytes
+		offset = end
+		switch {
+		case piece.Complete:
+			state.Ranges = appendRange(state.Ranges, start, end)
+		case piece.Partial:
+			state.Partial = appendRange(state.Partial, start, end)
+		}
+	}
+	return state
+}
+
+// appendRange 追加字节范围，和上一个范围相邻时合并
+func appendRange(ranges [][2]int64, start, end int64) [][2]int64 {
+	if n := len(ranges); n > 0 && ranges[n-1][1] == start {
+		ranges[n-1][1] = end
+		return ranges
+	}
+	return append(ranges, [2]int64{start, end})
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidQuery 查询参数不合法
+var ErrInvalidQuery = errors.New("invalid query")
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// magnetSortColumns 磁力链接列表允许的排序字段
+var magnetSortColumns = map[string]string{
+	"name":          "COALESCE(NULLIF(library_entries.name, ''), magnets.name)",
+	"size":          "magnets.total_size",
+	"created_at":    "magnets.created_at",
+	"last_accessed": "magnets.last_accessed",
+	"access_count":  "magnets.access_count",
+	"status":        "magnets.status",
+}
+
+// fileSortColumns 文件列表允许的排序字段
+var fileSortColumns = map[string]string{
+	"index": "file_index",
+	"name":  "file_name",
+	"size":  "file_size",
+}
+
+// MagnetQuery 磁力链接列表的过滤、排序和分页参数，零值表示不过滤
+type MagnetQuery struct {
+	Status        []string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	MinSize       int64
+	MaxSize       int64
+	HasVideo      *bool
+	Tags          []string // 需要同时带有的标签
+	Category      uint     // 分类 ID，包括其子分类
+	Search        string
+	Sort          string // magnetSortColumns 中的字段，默认 last_accessed
+	Desc          bool
+	Limit         int
+	Offset        int
+	Cursor        string // 上一页返回的 next_cursor，设置后忽略 Offset
+}
+
+// FileQuery 文件列表的过滤、排序和分页参数
+type FileQuery struct {
+	Search   string
+	MimeType string // 前缀匹配，例如 video 或 video/mp4
+	MinSize  int64
+	MaxSize  int64
+	Sort     string // fileSortColumns 中的字段，默认 index
+	Desc     bool
+	Limit    int
+	Offset   int
+}
+
+// MagnetPage 一页磁力链接
+type MagnetPage struct {
+	Items      []models.LibraryMagnet `json:"items"`
+	Total      int64                  `json:"total"`
+	Limit      int                    `json:"limit"`
+	Offset     int                    `json:"offset"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+// FilePage 一页文件
+type FilePage struct {
+	Items  []models.File `json:"items"`
+	Total  int64         `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+// pageCursor 游标分页的位置：上一页最后一行的排序值和条目 ID，同时记录排序方式防止混用
+type pageCursor struct {
+	Sort    string      `json:"s"`
+	Desc    bool        `json:"d"`
+	Value   interface{} `json:"v"`
+	EntryID uint        `json:"id"`
+}
+
+// ListMagnets 查询用户可见的磁力链接
+func (s *TorrentService) ListMagnets(user *config.UserConfig, q MagnetQuery) (*MagnetPage, error) {
+	if q.Sort == "" {
+		q.Sort = "last_accessed"
+	}
+	sortColumn, ok := magnetSortColumns[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported sort field %s", ErrInvalidQuery, q.Sort)
+	}
+	q.Limit = clampLimit(q.Limit)
+
+	query := s.filterMagnets(s.VisibleMagnets(user), q)
+
+	var total int64
+	if err := s.filterMagnets(s.uniqueVisibleEntries(user).Joins("JOIN magnets ON magnets.id = library_entries.magnet_id"), q).
+		Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	direction, compare := "ASC", ">"
+	if q.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor, q.Sort, q.Desc)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(fmt.Sprintf("(%s %s ?) OR (%s = ? AND library_entries.id %s ?)", sortColumn, compare, sortColumn, compare),
+			cursor.Value, cursor.Value, cursor.EntryID)
+		q.Offset = 0
+	}
+
+	page := &MagnetPage{Items: []models.LibraryMagnet{}, Total: total, Limit: q.Limit, Offset: q.Offset}
+	if err := query.Order(fmt.Sprintf("%s %s, library_entries.id %s", sortColumn, direction, direction)).
+		Limit(q.Limit).Offset(q.Offset).Scan(&page.Items).Error; err != nil {
+		return nil, err
+	}
+
+	if len(page.Items) == q.Limit {
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = encodeCursor(pageCursor{
+			Sort:    q.Sort,
+			Desc:    q.Desc,
+			Value:   magnetSortValue(&last, q.Sort),
+			EntryID: last.EntryID,
+		})
+	}
+	return page, nil
+}
+
+// filterMagnets 添加过滤条件，查询中需要已经关联 magnets 和 library_entries
+func (s *TorrentService) filterMagnets(query *gorm.DB, q MagnetQuery) *gorm.DB {
+	if len(q.Status) > 0 {
+		query = query.Where("magnets.status IN ?", q.Status)
+	}
+	if !q.CreatedAfter.IsZero() {
+		query = query.Where("magnets.created_at >= ?", q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		query = query.Where("magnets.created_at < ?", q.CreatedBefore)
+	}
+	if q.MinSize > 0 {
+		query = query.Where("magnets.total_size >= ?", q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		query = query.Where("magnets.total_size <= ?", q.MaxSize)
+	}
+	if q.HasVideo != nil {
+		videos := s.db.Model(&models.File{}).Select("1").
+			Where("files.magnet_id = magnets.id AND files.mime_type LIKE ?", "video/%")
+		if *q.HasVideo {
+			query = query.Where("EXISTS (?)", videos)
+		} else {
+			query = query.Where("NOT EXISTS (?)", videos)
+		}
+	}
+
+	for _, tag := range q.Tags {
+		tagged := s.db.Model(&models.EntryTag{}).Select("1").
+			Joins("JOIN tags ON tags.id = entry_tags.tag_id").
+			Where("entry_tags.entry_id = library_entries.id AND tags.name = ?", tag)
+		query = query.Where("EXISTS (?)", tagged)
+	}
+	if q.Category != 0 {
+		ids, err := s.categorySubtree(q.Category)
+		if err != nil {
+			query.AddError(err)
+		}
+		query = query.Where("library_entries.category_id IN ?", ids)
+	}
+
+	// 每个关键词都要匹配名称或任意一个文件名，使用数据库的全文索引，见 textCondition
+	for _, term := range strings.Fields(q.Search) {
+		nameCondition, nameArg := s.textCondition(magnetNameColumn, term)
+		entryCondition, entryArg := s.textCondition(entryNameColumn, term)
+		fileCondition, fileArg := s.textCondition(fileNameColumn, term)
+		files := s.db.Model(&models.File{}).Select("1").
+			Where("files.magnet_id = magnets.id AND "+fileCondition, fileArg)
+		query = query.Where(nameCondition+" OR "+entryCondition+" OR EXISTS (?)", nameArg, entryArg, files)
+	}
+	return query
+}
+
+// ListFiles 查询磁力链接中的文件，调用方需要先检查访问权限
+func (s *TorrentService) ListFiles(magnetID string, q FileQuery) (*FilePage, error) {
+	if q.Sort == "" {
+		q.Sort = "index"
+	}
+	sortColumn, ok := fileSortColumns[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported sort field %s", ErrInvalidQuery, q.Sort)
+	}
+	q.Limit = clampLimit(q.Limit)
+
+	query := s.db.Model(&models.File{}).Where("magnet_id = ?", magnetID)
+	for _, term := range strings.Fields(q.Search) {
+		condition, arg := s.textCondition(fileNameColumn, term)
+		query = query.Where(condition, arg)
+	}
+	if q.MimeType != "" {
+		query = query.Where("mime_type LIKE ? ESCAPE '!'", escapeLike(q.MimeType)+"%")
+	}
+	if q.MinSize > 0 {
+		query = query.Where("file_size >= ?", q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		query = query.Where("file_size <= ?", q.MaxSize)
+	}
+
+	page := &FilePage{Items: []models.File{}, Limit: q.Limit, Offset: q.Offset}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	direction := "ASC"
+	if q.Desc {
+		direction = "DESC"
+	}
+	if err := query.Order(fmt.Sprintf("%s %s, id %s", sortColumn, direction, direction)).
+		Limit(q.Limit).Offset(q.Offset).Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// MagnetFiles 批量返回磁力链接的全部文件，按文件序号排序，调用方需要先检查访问权限
+func (s *TorrentService) MagnetFiles(magnetIDs []string) (map[string][]models.File, error) {
+	files := make(map[string][]models.File, len(magnetIDs))
+	if len(magnetIDs) == 0 {
+		return files, nil
+	}
+
+	var rows []models.File
+	if err := s.db.Where("magnet_id IN ?", magnetIDs).Order("magnet_id, file_index").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, file := range rows {
+		files[file.MagnetID] = append(files[file.MagnetID], file)
+	}
+	return files, nil
+}
+
+// likeOperator 返回不区分大小写的 LIKE 运算符，用于没有全文索引或关键词太短时的子串匹配：
+// PostgreSQL 使用 ILIKE；MySQL 和 SQL Server 的默认排序规则本身不区分大小写；SQLite 的 LIKE 对 ASCII 不区分大小写
+func (s *TorrentService) likeOperator() string {
+	if s.db.Dialector.Name() == "postgres" {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+// escapeLike 转义 LIKE 通配符，使用 ! 作为转义字符以避免各数据库对反斜杠的不同处理
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_", "[", "![").Replace(s)
+}
+
+func likePattern(term string) string {
+	return "%" + escapeLike(term) + "%"
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageSize
+	}
+	if limit > maxPageSize {
+		return maxPageSize
+	}
+	return limit
+}
+
+// magnetSortValue 返回用于游标的排序字段值
+func magnetSortValue(m *models.LibraryMagnet, sort string) interface{} {
+	switch sort {
+	case "name":
+		return m.DisplayName
+	case "size":
+		return m.TotalSize
+	case "created_at":
+		return m.CreatedAt
+	case "access_count":
+		return m.AccessCount
+	case "status":
+		return m.Status
+	default:
+		return m.LastAccessed
+	}
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor 解析游标并把排序值还原为数据库列对应的类型
+func decodeCursor(s, sort string, desc bool) (*pageCursor, error) {
+	malformed := fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, malformed
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var cursor pageCursor
+	if err := decoder.Decode(&cursor); err != nil {
+		return nil, malformed
+	}
+	if cursor.Sort != sort || cursor.Desc != desc {
+		return nil, fmt.Errorf("%w: cursor was created with a different sort order", ErrInvalidQuery)
+	}
+
+	switch v := cursor.Value.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, malformed
+		}
+		cursor.Value = n
+	case string:
+		if sort == "created_at" || sort == "last_accessed" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, malformed
+			}
+			cursor.Value = t
+		}
+	default:
+		return nil, malformed
+	}
+	return &cursor, nil
+}
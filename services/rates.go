@@ -0,0 +1,61 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// rateSampleInterval 两次采样的最短间隔，间隔内的查询返回上一次计算的速率
+const rateSampleInterval = time.Second
+
+// rateSample 上一次查询时的传输量和据此计算的速率（字节/秒）
+type rateSample struct {
+	transferSample
+	download int64
+	upload   int64
+}
+
+// rateTracker 根据前后两次查询之间的传输量差值计算每个种子的实时速率，
+// 供 qBittorrent 和 Transmission 兼容接口显示下载和上传速度
+type rateTracker struct {
+	samples map[string]rateSample
+	mutex   sync.Mutex
+}
+
+func newRateTracker() *rateTracker {
+	return &rateTracker{samples: make(map[string]rateSample)}
+}
+
+// update 记录种子当前的传输量，返回下载和上传速率。第一次查询时没有可比较的采样，速率为 0
+func (t *rateTracker) update(infoHash string, downloaded, uploaded int64) (int64, int64) {
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	prev, ok := t.samples[infoHash]
+	if ok && now.Sub(prev.at) < rateSampleInterval {
+		return prev.download, prev.upload
+	}
+
+	sample := rateSample{transferSample: transferSample{at: now, downloaded: downloaded, uploaded: uploaded}}
+	if ok {
+		elapsed := now.Sub(prev.at).Seconds()
+		// 种子重新加入客户端后计数从 0 开始，差值为负时按 0 处理
+		if delta := downloaded - prev.downloaded; delta > 0 {
+			sample.download = int64(float64(delta) / elapsed)
+		}
+		if delta := uploaded - prev.uploaded; delta > 0 {
+			sample.upload = int64(float64(delta) / elapsed)
+		}
+	}
+	t.samples[infoHash] = sample
+	return sample.download, sample.upload
+}
+
+// remove 删除种子的采样
+func (t *rateTracker) remove(infoHash string) {
+	t.mutex.Lock()
+	delete(t.samples, infoHash)
+	t.mutex.Unlock()
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// readerTracker 统计每个种子当前打开的文件读取器数量
+type readerTracker struct {
+	counts map[string]int
+	mutex  sync.Mutex
+}
+
+func newReaderTracker() *readerTracker {
+	return &readerTracker{counts: make(map[string]int)}
+}
+
+func (t *readerTracker) acquire(infoHash string) {
+	t.mutex.Lock()
+	t.counts[infoHash]++
+	t.mutex.Unlock()
+}
+
+func (t *readerTracker) release(infoHash string) {
+	t.mutex.Lock()
+	t.counts[infoHash]--
+	if t.counts[infoHash] <= 0 {
+		delete(t.counts, infoHash)
+	}
+	t.mutex.Unlock()
+}
+
+// count 返回指定种子的读取器数量，infoHash 为空时返回总数
+func (t *readerTracker) count(infoHash string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if infoHash != "" {
+		return t.counts[infoHash]
+	}
+	total := 0
+	for _, n := range t.counts {
+		total += n
+	}
+	return total
+}
+
+// snapshot 返回每个种子的读取器数量副本
+func (t *readerTracker) snapshot() map[string]int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	counts := make(map[string]int, len(t.counts))
+	for infoHash, n := range t.counts {
+		counts[infoHash] = n
+	}
+	return counts
+}
+
+// wait 等待读取器全部关闭，infoHash 为空时等待所有种子
+func (t *readerTracker) wait(ctx context.Context, infoHash string) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for t.count(infoHash) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// trackedReader 关闭时自动减少计数的读取器
+type trackedReader struct {
+	torrent.Reader
+	once    sync.Once
+	release func()
+}
+
+func (r *trackedReader) Close() error {
+	err := r.Reader.Close()
+	r.once.Do(r.release)
+	return err
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSearchResults 聚合后最多返回的结果数
+const maxSearchResults = 500
+
+// SearchProvider 种子搜索源，内置 Torznab/Newznab 实现，也可以通过 SearchService.AddProvider 注册其他实现
+type SearchProvider interface {
+	Name() string
+	Search(ctx context.Context, query string) ([]SearchResult, error)
+}
+
+// TorrentDownloader 可以下载自己结果中 .torrent 文件的搜索源，没有磁力链接的结果需要通过它添加。
+// 下载地址跳转到磁力链接时返回 magnetURI 而不是文件内容
+type TorrentDownloader interface {
+	DownloadTorrent(ctx context.Context, rawURL string) (data []byte, magnetURI string, err error)
+}
+
+// SearchResult 单条搜索结果，Seeders 和 Leechers 为 -1 表示未知
+type SearchResult struct {
+	Title       string     `json:"title"`
+	InfoHash    string     `json:"info_hash,omitempty"`
+	MagnetURI   string     `json:"magnet_uri,omitempty"`
+	TorrentURL  string     `json:"torrent_url,omitempty"` // 没有磁力链接时通过搜索源下载的 .torrent 地址
+	Size        int64      `json:"size"`
+	Seeders     int        `json:"seeders"`
+	Leechers    int        `json:"leechers"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	Providers   []string   `json:"providers"`
+}
+
+// SearchResponse 聚合后的搜索结果，Errors 记录失败的搜索源
+type SearchResponse struct {
+	Query   string            `json:"query"`
+	Results []SearchResult    `json:"results"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// SearchAdd 把搜索结果加入媒体库：优先使用磁力链接，否则由 Provider 下载 TorrentURL
+type SearchAdd struct {
+	MagnetURI  string `json:"magnet_uri"`
+	TorrentURL string `json:"torrent_url"`
+	Provider   string `json:"provider"`
+	Name       string `json:"name"`
+}
+
+// SearchService 并发查询所有搜索源，按 infoHash 去重后按做种数和大小排序
+type SearchService struct {
+	torrentService *TorrentService
+	timeout        time.Duration
+	providers      []SearchProvider
+	mutex          sync.RWMutex
+}
+
+func NewSearchService(cfg *config.Config, torrentService *TorrentService) *SearchService {
+	s := &SearchService{
+		torrentService: torrentService,
+		timeout:        cfg.Search.Timeout,
+	}
+	for _, provider := range cfg.Search.Providers {
+		s.AddProvider(NewTorznabProvider(provider, cfg.Search.Timeout, cfg.Torrent.UserAgent))
+	}
+	return s
+}
+
+// AddProvider 注册搜索源，同名的搜索源会被替换
+func (s *SearchService) AddProvider(provider SearchProvider) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, existing := range s.providers {
+		if existing.Name() == provider.Name() {
+			s.providers[i] = provider
+			return
+		}
+	}
+	s.providers = append(s.providers, provider)
+}
+
+// Providers 返回已注册的搜索源名称
+func (s *SearchService) Providers() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make([]string, 0, len(s.providers))
+	for _, provider := range s.providers {
+		names = append(names, provider.Name())
+	}
+	return names
+}
+
+func (s *SearchService) provider(name string) SearchProvider {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, provider := range s.providers {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+// Search 在指定的搜索源中搜索，names 为空表示全部。单个搜索源失败或超时不影响其他搜索源的结果
+func (s *SearchService) Search(ctx context.Context, query string, names []string, limit int) (*SearchResponse, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("%w: q is required", ErrInvalidQuery)
+	}
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	var providers []SearchProvider
+	if len(names) == 0 {
+		s.mutex.RLock()
+		providers = append(providers, s.providers...)
+		s.mutex.RUnlock()
+	} else {
+		for _, name := range names {
+			provider := s.provider(name)
+			if provider == nil {
+				return nil, fmt.Errorf("%w: unknown provider %s", ErrInvalidQuery, name)
+			}
+			providers = append(providers, provider)
+		}
+	}
+
+	type providerResult struct {
+		name    string
+		results []SearchResult
+		err     error
+	}
+	ch := make(chan providerResult, len(providers))
+	for _, provider := range providers {
+		go func(provider SearchProvider) {
+			ctx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+			results, err := provider.Search(ctx, query)
+			ch <- providerResult{name: provider.Name(), results: results, err: err}
+		}(provider)
+	}
+
+	resp := &SearchResponse{Query: query, Results: []SearchResult{}}
+	var all []SearchResult
+	for range providers {
+		result := <-ch
+		if result.err != nil {
+			log.Printf("Search provider %s failed: %v", result.name, result.err)
+			if resp.Errors == nil {
+				resp.Errors = make(map[string]string)
+			}
+			resp.Errors[result.name] = result.err.Error()
+			continue
+		}
+		for _, item := range result.results {
+			item.Providers = []string{result.name}
+			all = append(all, item)
+		}
+	}
+
+	resp.Results = mergeSearchResults(all)
+	if len(resp.Results) > limit {
+		resp.Results = resp.Results[:limit]
+	}
+	return resp, nil
+}
+
+// mergeSearchResults 按 infoHash 合并重复结果，没有 infoHash 的结果按 .torrent 地址合并，
+// 然后按做种数、大小降序排列
+func mergeSearchResults(items []SearchResult) []SearchResult {
+	merged := make([]SearchResult, 0, len(items))
+	index := make(map[string]int, len(items))
+	for _, item := range items {
+		if item.MagnetURI != "" {
+			magnetURI, infoHash, err := NormalizeMagnetURI(item.MagnetURI)
+			if err != nil {
+				continue
+			}
+			item.MagnetURI, item.InfoHash = magnetURI, infoHash
+		}
+
+		key := item.InfoHash
+		if key == "" {
+			key = "url:" + item.TorrentURL
+		}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, item)
+			continue
+		}
+
+		existing := &merged[i]
+		if item.Seeders > existing.Seeders {
+			existing.Title = item.Title
+			existing.Seeders = item.Seeders
+		}
+		if item.Leechers > existing.Leechers {
+			existing.Leechers = item.Leechers
+		}
+		if existing.Size == 0 {
+			existing.Size = item.Size
+		}
+		if existing.PublishedAt == nil {
+			existing.PublishedAt = item.PublishedAt
+		}
+		for _, name := range item.Providers {
+			if !containsString(existing.Providers, name) {
+				existing.Providers = append(existing.Providers, name)
+			}
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Seeders != merged[j].Seeders {
+			return merged[i].Seeders > merged[j].Seeders
+		}
+		return merged[i].Size > merged[j].Size
+	})
+	return merged
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Add 把搜索结果加入用户的媒体库
+func (s *SearchService) Add(ctx context.Context, user *config.UserConfig, req SearchAdd) (*models.LibraryMagnet, error) {
+	if req.MagnetURI != "" {
+		magnetURI, _, err := NormalizeMagnetURI(req.MagnetURI)
+		if err != nil {
+			return nil, err
+		}
+		return s.torrentService.AddMagnet(user, magnetURI, req.Name)
+	}
+	if req.TorrentURL == "" {
+		return nil, fmt.Errorf("%w: magnet_uri or torrent_url is required", ErrInvalidInput)
+	}
+
+	// .torrent 只能通过返回它的搜索源下载，避免服务端请求任意地址
+	provider := s.provider(req.Provider)
+	if provider == nil {
+		return nil, fmt.Errorf("%w: unknown provider %s", ErrInvalidInput, req.Provider)
+	}
+	downloader, ok := provider.(TorrentDownloader)
+	if !ok {
+		return nil, fmt.Errorf("%w: provider %s cannot download torrent files", ErrInvalidInput, req.Provider)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	data, magnetURI, err := downloader.DownloadTorrent(ctx, req.TorrentURL)
+	if err != nil {
+		if errors.Is(err, ErrInvalidInput) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("download torrent: %w", err)
+	}
+	if magnetURI != "" {
+		return s.Add(ctx, user, SearchAdd{MagnetURI: magnetURI, Name: req.Name})
+	}
+	file, err := ParseTorrentFile(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.torrentService.AddTorrentFile(user, file, req.Name)
+}
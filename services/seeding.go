@@ -0,0 +1,92 @@
+package services
+
+import (
+	"log"
+	"magnet-webdav/models"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// 做种策略
+const (
+	SeedModeAlways = "always"
+	SeedModeNever  = "never"
+	SeedModeRatio  = "ratio"
+)
+
+// seedCheckInterval 检查分享率的间隔
+const seedCheckInterval = 30 * time.Second
+
+// ValidSeedMode 是否为支持的做种策略
+func ValidSeedMode(mode string) bool {
+	switch mode {
+	case SeedModeAlways, SeedModeNever, SeedModeRatio:
+		return true
+	}
+	return false
+}
+
+// shareRatio 上传量与种子大小之比
+func shareRatio(torr *torrent.Torrent) float64 {
+	if torr.Info() == nil || torr.Length() == 0 {
+		return 0
+	}
+	stats := torr.Stats()
+	return float64(stats.BytesWrittenData.Int64()) / float64(torr.Length())
+}
+
+// applySeedingPolicy 根据做种策略允许或禁止上传
+func applySeedingPolicy(torr *torrent.Torrent, magnet *models.Magnet) {
+	switch magnet.SeedMode {
+	case SeedModeNever:
+		torr.DisallowDataUpload()
+	case SeedModeRatio:
+		if magnet.SeedRatio > 0 && shareRatio(torr) >= magnet.SeedRatio {
+			torr.DisallowDataUpload()
+		} else {
+			torr.AllowDataUpload()
+		}
+	default:
+		torr.AllowDataUpload()
+	}
+}
+
+// refreshSeedingPolicy 从数据库读取做种策略并应用到活跃的种子
+func (s *TorrentService) refreshSeedingPolicy(infoHash string) {
+	torr := s.GetTorrent(infoHash)
+	if torr == nil {
+		return
+	}
+
+	var magnet models.Magnet
+	if err := s.db.Select("id", "seed_mode", "seed_ratio").Where("id = ?", infoHash).First(&magnet).Error; err != nil {
+		return
+	}
+	applySeedingPolicy(torr, &magnet)
+}
+
+// enforceSeedingPolicies 定期检查按分享率做种的种子，达到分享率后停止上传
+func (s *TorrentService) enforceSeedingPolicies() {
+	ticker := time.NewTicker(seedCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			var magnets []models.Magnet
+			if err := s.db.Select("id", "seed_mode", "seed_ratio").
+				Where("seed_mode = ?", SeedModeRatio).Find(&magnets).Error; err != nil {
+				log.Printf("Failed to load seeding policies: %v", err)
+				continue
+			}
+			for i := range magnets {
+				if torr := s.GetTorrent(magnets[i].ID); torr != nil {
+					applySeedingPolicy(torr, &magnets[i])
+				}
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// shareSecretFileName 未配置 share.secret 时自动生成的密钥文件名
+const shareSecretFileName = "share.key"
+
+var (
+	ErrShareLinkInvalid   = errors.New("invalid share link")
+	ErrShareLinkExpired   = errors.New("share link expired")
+	ErrShareLinkExhausted = errors.New("share link download limit reached")
+)
+
+// ShareLinkOptions 创建分享链接的参数
+type ShareLinkOptions struct {
+	TTL          time.Duration
+	MaxDownloads int
+	BoundIP      string
+}
+
+// ShareLinkService 管理带签名和有效期的文件分享链接
+type ShareLinkService struct {
+	cfg            *config.Config
+	db             *gorm.DB
+	torrentService *TorrentService
+	secret         []byte
+}
+
+func NewShareLinkService(cfg *config.Config, torrentService *TorrentService) (*ShareLinkService, error) {
+	secret, err := loadShareSecret(cfg.Share.Secret, shareSecretPath(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ShareLinkService{
+		cfg:            cfg,
+		db:             torrentService.DB(),
+		torrentService: torrentService,
+		secret:         secret,
+	}, nil
+}
+
+// shareSecretPath 自动生成的密钥保存位置。SQLite 保存在数据库文件旁边，
+// 其他数据库保存在下载目录中，和 .metainfo 一样随下载目录持久化
+func shareSecretPath(cfg *config.Config) string {
+	if cfg.Database.Driver == "sqlite" {
+		return filepath.Join(filepath.Dir(cfg.Database.GetConnectionString()), shareSecretFileName)
+	}
+	return filepath.Join(cfg.Torrent.DownloadDir, "."+shareSecretFileName)
+}
+
+// loadShareSecret 读取签名密钥，未配置时生成随机密钥并持久化到 path，保证重启后链接仍然有效
+func loadShareSecret(configured, path string) ([]byte, error) {
+	if configured != "" {
+		return []byte(configured), nil
+	}
+
+	if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return []byte(strings.TrimSpace(string(data))), nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate share secret: %w", err)
+	}
+	secret := hex.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(secret), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save share secret: %w", err)
+	}
+
+	log.Printf("Generated share link secret: %s", path)
+	return []byte(secret), nil
+}
+
+// CreateLink 为用户可见的种子文件创建分享链接
+func (s *ShareLinkService) CreateLink(user *config.UserConfig, magnetID string, fileIndex int, opts ShareLinkOptions) (*models.ShareLink, error) {
+	if !s.torrentService.CanAccess(user, magnetID) {
+		return nil, ErrNotFound
+	}
+
+	var file models.File
+	if err := s.db.Where("magnet_id = ? AND file_index = ?", magnetID, fileIndex).First(&file).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = s.cfg.Share.DefaultTTL
+	}
+	if ttl > s.cfg.Share.MaxTTL {
+		return nil, fmt.Errorf("expiry exceeds maximum of %s", s.cfg.Share.MaxTTL)
+	}
+	if opts.MaxDownloads < 0 {
+		return nil, fmt.Errorf("max downloads must not be negative")
+	}
+
+	link := &models.ShareLink{
+		MagnetID:     magnetID,
+		FileIndex:    fileIndex,
+		FileName:     file.FileName,
+		Owner:        s.torrentService.ownerName(user),
+		ExpiresAt:    time.Now().Add(ttl).Truncate(time.Second),
+		MaxDownloads: opts.MaxDownloads,
+		BoundIP:      opts.BoundIP,
+	}
+	if err := s.db.Create(link).Error; err != nil {
+		return nil, fmt.Errorf("failed to create share link: %w", err)
+	}
+
+	link.URL = s.LinkPath(link)
+	return link, nil
+}
+
+// ListLinks 列出用户创建的分享链接
+func (s *ShareLinkService) ListLinks(user *config.UserConfig) ([]models.ShareLink, error) {
+	links := []models.ShareLink{}
+	err := s.db.Where("owner = ?", s.torrentService.ownerName(user)).
+		Order("created_at DESC").
+		Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range links {
+		if links[i].RevokedAt == nil {
+			links[i].URL = s.LinkPath(&links[i])
+		}
+	}
+	return links, nil
+}
+
+// RevokeLink 撤销用户创建的分享链接
+func (s *ShareLinkService) RevokeLink(user *config.UserConfig, id uint) error {
+	result := s.db.Model(&models.ShareLink{}).
+		Where("id = ? AND owner = ? AND revoked_at IS NULL", id, s.torrentService.ownerName(user)).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LinkPath 返回分享链接的相对路径
+func (s *ShareLinkService) LinkPath(link *models.ShareLink) string {
+	return fmt.Sprintf("/s/%d?expires=%d&sig=%s", link.ID, link.ExpiresAt.Unix(), s.sign(link.ID, link.ExpiresAt.Unix()))
+}
+
+// sign 计算链接签名，签名覆盖链接 ID 和过期时间
+func (s *ShareLinkService) sign(id uint, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%d:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ResolveLink 校验签名、有效期、IP 绑定和撤销状态，返回链接指向的文件
+func (s *ShareLinkService) ResolveLink(id uint, expires int64, sig, clientIP string) (*models.ShareLink, *models.File, error) {
+	if !hmac.Equal([]byte(sig), []byte(s.sign(id, expires))) {
+		return nil, nil, ErrShareLinkInvalid
+	}
+
+	var link models.ShareLink
+	if err := s.db.First(&link, id).Error; err != nil {
+		return nil, nil, ErrShareLinkInvalid
+	}
+	if link.RevokedAt != nil || link.ExpiresAt.Unix() != expires {
+		return nil, nil, ErrShareLinkInvalid
+	}
+	if time.Now().After(link.ExpiresAt) {
+		return nil, nil, ErrShareLinkExpired
+	}
+	if link.BoundIP != "" && link.BoundIP != clientIP {
+		return nil, nil, ErrShareLinkInvalid
+	}
+	if link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads {
+		return nil, nil, ErrShareLinkExhausted
+	}
+
+	// 创建者的媒体库条目或共享被移除、账号被删除后，链接随之失效
+	if !s.ownerCanAccess(&link) {
+		return nil, nil, ErrNotFound
+	}
+
+	var file models.File
+	if err := s.db.Where("magnet_id = ? AND file_index = ?", link.MagnetID, link.FileIndex).First(&file).Error; err != nil {
+		return nil, nil, ErrNotFound
+	}
+
+	return &link, &file, nil
+}
+
+// ownerCanAccess 判断链接创建者当前是否仍可以访问链接指向的磁力链接，未启用认证时只要求媒体库中仍有该磁力链接
+func (s *ShareLinkService) ownerCanAccess(link *models.ShareLink) bool {
+	var owner *config.UserConfig
+	if s.cfg.Auth.Enabled {
+		if owner = s.cfg.Auth.FindUser(link.Owner); owner == nil {
+			return false
+		}
+	}
+	return s.torrentService.CanAccess(owner, link.MagnetID)
+}
+
+// RecordDownload 计入一次下载，超过次数限制时返回 ErrShareLinkExhausted
+func (s *ShareLinkService) RecordDownload(link *models.ShareLink) error {
+	// 条件更新保证并发下载时不会超过次数限制
+	result := s.db.Model(&models.ShareLink{}).
+		Where("id = ? AND (max_downloads = 0 OR downloads < max_downloads)", link.ID).
+		Update("downloads", gorm.Expr("downloads + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareLinkExhausted
+	}
+	link.Downloads++
+	return nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"magnet-webdav/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// partFileSuffix 未下载完成的文件使用的后缀
+const partFileSuffix = ".part"
+
+// sharedPathsBatch 查询其他种子是否引用同一路径时每批的路径数，避免超出数据库的参数个数限制
+const sharedPathsBatch = 500
+
+// metainfoFilePaths 返回元数据中每个文件的路径，和客户端中 File.Path() 的格式一致（以种子名称开头）
+func metainfoFilePaths(info *metainfo.Info) []string {
+	var paths []string
+	for _, file := range info.UpvertedFiles() {
+		paths = append(paths, strings.Join(append([]string{info.BestName()}, file.BestPath()...), "/"))
+	}
+	return paths
+}
+
+// torrentDataPaths 根据种子自己的元数据返回下载的文件路径，客户端中的种子还没有元数据时读取保存的 .torrent 文件。
+// 不使用 files 表中的记录，这些记录可能来自导入，不能证明文件属于这个种子。没有元数据时不会有下载的数据，返回空
+func (s *TorrentService) torrentDataPaths(infoHash string) ([]string, error) {
+	if torr := s.GetTorrent(infoHash); torr != nil && torr.Info() != nil {
+		paths := make([]string, 0, len(torr.Files()))
+		for _, file := range torr.Files() {
+			paths = append(paths, file.Path())
+		}
+		return paths, nil
+	}
+
+	data, err := s.readMetainfo(infoHash)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
+	return metainfoFilePaths(&info), nil
+}
+
+// sharedDataPaths 返回 paths 中同时被其他种子（files 表中的记录或客户端中的种子）引用的路径
+func (s *TorrentService) sharedDataPaths(infoHash string, paths []string) (map[string]bool, error) {
+	shared := make(map[string]bool)
+	for start := 0; start < len(paths); start += sharedPathsBatch {
+		end := start + sharedPathsBatch
+		if end > len(paths) {
+			end = len(paths)
+		}
+		var found []string
+		err := s.db.Model(&models.File{}).
+			Where("magnet_id <> ? AND file_path IN ?", infoHash, paths[start:end]).
+			Distinct().Pluck("file_path", &found).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range found {
+			shared[path] = true
+		}
+	}
+
+	wanted := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		wanted[path] = true
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for other, torr := range s.activeTorrents {
+		if other == infoHash || torr.Info() == nil {
+			continue
+		}
+		for _, file := range torr.Files() {
+			if wanted[file.Path()] {
+				shared[file.Path()] = true
+			}
+		}
+	}
+	return shared, nil
+}
+
+// removeTorrentData 删除种子下载的文件以及因此变空的目录。
+// 文件存储把每个文件保存在下载目录下的 File.Path()，只删除位于下载目录内、且没有其他种子引用的路径
+func (s *TorrentService) removeTorrentData(infoHash string, paths []string) error {
+	root, err := filepath.Abs(s.cfg.Torrent.DownloadDir)
+	if err != nil {
+		return err
+	}
+	shared, err := s.sharedDataPaths(infoHash, paths)
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	var errs []error
+	for _, filePath := range paths {
+		if shared[filePath] {
+			errs = append(errs, fmt.Errorf("keeping %s, it is used by another torrent", filePath))
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(filePath))
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			errs = append(errs, fmt.Errorf("refusing to delete %s outside of download directory", filePath))
+			continue
+		}
+
+		for _, name := range []string{path, path + partFileSuffix} {
+			if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+		for dir := filepath.Dir(path); dir != root; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	// 从最深的目录开始删除，非空目录会删除失败，忽略即可
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, dir := range sorted {
+		os.Remove(dir)
+	}
+
+	return errors.Join(errs...)
+}
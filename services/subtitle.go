@@ -0,0 +1,179 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"magnet-webdav/models"
+	"magnet-webdav/subtitle"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// subtitleReadTimeout 读取字幕文件的超时，字幕通常只占一两个分片
+	subtitleReadTimeout = 30 * time.Second
+	// maxSubtitleSize 转换的字幕文件的最大大小
+	maxSubtitleSize = 16 << 20
+	// subtitleCacheSize 缓存的转换结果数
+	subtitleCacheSize = 64
+)
+
+// WebVTTFile 由种子中的 SRT/ASS 字幕生成的虚拟 .vtt 文件
+type WebVTTFile struct {
+	Path   string
+	Source *models.File
+}
+
+// WebVTTPath 字幕文件对应的虚拟 .vtt 文件路径
+func WebVTTPath(filePath string) string {
+	return strings.TrimSuffix(filePath, path.Ext(filePath)) + ".vtt"
+}
+
+// WebVTTFiles 返回文件列表对应的虚拟 .vtt 文件。种子中已有同名文件时不生成，
+// 多个字幕对应同一路径时（例如 a.srt 和 a.ass）使用文件序号最小的
+func WebVTTFiles(files []models.File) []WebVTTFile {
+	existing := make(map[string]bool, len(files))
+	for _, file := range files {
+		existing[file.FilePath] = true
+	}
+
+	var result []WebVTTFile
+	for i := range files {
+		if subtitle.FormatOf(files[i].FilePath) == "" {
+			continue
+		}
+		vttPath := WebVTTPath(files[i].FilePath)
+		if existing[vttPath] {
+			continue
+		}
+		existing[vttPath] = true
+		result = append(result, WebVTTFile{Path: vttPath, Source: &files[i]})
+	}
+	return result
+}
+
+// SubtitleService 把字幕转换为 WebVTT 并缓存最近的结果
+type SubtitleService struct {
+	torrentService *TorrentService
+	cache          map[string][]byte
+	order          []string // 缓存的写入顺序，超过 subtitleCacheSize 时淘汰最早的
+	mutex          sync.Mutex
+}
+
+func NewSubtitleService(torrentService *TorrentService) *SubtitleService {
+	return &SubtitleService{
+		torrentService: torrentService,
+		cache:          make(map[string][]byte),
+	}
+}
+
+// FindWebVTT 查找虚拟 .vtt 文件对应的字幕，vttPath 不是虚拟文件时返回 ErrNotFound。调用方负责权限检查
+func (s *SubtitleService) FindWebVTT(magnetID, vttPath string) (*WebVTTFile, error) {
+	if strings.ToLower(path.Ext(vttPath)) != ".vtt" {
+		return nil, ErrNotFound
+	}
+	var files []models.File
+	if err := s.torrentService.DB().Where("magnet_id = ?", magnetID).Order("file_index").Find(&files).Error; err != nil {
+		return nil, err
+	}
+	for _, vtt := range WebVTTFiles(files) {
+		if vtt.Path == vttPath {
+			return &vtt, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// WebVTT 返回转换后的内容
+func (s *SubtitleService) WebVTT(vtt *WebVTTFile) ([]byte, error) {
+	source := vtt.Source
+	key := subtitleCacheKey(source)
+	if data, ok := s.cached(key); ok {
+		return data, nil
+	}
+	if source.FileSize > maxSubtitleSize {
+		return nil, fmt.Errorf("%w: subtitle %s is larger than %d bytes", ErrInvalidInput, source.FileName, maxSubtitleSize)
+	}
+
+	_, reader, err := s.torrentService.GetFileStream(source.MagnetID, source.FilePath, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotReady, err)
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), subtitleReadTimeout)
+	defer cancel()
+	reader.SetContext(ctx)
+
+	var buf bytes.Buffer
+	err = subtitle.ToWebVTT(&buf, io.LimitReader(reader, source.FileSize), subtitle.FormatOf(source.FilePath))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: reading %s timed out", ErrNotReady, source.FileName)
+		}
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	s.store(key, data)
+	return data, nil
+}
+
+// WebVTTSize 返回虚拟文件的大小。没有缓存时，字幕已下载完成则立即转换，
+// 否则返回字幕文件本身的大小作为估计值
+func (s *SubtitleService) WebVTTSize(vtt *WebVTTFile) int64 {
+	if data, ok := s.cached(subtitleCacheKey(vtt.Source)); ok {
+		return int64(len(data))
+	}
+	if s.downloaded(vtt.Source) {
+		if data, err := s.WebVTT(vtt); err == nil {
+			return int64(len(data))
+		}
+	}
+	return vtt.Source.FileSize
+}
+
+// downloaded 判断文件是否已全部下载
+func (s *SubtitleService) downloaded(file *models.File) bool {
+	torr := s.torrentService.GetTorrent(file.MagnetID)
+	if torr == nil || torr.Info() == nil {
+		return false
+	}
+	for _, f := range torr.Files() {
+		if f.Path() == file.FilePath {
+			return f.BytesCompleted() == f.Length()
+		}
+	}
+	return false
+}
+
+// subtitleCacheKey 文件内容变化时（重新同步后 UpdatedAt 改变）使用新的缓存
+func subtitleCacheKey(file *models.File) string {
+	return file.MagnetID + "/" + file.FilePath + "@" + file.UpdatedAt.Format(time.RFC3339Nano)
+}
+
+func (s *SubtitleService) cached(key string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.cache[key]
+	return data, ok
+}
+
+func (s *SubtitleService) store(key string, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.cache[key]; ok {
+		return
+	}
+	s.cache[key] = data
+	s.order = append(s.order, key)
+	for len(s.order) > subtitleCacheSize {
+		delete(s.cache, s.order[0])
+		s.order = s.order[1:]
+	}
+}
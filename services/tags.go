@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+
+	"gorm.io/gorm"
+)
+
+// TagCount 标签以及当前用户可见的使用次数
+type TagCount struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// ListTags 列出当前用户可见的条目上使用的标签
+func (s *TorrentService) ListTags(user *config.UserConfig) ([]TagCount, error) {
+	tags := []TagCount{}
+	err := s.VisibleEntries(user).
+		Select("tags.id AS id, tags.name AS name, COUNT(*) AS count").
+		Joins("JOIN entry_tags ON entry_tags.entry_id = library_entries.id").
+		Joins("JOIN tags ON tags.id = entry_tags.tag_id").
+		Group("tags.id, tags.name").
+		Order("tags.name").
+		Scan(&tags).Error
+	return tags, err
+}
+
+// RenameTag 重命名标签，所有使用该标签的条目都会受影响
+func (s *TorrentService) RenameTag(id uint, name string) (*models.Tag, error) {
+	name, err := validateLabel("tag", name)
+	if err != nil {
+		return nil, err
+	}
+
+	var tag models.Tag
+	err = s.db.Where("id = ?", id).First(&tag).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.Tag{}).Where("name = ? AND id <> ?", name, id).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("%w: tag %q already exists", ErrInvalidInput, name)
+	}
+
+	if err := s.db.Model(&tag).Update("name", name).Error; err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// DeleteTag 删除标签并从所有条目上移除
+func (s *TorrentService) DeleteTag(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", id).Delete(&models.Tag{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Where("tag_id = ?", id).Delete(&models.EntryTag{}).Error
+	})
+}
+
+// MagnetsWithTag 返回带有指定标签的全部可见磁力链接，不分页，用于 WebDAV 目录
+func (s *TorrentService) MagnetsWithTag(user *config.UserConfig, tag string) ([]models.LibraryMagnet, error) {
+	magnets := []models.LibraryMagnet{}
+	err := s.filterMagnets(s.VisibleMagnets(user), MagnetQuery{Tags: []string{tag}}).
+		Order("display_name, library_entries.id").
+		Scan(&magnets).Error
+	return magnets, err
+}
+
+// EntryTags 批量返回条目的标签名称，按名称排序
+func (s *TorrentService) EntryTags(entryIDs []uint) (map[uint][]string, error) {
+	tags := make(map[uint][]string, len(entryIDs))
+	if len(entryIDs) == 0 {
+		return tags, nil
+	}
+
+	var rows []struct {
+		EntryID uint
+		Name    string
+	}
+	err := s.db.Model(&models.Tag{}).
+		Select("entry_tags.entry_id AS entry_id, tags.name AS name").
+		Joins("JOIN entry_tags ON entry_tags.tag_id = tags.id").
+		Where("entry_tags.entry_id IN ?", entryIDs).
+		Order("tags.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		tags[row.EntryID] = append(tags[row.EntryID], row.Name)
+	}
+	return tags, nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"magnet-webdav/config"
+	"magnet-webdav/metrics"
 	"magnet-webdav/models"
 	"path"
 	"regexp"
@@ -27,6 +28,12 @@ type TorrentService struct {
 	mutex          sync.RWMutex
 	ctx            context.Context
 	cancel         context.CancelFunc
+	readers        *readerTracker
+	pending        sync.WaitGroup // 尚未完成的后台写库任务
+	metrics        *torrentMetrics
+	rates          *rateTracker
+	events         *EventBus
+	fullText       fullTextIndex // 搜索使用的全文索引，Start 时建立
 }
 
 func NewTorrentService(cfg *config.Config, db *gorm.DB) *TorrentService {
@@ -37,6 +44,10 @@ func NewTorrentService(cfg *config.Config, db *gorm.DB) *TorrentService {
 		activeTorrents: make(map[string]*torrent.Torrent),
 		ctx:            ctx,
 		cancel:         cancel,
+		readers:        newReaderTracker(),
+		metrics:        newTorrentMetrics(),
+		rates:          newRateTracker(),
+		events:         NewEventBus(),
 	}
 }
 
@@ -55,11 +66,20 @@ func (s *TorrentService) Start() error {
 
 	s.client = client
 
+	s.setupFullTextSearch()
+
+	// 为旧数据补建媒体库条目
+	if err := s.backfillLibraryEntries(); err != nil {
+		log.Printf("Failed to backfill library entries: %v", err)
+	}
+
 	// 恢复之前活跃的种子
 	if err := s.restoreActiveTorrents(); err != nil {
 		log.Printf("Failed to restore active torrents: %v", err)
 	}
 
+	go s.enforceSeedingPolicies()
+
 	log.Println("Torrent service started")
 	return nil
 }
@@ -70,6 +90,10 @@ func (s *TorrentService) Start() error {
 func (s *TorrentService) Stop() {
 	s.cancel()
 
+	// 等待后台统计写入完成并保存种子状态
+	s.pending.Wait()
+	s.PersistState()
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -85,51 +109,84 @@ func (s *TorrentService) Stop() {
 	log.Println("Torrent service stopped")
 }
 
-func (s *TorrentService) AddMagnet(magnetURI string) (*models.Magnet, error) {
+// AddMagnet 添加磁力链接到用户的媒体库，相同 infoHash 的种子只会添加一次
+func (s *TorrentService) AddMagnet(user *config.UserConfig, magnetURI, name string) (*models.LibraryMagnet, error) {
 	infoHash := s.extractInfoHash(magnetURI)
 
 	// 检查是否已存在
 	var existingMagnet models.Magnet
-	if err := s.db.Where("id = ?", infoHash).First(&existingMagnet).Error; err == nil {
-		return &existingMagnet, nil
-	}
+	if err := s.db.Where("id = ?", infoHash).First(&existingMagnet).Error; err != nil {
+		// 创建新的磁力记录
+		magnet := &models.Magnet{
+			ID:        infoHash,
+			MagnetURI: magnetURI,
+			Status:    "pending",
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+
+		if err := s.db.Create(magnet).Error; err != nil {
+			return nil, fmt.Errorf("failed to create magnet record: %w", err)
+		}
 
-	// 创建新的磁力记录
-	magnet := &models.Magnet{
-		ID:        infoHash,
-		MagnetURI: magnetURI,
-		Status:    "pending",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		// 添加到 torrent 客户端
+		go s.addTorrentToClient(magnetURI, infoHash)
 	}
 
-	if err := s.db.Create(magnet).Error; err != nil {
-		return nil, fmt.Errorf("failed to create magnet record: %w", err)
+	// 每个用户拥有独立的媒体库条目
+	owner := s.ownerName(user)
+	if _, err := s.ensureLibraryEntry(infoHash, owner, name); err != nil {
+		return nil, err
 	}
 
-	// 添加到 torrent 客户端
-	go s.addTorrentToClient(magnetURI, infoHash)
+	magnet, err := s.GetLibraryMagnet(user, infoHash)
+	if err != nil {
+		return nil, err
+	}
 
+	s.events.Publish(Event{
+		Type:     EventMagnetAdded,
+		MagnetID: infoHash,
+		Owner:    owner,
+		Data:     map[string]interface{}{"name": magnet.DisplayName, "status": magnet.Status},
+	})
 	return magnet, nil
 }
 
 func (s *TorrentService) addTorrentToClient(magnetURI, infoHash string) {
-	torr, err := s.client.AddMagnet(magnetURI)
+	torr, err := s.addToClient(magnetURI, infoHash)
 	if err != nil {
 		log.Printf("Failed to add magnet: %v", err)
 		s.updateMagnetStatus(infoHash, "error", err.Error())
 		return
 	}
 
+	// 添加期间磁力记录可能已被 RemoveMagnet 删除，那时它找不到要移除的种子。
+	// RemoveMagnet 删除记录后才获取锁移除种子，所以持有锁检查记录就不会漏掉
 	s.mutex.Lock()
+	var count int64
+	if err := s.db.Model(&models.Magnet{}).Where("id = ?", infoHash).Count(&count).Error; err == nil && count == 0 {
+		s.mutex.Unlock()
+		log.Printf("Magnet %s was removed while being added, dropping torrent", infoHash)
+		torr.Drop()
+		return
+	}
 	s.activeTorrents[infoHash] = torr
 	s.mutex.Unlock()
 
 	// 等待元数据
+	waitStart := time.Now()
 	select {
 	case <-torr.GotInfo():
+		metrics.MetadataWait.Observe(time.Since(waitStart).Seconds(), "ok")
+		s.publish(EventMetadataReceived, infoHash, map[string]interface{}{
+			"name":       torr.Name(),
+			"total_size": torr.Length(),
+			"file_count": len(torr.Files()),
+		})
 		s.handleTorrentReady(torr, infoHash)
 	case <-time.After(30 * time.Second):
+		metrics.MetadataWait.Observe(time.Since(waitStart).Seconds(), "timeout")
 		log.Printf("Timeout waiting for metadata: %s", infoHash)
 		s.updateMagnetStatus(infoHash, "error", "metadata timeout")
 	case <-s.ctx.Done():
@@ -143,7 +200,6 @@ func (s *TorrentService) handleTorrentReady(torr *torrent.Torrent, infoHash stri
 		"name":       torr.Name(),
 		"total_size": torr.Length(),
 		"file_count": len(torr.Files()),
-		"status":     "ready",
 		"updated_at": time.Now(),
 	}
 
@@ -152,6 +208,21 @@ func (s *TorrentService) handleTorrentReady(torr *torrent.Torrent, infoHash stri
 		return
 	}
 
+	// 重启后恢复的种子已经是 ready，只在状态实际变化时发布事件，避免重复投递 Webhook 和通知。
+	// 事件在文件记录同步完成后发布，订阅者收到时可以直接读取文件列表
+	result := s.db.Model(&models.Magnet{}).Where("id = ? AND status <> ?", infoHash, "ready").Update("status", "ready")
+	if result.Error != nil {
+		log.Printf("Failed to update magnet status: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		defer s.publish(EventStatusChanged, infoHash, map[string]interface{}{"status": "ready"})
+	}
+	s.refreshSeedingPolicy(infoHash)
+	if err := s.saveMetainfo(infoHash, torr); err != nil {
+		log.Printf("Failed to save torrent file of %s: %v", infoHash, err)
+	}
+
 	// 获取现有的文件记录
 	var existingFiles []models.File
 	if err := s.db.Where("magnet_id = ?", infoHash).Find(&existingFiles).Error; err != nil {
@@ -267,6 +338,13 @@ func (s *TorrentService) handleTorrentReady(torr *torrent.Torrent, infoHash stri
 	log.Printf("  Total files: %d", len(torr.Files()))
 	log.Printf("  Created: %d, Updated: %d, Deleted: %d",
 		len(filesToCreate), len(filesToUpdate), len(existingFileMap))
+
+	s.publish(EventFilesSynced, infoHash, map[string]interface{}{
+		"total":   len(torr.Files()),
+		"created": len(filesToCreate),
+		"updated": len(filesToUpdate),
+		"deleted": len(existingFileMap),
+	})
 }
 
 func (s *TorrentService) GetTorrent(infoHash string) *torrent.Torrent {
@@ -308,14 +386,67 @@ func (s *TorrentService) GetFileStream(infoHash, filePath string, start, end int
 	}
 
 	// 更新访问统计
-	go s.updateAccessStats(infoHash)
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		s.updateAccessStats(infoHash)
+	}()
 
 	reader := targetFile.NewReader()
 	if start > 0 {
 		reader.Seek(start, 0)
 	}
 
-	return targetFile, reader, nil
+	// 统计打开的读取器，关闭服务前需要等待它们结束
+	s.readers.acquire(infoHash)
+	s.publish(EventStreamStarted, infoHash, map[string]interface{}{
+		"file":         filePath,
+		"offset":       start,
+		"open_readers": s.readers.count(infoHash),
+	})
+	tracked := &trackedReader{
+		Reader: reader,
+		release: func() {
+			s.readers.release(infoHash)
+			s.publish(EventStreamStopped, infoHash, map[string]interface{}{
+				"file":         filePath,
+				"open_readers": s.readers.count(infoHash),
+			})
+		},
+	}
+
+	return targetFile, tracked, nil
+}
+
+// OpenReaders 返回指定种子打开的读取器数量，infoHash 为空时返回总数
+func (s *TorrentService) OpenReaders(infoHash string) int {
+	return s.readers.count(infoHash)
+}
+
+// WaitForReaders 等待读取器全部关闭，infoHash 为空时等待所有种子
+func (s *TorrentService) WaitForReaders(ctx context.Context, infoHash string) error {
+	return s.readers.wait(ctx, infoHash)
+}
+
+// PersistState 把活跃种子的下载进度写入数据库
+func (s *TorrentService) PersistState() {
+	s.mutex.RLock()
+	progress := make(map[string]int64, len(s.activeTorrents))
+	for infoHash, torr := range s.activeTorrents {
+		if torr.Info() != nil {
+			progress[infoHash] = torr.BytesCompleted()
+		}
+	}
+	s.mutex.RUnlock()
+
+	for infoHash, completed := range progress {
+		if err := s.db.Model(&models.Magnet{}).Where("id = ?", infoHash).
+			Update("bytes_completed", completed).Error; err != nil {
+			log.Printf("Failed to persist torrent state %s: %v", infoHash, err)
+		}
+	}
+
+	log.Printf("Persisted state of %d torrents", len(progress))
 }
 
 
@@ -345,6 +476,7 @@ func (s *TorrentService) getMimeType(filename string) string {
 		".png":  "image/png",
 		".srt":  "text/plain",
 		".ass":  "text/plain",
+		".vtt":  "text/vtt",
 	}
 
 	if mime, exists := mimeTypes[ext]; exists {
@@ -362,7 +494,21 @@ func (s *TorrentService) updateMagnetStatus(infoHash, status, errorMsg string) {
 		updateData["name"] = errorMsg
 	}
 
-	s.db.Model(&models.Magnet{}).Where("id = ?", infoHash).Updates(updateData)
+	result := s.db.Model(&models.Magnet{}).Where("id = ?", infoHash).Updates(updateData)
+	if result.Error != nil {
+		log.Printf("Failed to update magnet status: %v", result.Error)
+		return
+	}
+	// 磁力记录已被删除时不再发布事件
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	data := map[string]interface{}{"status": status}
+	if errorMsg != "" {
+		data["error"] = errorMsg
+	}
+	s.publish(EventStatusChanged, infoHash, data)
 }
 
 func (s *TorrentService) updateAccessStats(infoHash string) {
@@ -375,7 +521,8 @@ func (s *TorrentService) updateAccessStats(infoHash string) {
 
 func (s *TorrentService) restoreActiveTorrents() error {
 	var magnets []models.Magnet
-	if err := s.db.Where("status = ?", "ready").Find(&magnets).Error; err != nil {
+	// 导入后尚未开始下载的种子同样需要恢复
+	if err := s.db.Where("status IN ?", []string{"ready", "pending"}).Find(&magnets).Error; err != nil {
 		return err
 	}
 
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"magnet-webdav/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// torznabResultLimit 每次搜索向索引器请求的结果数
+const torznabResultLimit = 100
+
+// TorznabProvider Torznab/Newznab 搜索源，例如 Jackett、Prowlarr 或支持 Torznab 的站点
+type TorznabProvider struct {
+	name       string
+	baseURL    *url.URL
+	apiKey     string
+	categories []int
+	userAgent  string
+	client     *http.Client
+}
+
+func NewTorznabProvider(cfg config.SearchProviderConfig, timeout time.Duration, userAgent string) *TorznabProvider {
+	// 配置加载时已经校验过地址
+	baseURL, _ := url.Parse(strings.TrimRight(cfg.URL, "/"))
+	return &TorznabProvider{
+		name:       cfg.Name,
+		baseURL:    baseURL,
+		apiKey:     cfg.APIKey,
+		categories: cfg.Categories,
+		userAgent:  userAgent,
+		client:     &http.Client{Timeout: timeout, CheckRedirect: stopAtMagnetRedirect},
+	}
+}
+
+func (p *TorznabProvider) Name() string {
+	return p.name
+}
+
+// Search 调用 <url>/api?t=search。结果中的 seeders 和 peers 来自 torznab 属性，没有磁力链接和 .torrent 地址的结果会被忽略
+func (p *TorznabProvider) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	params := url.Values{}
+	params.Set("t", "search")
+	params.Set("q", query)
+	params.Set("extended", "1")
+	params.Set("limit", strconv.Itoa(torznabResultLimit))
+	if p.apiKey != "" {
+		params.Set("apikey", p.apiKey)
+	}
+	if len(p.categories) > 0 {
+		cats := make([]string, 0, len(p.categories))
+		for _, cat := range p.categories {
+			cats = append(cats, strconv.Itoa(cat))
+		}
+		params.Set("cat", strings.Join(cats, ","))
+	}
+
+	data, _, err := fetchURL(ctx, p.client, p.userAgent, p.baseURL.String()+"/api?"+params.Encode(), maxFeedSize)
+	if err != nil {
+		// 错误信息中的地址包含 apikey
+		return nil, p.redact(err)
+	}
+	entries, err := ParseFeed(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(entries))
+	for _, entry := range entries {
+		if entry.MagnetURI == "" && entry.TorrentURL == "" {
+			continue
+		}
+		leechers := -1
+		if entry.Peers >= 0 && entry.Seeders >= 0 && entry.Peers >= entry.Seeders {
+			leechers = entry.Peers - entry.Seeders
+		}
+		results = append(results, SearchResult{
+			Title:       entry.Title,
+			MagnetURI:   entry.MagnetURI,
+			TorrentURL:  entry.TorrentURL,
+			Size:        entry.Size,
+			Seeders:     entry.Seeders,
+			Leechers:    leechers,
+			PublishedAt: entry.PublishedAt,
+		})
+	}
+	return results, nil
+}
+
+// DownloadTorrent 下载结果中的 .torrent 文件，只允许和索引器相同的主机
+func (p *TorznabProvider) DownloadTorrent(ctx context.Context, rawURL string) ([]byte, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != p.baseURL.Scheme || u.Host != p.baseURL.Host {
+		return nil, "", fmt.Errorf("%w: torrent_url does not belong to provider %s", ErrInvalidInput, p.name)
+	}
+	data, magnetURI, err := fetchURL(ctx, p.client, p.userAgent, rawURL, maxTorrentFileSize)
+	if err != nil {
+		return nil, "", p.redact(err)
+	}
+	return data, magnetURI, nil
+}
+
+// redact 去掉错误信息中的 API 密钥
+func (p *TorznabProvider) redact(err error) error {
+	if p.apiKey == "" || !strings.Contains(err.Error(), p.apiKey) {
+		return err
+	}
+	return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), p.apiKey, "***"))
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"magnet-webdav/config"
+	"magnet-webdav/models"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Webhook 事件
+const (
+	WebhookMagnetAdded   = "magnet.added"
+	WebhookMagnetReady   = "magnet.ready"
+	WebhookMagnetFailed  = "magnet.failed"
+	WebhookFilesSynced   = "magnet.files_synced"
+	WebhookMagnetRemoved = "magnet.removed"
+)
+
+var webhookEvents = map[string]bool{
+	WebhookMagnetAdded:   true,
+	WebhookMagnetReady:   true,
+	WebhookMagnetFailed:  true,
+	WebhookFilesSynced:   true,
+	WebhookMagnetRemoved: true,
+}
+
+// 投递状态
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	// webhookPollInterval 没有新事件时检查到期重试的间隔
+	webhookPollInterval = 5 * time.Second
+	// webhookBatchSize 每轮最多投递的记录数
+	webhookBatchSize = 20
+	// webhookEventBuffer Webhook 订阅事件总线的缓冲区
+	webhookEventBuffer = 1024
+	// maxResponseLog 记录到 last_error 中的响应体长度
+	maxResponseLog = 256
+)
+
+// WebhookPayload Webhook 请求体
+type WebhookPayload struct {
+	Event     string                 `json:"event"`
+	Timestamp time.Time              `json:"timestamp"`
+	MagnetID  string                 `json:"magnet_id"`
+	Owner     string                 `json:"owner,omitempty"`
+	Magnet    *models.Magnet         `json:"magnet,omitempty"`
+	Files     []models.File          `json:"files,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// WebhookEndpointInfo 对外展示的端点信息，不包含密钥
+type WebhookEndpointInfo struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Signed bool     `json:"signed"`
+}
+
+// DeliveryFilter 查询投递记录的条件
+type DeliveryFilter struct {
+	Status   string
+	Endpoint string
+	Event    string
+	Limit    int
+}
+
+// WebhookService 把媒体库事件投递到配置的 Webhook 端点
+type WebhookService struct {
+	cfg       *config.WebhookConfig
+	userAgent string
+	db        *gorm.DB
+	events    *EventBus
+	client    *http.Client
+	endpoints map[string]config.WebhookEndpoint
+	wake      chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewWebhookService(cfg *config.Config, torrentService *TorrentService) (*WebhookService, error) {
+	endpoints := make(map[string]config.WebhookEndpoint, len(cfg.Webhook.Endpoints))
+	for _, endpoint := range cfg.Webhook.Endpoints {
+		for _, event := range endpoint.Events {
+			if !webhookEvents[event] {
+				return nil, fmt.Errorf("webhook endpoint %s: unknown event %s", endpoint.Name, event)
+			}
+		}
+		endpoints[endpoint.Name] = endpoint
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookService{
+		cfg:       &cfg.Webhook,
+		userAgent: cfg.Torrent.UserAgent,
+		db:        torrentService.DB(),
+		events:    torrentService.Events(),
+		client:    &http.Client{Timeout: cfg.Webhook.Timeout},
+		endpoints: endpoints,
+		wake:      make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// Start 订阅事件并启动投递循环，重启前未完成的投递会继续重试
+func (w *WebhookService) Start() {
+	sub := w.events.SubscribeBuffered(webhookEventBuffer)
+
+	w.wg.Add(2)
+	go func() {
+		defer w.wg.Done()
+		defer sub.Close()
+		for {
+			select {
+			case event := <-sub.C:
+				w.enqueue(event)
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		defer w.wg.Done()
+		w.run()
+	}()
+
+	if len(w.endpoints) > 0 {
+		log.Printf("Webhook service started with %d endpoints", len(w.endpoints))
+	}
+}
+
+// Stop 停止投递，进行中的请求会被取消并在下次启动时重试
+func (w *WebhookService) Stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// Endpoints 返回配置的端点
+func (w *WebhookService) Endpoints() []WebhookEndpointInfo {
+	infos := make([]WebhookEndpointInfo, 0, len(w.cfg.Endpoints))
+	for _, endpoint := range w.cfg.Endpoints {
+		events := endpoint.Events
+		if len(events) == 0 {
+			events = []string{"*"}
+		}
+		infos = append(infos, WebhookEndpointInfo{
+			Name:   endpoint.Name,
+			URL:    endpoint.URL,
+			Events: events,
+			Signed: endpoint.Secret != "",
+		})
+	}
+	return infos
+}
+
+// ListDeliveries 按创建时间倒序列出投递记录
+func (w *WebhookService) ListDeliveries(filter DeliveryFilter) ([]models.WebhookDelivery, error) {
+	query := w.db.Order("id DESC")
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Endpoint != "" {
+		query = query.Where("endpoint = ?", filter.Endpoint)
+	}
+	if filter.Event != "" {
+		query = query.Where("event = ?", filter.Event)
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	if err := query.Limit(filter.Limit).Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetDelivery 获取单条投递记录
+func (w *WebhookService) GetDelivery(id uint) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := w.db.First(&delivery, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// RetryDelivery 把投递记录重新放回队列并重置尝试次数
+func (w *WebhookService) RetryDelivery(id uint) (*models.WebhookDelivery, error) {
+	delivery, err := w.GetDelivery(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.db.Model(delivery).Updates(map[string]interface{}{
+		"status":          DeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+	w.notify()
+	return w.GetDelivery(id)
+}
+
+// webhookEvent 把事件总线上的事件转换为 Webhook 事件
+func webhookEvent(event Event) (string, bool) {
+	switch event.Type {
+	case EventMagnetAdded:
+		return WebhookMagnetAdded, true
+	case EventFilesSynced:
+		return WebhookFilesSynced, true
+	case EventMagnetRemoved:
+		return WebhookMagnetRemoved, true
+	case EventStatusChanged:
+		switch event.Data["status"] {
+		case "ready":
+			return WebhookMagnetReady, true
+		case "error":
+			return WebhookMagnetFailed, true
+		}
+	}
+	return "", false
+}
+
+// subscribed 端点是否订阅了该事件
+func subscribed(endpoint config.WebhookEndpoint, event string) bool {
+	if len(endpoint.Events) == 0 {
+		return true
+	}
+	for _, name := range endpoint.Events {
+		if name == event {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue 为订阅了该事件的每个端点写入一条待投递记录
+func (w *WebhookService) enqueue(event Event) {
+	name, ok := webhookEvent(event)
+	if !ok {
+		return
+	}
+
+	var targets []config.WebhookEndpoint
+	for _, endpoint := range w.cfg.Endpoints {
+		if subscribed(endpoint, name) {
+			targets = append(targets, endpoint)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	payload, err := w.buildPayload(name, event)
+	if err != nil {
+		log.Printf("Failed to build webhook payload for %s: %v", name, err)
+		return
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, 0, len(targets))
+	for _, endpoint := range targets {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			Endpoint:      endpoint.Name,
+			URL:           endpoint.URL,
+			Event:         name,
+			MagnetID:      event.MagnetID,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+		})
+	}
+	if err := w.db.Create(&deliveries).Error; err != nil {
+		log.Printf("Failed to queue webhook deliveries: %v", err)
+		return
+	}
+	w.notify()
+}
+
+// buildPayload 生成请求体，磁力链接和文件信息在入队时读取，重试时内容保持不变
+func (w *WebhookService) buildPayload(name string, event Event) ([]byte, error) {
+	payload := WebhookPayload{
+		Event:     name,
+		Timestamp: event.Time,
+		MagnetID:  event.MagnetID,
+		Owner:     event.Owner,
+		Data:      event.Data,
+	}
+
+	// 删除事件发生时磁力记录可能已经不存在
+	if name != WebhookMagnetRemoved {
+		var magnet models.Magnet
+		if err := w.db.Where("id = ?", event.MagnetID).First(&magnet).Error; err == nil {
+			payload.Magnet = &magnet
+		}
+	}
+	if name == WebhookMagnetReady || name == WebhookFilesSynced {
+		if err := w.db.Where("magnet_id = ?", event.MagnetID).Order("file_index").Find(&payload.Files).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(payload)
+}
+
+func (w *WebhookService) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 投递循环：有新记录时立即投递，否则定期检查到期的重试
+func (w *WebhookService) run() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+	w.cleanup()
+
+	for {
+		w.deliverDue()
+
+		select {
+		case <-w.wake:
+		case <-ticker.C:
+		case <-cleanup.C:
+			w.cleanup()
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *WebhookService) deliverDue() {
+	for w.ctx.Err() == nil {
+		var deliveries []models.WebhookDelivery
+		if err := w.db.Where("status = ? AND next_attempt_at <= ?", DeliveryPending, time.Now()).
+			Order("id").Limit(webhookBatchSize).Find(&deliveries).Error; err != nil {
+			log.Printf("Failed to load webhook deliveries: %v", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+
+		for i := range deliveries {
+			if w.ctx.Err() != nil {
+				return
+			}
+			w.deliver(&deliveries[i])
+		}
+	}
+}
+
+// deliver 发送一次请求并更新投递记录
+func (w *WebhookService) deliver(delivery *models.WebhookDelivery) {
+	endpoint, ok := w.endpoints[delivery.Endpoint]
+	if !ok {
+		// 端点已从配置中删除，不再重试
+		w.db.Model(delivery).Updates(map[string]interface{}{
+			"status":     DeliveryFailed,
+			"last_error": "endpoint no longer configured",
+		})
+		return
+	}
+
+	code, err := w.send(endpoint, delivery)
+	if w.ctx.Err() != nil {
+		// 服务关闭导致的失败不计入尝试次数
+		return
+	}
+
+	updates := map[string]interface{}{
+		"attempts":      delivery.Attempts + 1,
+		"response_code": code,
+	}
+	if err == nil {
+		now := time.Now()
+		updates["status"] = DeliverySucceeded
+		updates["last_error"] = ""
+		updates["delivered_at"] = &now
+	} else {
+		attempts := delivery.Attempts + 1
+		updates["last_error"] = truncate(err.Error(), 1024)
+		if attempts >= w.cfg.MaxAttempts {
+			updates["status"] = DeliveryFailed
+			log.Printf("Webhook delivery %d to %s failed after %d attempts: %v", delivery.ID, endpoint.Name, attempts, err)
+		} else {
+			updates["next_attempt_at"] = time.Now().Add(w.backoff(attempts))
+		}
+	}
+
+	if err := w.db.Model(delivery).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// send 发送请求，2xx 视为成功
+func (w *WebhookService) send(endpoint config.WebhookEndpoint, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", w.userAgent)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if endpoint.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(endpoint.Secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseLog))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(snippet))
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook 计算签名：HMAC-SHA256(secret, timestamp + "." + body)，接收方用同样方式校验
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff 第 n 次失败后的等待时间，指数增长并加入最多 10% 的随机抖动
+func (w *WebhookService) backoff(attempts int) time.Duration {
+	delay := w.cfg.RetryMax
+	if shift := attempts - 1; shift < 32 {
+		if d := w.cfg.RetryBase << shift; d > 0 && d < w.cfg.RetryMax {
+			delay = d
+		}
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/10+1))
+}
+
+// cleanup 删除超过保留时间的已完成投递记录
+func (w *WebhookService) cleanup() {
+	cutoff := time.Now().Add(-w.cfg.Retention)
+	result := w.db.Where("status <> ? AND created_at < ?", DeliveryPending, cutoff).Delete(&models.WebhookDelivery{})
+	if result.Error != nil {
+		log.Printf("Failed to clean up webhook deliveries: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("Cleaned up %d old webhook deliveries", result.RowsAffected)
+	}
+}
+
+// truncate 截断到最多 n 个字节，不会截断多字节字符
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,122 @@
+package subtitle
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// assOverride 覆盖标签块，包括样式、位置和特效，也用于写注释
+	assOverride = regexp.MustCompile(`\{[^}]*\}`)
+	// assDrawing 绘图模式，开启后文本是矢量路径而不是字幕
+	assDrawing = regexp.MustCompile(`\\p[1-9]`)
+)
+
+// assDefaultFormat [Events] 中没有 Format 行时使用的 ASS 默认字段
+var assDefaultFormat = []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+
+type assCue struct {
+	start, end time.Duration
+	text       string
+}
+
+// convertASS 读取 [Events] 中的 Dialogue 并按开始时间输出，样式和覆盖标签全部去掉
+func convertASS(w *bufio.Writer, r io.Reader) error {
+	lines := newLineScanner(r)
+	format := assDefaultFormat
+	section := ""
+	var cues []assCue
+
+	for {
+		line, ok := lines.Next()
+		if !ok {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(line)
+			continue
+		}
+		if section != "[events]" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Format":
+			fields := strings.Split(value, ",")
+			format = make([]string, len(fields))
+			for i, field := range fields {
+				format[i] = strings.ToLower(strings.TrimSpace(field))
+			}
+		case "Dialogue":
+			// Text 总是最后一个字段，其中可以包含逗号
+			values := strings.SplitN(value, ",", len(format))
+			if len(values) < len(format) {
+				continue
+			}
+			var cue assCue
+			var startOK, endOK bool
+			for i, name := range format {
+				switch name {
+				case "start":
+					cue.start, startOK = parseTimestamp(values[i])
+				case "end":
+					cue.end, endOK = parseTimestamp(values[i])
+				case "text":
+					cue.text = escapeText(assPlainText(values[i]))
+				}
+			}
+			if startOK && endOK && cue.text != "" {
+				cues = append(cues, cue)
+			}
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].start < cues[j].start
+	})
+	for _, cue := range cues {
+		writeCue(w, cue.start, cue.end, cue.text)
+	}
+	return nil
+}
+
+// MatroskaASSText 取出 Matroska 中 ASS 数据块的纯文本。数据块的字段为
+// ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+func MatroskaASSText(block []byte) string {
+	fields := strings.SplitN(string(block), ",", 9)
+	if len(fields) < 9 {
+		return ""
+	}
+	return assPlainText(fields[8])
+}
+
+// assPlainText 去掉覆盖标签并转换换行，绘图模式的事件返回空字符串
+func assPlainText(s string) string {
+	for _, block := range assOverride.FindAllString(s, -1) {
+		if assDrawing.MatchString(block) {
+			return ""
+		}
+	}
+	s = assOverride.ReplaceAllString(s, "")
+	s = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, "\u00a0").Replace(s)
+
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
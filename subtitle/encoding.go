@@ -0,0 +1,119 @@
+package subtitle
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// detectSampleSize 用于识别编码的样本大小
+const detectSampleSize = 64 << 10
+
+// commonHanzi 简体和繁体中文里的高频字，用于区分 GBK 和 Big5：
+// 用错误的编码解码时大多得到生僻字
+const commonHanzi = "的一是不了在人有我他这這个個们們中来來上大为為和国國地到以说說时時要就出会會可也你对對生能而子那得于於着著下自之年过過发發后後作里裡用道行所然家种種事成方多经經么麼去法学學如都同现現当當没沒动動面起看定天分还還进進好小部其些主样樣理心她本前开開但因只从從想实實吗嗎呢吧啊"
+
+var commonHanziSet = func() map[rune]bool {
+	set := make(map[rune]bool)
+	for _, r := range commonHanzi {
+		set[r] = true
+	}
+	return set
+}()
+
+// NewUTF8Reader 识别字幕的编码并转换为 UTF-8，支持带 BOM 的 UTF-8/UTF-16、
+// 不带 BOM 的 UTF-16，以及中文字幕常见的 GBK（按 GB18030 解码）和 Big5。返回识别出的编码名称
+func NewUTF8Reader(r io.Reader) (io.Reader, string) {
+	br := bufio.NewReaderSize(r, detectSampleSize)
+	sample, _ := br.Peek(detectSampleSize)
+
+	name, enc := detectEncoding(sample)
+	if enc == nil {
+		// UTF-8 需要去掉 BOM
+		if bytes.HasPrefix(sample, []byte("\xEF\xBB\xBF")) {
+			br.Discard(3)
+		}
+		return br, name
+	}
+	return transform.NewReader(br, enc.NewDecoder()), name
+}
+
+// detectEncoding 返回样本的编码，UTF-8 时 encoding 为 nil
+func detectEncoding(sample []byte) (string, encoding.Encoding) {
+	switch {
+	case bytes.HasPrefix(sample, []byte("\xEF\xBB\xBF")):
+		return "utf-8", nil
+	case bytes.HasPrefix(sample, []byte("\xFF\xFE")):
+		return "utf-16le", unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(sample, []byte("\xFE\xFF")):
+		return "utf-16be", unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	}
+
+	// 不带 BOM 的 UTF-16：ASCII 字符的高字节为 0
+	if len(sample) >= 4 {
+		var evenZeros, oddZeros int
+		for i := 0; i+1 < len(sample); i += 2 {
+			if sample[i] == 0 {
+				evenZeros++
+			}
+			if sample[i+1] == 0 {
+				oddZeros++
+			}
+		}
+		pairs := len(sample) / 2
+		switch {
+		case oddZeros > pairs/4 && evenZeros == 0:
+			return "utf-16le", unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+		case evenZeros > pairs/4 && oddZeros == 0:
+			return "utf-16be", unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+		}
+	}
+
+	if validUTF8Prefix(sample) {
+		return "utf-8", nil
+	}
+
+	gbkScore := hanziScore(sample, simplifiedchinese.GB18030)
+	big5Score := hanziScore(sample, traditionalchinese.Big5)
+	if big5Score > gbkScore {
+		return "big5", traditionalchinese.Big5
+	}
+	return "gbk", simplifiedchinese.GB18030
+}
+
+// validUTF8Prefix 样本是否为合法的 UTF-8，样本末尾可能截断在多字节字符中间
+func validUTF8Prefix(sample []byte) bool {
+	for len(sample) > 0 {
+		r, size := utf8.DecodeRune(sample)
+		if r == utf8.RuneError && size <= 1 {
+			return len(sample) < utf8.UTFMax && !utf8.FullRune(sample)
+		}
+		sample = sample[size:]
+	}
+	return true
+}
+
+// hanziScore 用指定编码解码样本，高频字加分，无法解码的字节扣分
+func hanziScore(sample []byte, enc encoding.Encoding) int {
+	decoded, _, err := transform.Bytes(enc.NewDecoder(), sample)
+	if err != nil {
+		return -1 << 30
+	}
+	score := 0
+	for _, r := range string(decoded) {
+		switch {
+		case r == utf8.RuneError:
+			score -= 10
+		case commonHanziSet[r]:
+			score++
+		}
+	}
+	return score
+}
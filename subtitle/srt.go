@@ -0,0 +1,121 @@
+package subtitle
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// srtOverride 部分 SRT 字幕中夹带的 ASS 覆盖标签，例如 {\an8}
+var srtOverride = regexp.MustCompile(`\{\\[^}]*\}`)
+
+// parseSRTTiming 解析 00:00:01,000 --> 00:00:02,500 形式的时间轴，忽略后面的坐标
+func parseSRTTiming(line string) (time.Duration, time.Duration, bool) {
+	from, to, ok := strings.Cut(line, "-->")
+	if !ok {
+		return 0, 0, false
+	}
+	if fields := strings.Fields(to); len(fields) > 0 {
+		to = fields[0]
+	}
+	start, ok := parseTimestamp(from)
+	if !ok {
+		return 0, 0, false
+	}
+	end, ok := parseTimestamp(to)
+	if !ok {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// convertSRT 逐条转换 SRT 字幕。序号行被去掉，缺少空行分隔的字幕也能识别
+func convertSRT(w *bufio.Writer, r io.Reader) error {
+	lines := newLineScanner(r)
+	var start, end time.Duration
+	var text []string
+	inCue := false
+
+	flush := func() {
+		if inCue && len(text) > 0 {
+			writeCue(w, start, end, strings.Join(text, "\n"))
+		}
+		inCue = false
+		text = text[:0]
+	}
+
+	for {
+		line, ok := lines.Next()
+		if !ok {
+			break
+		}
+		line = strings.TrimSpace(line)
+
+		if s, e, ok := parseSRTTiming(line); ok {
+			flush()
+			start, end, inCue = s, e, true
+			continue
+		}
+		if line == "" {
+			flush()
+			continue
+		}
+		if isDigits(line) {
+			if next, ok := lines.Peek(); ok {
+				if _, _, timing := parseSRTTiming(strings.TrimSpace(next)); timing {
+					continue
+				}
+			}
+		}
+		if inCue {
+			if converted := srtText(line); converted != "" {
+				text = append(text, converted)
+			}
+		}
+	}
+	flush()
+	return lines.Err()
+}
+
+// srtText 保留 WebVTT 支持的 <b> <i> <u>，去掉 <font> 和 ASS 覆盖标签，转义其他特殊字符
+func srtText(s string) string {
+	s = srtOverride.ReplaceAllString(s, "")
+
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			b.WriteString(escapeText(s))
+			break
+		}
+		b.WriteString(escapeText(s[:i]))
+		s = s[i:]
+
+		if end := strings.IndexByte(s, '>'); end > 0 {
+			tag := strings.ToLower(strings.TrimSpace(s[1:end]))
+			switch {
+			case tag == "b" || tag == "i" || tag == "u" || tag == "/b" || tag == "/i" || tag == "/u":
+				b.WriteString("<" + tag + ">")
+				s = s[end+1:]
+				continue
+			case tag == "/font" || strings.HasPrefix(tag, "font "):
+				s = s[end+1:]
+				continue
+			}
+		}
+		b.WriteString("&lt;")
+		s = s[1:]
+	}
+	return strings.TrimSpace(b.String())
+}
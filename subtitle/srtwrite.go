@@ -0,0 +1,41 @@
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Cue 一条纯文本字幕
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// WriteSRT 按顺序输出 SRT 字幕，空白的字幕被跳过，文本中的空行被去掉
+func WriteSRT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	n := 0
+	for _, cue := range cues {
+		var lines []string
+		for _, line := range strings.Split(strings.ReplaceAll(cue.Text, "\r\n", "\n"), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		n++
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n\n", n, srtTimestamp(cue.Start), srtTimestamp(cue.End), strings.Join(lines, "\n"))
+	}
+	return bw.Flush()
+}
+
+// srtTimestamp 格式化为 SRT 的 HH:MM:SS,mmm
+func srtTimestamp(d time.Duration) string {
+	return strings.Replace(formatTimestamp(d), ".", ",", 1)
+}
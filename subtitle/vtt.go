@@ -0,0 +1,149 @@
+// Package subtitle 把 SRT 和 ASS/SSA 字幕转换为浏览器 <track> 可以使用的 WebVTT
+package subtitle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支持转换的字幕格式
+const (
+	FormatSRT = "srt"
+	FormatASS = "ass" // 包括 SSA
+)
+
+// maxLineLength 单行字幕的最大长度
+const maxLineLength = 1 << 20
+
+// ErrUnsupported 不支持转换的字幕格式
+var ErrUnsupported = errors.New("unsupported subtitle format")
+
+// FormatOf 根据扩展名返回字幕格式，不支持时返回空字符串
+func FormatOf(filePath string) string {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".srt":
+		return FormatSRT
+	case ".ass", ".ssa":
+		return FormatASS
+	}
+	return ""
+}
+
+// ToWebVTT 识别编码后把字幕转换为 WebVTT 写入 w。SRT 逐行转换；
+// ASS 的事件不一定按时间排列，需要读完 [Events] 后排序输出
+func ToWebVTT(w io.Writer, r io.Reader, format string) error {
+	src, _ := NewUTF8Reader(r)
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n\n")
+
+	var err error
+	switch format {
+	case FormatSRT:
+		err = convertSRT(bw, src)
+	case FormatASS:
+		err = convertASS(bw, src)
+	default:
+		return ErrUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// lineScanner 按行读取并去掉行尾的 \r，支持预读一行
+type lineScanner struct {
+	scanner *bufio.Scanner
+	peeked  *string
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineLength)
+	return &lineScanner{scanner: scanner}
+}
+
+func (s *lineScanner) Next() (string, bool) {
+	if s.peeked != nil {
+		line := *s.peeked
+		s.peeked = nil
+		return line, true
+	}
+	if !s.scanner.Scan() {
+		return "", false
+	}
+	return strings.TrimRight(s.scanner.Text(), "\r"), true
+}
+
+func (s *lineScanner) Peek() (string, bool) {
+	if s.peeked == nil {
+		line, ok := s.Next()
+		if !ok {
+			return "", false
+		}
+		s.peeked = &line
+	}
+	return *s.peeked, true
+}
+
+func (s *lineScanner) Err() error {
+	return s.scanner.Err()
+}
+
+// parseTimestamp 解析 [H:]MM:SS[.,]fff 形式的时间，小数部分可以是 1 到 3 位以上
+func parseTimestamp(s string) (time.Duration, bool) {
+	s = strings.TrimSpace(s)
+	clock, fraction := s, ""
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 {
+		clock, fraction = s[:i], s[i+1:]
+	}
+
+	parts := strings.Split(clock, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		total = total*60 + time.Duration(n)*time.Second
+	}
+
+	if fraction != "" {
+		// 只保留毫秒精度，例如 ASS 的 .50 为 500 毫秒
+		if len(fraction) > 3 {
+			fraction = fraction[:3]
+		}
+		fraction += strings.Repeat("0", 3-len(fraction))
+		ms, err := strconv.Atoi(fraction)
+		if err != nil || ms < 0 {
+			return 0, false
+		}
+		total += time.Duration(ms) * time.Millisecond
+	}
+	return total, true
+}
+
+// formatTimestamp 格式化为 WebVTT 的 HH:MM:SS.mmm
+func formatTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// writeCue 输出一条字幕，text 中不能有空行
+func writeCue(w *bufio.Writer, start, end time.Duration, text string) {
+	w.WriteString(formatTimestamp(start) + " --> " + formatTimestamp(end) + "\n" + text + "\n\n")
+}
+
+// escapeText 转义 WebVTT 中有特殊含义的字符，同时避免文本中出现 -->
+func escapeText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
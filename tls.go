@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"magnet-webdav/config"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader 持有当前证书，重新加载时只替换指针，已建立的连接不受影响
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	mutex    sync.RWMutex
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新读取证书和私钥，失败时保留旧证书
+func (r *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mutex.Lock()
+	r.cert = &cert
+	r.modTime = r.latestModTime()
+	r.mutex.Unlock()
+
+	log.Printf("TLS certificate loaded: %s", r.certFile)
+	return nil
+}
+
+// GetCertificate 供 tls.Config 使用
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// Watch 定期检查证书文件的修改时间，变化时自动重新加载
+func (r *certReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mutex.RLock()
+			changed := r.latestModTime().After(r.modTime)
+			r.mutex.RUnlock()
+
+			if changed {
+				if err := r.Reload(); err != nil {
+					log.Printf("TLS certificate reload failed: %v", err)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// buildTLSConfig 根据配置生成 tls.Config，配置了客户端 CA 时启用双向 TLS
+func buildTLSConfig(cfg *config.ServerConfig, reloader *certReloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if cfg.TLSClientCA != "" {
+		caPEM, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA: %s", cfg.TLSClientCA)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.TLSClientAuth == "require" {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// newRedirectServer 创建把 HTTP 请求跳转到 HTTPS 的服务器
+func newRedirectServer(cfg *config.ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:        ":" + cfg.HTTPRedirectPort,
+		ReadTimeout: cfg.ReadTimeout,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if cfg.Port != "443" {
+				host = net.JoinHostPort(host, cfg.Port)
+			}
+			// 非 GET 请求使用 308 保留方法和请求体，WebDAV 客户端会用到
+			code := http.StatusPermanentRedirect
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				code = http.StatusMovedPermanently
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+		}),
+	}
+}
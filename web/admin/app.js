@@ -1,4 +1,9 @@
 let currentMagnetId = null;
+let refreshTimer = null;
+const pageSize = 20;
+let currentOffset = 0;
+let totalMagnets = 0;
+const openStreams = {};
 
 // 加载统计数据
 async function loadStats() {
@@ -58,14 +63,29 @@ async function addMagnet() {
     }
 }
 
-// 加载磁力链接列表
-async function loadMagnets() {
+// 加载磁力链接列表，实时刷新时不显示加载提示，避免列表闪烁
+async function loadMagnets(silent) {
     const list = document.getElementById('magnetsList');
-    list.innerHTML = '<div class="loading">加载中...</div>';
+    if (!silent) {
+        list.innerHTML = '<div class="loading">加载中...</div>';
+    }
 
     try {
-        const response = await fetch('/api/magnets');
-        const magnets = await response.json();
+        const response = await fetch('/api/magnets?' + magnetQuery());
+        const page = await response.json();
+        if (!response.ok) {
+            throw new Error(page.error);
+        }
+
+        // 删除后当前页可能已经为空，回到上一页
+        if (page.items.length === 0 && currentOffset > 0) {
+            currentOffset = Math.max(0, currentOffset - pageSize);
+            return loadMagnets(silent);
+        }
+
+        const magnets = page.items;
+        totalMagnets = page.total;
+        renderPager();
 
         if (magnets.length === 0) {
             list.innerHTML = '<div class="loading">暂无磁力链接</div>';
@@ -75,13 +95,15 @@ async function loadMagnets() {
         list.innerHTML = magnets.map(magnet => `
             <div class="magnet-item">
                 <div class="magnet-header">
-                    <div class="magnet-name">${magnet.name || magnet.id}</div>
+                    <div class="magnet-name">${magnet.display_name || magnet.name || magnet.id}</div>
                     <div class="magnet-status ${magnet.status}">${getStatusText(magnet.status)}</div>
                 </div>
                 <div class="magnet-info">
                     <span>文件数: ${magnet.file_count || 0}</span>
                     <span>大小: ${formatFileSize(magnet.total_size || 0)}</span>
                     <span>访问: ${magnet.access_count} 次</span>
+                    <span>所有者: ${magnet.owner}</span>
+                    ${openStreams[magnet.id] ? `<span class="streaming">播放中: ${openStreams[magnet.id]}</span>` : ''}
                     <span>添加: ${new Date(magnet.created_at).toLocaleDateString()}</span>
                 </div>
                 <div class="magnet-actions">
@@ -96,6 +118,42 @@ async function loadMagnets() {
     }
 }
 
+// magnetQuery 根据搜索框和筛选条件生成查询参数
+function magnetQuery() {
+    const params = new URLSearchParams();
+    const search = document.getElementById('searchInput').value.trim();
+    const status = document.getElementById('statusFilter').value;
+    const [sort, order] = document.getElementById('sortSelect').value.split(':');
+
+    if (search) params.set('q', search);
+    if (status) params.set('status', status);
+    if (document.getElementById('videoFilter').checked) params.set('has_video', 'true');
+    if (sort) params.set('sort', sort);
+    if (order) params.set('order', order);
+    params.set('limit', pageSize);
+    params.set('offset', currentOffset);
+    return params.toString();
+}
+
+function renderPager() {
+    const pages = Math.max(1, Math.ceil(totalMagnets / pageSize));
+    const current = Math.floor(currentOffset / pageSize) + 1;
+    document.getElementById('pageInfo').textContent = `第 ${current} / ${pages} 页，共 ${totalMagnets} 条`;
+    document.getElementById('prevPage').disabled = currentOffset === 0;
+    document.getElementById('nextPage').disabled = currentOffset + pageSize >= totalMagnets;
+}
+
+function changePage(delta) {
+    currentOffset = Math.max(0, currentOffset + delta * pageSize);
+    loadMagnets();
+}
+
+// 筛选条件变化后回到第一页
+function applyFilters() {
+    currentOffset = 0;
+    loadMagnets();
+}
+
 // 查看文件列表
 async function viewFiles(magnetId) {
     currentMagnetId = magnetId;
@@ -106,15 +164,20 @@ async function viewFiles(magnetId) {
     list.innerHTML = '<div class="loading">加载中...</div>';
 
     try {
-        const response = await fetch(`/api/magnets/${magnetId}/files`);
-        const files = await response.json();
+        const response = await fetch(`/api/magnets/${magnetId}/files?limit=500`);
+        const page = await response.json();
+        const files = page.items;
 
         if (files.length === 0) {
             list.innerHTML = '<div class="loading">暂无文件</div>';
             return;
         }
 
-        list.innerHTML = files.map(file => `
+        const more = page.total > files.length
+            ? `<div class="loading">共 ${page.total} 个文件，仅显示前 ${files.length} 个</div>`
+            : '';
+
+        list.innerHTML = more + files.map(file => `
             <div class="file-item">
                 <div class="file-info">
                     <div class="file-name">${file.file_name}</div>
@@ -124,8 +187,9 @@ async function viewFiles(magnetId) {
                     </div>
                 </div>
                 <div class="file-actions">
-                    <a href="/webdav/${magnetId}/${encodeURIComponent(file.file_path)}" 
+                    <a href="${playURL(magnetId, file)}" 
                        class="btn btn-primary" target="_blank">播放</a>
+                    <button class="btn btn-primary" onclick="shareFile('${magnetId}', ${file.file_index})">分享</button>
                 </div>
             </div>
         `).join('');
@@ -134,6 +198,41 @@ async function viewFiles(magnetId) {
     }
 }
 
+// 视频文件打开播放页，其他文件直接打开
+function playURL(magnetId, file) {
+    if (file.mime_type && file.mime_type.startsWith('video/')) {
+        return `/player/?magnet=${encodeURIComponent(magnetId)}&file=${file.file_index}`;
+    }
+    return `/webdav/${magnetId}/${encodeURIComponent(file.file_path)}`;
+}
+
+// 创建文件分享链接
+async function shareFile(magnetId, fileIndex) {
+    const expiresIn = prompt('链接有效期（例如 24h、30m）', '24h');
+    if (expiresIn === null) {
+        return;
+    }
+
+    try {
+        const response = await fetch(`/api/magnets/${magnetId}/files/${fileIndex}/share`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ expires_in: expiresIn }),
+        });
+
+        const result = await response.json();
+        if (response.ok) {
+            prompt('分享链接已创建，复制下面的地址：', result.url);
+        } else {
+            alert('分享失败: ' + result.error);
+        }
+    } catch (error) {
+        alert('网络错误: ' + error.message);
+    }
+}
+
 // 删除磁力链接
 async function removeMagnet(magnetId) {
     if (!confirm('确定要删除这个磁力链接吗？')) {
@@ -164,6 +263,67 @@ async function removeMagnet(magnetId) {
     }
 }
 
+// 订阅服务端事件，收到事件后刷新列表
+function connectEvents() {
+    if (!window.EventSource) {
+        return;
+    }
+
+    const source = new EventSource('/api/events');
+    const refreshTypes = [
+        'magnet.added',
+        'magnet.metadata_received',
+        'magnet.status_changed',
+        'magnet.files_synced',
+        'magnet.removed',
+        'stream.started',
+        'stream.stopped',
+    ];
+
+    refreshTypes.forEach(type => {
+        source.addEventListener(type, event => handleEvent(JSON.parse(event.data)));
+    });
+
+    source.onopen = () => setLiveStatus(true);
+    // EventSource 会自动重连，并通过 Last-Event-ID 补发错过的事件
+    source.onerror = () => setLiveStatus(false);
+}
+
+function handleEvent(event) {
+    if (event.type === 'stream.started' || event.type === 'stream.stopped') {
+        openStreams[event.magnet_id] = event.data.open_readers;
+    }
+
+    if (event.type === 'magnet.files_synced' && currentMagnetId === event.magnet_id) {
+        viewFiles(event.magnet_id);
+    }
+
+    if (event.type === 'magnet.removed' && currentMagnetId === event.magnet_id) {
+        document.getElementById('filesSection').style.display = 'none';
+        currentMagnetId = null;
+    }
+
+    scheduleRefresh();
+}
+
+// 合并短时间内的多个事件，只刷新一次
+function scheduleRefresh() {
+    if (refreshTimer) {
+        return;
+    }
+    refreshTimer = setTimeout(() => {
+        refreshTimer = null;
+        loadMagnets(true);
+        loadStats();
+    }, 300);
+}
+
+function setLiveStatus(connected) {
+    const indicator = document.getElementById('liveStatus');
+    indicator.className = 'live-status ' + (connected ? 'connected' : 'disconnected');
+    indicator.textContent = connected ? '实时更新' : '连接断开，正在重连...';
+}
+
 // 工具函数
 function getStatusText(status) {
     const statusMap = {
@@ -199,6 +359,13 @@ function formatDuration(seconds) {
 document.addEventListener('DOMContentLoaded', function() {
     loadStats();
     loadMagnets();
+    connectEvents();
+
+    let searchTimer = null;
+    document.getElementById('searchInput').addEventListener('input', function() {
+        clearTimeout(searchTimer);
+        searchTimer = setTimeout(applyFilters, 300);
+    });
 
     // 支持回车键添加磁力链接
     document.getElementById('magnetInput').addEventListener('keypress', function(e) {
@@ -0,0 +1,346 @@
+const params = new URLSearchParams(location.search);
+const magnetId = params.get('magnet');
+const fileIndex = params.get('file');
+const fileApi = `/api/magnets/${encodeURIComponent(magnetId)}/files/${encodeURIComponent(fileIndex)}`;
+
+const video = document.getElementById('video');
+let fileState = null;
+let stateTimer = null;
+let lastSaved = 0;
+
+// 加载播放信息
+async function loadPlayer() {
+    if (!magnetId || fileIndex === null) {
+        document.getElementById('title').textContent = '缺少 magnet 或 file 参数';
+        return;
+    }
+
+    try {
+        const response = await fetch(`${fileApi}/player`);
+        const info = await response.json();
+        if (!response.ok) {
+            document.getElementById('title').textContent = '加载失败: ' + info.error;
+            return;
+        }
+
+        const file = info.file;
+        document.title = file.file_name + ' - Magnet WebDAV';
+        document.getElementById('title').textContent = file.file_name;
+        document.getElementById('path').textContent = file.file_path + ' • ' + formatFileSize(file.file_size);
+        document.getElementById('backLink').href = `/webdav/${magnetId}/`;
+
+        addSource('原始文件', webdavURL(file.file_path));
+        video.src = webdavURL(file.file_path);
+        loadSubtitles(info.subtitles);
+        setupResume(info.progress);
+        loadMediaInfo(file);
+        refreshState();
+    } catch (error) {
+        document.getElementById('title').textContent = '加载失败: ' + error.message;
+    }
+}
+
+function webdavURL(filePath) {
+    return `/webdav/${magnetId}/${encodeURIComponent(filePath)}`;
+}
+
+function addSource(label, url) {
+    const option = document.createElement('option');
+    option.value = url;
+    option.textContent = label;
+    document.getElementById('sourceSelect').appendChild(option);
+}
+
+// 切换来源时保持播放位置
+function changeSource() {
+    const time = video.currentTime;
+    const paused = video.paused;
+    video.src = document.getElementById('sourceSelect').value;
+    video.addEventListener('loadedmetadata', function() {
+        video.currentTime = time;
+        if (!paused) {
+            video.play();
+        }
+    }, { once: true });
+}
+
+// 同目录下的字幕，SRT/ASS 使用服务器转换后的 WebVTT
+function loadSubtitles(subtitles) {
+    const select = document.getElementById('subtitleSelect');
+    select.innerHTML = '<option value="">关闭</option>';
+
+    subtitles.forEach((sub, i) => {
+        const track = document.createElement('track');
+        track.kind = 'subtitles';
+        track.label = sub.label;
+        track.src = webdavURL(sub.path);
+        if (sub.language) {
+            track.srclang = sub.language;
+        }
+        video.appendChild(track);
+
+        const option = document.createElement('option');
+        option.value = i;
+        option.textContent = sub.label;
+        select.appendChild(option);
+    });
+
+    if (subtitles.length > 0) {
+        select.value = '0';
+    }
+    selectSubtitle();
+}
+
+function selectSubtitle() {
+    const selected = document.getElementById('subtitleSelect').value;
+    for (let i = 0; i < video.textTracks.length; i++) {
+        video.textTracks[i].mode = String(i) === selected ? 'showing' : 'disabled';
+    }
+}
+
+// 媒体信息：容器、编码和音轨，解析成功的 MP4/MKV 提供 HLS 来源
+async function loadMediaInfo(file) {
+    const container = document.getElementById('mediaInfo');
+    try {
+        const response = await fetch(`${fileApi}/media`);
+        const info = await response.json();
+        // 解析失败的记录中 error 为失败原因
+        if (!response.ok || info.error) {
+            container.className = 'loading';
+            container.textContent = '无法解析: ' + info.error;
+            return;
+        }
+
+        const rows = info.tracks.map(track => `
+            <tr>
+                <td>${track.number}</td>
+                <td>${trackTypeText(track.type)}</td>
+                <td>${escapeHTML(track.codec)}</td>
+                <td>${escapeHTML(track.language || '')}</td>
+                <td>${escapeHTML(track.name || '')}</td>
+                <td>${trackDetails(track)}</td>
+                <td>${track.default ? '默认' : ''}</td>
+            </tr>
+        `).join('');
+        container.className = '';
+        container.innerHTML = `
+            <p>${escapeHTML(info.container)} • 时长 ${formatDuration(Math.round(info.duration))}
+               ${info.width ? `• ${info.width}×${info.height}` : ''}</p>
+            <table>
+                <tr><th>#</th><th>类型</th><th>编码</th><th>语言</th><th>名称</th><th>参数</th><th></th></tr>
+                ${rows}
+            </table>
+        `;
+
+        if (video.canPlayType('application/vnd.apple.mpegurl')) {
+            addSource('HLS', `/stream/${magnetId}/${encodeURIComponent(file.file_path)}/index.m3u8`);
+        }
+        setupAudioTracks(info.tracks.filter(track => track.type === 'audio'));
+    } catch (error) {
+        container.className = 'error';
+        container.textContent = '加载失败: ' + error.message;
+    }
+}
+
+// 浏览器支持 audioTracks 时可以切换音轨，否则只显示音轨信息
+function setupAudioTracks(tracks) {
+    if (!video.audioTracks || tracks.length < 2) {
+        return;
+    }
+    const select = document.getElementById('audioSelect');
+    const fill = () => {
+        select.innerHTML = '';
+        for (let i = 0; i < video.audioTracks.length; i++) {
+            const track = video.audioTracks[i];
+            const option = document.createElement('option');
+            option.value = i;
+            option.textContent = track.label || track.language || (tracks[i] ? tracks[i].language || tracks[i].codec : `音轨 ${i + 1}`);
+            option.selected = track.enabled;
+            select.appendChild(option);
+        }
+        document.getElementById('audioControl').style.display = video.audioTracks.length > 1 ? '' : 'none';
+    };
+    video.audioTracks.addEventListener('addtrack', fill);
+    fill();
+}
+
+function selectAudioTrack() {
+    const selected = document.getElementById('audioSelect').value;
+    for (let i = 0; i < video.audioTracks.length; i++) {
+        video.audioTracks[i].enabled = String(i) === selected;
+    }
+}
+
+// 播放位置：打开时跳转到上次的位置，播放中定期保存，播放结束后删除
+function setupResume(progress) {
+    if (!progress || progress.position < 5) {
+        return;
+    }
+    video.addEventListener('loadedmetadata', function() {
+        if (video.duration && progress.position > video.duration - 10) {
+            return;
+        }
+        video.currentTime = progress.position;
+        const resume = document.getElementById('resume');
+        resume.style.display = 'block';
+        resume.innerHTML = `已从上次的位置 ${formatDuration(Math.floor(progress.position))} 继续播放
+            <button onclick="restart()">从头播放</button>`;
+    }, { once: true });
+}
+
+function restart() {
+    video.currentTime = 0;
+    document.getElementById('resume').style.display = 'none';
+}
+
+function saveProgress(keepalive) {
+    if (!video.currentTime || video.ended) {
+        return;
+    }
+    lastSaved = Date.now();
+    fetch(`${fileApi}/progress`, {
+        method: 'PUT',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ position: video.currentTime, duration: video.duration || 0 }),
+        keepalive: keepalive,
+    }).catch(error => console.error('Failed to save progress:', error));
+}
+
+// 分片完成情况，按文件大小比例近似对应到播放时间
+async function refreshState() {
+    clearTimeout(stateTimer);
+    try {
+        const response = await fetch(`${fileApi}/state`);
+        const state = await response.json();
+        const label = document.getElementById('torrentState');
+        if (response.ok) {
+            fileState = state;
+            const percent = state.length ? (state.bytes_completed / state.length * 100).toFixed(1) : '100.0';
+            label.textContent = `${percent}% • ${state.active_peers} 个连接 • ${state.seeders} 个做种`;
+        } else {
+            fileState = null;
+            label.textContent = state.error;
+        }
+        drawAvailability();
+        // 已下载完成后不再刷新
+        if (!fileState || fileState.bytes_completed < fileState.length) {
+            stateTimer = setTimeout(refreshState, 2000);
+        }
+    } catch (error) {
+        stateTimer = setTimeout(refreshState, 5000);
+    }
+}
+
+function drawAvailability() {
+    const canvas = document.getElementById('pieces');
+    canvas.width = canvas.clientWidth;
+    const ctx = canvas.getContext('2d');
+    const width = canvas.width;
+    const height = canvas.height;
+    ctx.clearRect(0, 0, width, height);
+
+    if (fileState && fileState.length > 0) {
+        const scale = width / fileState.length;
+        ctx.fillStyle = '#27ae60';
+        fileState.ranges.forEach(([start, end]) => {
+            ctx.fillRect(Math.floor(start * scale), 0, Math.max(1, Math.ceil((end - start) * scale)), height / 2);
+        });
+        ctx.fillStyle = '#f39c12';
+        fileState.partial.forEach(([start, end]) => {
+            ctx.fillRect(Math.floor(start * scale), 0, Math.max(1, Math.ceil((end - start) * scale)), height / 2);
+        });
+    }
+
+    if (video.duration) {
+        const scale = width / video.duration;
+        ctx.fillStyle = '#3498db';
+        for (let i = 0; i < video.buffered.length; i++) {
+            const start = video.buffered.start(i);
+            const end = video.buffered.end(i);
+            ctx.fillRect(Math.floor(start * scale), height / 2, Math.max(1, Math.ceil((end - start) * scale)), height / 2);
+        }
+        ctx.fillStyle = '#c0392b';
+        ctx.fillRect(Math.floor(video.currentTime * scale), 0, 2, height);
+    }
+}
+
+// 工具函数
+function trackTypeText(type) {
+    const typeMap = {
+        'video': '视频',
+        'audio': '音频',
+        'subtitle': '字幕'
+    };
+    return typeMap[type] || type;
+}
+
+function trackDetails(track) {
+    if (track.type === 'video' && track.width) {
+        return `${track.width}×${track.height}`;
+    }
+    if (track.type === 'audio') {
+        const parts = [];
+        if (track.channels) parts.push(`${track.channels} 声道`);
+        if (track.sample_rate) parts.push(`${track.sample_rate} Hz`);
+        return parts.join(' • ');
+    }
+    return '';
+}
+
+function escapeHTML(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+}
+
+function formatFileSize(bytes) {
+    if (bytes === 0) return '0 B';
+    const k = 1024;
+    const sizes = ['B', 'KB', 'MB', 'GB', 'TB'];
+    const i = Math.floor(Math.log(bytes) / Math.log(k));
+    return parseFloat((bytes / Math.pow(k, i)).toFixed(2)) + ' ' + sizes[i];
+}
+
+function formatDuration(seconds) {
+    const hours = Math.floor(seconds / 3600);
+    const minutes = Math.floor((seconds % 3600) / 60);
+    const secs = seconds % 60;
+
+    if (hours > 0) {
+        return `${hours}:${minutes.toString().padStart(2, '0')}:${secs.toString().padStart(2, '0')}`;
+    } else {
+        return `${minutes}:${secs.toString().padStart(2, '0')}`;
+    }
+}
+
+// 初始化
+document.addEventListener('DOMContentLoaded', function() {
+    document.getElementById('sourceSelect').addEventListener('change', changeSource);
+    document.getElementById('subtitleSelect').addEventListener('change', selectSubtitle);
+    document.getElementById('audioSelect').addEventListener('change', selectAudioTrack);
+
+    video.addEventListener('waiting', () => {
+        document.getElementById('buffering').style.display = 'block';
+    });
+    ['playing', 'canplay', 'pause'].forEach(event => video.addEventListener(event, () => {
+        document.getElementById('buffering').style.display = 'none';
+    }));
+    video.addEventListener('progress', drawAvailability);
+    video.addEventListener('timeupdate', () => {
+        drawAvailability();
+        if (Date.now() - lastSaved > 10000) {
+            saveProgress(false);
+        }
+    });
+    video.addEventListener('pause', () => saveProgress(false));
+    video.addEventListener('ended', () => {
+        fetch(`${fileApi}/progress`, { method: 'DELETE' })
+            .catch(error => console.error('Failed to delete progress:', error));
+    });
+    window.addEventListener('pagehide', () => saveProgress(true));
+    window.addEventListener('resize', drawAvailability);
+
+    loadPlayer();
+});